@@ -3,6 +3,7 @@ package policyprocessor
 import (
 	"context"
 	"fmt"
+	"runtime"
 	"testing"
 
 	"github.com/usetero/policy-go/backend/hyperscan"
@@ -570,6 +571,90 @@ func BenchmarkLogs_TransformNoMatch(b *testing.B) {
 	benchmarkLogTransform(b, policies)
 }
 
+// BenchmarkLogs_ResourceDecision compares evaluating every record against
+// settling whole resources once, for policies that only read resource
+// attributes. The policies keep what they match so the batch is reusable.
+func BenchmarkLogs_ResourceDecision(b *testing.B) {
+	policies := []*policyv1.Policy{
+		{
+			Id:      "keep-by-resource",
+			Name:    "Keep By Resource",
+			Enabled: true,
+			Target: &policyv1.Policy_Log{
+				Log: &policyv1.LogTarget{
+					Match: []*policyv1.LogMatcher{
+						{
+							Field: &policyv1.LogMatcher_ResourceAttribute{ResourceAttribute: &policyv1.AttributePath{Path: []string{"service.name"}}},
+							Match: &policyv1.LogMatcher_StartsWith{StartsWith: "service-"},
+						},
+					},
+					Keep: "all",
+				},
+			},
+		},
+	}
+	ctx := context.Background()
+	logs := generateLogs(8, 2, 64)
+
+	b.Run("per_record", func(b *testing.B) {
+		p := createBenchmarkProcessor(b, policies)
+		// Pin an empty plan to the snapshot so no container is settled in
+		// bulk and every record is evaluated.
+		p.logPlans.entry.Store(&planEntry[policy.LogField]{snapshot: p.registry.LogSnapshot()})
+		b.ReportAllocs()
+		for b.Loop() {
+			_, _ = p.processLogs(ctx, logs)
+		}
+	})
+
+	b.Run("resource_decision", func(b *testing.B) {
+		p := createBenchmarkProcessor(b, policies)
+		b.ReportAllocs()
+		for b.Loop() {
+			_, _ = p.processLogs(ctx, logs)
+		}
+	})
+}
+
+// BenchmarkLogs_Parallel shows the throughput of a large batch as the worker
+// pool grows. The policy matches on the record body so every record is
+// evaluated, and keeps what it matches so the batch is reusable.
+func BenchmarkLogs_Parallel(b *testing.B) {
+	policies := []*policyv1.Policy{
+		{
+			Id:      "keep-resource-logs",
+			Name:    "Keep Resource Logs",
+			Enabled: true,
+			Target: &policyv1.Policy_Log{
+				Log: &policyv1.LogTarget{
+					Match: []*policyv1.LogMatcher{
+						{
+							Field: &policyv1.LogMatcher_LogField{LogField: policyv1.LogField_LOG_FIELD_BODY},
+							Match: &policyv1.LogMatcher_Regex{Regex: "from resource [0-9]+ scope 1"},
+						},
+					},
+					Keep: "all",
+				},
+			},
+		},
+	}
+	ctx := context.Background()
+	logs := generateLogs(64, 2, 128)
+	records := logs.LogRecordCount()
+
+	for workers := 1; workers <= runtime.GOMAXPROCS(0); workers *= 2 {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			p := createBenchmarkProcessor(b, policies)
+			p.workers = newWorkerPool(ParallelismConfig{Enabled: true, MaxWorkers: workers})
+			b.ReportAllocs()
+			for b.Loop() {
+				_, _ = p.processLogs(ctx, logs)
+			}
+			b.ReportMetric(float64(records*b.N)/b.Elapsed().Seconds(), "records/s")
+		})
+	}
+}
+
 // =============================================================================
 // METRICS BENCHMARKS
 // =============================================================================
@@ -2,6 +2,10 @@ package policyprocessor
 
 import (
 	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"time"
 
 	"github.com/usetero/policy-go/policy"
 	"go.opentelemetry.io/collector/component"
@@ -11,6 +15,391 @@ import (
 type Config struct {
 	// Providers is the list of policy providers to use.
 	Providers []policy.ProviderConfig `mapstructure:"providers"`
+
+	// RateLimits caps how many records matching a policy are kept per second.
+	// Limits apply to logs and traces on top of the policy's own keep action.
+	RateLimits []RateLimitConfig `mapstructure:"rate_limits"`
+
+	// LogSampling configures how percentage sampling policies decide which
+	// log records to keep.
+	LogSampling LogSamplingConfig `mapstructure:"log_sampling"`
+
+	// Dedup collapses repeated log records matching a policy into a single
+	// record per time window.
+	Dedup []DedupConfig `mapstructure:"dedup"`
+
+	// Downsample keeps at most one datapoint per series and interval for
+	// metrics matching a policy.
+	Downsample []DownsampleConfig `mapstructure:"downsample"`
+
+	// Histograms reduces the resolution of histogram datapoints of metrics
+	// matching a policy.
+	Histograms []HistogramConfig `mapstructure:"histograms"`
+
+	// Exemplars matches datapoints of metrics matching a policy by their
+	// exemplars, and strips the exemplars or drops the datapoints.
+	Exemplars []ExemplarConfig `mapstructure:"exemplars"`
+
+	// CardinalityLimits caps the number of distinct series per metric name
+	// for metrics matching a policy.
+	CardinalityLimits []CardinalityLimitConfig `mapstructure:"cardinality_limits"`
+
+	// DroppedLogMetrics summarizes dropped log records into a metric emitted
+	// through the metrics pipeline of the same processor.
+	DroppedLogMetrics DroppedLogMetricsConfig `mapstructure:"dropped_log_metrics"`
+
+	// Parallelism evaluates the resources of a batch concurrently.
+	Parallelism ParallelismConfig `mapstructure:"parallelism"`
+
+	// TailSampling buffers spans by trace ID and applies trace policies to
+	// whole traces instead of individual spans.
+	TailSampling TailSamplingConfig `mapstructure:"tail_sampling"`
+
+	// TraceDecisions makes logs follow the keep or drop decision made for
+	// their trace by the traces pipeline of the same processor.
+	TraceDecisions TraceDecisionsConfig `mapstructure:"trace_decisions"`
+
+	// OrphanedSpans configures what happens to the children of a span
+	// dropped by a policy.
+	OrphanedSpans OrphanedSpansConfig `mapstructure:"orphaned_spans"`
+
+	// DroppedSpanMetrics generates request, error and duration metrics for
+	// dropped spans, emitted through the metrics pipeline of the same
+	// processor.
+	DroppedSpanMetrics DroppedSpanMetricsConfig `mapstructure:"dropped_span_metrics"`
+
+	// Telemetry enables optional internal telemetry of the processor.
+	Telemetry TelemetryConfig `mapstructure:"telemetry"`
+
+	// DecisionLog logs a sample of individual policy decisions at debug
+	// level.
+	DecisionLog DecisionLogConfig `mapstructure:"decision_log"`
+
+	// UsageReport periodically sends per-policy usage statistics to the
+	// control plane.
+	UsageReport UsageReportConfig `mapstructure:"usage_report"`
+
+	// Debug serves the loaded policies and their hit counts over HTTP.
+	Debug DebugConfig `mapstructure:"debug"`
+}
+
+// DecisionLogConfig configures the debug log of individual policy
+// decisions.
+type DecisionLogConfig struct {
+	// Enabled logs policy decisions. The collector must log at debug level.
+	Enabled bool `mapstructure:"enabled"`
+	// SamplingRate is the fraction of records whose decision is logged,
+	// between 0 and 1. Defaults to 0.01.
+	SamplingRate float64 `mapstructure:"sampling_rate"`
+	// PolicyIDs limits logging to decisions for records matching one of
+	// these policies. Empty logs decisions for every record, including those
+	// no policy matched.
+	PolicyIDs []string `mapstructure:"policy_ids"`
+}
+
+// UsageReportConfig configures the periodic report of per-policy usage
+// statistics.
+type UsageReportConfig struct {
+	// Endpoint is the http or https URL reports are POSTed to as JSON.
+	// Empty disables them.
+	Endpoint string `mapstructure:"endpoint"`
+	// Headers are sent with each report, for example to authenticate.
+	Headers []policy.Header `mapstructure:"headers"`
+	// Interval is how often a report is sent. Defaults to 1m.
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// DebugConfig configures the debug HTTP endpoint.
+type DebugConfig struct {
+	// Endpoint is the host:port the debug pages are served on. Empty
+	// disables them.
+	Endpoint string `mapstructure:"endpoint"`
+	// TapLimit is the number of records per second sent to each client of
+	// the tap. Defaults to 1.
+	TapLimit float64 `mapstructure:"tap_limit"`
+}
+
+// TelemetryConfig configures optional internal telemetry.
+type TelemetryConfig struct {
+	// Bytes reports the estimated OTLP size of processed records and the
+	// bytes saved on them, by result and winning policy. Attributing records
+	// to a policy replays policy matching for each record, so it is off by
+	// default.
+	Bytes bool `mapstructure:"bytes"`
+	// RecordTiming reports how long the policy engine takes to evaluate
+	// each record, for profiling expensive matchers. Reading the clock
+	// around every record has a cost, so it is off by default.
+	RecordTiming bool `mapstructure:"record_timing"`
+	// Tracing creates a span for each batch with its record counts by
+	// result, and for each policy recompile, through the collector's own
+	// tracer provider.
+	Tracing bool `mapstructure:"tracing"`
+}
+
+// DroppedSpanMetricsConfig configures the RED metrics generated for dropped
+// spans.
+type DroppedSpanMetricsConfig struct {
+	// Enabled turns on accumulation of metrics for dropped spans.
+	Enabled bool `mapstructure:"enabled"`
+	// Namespace prefixes the emitted metric names. Defaults to
+	// policy.dropped_spans.
+	Namespace string `mapstructure:"namespace"`
+	// Buckets are the explicit bucket boundaries of the duration histogram.
+	// Defaults to the spanmetrics connector's boundaries.
+	Buckets []time.Duration `mapstructure:"buckets"`
+	// FlushInterval is how often the accumulated metrics are emitted.
+	// Defaults to 1m.
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
+}
+
+// Orphaned span strategies.
+const (
+	// OrphanedSpansKeep leaves the children of dropped spans unchanged.
+	OrphanedSpansKeep = "keep"
+	// OrphanedSpansReparent points the children of dropped spans at their
+	// nearest kept ancestor.
+	OrphanedSpansReparent = "reparent"
+	// OrphanedSpansDrop drops every descendant of a dropped span.
+	OrphanedSpansDrop = "drop"
+)
+
+// OrphanedSpansConfig configures how spans whose parent was dropped are
+// handled. Only parents in the same batch are considered.
+type OrphanedSpansConfig struct {
+	// Strategy is "keep" (default), "reparent" or "drop".
+	Strategy string `mapstructure:"strategy"`
+}
+
+// TraceDecisionsConfig configures the trace decision cache shared by the
+// traces and logs instances of the processor.
+type TraceDecisionsConfig struct {
+	// Enabled records trace decisions and applies them to logs.
+	Enabled bool `mapstructure:"enabled"`
+	// TTL is how long a trace decision is remembered. Defaults to 5m.
+	TTL time.Duration `mapstructure:"ttl"`
+	// MaxTraces bounds the number of remembered decisions. The oldest is
+	// forgotten first. Defaults to 100000.
+	MaxTraces int `mapstructure:"max_traces"`
+	// LogWait is how long logs whose trace has not been decided yet are held
+	// back waiting for it. Logs still undecided afterwards keep the decision
+	// of the log policies. Defaults to 5s.
+	LogWait time.Duration `mapstructure:"log_wait"`
+}
+
+// Tail sampling match modes.
+const (
+	// TailMatchAny applies a policy to a trace when any of its spans match.
+	TailMatchAny = "any"
+	// TailMatchAll applies a policy to a trace only when all of its spans match.
+	TailMatchAll = "all"
+)
+
+// TailSamplingConfig configures trace-level sampling decisions.
+type TailSamplingConfig struct {
+	// Enabled buffers spans until their trace is decided.
+	Enabled bool `mapstructure:"enabled"`
+	// DecisionWait is how long spans of a trace are buffered after its first
+	// span arrives before the trace is decided. Defaults to 30s.
+	DecisionWait time.Duration `mapstructure:"decision_wait"`
+	// MaxTraces bounds the number of traces buffered at once. When full, the
+	// oldest trace is decided early. Defaults to 50000.
+	MaxTraces int `mapstructure:"max_traces"`
+	// MaxSpansPerTrace bounds the number of spans buffered for a single
+	// trace. A trace reaching it is decided early, and its later spans
+	// follow the decision. Defaults to 10000.
+	MaxSpansPerTrace int `mapstructure:"max_spans_per_trace"`
+	// Policies sets how individual trace policies match a whole trace.
+	// Policies not listed match when any span matches.
+	Policies []TailSamplingPolicyConfig `mapstructure:"policies"`
+}
+
+// TailSamplingPolicyConfig sets how a single policy matches a trace.
+type TailSamplingPolicyConfig struct {
+	// PolicyID is the ID of the trace policy.
+	PolicyID string `mapstructure:"policy_id"`
+	// Match is "any" (default) to apply the policy when any span of the trace
+	// matches, or "all" to require every span to match.
+	Match string `mapstructure:"match"`
+}
+
+// ParallelismConfig configures concurrent evaluation of large batches.
+type ParallelismConfig struct {
+	// Enabled evaluates independent ResourceLogs, ResourceSpans and
+	// ResourceMetrics of a batch on a shared pool of worker goroutines.
+	Enabled bool `mapstructure:"enabled"`
+	// MaxWorkers caps the number of goroutines evaluating a batch, including
+	// the pipeline goroutine that delivered it. Defaults to GOMAXPROCS.
+	MaxWorkers int `mapstructure:"max_workers"`
+}
+
+// LogSamplingConfig configures log sampling behavior.
+type LogSamplingConfig struct {
+	// TraceConsistent derives the keep decision for logs matching a
+	// percentage sampling policy from the record's trace ID, using the same
+	// threshold and randomness scheme as span sampling. Logs and spans of the
+	// same trace sampled at the same percentage are then kept together. It
+	// only applies to policies without a sample key, and decides as the
+	// engine does for a policy with sample_key: trace_id.
+	TraceConsistent bool `mapstructure:"trace_consistent"`
+}
+
+// DedupConfig configures log deduplication for a single policy.
+type DedupConfig struct {
+	// PolicyID is the ID of the policy whose matching logs are deduplicated.
+	PolicyID string `mapstructure:"policy_id"`
+	// Window is how long identical records are collapsed into the first one.
+	Window time.Duration `mapstructure:"window"`
+	// Attributes lists the log record attributes that, together with the
+	// body, identify a message.
+	Attributes []string `mapstructure:"attributes"`
+}
+
+// Downsample aggregations for gauges.
+const (
+	// DownsampleLast keeps the latest datapoint of each interval.
+	DownsampleLast = "last"
+	// DownsampleMin keeps the smallest value of each interval.
+	DownsampleMin = "min"
+	// DownsampleMax keeps the largest value of each interval.
+	DownsampleMax = "max"
+	// DownsampleAvg keeps the average value of each interval.
+	DownsampleAvg = "avg"
+)
+
+// DownsampleConfig configures metric downsampling for a single policy.
+type DownsampleConfig struct {
+	// PolicyID is the ID of the policy whose matching datapoints are
+	// downsampled.
+	PolicyID string `mapstructure:"policy_id"`
+	// Interval is the length of the time windows, aligned to the Unix
+	// epoch, in which at most one datapoint per series is kept.
+	Interval time.Duration `mapstructure:"interval"`
+	// Aggregation selects how gauge datapoints of an interval are combined:
+	// "last" (default), "min", "max" or "avg". Delta sums are always summed
+	// and cumulative sums keep the last datapoint.
+	Aggregation string `mapstructure:"aggregation"`
+}
+
+// Limits of the exponential histogram scale.
+const (
+	minExponentialScale = -10
+	maxExponentialScale = 20
+)
+
+// HistogramConfig configures histogram resolution reduction for a single
+// policy.
+type HistogramConfig struct {
+	// PolicyID is the ID of the policy whose matching histograms are
+	// transformed.
+	PolicyID string `mapstructure:"policy_id"`
+	// Boundaries is the coarser set of explicit bucket boundaries that
+	// Histogram datapoints are merged into. Only boundaries the datapoint
+	// already has are kept, so counts stay exact.
+	Boundaries []float64 `mapstructure:"boundaries"`
+	// MaxScale caps the scale of ExponentialHistogram datapoints. Unset
+	// leaves the scale unchanged.
+	MaxScale *int32 `mapstructure:"max_scale"`
+}
+
+// Exemplar rule actions.
+const (
+	// ExemplarActionStrip removes exemplars from matching datapoints.
+	ExemplarActionStrip = "strip"
+	// ExemplarActionDrop drops matching datapoints.
+	ExemplarActionDrop = "drop"
+)
+
+// Exemplar strip modes.
+const (
+	// ExemplarStripAll removes every exemplar of a datapoint that has a
+	// matching exemplar.
+	ExemplarStripAll = "all"
+	// ExemplarStripMatching removes only the matching exemplars.
+	ExemplarStripMatching = "matching"
+)
+
+// ExemplarConfig matches the datapoints of a single policy by their
+// exemplars. A datapoint matches when any of its exemplars does.
+type ExemplarConfig struct {
+	// PolicyID is the ID of the policy whose datapoints are matched.
+	PolicyID string `mapstructure:"policy_id"`
+	// FilteredAttributes matches exemplars carrying any of these filtered
+	// attributes.
+	FilteredAttributes []ExemplarAttributeConfig `mapstructure:"filtered_attributes"`
+	// TraceID matches exemplars linked to a trace.
+	TraceID bool `mapstructure:"trace_id"`
+	// Action is applied to matching datapoints: "strip" (default) removes
+	// their exemplars, "drop" drops them. With neither FilteredAttributes
+	// nor TraceID set, every exemplar matches, so every datapoint that has
+	// exemplars does.
+	Action string `mapstructure:"action"`
+	// Strip selects which exemplars the strip action removes: "all"
+	// (default) or "matching".
+	Strip string `mapstructure:"strip"`
+}
+
+// ExemplarAttributeConfig matches an exemplar filtered attribute.
+type ExemplarAttributeConfig struct {
+	// Key is the attribute key.
+	Key string `mapstructure:"key"`
+	// Value is the string value the attribute must have. Empty matches any
+	// value.
+	Value string `mapstructure:"value"`
+}
+
+// Cardinality limit actions.
+const (
+	// CardinalityDrop drops datapoints of new series once the limit is
+	// reached.
+	CardinalityDrop = "drop"
+	// CardinalityOverflow collapses datapoints of new series into a single
+	// overflow series once the limit is reached. Cumulative datapoints
+	// cannot be merged and are dropped instead.
+	CardinalityOverflow = "overflow"
+)
+
+// CardinalityLimitConfig configures a series cardinality limit for a single
+// policy.
+type CardinalityLimitConfig struct {
+	// PolicyID is the ID of the policy whose matching metrics are limited.
+	PolicyID string `mapstructure:"policy_id"`
+	// MaxSeries is the number of distinct series tracked per metric name.
+	MaxSeries int `mapstructure:"max_series"`
+	// Window is how long a series is tracked after its last datapoint.
+	// Defaults to 10m.
+	Window time.Duration `mapstructure:"window"`
+	// Action is "drop" (default) or "overflow".
+	Action string `mapstructure:"action"`
+}
+
+// DroppedLogMetricsConfig configures the dropped log summary metric.
+type DroppedLogMetricsConfig struct {
+	// Enabled turns on counting of dropped log records.
+	Enabled bool `mapstructure:"enabled"`
+	// MetricName is the name of the emitted delta Sum.
+	// Defaults to policy.dropped_log_records.
+	MetricName string `mapstructure:"metric_name"`
+	// Attributes lists the attributes to group by in addition to the policy
+	// ID. Each name is looked up as a log field (severity_text, event_name),
+	// then a log record attribute, then a resource attribute.
+	Attributes []string `mapstructure:"attributes"`
+	// FlushInterval is how often the accumulated counts are emitted.
+	// Defaults to 1m.
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
+}
+
+// RateLimitConfig configures a token-bucket rate limit for a single policy.
+type RateLimitConfig struct {
+	// PolicyID is the ID of the policy the limit applies to.
+	PolicyID string `mapstructure:"policy_id"`
+	// RecordsPerSecond is the sustained number of matching records kept per second.
+	RecordsPerSecond float64 `mapstructure:"records_per_second"`
+	// Burst is the maximum number of records kept in a single burst.
+	// Defaults to RecordsPerSecond rounded up.
+	Burst int `mapstructure:"burst"`
+	// ResourceAttribute, when set, keeps a separate bucket for each value of
+	// this resource attribute (e.g. service.name).
+	ResourceAttribute string `mapstructure:"resource_attribute"`
 }
 
 var _ component.Config = (*Config)(nil)
@@ -25,5 +414,349 @@ func (cfg *Config) Validate() error {
 			return fmt.Errorf("provider[%d]: %w", i, err)
 		}
 	}
+	seen := make(map[string]bool, len(cfg.RateLimits))
+	for i, rl := range cfg.RateLimits {
+		if err := rl.Validate(); err != nil {
+			return fmt.Errorf("rate_limits[%d]: %w", i, err)
+		}
+		if seen[rl.PolicyID] {
+			return fmt.Errorf("rate_limits[%d]: duplicate policy_id %q", i, rl.PolicyID)
+		}
+		seen[rl.PolicyID] = true
+	}
+	seen = make(map[string]bool, len(cfg.Dedup))
+	for i, d := range cfg.Dedup {
+		if err := d.Validate(); err != nil {
+			return fmt.Errorf("dedup[%d]: %w", i, err)
+		}
+		if seen[d.PolicyID] {
+			return fmt.Errorf("dedup[%d]: duplicate policy_id %q", i, d.PolicyID)
+		}
+		seen[d.PolicyID] = true
+	}
+	seen = make(map[string]bool, len(cfg.Downsample))
+	for i, d := range cfg.Downsample {
+		if err := d.Validate(); err != nil {
+			return fmt.Errorf("downsample[%d]: %w", i, err)
+		}
+		if seen[d.PolicyID] {
+			return fmt.Errorf("downsample[%d]: duplicate policy_id %q", i, d.PolicyID)
+		}
+		seen[d.PolicyID] = true
+	}
+	seen = make(map[string]bool, len(cfg.Histograms))
+	for i, h := range cfg.Histograms {
+		if err := h.Validate(); err != nil {
+			return fmt.Errorf("histograms[%d]: %w", i, err)
+		}
+		if seen[h.PolicyID] {
+			return fmt.Errorf("histograms[%d]: duplicate policy_id %q", i, h.PolicyID)
+		}
+		seen[h.PolicyID] = true
+	}
+	seen = make(map[string]bool, len(cfg.Exemplars))
+	for i, e := range cfg.Exemplars {
+		if err := e.Validate(); err != nil {
+			return fmt.Errorf("exemplars[%d]: %w", i, err)
+		}
+		if seen[e.PolicyID] {
+			return fmt.Errorf("exemplars[%d]: duplicate policy_id %q", i, e.PolicyID)
+		}
+		seen[e.PolicyID] = true
+	}
+	seen = make(map[string]bool, len(cfg.CardinalityLimits))
+	for i, c := range cfg.CardinalityLimits {
+		if err := c.Validate(); err != nil {
+			return fmt.Errorf("cardinality_limits[%d]: %w", i, err)
+		}
+		if seen[c.PolicyID] {
+			return fmt.Errorf("cardinality_limits[%d]: duplicate policy_id %q", i, c.PolicyID)
+		}
+		seen[c.PolicyID] = true
+	}
+	if err := cfg.DroppedLogMetrics.Validate(); err != nil {
+		return fmt.Errorf("dropped_log_metrics: %w", err)
+	}
+	if err := cfg.Parallelism.Validate(); err != nil {
+		return fmt.Errorf("parallelism: %w", err)
+	}
+	if err := cfg.TailSampling.Validate(); err != nil {
+		return fmt.Errorf("tail_sampling: %w", err)
+	}
+	if err := cfg.TraceDecisions.Validate(); err != nil {
+		return fmt.Errorf("trace_decisions: %w", err)
+	}
+	if err := cfg.OrphanedSpans.Validate(); err != nil {
+		return fmt.Errorf("orphaned_spans: %w", err)
+	}
+	if err := cfg.DroppedSpanMetrics.Validate(); err != nil {
+		return fmt.Errorf("dropped_span_metrics: %w", err)
+	}
+	if err := cfg.DecisionLog.Validate(); err != nil {
+		return fmt.Errorf("decision_log: %w", err)
+	}
+	if err := cfg.UsageReport.Validate(); err != nil {
+		return fmt.Errorf("usage_report: %w", err)
+	}
+	if err := cfg.Debug.Validate(); err != nil {
+		return fmt.Errorf("debug: %w", err)
+	}
+	return nil
+}
+
+// Validate checks if the decision log configuration is valid.
+func (cfg *DecisionLogConfig) Validate() error {
+	if cfg.SamplingRate < 0 || cfg.SamplingRate > 1 {
+		return fmt.Errorf("sampling_rate must be between 0 and 1")
+	}
+	for i, id := range cfg.PolicyIDs {
+		if id == "" {
+			return fmt.Errorf("policy_ids[%d]: must not be empty", i)
+		}
+	}
+	return nil
+}
+
+// Validate checks if the usage report configuration is valid.
+func (cfg *UsageReportConfig) Validate() error {
+	if cfg.Interval < 0 {
+		return fmt.Errorf("interval must not be negative")
+	}
+	for i, h := range cfg.Headers {
+		if h.Name == "" {
+			return fmt.Errorf("headers[%d]: name must not be empty", i)
+		}
+	}
+	if cfg.Endpoint == "" {
+		return nil
+	}
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return fmt.Errorf("endpoint: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("endpoint: must be an http or https URL")
+	}
+	return nil
+}
+
+// Validate checks if the debug configuration is valid.
+func (cfg *DebugConfig) Validate() error {
+	if cfg.TapLimit < 0 {
+		return fmt.Errorf("tap_limit must not be negative")
+	}
+	if cfg.Endpoint == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(cfg.Endpoint); err != nil {
+		return fmt.Errorf("endpoint: %w", err)
+	}
+	return nil
+}
+
+// Validate checks if the dropped span metrics configuration is valid.
+func (cfg *DroppedSpanMetricsConfig) Validate() error {
+	if cfg.FlushInterval < 0 {
+		return fmt.Errorf("flush_interval must not be negative")
+	}
+	for i, b := range cfg.Buckets {
+		if b <= 0 {
+			return fmt.Errorf("buckets[%d]: must be positive", i)
+		}
+		if i > 0 && b <= cfg.Buckets[i-1] {
+			return fmt.Errorf("buckets[%d]: must be greater than the previous bucket", i)
+		}
+	}
+	return nil
+}
+
+// Validate checks if the orphaned spans configuration is valid.
+func (cfg *OrphanedSpansConfig) Validate() error {
+	switch cfg.Strategy {
+	case "", OrphanedSpansKeep, OrphanedSpansReparent, OrphanedSpansDrop:
+		return nil
+	default:
+		return fmt.Errorf("strategy must be %q, %q or %q", OrphanedSpansKeep, OrphanedSpansReparent, OrphanedSpansDrop)
+	}
+}
+
+// Validate checks if the trace decisions configuration is valid.
+func (cfg *TraceDecisionsConfig) Validate() error {
+	if cfg.TTL < 0 {
+		return fmt.Errorf("ttl must not be negative")
+	}
+	if cfg.MaxTraces < 0 {
+		return fmt.Errorf("max_traces must not be negative")
+	}
+	if cfg.LogWait < 0 {
+		return fmt.Errorf("log_wait must not be negative")
+	}
+	return nil
+}
+
+// Validate checks if the tail sampling configuration is valid.
+func (cfg *TailSamplingConfig) Validate() error {
+	if cfg.DecisionWait < 0 {
+		return fmt.Errorf("decision_wait must not be negative")
+	}
+	if cfg.MaxTraces < 0 {
+		return fmt.Errorf("max_traces must not be negative")
+	}
+	if cfg.MaxSpansPerTrace < 0 {
+		return fmt.Errorf("max_spans_per_trace must not be negative")
+	}
+	seen := make(map[string]bool, len(cfg.Policies))
+	for i, p := range cfg.Policies {
+		if p.PolicyID == "" {
+			return fmt.Errorf("policies[%d]: policy_id is required", i)
+		}
+		switch p.Match {
+		case "", TailMatchAny, TailMatchAll:
+		default:
+			return fmt.Errorf("policies[%d]: match must be %q or %q", i, TailMatchAny, TailMatchAll)
+		}
+		if seen[p.PolicyID] {
+			return fmt.Errorf("policies[%d]: duplicate policy_id %q", i, p.PolicyID)
+		}
+		seen[p.PolicyID] = true
+	}
+	return nil
+}
+
+// Validate checks if the parallelism configuration is valid.
+func (cfg *ParallelismConfig) Validate() error {
+	if cfg.MaxWorkers < 0 {
+		return fmt.Errorf("max_workers must not be negative")
+	}
+	return nil
+}
+
+// Validate checks if the dedup configuration is valid.
+func (cfg *DedupConfig) Validate() error {
+	if cfg.PolicyID == "" {
+		return fmt.Errorf("policy_id is required")
+	}
+	if cfg.Window <= 0 {
+		return fmt.Errorf("window must be positive")
+	}
+	return nil
+}
+
+// Validate checks if the downsample configuration is valid.
+func (cfg *DownsampleConfig) Validate() error {
+	if cfg.PolicyID == "" {
+		return fmt.Errorf("policy_id is required")
+	}
+	if cfg.Interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+	switch cfg.Aggregation {
+	case "", DownsampleLast, DownsampleMin, DownsampleMax, DownsampleAvg:
+		return nil
+	default:
+		return fmt.Errorf("aggregation must be %q, %q, %q or %q", DownsampleLast, DownsampleMin, DownsampleMax, DownsampleAvg)
+	}
+}
+
+// Validate checks if the histogram configuration is valid.
+func (cfg *HistogramConfig) Validate() error {
+	if cfg.PolicyID == "" {
+		return fmt.Errorf("policy_id is required")
+	}
+	if len(cfg.Boundaries) == 0 && cfg.MaxScale == nil {
+		return fmt.Errorf("boundaries or max_scale is required")
+	}
+	for i, b := range cfg.Boundaries {
+		if math.IsNaN(b) || math.IsInf(b, 0) {
+			return fmt.Errorf("boundaries[%d]: must be finite", i)
+		}
+		if i > 0 && b <= cfg.Boundaries[i-1] {
+			return fmt.Errorf("boundaries[%d]: must be greater than the previous boundary", i)
+		}
+	}
+	if cfg.MaxScale != nil && (*cfg.MaxScale < minExponentialScale || *cfg.MaxScale > maxExponentialScale) {
+		return fmt.Errorf("max_scale must be between %d and %d", minExponentialScale, maxExponentialScale)
+	}
+	return nil
+}
+
+// Validate checks if the exemplar configuration is valid.
+func (cfg *ExemplarConfig) Validate() error {
+	if cfg.PolicyID == "" {
+		return fmt.Errorf("policy_id is required")
+	}
+	for i, a := range cfg.FilteredAttributes {
+		if a.Key == "" {
+			return fmt.Errorf("filtered_attributes[%d]: key is required", i)
+		}
+	}
+	switch cfg.Action {
+	case "", ExemplarActionStrip:
+	case ExemplarActionDrop:
+		if cfg.Strip != "" {
+			return fmt.Errorf("strip applies only to the %q action", ExemplarActionStrip)
+		}
+	default:
+		return fmt.Errorf("action must be %q or %q", ExemplarActionStrip, ExemplarActionDrop)
+	}
+	switch cfg.Strip {
+	case "", ExemplarStripAll, ExemplarStripMatching:
+		return nil
+	default:
+		return fmt.Errorf("strip must be %q or %q", ExemplarStripAll, ExemplarStripMatching)
+	}
+}
+
+// Validate checks if the cardinality limit configuration is valid.
+func (cfg *CardinalityLimitConfig) Validate() error {
+	if cfg.PolicyID == "" {
+		return fmt.Errorf("policy_id is required")
+	}
+	if cfg.MaxSeries <= 0 {
+		return fmt.Errorf("max_series must be positive")
+	}
+	if cfg.Window < 0 {
+		return fmt.Errorf("window must not be negative")
+	}
+	switch cfg.Action {
+	case "", CardinalityDrop, CardinalityOverflow:
+		return nil
+	default:
+		return fmt.Errorf("action must be %q or %q", CardinalityDrop, CardinalityOverflow)
+	}
+}
+
+// Validate checks if the dropped log metrics configuration is valid.
+func (cfg *DroppedLogMetricsConfig) Validate() error {
+	if cfg.FlushInterval < 0 {
+		return fmt.Errorf("flush_interval must not be negative")
+	}
+	seen := make(map[string]bool, len(cfg.Attributes))
+	for _, a := range cfg.Attributes {
+		if a == "" {
+			return fmt.Errorf("attributes must not be empty")
+		}
+		if a == attrPolicyID {
+			return fmt.Errorf("attribute %q is reserved", a)
+		}
+		if seen[a] {
+			return fmt.Errorf("duplicate attribute %q", a)
+		}
+		seen[a] = true
+	}
+	return nil
+}
+
+// Validate checks if the rate limit configuration is valid.
+func (cfg *RateLimitConfig) Validate() error {
+	if cfg.PolicyID == "" {
+		return fmt.Errorf("policy_id is required")
+	}
+	if cfg.RecordsPerSecond <= 0 {
+		return fmt.Errorf("records_per_second must be positive")
+	}
+	if cfg.Burst < 0 {
+		return fmt.Errorf("burst must not be negative")
+	}
 	return nil
 }
@@ -0,0 +1,505 @@
+package policyprocessor
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usetero/policy-go/policy"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(*Config)
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			mutate: func(*Config) {},
+		},
+		{
+			name:    "no providers",
+			mutate:  func(c *Config) { c.Providers = nil },
+			wantErr: "at least one provider is required",
+		},
+		{
+			name: "valid rate limit",
+			mutate: func(c *Config) {
+				c.RateLimits = []RateLimitConfig{{PolicyID: "p", RecordsPerSecond: 10, ResourceAttribute: "service.name"}}
+			},
+		},
+		{
+			name: "rate limit missing policy id",
+			mutate: func(c *Config) {
+				c.RateLimits = []RateLimitConfig{{RecordsPerSecond: 10}}
+			},
+			wantErr: "rate_limits[0]: policy_id is required",
+		},
+		{
+			name: "rate limit non-positive rate",
+			mutate: func(c *Config) {
+				c.RateLimits = []RateLimitConfig{{PolicyID: "p"}}
+			},
+			wantErr: "rate_limits[0]: records_per_second must be positive",
+		},
+		{
+			name: "rate limit negative burst",
+			mutate: func(c *Config) {
+				c.RateLimits = []RateLimitConfig{{PolicyID: "p", RecordsPerSecond: 1, Burst: -1}}
+			},
+			wantErr: "rate_limits[0]: burst must not be negative",
+		},
+		{
+			name: "duplicate rate limit policy",
+			mutate: func(c *Config) {
+				c.RateLimits = []RateLimitConfig{
+					{PolicyID: "p", RecordsPerSecond: 1},
+					{PolicyID: "p", RecordsPerSecond: 2},
+				}
+			},
+			wantErr: `rate_limits[1]: duplicate policy_id "p"`,
+		},
+		{
+			name: "valid dedup",
+			mutate: func(c *Config) {
+				c.Dedup = []DedupConfig{{PolicyID: "p", Window: time.Minute, Attributes: []string{"k8s.pod.name"}}}
+			},
+		},
+		{
+			name: "dedup missing policy id",
+			mutate: func(c *Config) {
+				c.Dedup = []DedupConfig{{Window: time.Minute}}
+			},
+			wantErr: "dedup[0]: policy_id is required",
+		},
+		{
+			name: "dedup non-positive window",
+			mutate: func(c *Config) {
+				c.Dedup = []DedupConfig{{PolicyID: "p"}}
+			},
+			wantErr: "dedup[0]: window must be positive",
+		},
+		{
+			name: "duplicate dedup policy",
+			mutate: func(c *Config) {
+				c.Dedup = []DedupConfig{
+					{PolicyID: "p", Window: time.Second},
+					{PolicyID: "p", Window: time.Minute},
+				}
+			},
+			wantErr: `dedup[1]: duplicate policy_id "p"`,
+		},
+		{
+			name: "valid dropped log metrics",
+			mutate: func(c *Config) {
+				c.DroppedLogMetrics = DroppedLogMetricsConfig{Enabled: true, Attributes: []string{"service.name", "severity_text"}}
+			},
+		},
+		{
+			name: "dropped log metrics empty attribute",
+			mutate: func(c *Config) {
+				c.DroppedLogMetrics = DroppedLogMetricsConfig{Enabled: true, Attributes: []string{""}}
+			},
+			wantErr: "dropped_log_metrics: attributes must not be empty",
+		},
+		{
+			name: "dropped log metrics reserved attribute",
+			mutate: func(c *Config) {
+				c.DroppedLogMetrics = DroppedLogMetricsConfig{Enabled: true, Attributes: []string{"policy.id"}}
+			},
+			wantErr: `dropped_log_metrics: attribute "policy.id" is reserved`,
+		},
+		{
+			name: "dropped log metrics duplicate attribute",
+			mutate: func(c *Config) {
+				c.DroppedLogMetrics = DroppedLogMetricsConfig{Enabled: true, Attributes: []string{"service.name", "service.name"}}
+			},
+			wantErr: `dropped_log_metrics: duplicate attribute "service.name"`,
+		},
+		{
+			name: "dropped log metrics negative flush interval",
+			mutate: func(c *Config) {
+				c.DroppedLogMetrics = DroppedLogMetricsConfig{Enabled: true, FlushInterval: -time.Second}
+			},
+			wantErr: "dropped_log_metrics: flush_interval must not be negative",
+		},
+		{
+			name: "valid parallelism",
+			mutate: func(c *Config) {
+				c.Parallelism = ParallelismConfig{Enabled: true, MaxWorkers: 4}
+			},
+		},
+		{
+			name: "parallelism negative max workers",
+			mutate: func(c *Config) {
+				c.Parallelism = ParallelismConfig{Enabled: true, MaxWorkers: -1}
+			},
+			wantErr: "parallelism: max_workers must not be negative",
+		},
+		{
+			name: "valid tail sampling",
+			mutate: func(c *Config) {
+				c.TailSampling = TailSamplingConfig{
+					Enabled:      true,
+					DecisionWait: 10 * time.Second,
+					Policies:     []TailSamplingPolicyConfig{{PolicyID: "errors"}, {PolicyID: "health", Match: TailMatchAll}},
+				}
+			},
+		},
+		{
+			name: "tail sampling negative decision wait",
+			mutate: func(c *Config) {
+				c.TailSampling = TailSamplingConfig{Enabled: true, DecisionWait: -time.Second}
+			},
+			wantErr: "tail_sampling: decision_wait must not be negative",
+		},
+		{
+			name: "tail sampling negative max traces",
+			mutate: func(c *Config) {
+				c.TailSampling = TailSamplingConfig{Enabled: true, MaxTraces: -1}
+			},
+			wantErr: "tail_sampling: max_traces must not be negative",
+		},
+		{
+			name: "tail sampling negative max spans per trace",
+			mutate: func(c *Config) {
+				c.TailSampling = TailSamplingConfig{Enabled: true, MaxSpansPerTrace: -1}
+			},
+			wantErr: "tail_sampling: max_spans_per_trace must not be negative",
+		},
+		{
+			name: "tail sampling unknown match",
+			mutate: func(c *Config) {
+				c.TailSampling = TailSamplingConfig{Policies: []TailSamplingPolicyConfig{{PolicyID: "p", Match: "most"}}}
+			},
+			wantErr: `tail_sampling: policies[0]: match must be "any" or "all"`,
+		},
+		{
+			name: "tail sampling duplicate policy",
+			mutate: func(c *Config) {
+				c.TailSampling = TailSamplingConfig{Policies: []TailSamplingPolicyConfig{{PolicyID: "p"}, {PolicyID: "p"}}}
+			},
+			wantErr: `tail_sampling: policies[1]: duplicate policy_id "p"`,
+		},
+		{
+			name: "valid trace decisions",
+			mutate: func(c *Config) {
+				c.TraceDecisions = TraceDecisionsConfig{Enabled: true, TTL: time.Minute, MaxTraces: 1000, LogWait: time.Second}
+			},
+		},
+		{
+			name: "trace decisions negative ttl",
+			mutate: func(c *Config) {
+				c.TraceDecisions = TraceDecisionsConfig{Enabled: true, TTL: -time.Minute}
+			},
+			wantErr: "trace_decisions: ttl must not be negative",
+		},
+		{
+			name: "trace decisions negative max traces",
+			mutate: func(c *Config) {
+				c.TraceDecisions = TraceDecisionsConfig{Enabled: true, MaxTraces: -1}
+			},
+			wantErr: "trace_decisions: max_traces must not be negative",
+		},
+		{
+			name: "trace decisions negative log wait",
+			mutate: func(c *Config) {
+				c.TraceDecisions = TraceDecisionsConfig{Enabled: true, LogWait: -time.Second}
+			},
+			wantErr: "trace_decisions: log_wait must not be negative",
+		},
+		{
+			name: "valid orphaned spans",
+			mutate: func(c *Config) {
+				c.OrphanedSpans = OrphanedSpansConfig{Strategy: OrphanedSpansReparent}
+			},
+		},
+		{
+			name: "orphaned spans unknown strategy",
+			mutate: func(c *Config) {
+				c.OrphanedSpans = OrphanedSpansConfig{Strategy: "adopt"}
+			},
+			wantErr: `orphaned_spans: strategy must be "keep", "reparent" or "drop"`,
+		},
+		{
+			name: "valid dropped span metrics",
+			mutate: func(c *Config) {
+				c.DroppedSpanMetrics = DroppedSpanMetricsConfig{Enabled: true, Buckets: []time.Duration{time.Millisecond, time.Second}}
+			},
+		},
+		{
+			name: "dropped span metrics non-positive bucket",
+			mutate: func(c *Config) {
+				c.DroppedSpanMetrics = DroppedSpanMetricsConfig{Enabled: true, Buckets: []time.Duration{0}}
+			},
+			wantErr: "dropped_span_metrics: buckets[0]: must be positive",
+		},
+		{
+			name: "dropped span metrics unsorted buckets",
+			mutate: func(c *Config) {
+				c.DroppedSpanMetrics = DroppedSpanMetricsConfig{Enabled: true, Buckets: []time.Duration{time.Second, time.Second}}
+			},
+			wantErr: "dropped_span_metrics: buckets[1]: must be greater than the previous bucket",
+		},
+		{
+			name: "dropped span metrics negative flush interval",
+			mutate: func(c *Config) {
+				c.DroppedSpanMetrics = DroppedSpanMetricsConfig{Enabled: true, FlushInterval: -time.Second}
+			},
+			wantErr: "dropped_span_metrics: flush_interval must not be negative",
+		},
+		{
+			name: "valid downsample",
+			mutate: func(c *Config) {
+				c.Downsample = []DownsampleConfig{{PolicyID: "p", Interval: time.Minute, Aggregation: DownsampleAvg}}
+			},
+		},
+		{
+			name: "downsample missing policy id",
+			mutate: func(c *Config) {
+				c.Downsample = []DownsampleConfig{{Interval: time.Minute}}
+			},
+			wantErr: "downsample[0]: policy_id is required",
+		},
+		{
+			name: "downsample zero interval",
+			mutate: func(c *Config) {
+				c.Downsample = []DownsampleConfig{{PolicyID: "p"}}
+			},
+			wantErr: "downsample[0]: interval must be positive",
+		},
+		{
+			name: "downsample unknown aggregation",
+			mutate: func(c *Config) {
+				c.Downsample = []DownsampleConfig{{PolicyID: "p", Interval: time.Minute, Aggregation: "median"}}
+			},
+			wantErr: `downsample[0]: aggregation must be "last", "min", "max" or "avg"`,
+		},
+		{
+			name: "downsample duplicate policy",
+			mutate: func(c *Config) {
+				c.Downsample = []DownsampleConfig{{PolicyID: "p", Interval: time.Minute}, {PolicyID: "p", Interval: time.Second}}
+			},
+			wantErr: `downsample[1]: duplicate policy_id "p"`,
+		},
+		{
+			name: "valid histograms",
+			mutate: func(c *Config) {
+				c.Histograms = []HistogramConfig{{PolicyID: "p", Boundaries: []float64{10, 100}, MaxScale: int32Ptr(-2)}}
+			},
+		},
+		{
+			name: "histograms without transform",
+			mutate: func(c *Config) {
+				c.Histograms = []HistogramConfig{{PolicyID: "p"}}
+			},
+			wantErr: "histograms[0]: boundaries or max_scale is required",
+		},
+		{
+			name: "histograms unsorted boundaries",
+			mutate: func(c *Config) {
+				c.Histograms = []HistogramConfig{{PolicyID: "p", Boundaries: []float64{10, 10}}}
+			},
+			wantErr: "histograms[0]: boundaries[1]: must be greater than the previous boundary",
+		},
+		{
+			name: "histograms infinite boundary",
+			mutate: func(c *Config) {
+				c.Histograms = []HistogramConfig{{PolicyID: "p", Boundaries: []float64{math.Inf(1)}}}
+			},
+			wantErr: "histograms[0]: boundaries[0]: must be finite",
+		},
+		{
+			name: "histograms max scale out of range",
+			mutate: func(c *Config) {
+				c.Histograms = []HistogramConfig{{PolicyID: "p", MaxScale: int32Ptr(21)}}
+			},
+			wantErr: "histograms[0]: max_scale must be between -10 and 20",
+		},
+		{
+			name: "histograms duplicate policy",
+			mutate: func(c *Config) {
+				c.Histograms = []HistogramConfig{{PolicyID: "p", Boundaries: []float64{1}}, {PolicyID: "p", Boundaries: []float64{2}}}
+			},
+			wantErr: `histograms[1]: duplicate policy_id "p"`,
+		},
+		{
+			name: "valid exemplars",
+			mutate: func(c *Config) {
+				c.Exemplars = []ExemplarConfig{
+					{PolicyID: "p", FilteredAttributes: []ExemplarAttributeConfig{{Key: "user.id"}}, TraceID: true, Strip: ExemplarStripMatching},
+					{PolicyID: "q", FilteredAttributes: []ExemplarAttributeConfig{{Key: "tenant", Value: "internal"}}, Action: ExemplarActionDrop},
+				}
+			},
+		},
+		{
+			name: "exemplars without policy",
+			mutate: func(c *Config) {
+				c.Exemplars = []ExemplarConfig{{}}
+			},
+			wantErr: "exemplars[0]: policy_id is required",
+		},
+		{
+			name: "exemplars empty filtered attribute",
+			mutate: func(c *Config) {
+				c.Exemplars = []ExemplarConfig{{PolicyID: "p", FilteredAttributes: []ExemplarAttributeConfig{{Value: "42"}}}}
+			},
+			wantErr: "exemplars[0]: filtered_attributes[0]: key is required",
+		},
+		{
+			name: "exemplars invalid action",
+			mutate: func(c *Config) {
+				c.Exemplars = []ExemplarConfig{{PolicyID: "p", Action: "sample"}}
+			},
+			wantErr: `exemplars[0]: action must be "strip" or "drop"`,
+		},
+		{
+			name: "exemplars strip with drop action",
+			mutate: func(c *Config) {
+				c.Exemplars = []ExemplarConfig{{PolicyID: "p", Action: ExemplarActionDrop, Strip: ExemplarStripMatching}}
+			},
+			wantErr: `exemplars[0]: strip applies only to the "strip" action`,
+		},
+		{
+			name: "exemplars invalid strip",
+			mutate: func(c *Config) {
+				c.Exemplars = []ExemplarConfig{{PolicyID: "p", Strip: "some"}}
+			},
+			wantErr: `exemplars[0]: strip must be "all" or "matching"`,
+		},
+		{
+			name: "exemplars duplicate policy",
+			mutate: func(c *Config) {
+				c.Exemplars = []ExemplarConfig{{PolicyID: "p"}, {PolicyID: "p"}}
+			},
+			wantErr: `exemplars[1]: duplicate policy_id "p"`,
+		},
+		{
+			name: "valid cardinality limits",
+			mutate: func(c *Config) {
+				c.CardinalityLimits = []CardinalityLimitConfig{{PolicyID: "p", MaxSeries: 1000, Window: time.Hour, Action: CardinalityOverflow}}
+			},
+		},
+		{
+			name: "cardinality limits without max series",
+			mutate: func(c *Config) {
+				c.CardinalityLimits = []CardinalityLimitConfig{{PolicyID: "p"}}
+			},
+			wantErr: "cardinality_limits[0]: max_series must be positive",
+		},
+		{
+			name: "cardinality limits negative window",
+			mutate: func(c *Config) {
+				c.CardinalityLimits = []CardinalityLimitConfig{{PolicyID: "p", MaxSeries: 1, Window: -time.Second}}
+			},
+			wantErr: "cardinality_limits[0]: window must not be negative",
+		},
+		{
+			name: "cardinality limits invalid action",
+			mutate: func(c *Config) {
+				c.CardinalityLimits = []CardinalityLimitConfig{{PolicyID: "p", MaxSeries: 1, Action: "sample"}}
+			},
+			wantErr: `cardinality_limits[0]: action must be "drop" or "overflow"`,
+		},
+		{
+			name: "cardinality limits duplicate policy",
+			mutate: func(c *Config) {
+				c.CardinalityLimits = []CardinalityLimitConfig{{PolicyID: "p", MaxSeries: 1}, {PolicyID: "p", MaxSeries: 2}}
+			},
+			wantErr: `cardinality_limits[1]: duplicate policy_id "p"`,
+		},
+		{
+			name: "valid debug endpoint",
+			mutate: func(c *Config) {
+				c.Debug.Endpoint = "localhost:55690"
+			},
+		},
+		{
+			name: "debug endpoint without port",
+			mutate: func(c *Config) {
+				c.Debug.Endpoint = "localhost"
+			},
+			wantErr: "debug: endpoint: address localhost: missing port in address",
+		},
+		{
+			name: "valid decision log",
+			mutate: func(c *Config) {
+				c.DecisionLog = DecisionLogConfig{Enabled: true, SamplingRate: 0.5, PolicyIDs: []string{"p"}}
+			},
+		},
+		{
+			name: "decision log sampling rate above one",
+			mutate: func(c *Config) {
+				c.DecisionLog = DecisionLogConfig{Enabled: true, SamplingRate: 1.5}
+			},
+			wantErr: "decision_log: sampling_rate must be between 0 and 1",
+		},
+		{
+			name: "decision log empty policy id",
+			mutate: func(c *Config) {
+				c.DecisionLog = DecisionLogConfig{Enabled: true, PolicyIDs: []string{""}}
+			},
+			wantErr: "decision_log: policy_ids[0]: must not be empty",
+		},
+		{
+			name: "valid usage report",
+			mutate: func(c *Config) {
+				c.UsageReport = UsageReportConfig{
+					Endpoint: "https://policies.example.com/v1/usage",
+					Headers:  []policy.Header{{Name: "Authorization", Value: "Bearer token"}},
+					Interval: time.Minute,
+				}
+			},
+		},
+		{
+			name: "usage report endpoint without scheme",
+			mutate: func(c *Config) {
+				c.UsageReport.Endpoint = "policies.example.com/v1/usage"
+			},
+			wantErr: "usage_report: endpoint: must be an http or https URL",
+		},
+		{
+			name: "usage report negative interval",
+			mutate: func(c *Config) {
+				c.UsageReport.Interval = -time.Second
+			},
+			wantErr: "usage_report: interval must not be negative",
+		},
+		{
+			name: "usage report empty header name",
+			mutate: func(c *Config) {
+				c.UsageReport.Headers = []policy.Header{{Value: "token"}}
+			},
+			wantErr: "usage_report: headers[0]: name must not be empty",
+		},
+		{
+			name: "debug negative tap limit",
+			mutate: func(c *Config) {
+				c.Debug.TapLimit = -1
+			},
+			wantErr: "debug: tap_limit must not be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := createDefaultConfig().(*Config)
+			cfg.Providers = testProviders()
+			tt.mutate(cfg)
+
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func int32Ptr(v int32) *int32 {
+	return &v
+}
@@ -0,0 +1,336 @@
+package policyprocessor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/usetero/policy-go/policy"
+	"go.uber.org/zap"
+)
+
+// explainMaxBytes bounds the size of an explain request body.
+const explainMaxBytes = 4 << 20
+
+// debugServers holds the debug server of each component ID, shared by the
+// processor instances of its pipelines.
+var debugServers = newSharedRegistry[*debugServer]()
+
+// debugServer serves the policies loaded by the processor instances
+// registered with it. It listens while at least one instance is registered.
+type debugServer struct {
+	endpoint string
+	logger   *zap.Logger
+	tap      *policyTap
+
+	mu        sync.Mutex
+	instances []*policyProcessor
+	listener  net.Listener
+	server    *http.Server
+	// closing is closed when the server stops, ending open taps.
+	closing chan struct{}
+}
+
+func newDebugServer(cfg DebugConfig, logger *zap.Logger) *debugServer {
+	limit := cfg.TapLimit
+	if limit == 0 {
+		limit = 1
+	}
+	return &debugServer{endpoint: cfg.Endpoint, logger: logger, tap: newPolicyTap(limit)}
+}
+
+// add registers p, starting to listen when it is the first instance.
+func (s *debugServer) add(p *policyProcessor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		ln, err := net.Listen("tcp", s.endpoint)
+		if err != nil {
+			return err
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/policies", s.handleHTML)
+		mux.HandleFunc("/policies.json", s.handleJSON)
+		mux.HandleFunc("/tap", s.handleTap)
+		mux.HandleFunc("POST /explain/{signal}", s.handleExplain)
+		s.listener = ln
+		s.closing = make(chan struct{})
+		s.server = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+		go func(server *http.Server) {
+			if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				s.logger.Error("Policy debug server error", zap.Error(err))
+			}
+		}(s.server)
+		s.logger.Info("Policy debug pages available", zap.String("endpoint", "http://"+ln.Addr().String()+"/policies"))
+	}
+	s.instances = append(s.instances, p)
+	return nil
+}
+
+// remove unregisters p, closing the listener after the last instance.
+func (s *debugServer) remove(ctx context.Context, p *policyProcessor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, inst := range s.instances {
+		if inst == p {
+			s.instances = append(s.instances[:i], s.instances[i+1:]...)
+			break
+		}
+	}
+	if len(s.instances) > 0 || s.server == nil {
+		return nil
+	}
+	close(s.closing)
+	err := s.server.Shutdown(ctx)
+	s.listener = nil
+	s.server = nil
+	return err
+}
+
+// addr returns the address the server listens on, or nil when stopped.
+func (s *debugServer) addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+func (s *debugServer) pipelines() []debugPipeline {
+	s.mu.Lock()
+	instances := append([]*policyProcessor(nil), s.instances...)
+	s.mu.Unlock()
+	pipelines := make([]debugPipeline, 0, len(instances))
+	for _, p := range instances {
+		pipelines = append(pipelines, p.debugPipeline())
+	}
+	return pipelines
+}
+
+// instance returns the first registered instance in pipeline, or nil.
+func (s *debugServer) instance(pipeline string) *policyProcessor {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.instances {
+		if p.pipeline == pipeline {
+			return p
+		}
+	}
+	return nil
+}
+
+func (s *debugServer) handleJSON(w http.ResponseWriter, _ *http.Request) {
+	s.writeJSON(w, s.pipelines())
+}
+
+func (s *debugServer) writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		s.logger.Debug("Failed to write policy debug response", zap.Error(err))
+	}
+}
+
+func (s *debugServer) handleHTML(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := debugPage.Execute(w, s.pipelines()); err != nil {
+		s.logger.Debug("Failed to write policy debug response", zap.Error(err))
+	}
+}
+
+// handleTap streams the records matching the policy_id query parameter as
+// lines of JSON until the client disconnects or the server stops.
+func (s *debugServer) handleTap(w http.ResponseWriter, r *http.Request) {
+	policyID := r.URL.Query().Get("policy_id")
+	if policyID == "" {
+		http.Error(w, "policy_id is required", http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	s.mu.Lock()
+	closing := s.closing
+	s.mu.Unlock()
+
+	sub := s.tap.subscribe(policyID)
+	defer s.tap.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-closing:
+			return
+		case line := <-sub.events:
+			if _, err := w.Write(append(line, '\n')); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// handleExplain evaluates the records of an OTLP JSON request body against
+// the live policies of the pipeline named by the signal in the path.
+func (s *debugServer) handleExplain(w http.ResponseWriter, r *http.Request) {
+	signal := r.PathValue("signal")
+	p := s.instance(signal)
+	if p == nil {
+		http.Error(w, fmt.Sprintf("no %s pipeline", signal), http.StatusNotFound)
+		return
+	}
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, explainMaxBytes))
+	if err != nil {
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	explanations, err := p.explain(signal, payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, explanations)
+}
+
+// debugPipeline is the state of a single processor instance.
+type debugPipeline struct {
+	Pipeline         string          `json:"pipeline"`
+	LastCompileError *debugError     `json:"last_compile_error,omitempty"`
+	Providers        []debugProvider `json:"providers"`
+}
+
+type debugProvider struct {
+	ID            string        `json:"id"`
+	PolicyHash    string        `json:"policy_hash,omitempty"`
+	LastSync      *time.Time    `json:"last_sync,omitempty"`
+	SyncErrors    int64         `json:"sync_errors"`
+	CompileErrors int64         `json:"compile_errors"`
+	LastError     *debugError   `json:"last_error,omitempty"`
+	Policies      []debugPolicy `json:"policies"`
+}
+
+type debugPolicy struct {
+	ID     string `json:"id"`
+	Name   string `json:"name,omitempty"`
+	Signal string `json:"signal,omitempty"`
+	// Status is active, disabled or failed.
+	Status string `json:"status"`
+	// Hits counts records the policy matched whose outcome followed it;
+	// Misses counts those overridden by another policy.
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+type debugError struct {
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// debugPipeline returns the providers and policies of p.
+func (p *policyProcessor) debugPipeline() debugPipeline {
+	inv := p.inventory
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	out := debugPipeline{Pipeline: p.pipeline, Providers: make([]debugProvider, 0, len(inv.providers))}
+	if inv.lastCompileErr != "" {
+		out.LastCompileError = &debugError{Message: inv.lastCompileErr, Time: inv.lastCompileErrTime}
+	}
+	for _, state := range inv.providers {
+		provider := debugProvider{
+			ID:            state.id,
+			PolicyHash:    state.hash,
+			SyncErrors:    state.syncErrors,
+			CompileErrors: state.compileErrors,
+			Policies:      make([]debugPolicy, 0, len(state.entries)),
+		}
+		if !state.lastSync.IsZero() {
+			lastSync := state.lastSync
+			provider.LastSync = &lastSync
+		}
+		if state.lastErr != "" {
+			provider.LastError = &debugError{Message: state.lastErr, Time: state.lastErrTime}
+		}
+		for _, entry := range state.entries {
+			pol := debugPolicy{ID: entry.id, Name: entry.name, Status: "active"}
+			switch {
+			case !entry.enabled:
+				pol.Status = "disabled"
+			case entry.failed:
+				pol.Status = "failed"
+			}
+			if entry.signal >= 0 {
+				pol.Signal = signalTelemetryTypes[entry.signal]
+				totals := inv.policyTotals(entry.id, p.policyStats(entry.signal, entry.id))
+				pol.Hits, pol.Misses = totals.hits, totals.misses
+			}
+			provider.Policies = append(provider.Policies, pol)
+		}
+		out.Providers = append(out.Providers, provider)
+	}
+	return out
+}
+
+// policyStats returns the live engine stats of the policy with id in the
+// current snapshot of signal.
+func (p *policyProcessor) policyStats(signal int, id string) *policy.PolicyStats {
+	switch signal {
+	case signalLogs:
+		return p.registry.LogSnapshot().GetStats(id)
+	case signalMetrics:
+		return p.registry.MetricSnapshot().GetStats(id)
+	case signalTraces:
+		return p.registry.TraceSnapshot().GetStats(id)
+	}
+	return nil
+}
+
+var debugPage = template.Must(template.New("policies").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Policies</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; text-align: left; }
+.failed { color: #b00; }
+</style>
+</head>
+<body>
+{{range .}}
+<h1>{{.Pipeline}}</h1>
+{{with .LastCompileError}}<p class="failed">Last compile error at {{.Time.Format "2006-01-02T15:04:05Z07:00"}}: {{.Message}}</p>{{end}}
+{{range .Providers}}
+<h2>Provider {{.ID}}</h2>
+<p>Policy hash: {{.PolicyHash}}<br>
+Last sync: {{with .LastSync}}{{.Format "2006-01-02T15:04:05Z07:00"}}{{else}}never{{end}}<br>
+Sync errors: {{.SyncErrors}}, compile errors: {{.CompileErrors}}</p>
+{{with .LastError}}<p class="failed">Last error at {{.Time.Format "2006-01-02T15:04:05Z07:00"}}: {{.Message}}</p>{{end}}
+<table>
+<tr><th>ID</th><th>Name</th><th>Signal</th><th>Status</th><th>Hits</th><th>Misses</th></tr>
+{{range .Policies}}<tr{{if eq .Status "failed"}} class="failed"{{end}}><td>{{.ID}}</td><td>{{.Name}}</td><td>{{.Signal}}</td><td>{{.Status}}</td><td>{{.Hits}}</td><td>{{.Misses}}</td></tr>
+{{end}}</table>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,177 @@
+package policyprocessor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usetero/policy-go/policy"
+	policyv1 "github.com/usetero/policy-go/proto/tero/policy/v1"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.uber.org/zap"
+)
+
+func TestDebugServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies.json")
+	require.NoError(t, os.WriteFile(path, []byte(inventoryTestPolicies), 0o600))
+	cfg := &Config{
+		Providers: []policy.ProviderConfig{{Type: "file", ID: "local", Path: path}},
+		Debug:     DebugConfig{Endpoint: "localhost:0"},
+	}
+	id := component.MustNewID("policy")
+
+	p := newPolicyProcessor(zap.NewNop(), cfg, nil, pcommon.NewResource())
+	p.pipeline = "logs"
+	p.debug = debugServers.acquire(id, func() *debugServer { return newDebugServer(cfg.Debug, zap.NewNop()) })
+	p.debugID = id
+	require.NoError(t, p.start(context.Background(), nil))
+
+	logs := plog.NewLogs()
+	records := logs.ResourceLogs().AppendEmpty().ScopeLogs().AppendEmpty().LogRecords()
+	records.AppendEmpty().SetSeverityText("DEBUG")
+	records.AppendEmpty().SetSeverityText("DEBUG")
+	records.AppendEmpty().SetSeverityText("INFO")
+	_, err := p.processLogs(context.Background(), logs)
+	require.NoError(t, err)
+
+	base := "http://" + p.debug.addr().String()
+	resp, err := http.Get(base + "/policies.json")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var pipelines []debugPipeline
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&pipelines))
+
+	require.Len(t, pipelines, 1)
+	assert.Equal(t, "logs", pipelines[0].Pipeline)
+	assert.Nil(t, pipelines[0].LastCompileError)
+	require.Len(t, pipelines[0].Providers, 1)
+	provider := pipelines[0].Providers[0]
+	assert.Equal(t, "local", provider.ID)
+	assert.NotEmpty(t, provider.PolicyHash)
+	assert.NotNil(t, provider.LastSync)
+	assert.Equal(t, []debugPolicy{
+		{ID: "drop-debug", Name: "Drop debug logs", Signal: "logs", Status: "active", Hits: 2},
+		{ID: "drop-promhttp", Name: "Drop promhttp metrics", Signal: "metrics", Status: "active"},
+		{ID: "disabled", Name: "Disabled", Signal: "logs", Status: "disabled"},
+	}, provider.Policies)
+
+	resp, err = http.Get(base + "/policies")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "<td>drop-debug</td>")
+
+	require.NoError(t, p.shutdown(context.Background()))
+	assert.Nil(t, p.debug.addr())
+}
+
+func TestDebugServer_HitsSurviveStatsCollection(t *testing.T) {
+	p := createTestLogProcessor(t, nil)
+	p.inventory = newProviderInventory()
+	provider := &staticLogProvider{policies: []*policyv1.Policy{
+		logPolicy("drop-debug", "none", severityMatcher("DEBUG")),
+	}}
+	tracked := &trackedProvider{PolicyProvider: provider, p: p, state: p.inventory.add("static")}
+	_, err := p.registry.Register(tracked)
+	require.NoError(t, err)
+
+	logs := plog.NewLogs()
+	logs.ResourceLogs().AppendEmpty().ScopeLogs().AppendEmpty().LogRecords().AppendEmpty().SetSeverityText("DEBUG")
+	_, err = p.processLogs(context.Background(), logs)
+	require.NoError(t, err)
+	// A provider reporting stats to its server resets the engine's counts.
+	p.inventory.collectStats(p.registry.CollectStats)
+
+	logs = plog.NewLogs()
+	logs.ResourceLogs().AppendEmpty().ScopeLogs().AppendEmpty().LogRecords().AppendEmpty().SetSeverityText("DEBUG")
+	_, err = p.processLogs(context.Background(), logs)
+	require.NoError(t, err)
+
+	pipeline := p.debugPipeline()
+	require.Len(t, pipeline.Providers, 1)
+	assert.Equal(t, []debugPolicy{
+		{ID: "drop-debug", Signal: "logs", Status: "active", Hits: 2},
+	}, pipeline.Providers[0].Policies)
+}
+
+func TestDebugServer_Tap(t *testing.T) {
+	p := createTestLogProcessor(t, []*policyv1.Policy{
+		logPolicy("drop-debug", "none", severityMatcher("DEBUG")),
+	})
+	p.inventory = newProviderInventory()
+	p.debug = newDebugServer(DebugConfig{Endpoint: "localhost:0"}, zap.NewNop())
+	require.NoError(t, p.debug.add(p))
+	base := "http://" + p.debug.addr().String()
+
+	resp, err := http.Get(base + "/tap")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Get(base + "/tap?policy_id=drop-debug")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Eventually(t, p.tapping, time.Second, time.Millisecond)
+
+	logs := plog.NewLogs()
+	logs.ResourceLogs().AppendEmpty().ScopeLogs().AppendEmpty().LogRecords().AppendEmpty().SetSeverityText("DEBUG")
+	_, err = p.processLogs(context.Background(), logs)
+	require.NoError(t, err)
+
+	line, err := bufio.NewReader(resp.Body).ReadBytes('\n')
+	require.NoError(t, err)
+	var ev tapEvent
+	require.NoError(t, json.Unmarshal(line, &ev))
+	assert.Equal(t, "drop-debug", ev.PolicyID)
+	assert.Equal(t, "dropped", ev.Result)
+
+	// Stopping the server ends open taps.
+	require.NoError(t, p.debug.remove(context.Background(), p))
+	_, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.False(t, p.tapping())
+}
+
+func TestDebugServer_Explain(t *testing.T) {
+	p := createTestExplainProcessor(t, "logs", []*policyv1.Policy{
+		logPolicy("drop-debug", "none", severityMatcher("DEBUG")),
+	})
+	p.debug = newDebugServer(DebugConfig{Endpoint: "localhost:0"}, zap.NewNop())
+	require.NoError(t, p.debug.add(p))
+	defer func() { require.NoError(t, p.debug.remove(context.Background(), p)) }()
+	base := "http://" + p.debug.addr().String()
+
+	resp, err := http.Post(base+"/explain/logs", "application/json", strings.NewReader(explainTestLogs))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var explanations []recordExplanation
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&explanations))
+	require.Len(t, explanations, 3)
+	assert.Equal(t, "dropped", explanations[0].Result)
+	assert.Equal(t, "drop-debug", explanations[0].Winner)
+
+	resp, err = http.Post(base+"/explain/logs", "application/json", strings.NewReader("{"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Post(base+"/explain/traces", "application/json", strings.NewReader("{}"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,439 @@
+package policyprocessor
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/usetero/policy-go/policy"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// decisionLevel is the narrowest container whose fields a policy's matchers
+// read. A policy at levelResource matches either every record of a resource
+// or none of them, so it can be evaluated once per ResourceLogs (or the spans
+// and metrics equivalents) instead of once per record.
+type decisionLevel uint8
+
+const (
+	levelResource decisionLevel = iota
+	levelScope
+	levelRecord
+)
+
+// decisionPlan records the decision level of every policy in a snapshot.
+type decisionPlan struct {
+	levels []decisionLevel
+	// counts holds the number of policies at each level.
+	counts [levelRecord + 1]int
+}
+
+// decides reports whether evaluating a container at level can settle its
+// records without per-record evaluation. That takes a policy decidable at
+// exactly that level: policies decidable at a coarser level were already
+// considered there. A snapshot without policies is settled at resource level.
+func (plan *decisionPlan) decides(level decisionLevel) bool {
+	if plan == nil {
+		return false
+	}
+	return plan.counts[level] > 0 || (level == levelResource && len(plan.levels) == 0)
+}
+
+// open reports whether any policy depends on fields below level.
+func (plan *decisionPlan) open(level decisionLevel) bool {
+	for l := level + 1; l <= levelRecord; l++ {
+		if plan.counts[l] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// buildDecisionPlan classifies the snapshot's policies by the fields their
+// matchers read. fieldLevel maps a signal's non-attribute fields to a level.
+func buildDecisionPlan[F fieldType](snapshot *policy.PolicySnapshot[F], fieldLevel func(F) decisionLevel) *decisionPlan {
+	matchers := snapshot.CompiledMatchers()
+	if matchers == nil {
+		return nil
+	}
+	plan := &decisionPlan{levels: make([]decisionLevel, matchers.PolicyCount())}
+
+	refLevel := func(field F, scope policy.AttrScope, attrPath []string) decisionLevel {
+		if len(attrPath) == 0 {
+			return fieldLevel(field)
+		}
+		switch scope {
+		case policy.AttrScopeResource:
+			return levelResource
+		case policy.AttrScopeScope:
+			return levelScope
+		default:
+			return levelRecord
+		}
+	}
+	raise := func(policyIndex int, level decisionLevel) {
+		plan.levels[policyIndex] = max(plan.levels[policyIndex], level)
+	}
+
+	for _, check := range matchers.ExistenceChecks() {
+		raise(check.PolicyIndex, refLevel(check.Ref.Field, check.Ref.AttrScope, check.Ref.AttrPath))
+	}
+	for _, check := range matchers.TypedChecks() {
+		raise(check.PolicyIndex, refLevel(check.Ref.Field, check.Ref.AttrScope, check.Ref.AttrPath))
+	}
+	for _, entry := range matchers.Databases() {
+		level := refLevel(entry.Key.Ref.Field, entry.Key.Ref.AttrScope, entry.Key.Ref.AttrPath)
+		for _, ref := range entry.Database.PatternIndex() {
+			raise(ref.PolicyIndex, level)
+		}
+	}
+
+	for _, level := range plan.levels {
+		plan.counts[level]++
+	}
+	return plan
+}
+
+func logFieldLevel(field policy.LogField) decisionLevel {
+	switch field {
+	case policy.LogFieldResourceSchemaURL:
+		return levelResource
+	case policy.LogFieldScopeSchemaURL:
+		return levelScope
+	default:
+		return levelRecord
+	}
+}
+
+func traceFieldLevel(field policy.TraceField) decisionLevel {
+	switch field {
+	case policy.TraceFieldResourceSchemaURL:
+		return levelResource
+	case policy.TraceFieldScopeSchemaURL, policy.TraceFieldScopeName, policy.TraceFieldScopeVersion:
+		return levelScope
+	default:
+		return levelRecord
+	}
+}
+
+func metricFieldLevel(field policy.MetricField) decisionLevel {
+	switch field {
+	case policy.MetricFieldResourceSchemaURL:
+		return levelResource
+	case policy.MetricFieldScopeSchemaURL, policy.MetricFieldScopeName, policy.MetricFieldScopeVersion:
+		return levelScope
+	default:
+		return levelRecord
+	}
+}
+
+// planCache holds the decision plan for the most recent snapshot. Plans are
+// rebuilt only when the registry publishes a new snapshot.
+type planCache[F fieldType] struct {
+	entry atomic.Pointer[planEntry[F]]
+}
+
+type planEntry[F fieldType] struct {
+	snapshot *policy.PolicySnapshot[F]
+	plan     *decisionPlan
+}
+
+func (c *planCache[F]) get(snapshot *policy.PolicySnapshot[F], fieldLevel func(F) decisionLevel) *decisionPlan {
+	if snapshot == nil {
+		return nil
+	}
+	if e := c.entry.Load(); e != nil && e.snapshot == snapshot {
+		return e.plan
+	}
+	plan := buildDecisionPlan(snapshot, fieldLevel)
+	c.entry.Store(&planEntry[F]{snapshot: snapshot, plan: plan})
+	return plan
+}
+
+// containerAction is the outcome of evaluating a resource or scope once.
+type containerAction uint8
+
+const (
+	// actionEvaluate means records must be evaluated individually.
+	actionEvaluate containerAction = iota
+	// actionDrop drops every record in the container.
+	actionDrop
+	// actionKeep keeps every record in the container unchanged.
+	actionKeep
+	// actionNoMatch means no policy matches any record in the container.
+	actionNoMatch
+)
+
+// containerDecision is a decision that applies to every record in a container.
+type containerDecision struct {
+	action  containerAction
+	winner  int
+	matched []int
+}
+
+// decideContainer resolves the policies decidable at level against a match
+// set computed from the container's fields alone.
+//
+// A matching keep-none policy drops the container outright, since no other
+// policy can be more restrictive. Otherwise the container is only settled
+// when no policy depends on finer-grained fields: it then has no match, or
+// keeps every record when the winner keeps all and no matching policy
+// transforms. allowKeep disables the latter for signals where keeping still
+// mutates the record.
+func decideContainer[F fieldType](snapshot *policy.PolicySnapshot[F], plan *decisionPlan, s *matchSet, level decisionLevel, allowKeep bool) containerDecision {
+	d := containerDecision{winner: -1}
+	matchers := snapshot.CompiledMatchers()
+	best := -1
+	for i := range matchers.PolicyCount() {
+		if plan.levels[i] > level || s.disqualified[i] {
+			continue
+		}
+		p := matchers.PolicyByIndex(i)
+		if s.counts[i] < p.MatcherCount {
+			continue
+		}
+		d.matched = append(d.matched, i)
+		if r := p.Keep.Restrictiveness(); r > best {
+			best = r
+			d.winner = i
+		}
+	}
+
+	switch {
+	case d.winner >= 0 && matchers.PolicyByIndex(d.winner).Keep.Action == policy.KeepNone:
+		d.action = actionDrop
+	case plan.open(level):
+		d.action = actionEvaluate
+	case d.winner < 0:
+		d.action = actionNoMatch
+	case allowKeep && matchers.PolicyByIndex(d.winner).Keep.Action == policy.KeepAll:
+		d.action = actionKeep
+		for _, i := range d.matched {
+			if len(matchers.PolicyByIndex(i).Transforms) > 0 {
+				d.action = actionEvaluate
+				break
+			}
+		}
+	}
+	return d
+}
+
+// recordContainerStats updates policy stats as the engine would have had it
+// evaluated each of the n records: the winner of a drop gets a hit and the
+// other matching policies a miss; a keep is a hit for every matching policy.
+func recordContainerStats[F fieldType](snapshot *policy.PolicySnapshot[F], d containerDecision, n int) {
+	if n == 0 {
+		return
+	}
+	matchers := snapshot.CompiledMatchers()
+	for _, i := range d.matched {
+		stats := matchers.PolicyByIndex(i).Stats
+		if stats == nil {
+			continue
+		}
+		if d.action == actionDrop && i != d.winner {
+			stats.MatchMisses.Add(uint64(n))
+		} else {
+			stats.MatchHits.Add(uint64(n))
+		}
+	}
+}
+
+// result maps a settled container action to its telemetry result value.
+func (a containerAction) result() string {
+	switch a {
+	case actionDrop:
+		return "dropped"
+	case actionKeep:
+		return "kept"
+	default:
+		return "no_match"
+	}
+}
+
+// settledMatches returns the winning policy of a settled container decision
+// as its only match, for attributing its records.
+func settledMatches[F fieldType](snapshot *policy.PolicySnapshot[F], d containerDecision) policyMatches {
+	if d.action == actionNoMatch || d.winner < 0 {
+		return policyMatches{}
+	}
+	winner := snapshot.CompiledMatchers().PolicyByIndex(d.winner)
+	return policyMatches{IDs: []string{winner.ID}, Winner: winner.ID, WinnerKeep: winner.Keep.Action}
+}
+
+// keptBytes returns how many of the size bytes of a settled container's
+// records remain after the decision.
+func (d containerDecision) keptBytes(size int) int {
+	if d.action == actionDrop {
+		return 0
+	}
+	return size
+}
+
+// decideLogContainer evaluates the log policies decidable at level once for
+// a resource (or scope) and returns a decision covering all of its records.
+func (p *policyProcessor) decideLogContainer(snapshot *policy.LogSnapshot, plan *decisionPlan, level decisionLevel, ctx LogContext) containerDecision {
+	// Records following their trace's decision must be looked up one by one.
+	if !plan.decides(level) || p.traceDecisions != nil {
+		return containerDecision{action: actionEvaluate}
+	}
+	ctx.Record = plog.NewLogRecord()
+	if level == levelResource {
+		ctx.Scope = pcommon.NewInstrumentationScope()
+		ctx.ScopeSchemaURL = ""
+	}
+	s := logMatchSet(snapshot, ctx, false)
+	if s == nil {
+		return containerDecision{action: actionNoMatch}
+	}
+	// Keeping in bulk would bypass the per-record dedup and rate limit steps.
+	allowKeep := p.deduplicator == nil && p.rateLimiter == nil
+	return decideContainer(snapshot, plan, s, level, allowKeep)
+}
+
+// decideTraceContainer is the trace equivalent of decideLogContainer. Kept
+// spans always get a sampling threshold written, so only drops and
+// non-matches are settled in bulk.
+func (p *policyProcessor) decideTraceContainer(snapshot *policy.TraceSnapshot, plan *decisionPlan, level decisionLevel, ctx TraceContext) containerDecision {
+	if !plan.decides(level) {
+		return containerDecision{action: actionEvaluate}
+	}
+	ctx.Span = ptrace.NewSpan()
+	if level == levelResource {
+		ctx.Scope = pcommon.NewInstrumentationScope()
+		ctx.ScopeSchemaURL = ""
+	}
+	s := traceMatchSet(snapshot, ctx, false)
+	if s == nil {
+		return containerDecision{action: actionNoMatch}
+	}
+	return decideContainer(snapshot, plan, s, level, false)
+}
+
+// decideMetricContainer is the metric equivalent of decideLogContainer.
+func (p *policyProcessor) decideMetricContainer(snapshot *policy.MetricSnapshot, plan *decisionPlan, level decisionLevel, ctx MetricContext) containerDecision {
+	if !plan.decides(level) {
+		return containerDecision{action: actionEvaluate}
+	}
+	ctx.Metric = pmetric.NewMetric()
+	ctx.DatapointAttributes = pcommon.NewMap()
+	if level == levelResource {
+		ctx.Scope = pcommon.NewInstrumentationScope()
+		ctx.ScopeSchemaURL = ""
+	}
+	s := metricMatchSet(snapshot, ctx, false)
+	if s == nil {
+		return containerDecision{action: actionNoMatch}
+	}
+	// Keeping in bulk would bypass the per-datapoint downsample, histogram,
+	// exemplar and cardinality steps.
+	allowKeep := p.downsampler == nil && p.histograms == nil && p.exemplars == nil && p.cardinality == nil
+	return decideContainer(snapshot, plan, s, level, allowKeep)
+}
+
+// metricDataPointCount returns the number of datapoints in a metric, the
+// unit the metrics pipeline evaluates and reports on.
+func metricDataPointCount(m pmetric.Metric) int {
+	switch m.Type() {
+	case pmetric.MetricTypeGauge:
+		return m.Gauge().DataPoints().Len()
+	case pmetric.MetricTypeSum:
+		return m.Sum().DataPoints().Len()
+	case pmetric.MetricTypeHistogram:
+		return m.Histogram().DataPoints().Len()
+	case pmetric.MetricTypeExponentialHistogram:
+		return m.ExponentialHistogram().DataPoints().Len()
+	case pmetric.MetricTypeSummary:
+		return m.Summary().DataPoints().Len()
+	default:
+		return 0
+	}
+}
+
+// settleLogs applies a container decision to every record in records.
+func (p *policyProcessor) settleLogs(ctx context.Context, snapshot *policy.LogSnapshot, d containerDecision, logCtx LogContext, records plog.LogRecordSlice) {
+	n := records.Len()
+	recordContainerStats(snapshot, d, n)
+	p.recordResults(ctx, "logs", d.action.result(), int64(n))
+	if p.attributeRecords() {
+		var size int
+		for i := range n {
+			size += logRecordSize(records.At(i))
+		}
+		p.recordSettledBytes(ctx, "logs", d.action.result(), settledMatches(snapshot, d), n, size, d.keptBytes(size))
+	}
+	if d.action == actionDrop && p.droppedLogs != nil {
+		winner := snapshot.CompiledMatchers().PolicyByIndex(d.winner).ID
+		for i := range n {
+			logCtx.Record = records.At(i)
+			p.droppedLogs.record(winner, logCtx)
+		}
+	}
+	if p.decisionLog != nil {
+		matches := settledMatches(snapshot, d)
+		for i := range n {
+			if p.decisionLog.sample() {
+				logCtx.Record = records.At(i)
+				p.decisionLog.log("logs", d.action.result(), matches, logDecisionFields(logCtx)...)
+			}
+		}
+	}
+}
+
+// settleSpans applies a container decision to every span in spans.
+func (p *policyProcessor) settleSpans(ctx context.Context, snapshot *policy.TraceSnapshot, d containerDecision, resource pcommon.Resource, spans ptrace.SpanSlice) {
+	n := spans.Len()
+	recordContainerStats(snapshot, d, n)
+	p.recordResults(ctx, "traces", d.action.result(), int64(n))
+	if p.attributeRecords() {
+		var size int
+		for i := range n {
+			size += spanSize(spans.At(i))
+		}
+		p.recordSettledBytes(ctx, "traces", d.action.result(), settledMatches(snapshot, d), n, size, d.keptBytes(size))
+	}
+	if d.action == actionDrop {
+		p.recordDroppedSpans(resource, spans)
+	}
+	if p.traceDecisions != nil && d.action != actionNoMatch {
+		for i := range n {
+			p.recordTraceDecision(spans.At(i).TraceID(), d.action != actionDrop)
+		}
+	}
+	if p.decisionLog != nil {
+		matches := settledMatches(snapshot, d)
+		for i := range n {
+			if p.decisionLog.sample() {
+				traceCtx := TraceContext{Span: spans.At(i), Resource: resource}
+				p.decisionLog.log("traces", d.action.result(), matches, spanDecisionFields(traceCtx)...)
+			}
+		}
+	}
+}
+
+// settleMetrics applies a container decision to every datapoint in metrics.
+func (p *policyProcessor) settleMetrics(ctx context.Context, snapshot *policy.MetricSnapshot, d containerDecision, resource pcommon.Resource, metrics pmetric.MetricSlice) {
+	n, size := 0, 0
+	for i := range metrics.Len() {
+		n += metricDataPointCount(metrics.At(i))
+		if p.attributeRecords() {
+			size += metricDataPointsSize(metrics.At(i))
+		}
+	}
+	recordContainerStats(snapshot, d, n)
+	p.recordResults(ctx, "metrics", d.action.result(), int64(n))
+	p.recordSettledBytes(ctx, "metrics", d.action.result(), settledMatches(snapshot, d), n, size, d.keptBytes(size))
+	if p.decisionLog != nil {
+		matches := settledMatches(snapshot, d)
+		for i := range metrics.Len() {
+			metricCtx := MetricContext{Metric: metrics.At(i), Resource: resource}
+			for range metricDataPointCount(metrics.At(i)) {
+				if p.decisionLog.sample() {
+					p.decisionLog.log("metrics", d.action.result(), matches, metricDecisionFields(metricCtx)...)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package policyprocessor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	policyv1 "github.com/usetero/policy-go/proto/tero/policy/v1"
+	"github.com/usetero/tero-collector-distro/processor/policyprocessor/internal/metadata"
+	"github.com/usetero/tero-collector-distro/processor/policyprocessor/internal/metadatatest"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata/metricdatatest"
+)
+
+func logPolicy(id, keep string, matchers ...*policyv1.LogMatcher) *policyv1.Policy {
+	return &policyv1.Policy{
+		Id:      id,
+		Enabled: true,
+		Target: &policyv1.Policy_Log{
+			Log: &policyv1.LogTarget{Match: matchers, Keep: keep},
+		},
+	}
+}
+
+func resourceAttrMatcher(key, value string) *policyv1.LogMatcher {
+	return &policyv1.LogMatcher{
+		Field: &policyv1.LogMatcher_ResourceAttribute{ResourceAttribute: &policyv1.AttributePath{Path: []string{key}}},
+		Match: &policyv1.LogMatcher_Exact{Exact: value},
+	}
+}
+
+func scopeAttrMatcher(key, value string) *policyv1.LogMatcher {
+	return &policyv1.LogMatcher{
+		Field: &policyv1.LogMatcher_ScopeAttribute{ScopeAttribute: &policyv1.AttributePath{Path: []string{key}}},
+		Match: &policyv1.LogMatcher_Exact{Exact: value},
+	}
+}
+
+func severityMatcher(value string) *policyv1.LogMatcher {
+	return &policyv1.LogMatcher{
+		Field: &policyv1.LogMatcher_LogField{LogField: policyv1.LogField_LOG_FIELD_SEVERITY_TEXT},
+		Match: &policyv1.LogMatcher_Exact{Exact: value},
+	}
+}
+
+func TestBuildDecisionPlan(t *testing.T) {
+	p := createTestLogProcessor(t, []*policyv1.Policy{
+		logPolicy("resource", "none", resourceAttrMatcher("service.name", "a")),
+		logPolicy("scope", "none", resourceAttrMatcher("service.name", "a"), scopeAttrMatcher("lib", "x")),
+		logPolicy("record", "none", resourceAttrMatcher("service.name", "a"), severityMatcher("DEBUG")),
+	})
+	snapshot := p.registry.LogSnapshot()
+	plan := p.logPlans.get(snapshot, logFieldLevel)
+	require.NotNil(t, plan)
+
+	levels := make(map[string]decisionLevel)
+	matchers := snapshot.CompiledMatchers()
+	for i := range matchers.PolicyCount() {
+		levels[matchers.PolicyByIndex(i).ID] = plan.levels[i]
+	}
+	assert.Equal(t, map[string]decisionLevel{
+		"resource": levelResource,
+		"scope":    levelScope,
+		"record":   levelRecord,
+	}, levels)
+
+	assert.Same(t, plan, p.logPlans.get(snapshot, logFieldLevel), "plans are cached per snapshot")
+	assert.True(t, plan.decides(levelResource))
+	assert.True(t, plan.decides(levelScope))
+	assert.True(t, plan.open(levelScope))
+}
+
+// newLayeredLogs builds two resources with two scopes each; every scope holds
+// one DEBUG and one INFO record.
+func newLayeredLogs() plog.Logs {
+	logs := plog.NewLogs()
+	for _, svc := range []string{"a", "b"} {
+		rl := logs.ResourceLogs().AppendEmpty()
+		rl.Resource().Attributes().PutStr("service.name", svc)
+		for _, lib := range []string{"x", "y"} {
+			sl := rl.ScopeLogs().AppendEmpty()
+			sl.Scope().Attributes().PutStr("lib", lib)
+			for _, sev := range []string{"DEBUG", "INFO"} {
+				lr := sl.LogRecords().AppendEmpty()
+				lr.SetSeverityText(sev)
+				lr.Body().SetStr(svc + "/" + lib + "/" + sev)
+			}
+		}
+	}
+	return logs
+}
+
+func logBodies(logs plog.Logs) []string {
+	var out []string
+	for i := range logs.ResourceLogs().Len() {
+		rl := logs.ResourceLogs().At(i)
+		for j := range rl.ScopeLogs().Len() {
+			lrs := rl.ScopeLogs().At(j).LogRecords()
+			for k := range lrs.Len() {
+				out = append(out, lrs.At(k).Body().Str())
+			}
+		}
+	}
+	return out
+}
+
+func TestProcessLogs_ContainerDecisions(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies []*policyv1.Policy
+		want     []string
+		results  map[string]int64
+	}{
+		{
+			name:     "resource drop",
+			policies: []*policyv1.Policy{logPolicy("drop-a", "none", resourceAttrMatcher("service.name", "a"))},
+			want:     []string{"b/x/DEBUG", "b/x/INFO", "b/y/DEBUG", "b/y/INFO"},
+			results:  map[string]int64{"dropped": 4, "no_match": 4},
+		},
+		{
+			name: "resource drop with record level policies",
+			policies: []*policyv1.Policy{
+				logPolicy("drop-a", "none", resourceAttrMatcher("service.name", "a")),
+				logPolicy("drop-debug", "none", severityMatcher("DEBUG")),
+			},
+			want:    []string{"b/x/INFO", "b/y/INFO"},
+			results: map[string]int64{"dropped": 6, "no_match": 2},
+		},
+		{
+			name: "scope drop",
+			policies: []*policyv1.Policy{
+				logPolicy("drop-b-y", "none", resourceAttrMatcher("service.name", "b"), scopeAttrMatcher("lib", "y")),
+			},
+			want:    []string{"a/x/DEBUG", "a/x/INFO", "a/y/DEBUG", "a/y/INFO", "b/x/DEBUG", "b/x/INFO"},
+			results: map[string]int64{"dropped": 2, "no_match": 6},
+		},
+		{
+			name: "resource keep",
+			policies: []*policyv1.Policy{
+				logPolicy("keep-a", "all", resourceAttrMatcher("service.name", "a")),
+			},
+			want:    logBodies(newLayeredLogs()),
+			results: map[string]int64{"kept": 4, "no_match": 4},
+		},
+		{
+			name: "resource keep loses to record level drop",
+			policies: []*policyv1.Policy{
+				logPolicy("keep-a", "all", resourceAttrMatcher("service.name", "a")),
+				logPolicy("drop-debug", "none", severityMatcher("DEBUG")),
+			},
+			want:    []string{"a/x/INFO", "a/y/INFO", "b/x/INFO", "b/y/INFO"},
+			results: map[string]int64{"dropped": 4, "kept": 2, "no_match": 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := createTestLogProcessor(t, tt.policies)
+			tel := componenttest.NewTelemetry()
+			t.Cleanup(func() { require.NoError(t, tel.Shutdown(context.Background())) })
+			tb, err := metadata.NewTelemetryBuilder(tel.NewTelemetrySettings())
+			require.NoError(t, err)
+			p.telemetry = tb
+
+			result, err := p.processLogs(context.Background(), newLayeredLogs())
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, logBodies(result))
+
+			var want []metricdata.DataPoint[int64]
+			for res, n := range tt.results {
+				want = append(want, metricdata.DataPoint[int64]{
+					Attributes: attribute.NewSet(attrTelemetryType.String("logs"), attrResult.String(res)),
+					Value:      n,
+				})
+			}
+			metadatatest.AssertEqualProcessorPolicyRecords(t, tel, want, metricdatatest.IgnoreTimestamp())
+		})
+	}
+}
+
+func TestProcessLogs_ContainerDecisionStats(t *testing.T) {
+	p := createTestLogProcessor(t, []*policyv1.Policy{
+		logPolicy("drop-a", "none", resourceAttrMatcher("service.name", "a")),
+		logPolicy("keep-a", "all", resourceAttrMatcher("service.name", "a")),
+		logPolicy("keep-b", "all", resourceAttrMatcher("service.name", "b")),
+	})
+
+	_, err := p.processLogs(context.Background(), newLayeredLogs())
+	require.NoError(t, err)
+
+	matchers := p.registry.LogSnapshot().CompiledMatchers()
+	stats := func(id string) (uint64, uint64) {
+		pol, ok := matchers.GetPolicy(id)
+		require.True(t, ok)
+		return pol.Stats.MatchHits.Load(), pol.Stats.MatchMisses.Load()
+	}
+
+	hits, misses := stats("drop-a")
+	assert.Equal(t, [2]uint64{4, 0}, [2]uint64{hits, misses}, "the winning drop is a hit per record")
+	hits, misses = stats("keep-a")
+	assert.Equal(t, [2]uint64{0, 4}, [2]uint64{hits, misses}, "losing policies of a drop are a miss per record")
+	hits, misses = stats("keep-b")
+	assert.Equal(t, [2]uint64{4, 0}, [2]uint64{hits, misses}, "a keep is a hit per record")
+}
+
+func TestProcessTraces_ResourceDecision(t *testing.T) {
+	p := createTestTraceProcessor(t, []*policyv1.Policy{
+		{
+			Id:      "drop-a",
+			Enabled: true,
+			Target: &policyv1.Policy_Trace{
+				Trace: &policyv1.TraceTarget{
+					Match: []*policyv1.TraceMatcher{
+						{
+							Field: &policyv1.TraceMatcher_ResourceAttribute{ResourceAttribute: &policyv1.AttributePath{Path: []string{"service.name"}}},
+							Match: &policyv1.TraceMatcher_Exact{Exact: "a"},
+						},
+					},
+					Keep: dropConfig(),
+				},
+			},
+		},
+	})
+
+	traces := ptrace.NewTraces()
+	for _, svc := range []string{"a", "b"} {
+		rs := traces.ResourceSpans().AppendEmpty()
+		rs.Resource().Attributes().PutStr("service.name", svc)
+		spans := rs.ScopeSpans().AppendEmpty().Spans()
+		spans.AppendEmpty().SetName("one")
+		spans.AppendEmpty().SetName("two")
+	}
+
+	result, err := p.processTraces(context.Background(), traces)
+	require.NoError(t, err)
+	require.Equal(t, 1, result.ResourceSpans().Len())
+	svc, _ := result.ResourceSpans().At(0).Resource().Attributes().Get("service.name")
+	assert.Equal(t, "b", svc.Str())
+	assert.Equal(t, 2, result.ResourceSpans().At(0).ScopeSpans().At(0).Spans().Len())
+}
+
+func TestProcessMetrics_ScopeDecision(t *testing.T) {
+	p := createTestMetricProcessor(t, []*policyv1.Policy{
+		{
+			Id:      "drop-scope",
+			Enabled: true,
+			Target: &policyv1.Policy_Metric{
+				Metric: &policyv1.MetricTarget{
+					Match: []*policyv1.MetricMatcher{
+						{
+							Field: &policyv1.MetricMatcher_MetricField{MetricField: policyv1.MetricField_METRIC_FIELD_SCOPE_NAME},
+							Match: &policyv1.MetricMatcher_Exact{Exact: "noisy"},
+						},
+					},
+					Keep: false,
+				},
+			},
+		},
+	})
+
+	tel := componenttest.NewTelemetry()
+	t.Cleanup(func() { require.NoError(t, tel.Shutdown(context.Background())) })
+	tb, err := metadata.NewTelemetryBuilder(tel.NewTelemetrySettings())
+	require.NoError(t, err)
+	p.telemetry = tb
+
+	metrics := pmetric.NewMetrics()
+	rm := metrics.ResourceMetrics().AppendEmpty()
+	for _, scope := range []string{"noisy", "quiet"} {
+		sm := rm.ScopeMetrics().AppendEmpty()
+		sm.Scope().SetName(scope)
+		dps := sm.Metrics().AppendEmpty().SetEmptyGauge().DataPoints()
+		dps.AppendEmpty()
+		dps.AppendEmpty()
+		dps.AppendEmpty()
+	}
+
+	result, err := p.processMetrics(context.Background(), metrics)
+	require.NoError(t, err)
+	require.Equal(t, 1, result.ResourceMetrics().At(0).ScopeMetrics().Len())
+	assert.Equal(t, "quiet", result.ResourceMetrics().At(0).ScopeMetrics().At(0).Scope().Name())
+
+	metadatatest.AssertEqualProcessorPolicyRecords(t, tel, []metricdata.DataPoint[int64]{
+		{Attributes: attribute.NewSet(attrTelemetryType.String("metrics"), attrResult.String("dropped")), Value: 3},
+		{Attributes: attribute.NewSet(attrTelemetryType.String("metrics"), attrResult.String("no_match")), Value: 3},
+	}, metricdatatest.IgnoreTimestamp())
+}
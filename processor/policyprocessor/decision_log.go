@@ -0,0 +1,110 @@
+package policyprocessor
+
+import (
+	"math/rand/v2"
+	"slices"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// defaultDecisionLogSamplingRate is the fraction of decisions logged when no
+// sampling rate is configured.
+const defaultDecisionLogSamplingRate = 0.01
+
+// decisionLog logs a sample of individual policy decisions at debug level.
+type decisionLog struct {
+	logger *zap.Logger
+	rate   float64
+	// policyIDs limits logging to decisions involving one of them; empty
+	// logs every decision.
+	policyIDs []string
+
+	random func() float64
+}
+
+// newDecisionLog returns nil when the decision log is disabled or the
+// logger does not log at debug level.
+func newDecisionLog(cfg DecisionLogConfig, logger *zap.Logger) *decisionLog {
+	if !cfg.Enabled {
+		return nil
+	}
+	if !logger.Core().Enabled(zapcore.DebugLevel) {
+		logger.Warn("Policy decision log is enabled but debug logging is not; no decisions will be logged")
+		return nil
+	}
+	rate := cfg.SamplingRate
+	if rate == 0 {
+		rate = defaultDecisionLogSamplingRate
+	}
+	return &decisionLog{logger: logger, rate: rate, policyIDs: cfg.PolicyIDs, random: rand.Float64}
+}
+
+// sample reports whether the decision for the next record is logged, before
+// the policies it matched are known.
+func (l *decisionLog) sample() bool {
+	return l != nil && l.random() < l.rate
+}
+
+// wants reports whether a decision involving the policies ids is logged.
+func (l *decisionLog) wants(ids []string) bool {
+	if len(l.policyIDs) == 0 {
+		return true
+	}
+	for _, id := range ids {
+		if slices.Contains(l.policyIDs, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// log logs the decision for a record that matched matches, with fields
+// identifying the record. Dropped results are refined by the winning
+// policy's keep action.
+func (l *decisionLog) log(signal, result string, matches policyMatches, fields ...zap.Field) {
+	if !l.wants(matches.IDs) {
+		return
+	}
+	if result == "dropped" {
+		result = matches.dropResult()
+	}
+	l.logger.Debug("Policy decision", append([]zap.Field{
+		zap.String("signal", signal),
+		zap.String("result", result),
+		zap.String("winner", matches.Winner),
+		zap.Strings("policy_ids", matches.IDs),
+	}, fields...)...)
+}
+
+func logDecisionFields(ctx LogContext) []zap.Field {
+	fields := []zap.Field{serviceNameField(ctx.Resource.Attributes())}
+	if traceID := ctx.Record.TraceID(); !traceID.IsEmpty() {
+		fields = append(fields, zap.String("trace_id", traceID.String()))
+	}
+	return fields
+}
+
+func spanDecisionFields(ctx TraceContext) []zap.Field {
+	return []zap.Field{
+		serviceNameField(ctx.Resource.Attributes()),
+		zap.String("trace_id", ctx.Span.TraceID().String()),
+		zap.String("span_id", ctx.Span.SpanID().String()),
+		zap.String("span_name", ctx.Span.Name()),
+	}
+}
+
+func metricDecisionFields(ctx MetricContext) []zap.Field {
+	return []zap.Field{
+		serviceNameField(ctx.Resource.Attributes()),
+		zap.String("metric_name", ctx.Metric.Name()),
+	}
+}
+
+func serviceNameField(resource pcommon.Map) zap.Field {
+	if v, ok := resource.Get(attrServiceName); ok {
+		return zap.String("service_name", v.AsString())
+	}
+	return zap.Skip()
+}
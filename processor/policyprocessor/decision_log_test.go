@@ -0,0 +1,130 @@
+package policyprocessor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	policyv1 "github.com/usetero/policy-go/proto/tero/policy/v1"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// withDecisionLog enables the decision log of p, returning its entries.
+func withDecisionLog(p *policyProcessor, cfg DecisionLogConfig) *observer.ObservedLogs {
+	core, logs := observer.New(zapcore.DebugLevel)
+	p.logger = zap.New(core)
+	cfg.Enabled = true
+	p.decisionLog = newDecisionLog(cfg, p.logger)
+	return logs
+}
+
+func TestDecisionLog_Logs(t *testing.T) {
+	p := createTestLogProcessor(t, []*policyv1.Policy{
+		logPolicy("drop-debug", "none", severityMatcher("DEBUG")),
+		logPolicy("drop-noisy", "none", resourceAttrMatcher("service.name", "noisy")),
+		removeKeyPolicy(),
+	})
+	entries := withDecisionLog(p, DecisionLogConfig{SamplingRate: 1, PolicyIDs: []string{"drop-debug", "drop-noisy"}})
+
+	logs := plog.NewLogs()
+	rl := logs.ResourceLogs().AppendEmpty()
+	rl.Resource().Attributes().PutStr("service.name", "checkout")
+	records := rl.ScopeLogs().AppendEmpty().LogRecords()
+	debug := records.AppendEmpty()
+	debug.SetSeverityText("DEBUG")
+	debug.SetTraceID(pcommon.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16})
+	records.AppendEmpty().SetSeverityText("INFO")
+	records.AppendEmpty().SetSeverityText("WARN")
+	// Records of this resource are settled without evaluating each one.
+	noisy := logs.ResourceLogs().AppendEmpty()
+	noisy.Resource().Attributes().PutStr("service.name", "noisy")
+	noisy.ScopeLogs().AppendEmpty().LogRecords().AppendEmpty().SetSeverityText("INFO")
+
+	_, err := p.processLogs(context.Background(), logs)
+	require.NoError(t, err)
+
+	require.Equal(t, 2, entries.Len())
+	for _, entry := range entries.All() {
+		assert.Equal(t, zapcore.DebugLevel, entry.Level)
+		assert.Equal(t, "Policy decision", entry.Message)
+	}
+	assert.Equal(t, map[string]any{
+		"signal":       "logs",
+		"result":       "dropped",
+		"winner":       "drop-debug",
+		"policy_ids":   []any{"drop-debug"},
+		"service_name": "checkout",
+		"trace_id":     "0102030405060708090a0b0c0d0e0f10",
+	}, entries.All()[0].ContextMap())
+	assert.Equal(t, map[string]any{
+		"signal":       "logs",
+		"result":       "dropped",
+		"winner":       "drop-noisy",
+		"policy_ids":   []any{"drop-noisy"},
+		"service_name": "noisy",
+	}, entries.All()[1].ContextMap())
+}
+
+func TestDecisionLog_Metrics(t *testing.T) {
+	p := createTestMetricProcessor(t, []*policyv1.Policy{{
+		Id:      "drop-options",
+		Enabled: true,
+		Target: &policyv1.Policy_Metric{Metric: &policyv1.MetricTarget{
+			Match: []*policyv1.MetricMatcher{{
+				Field: &policyv1.MetricMatcher_DatapointAttribute{DatapointAttribute: &policyv1.AttributePath{Path: []string{"http.method"}}},
+				Match: &policyv1.MetricMatcher_Exact{Exact: "OPTIONS"},
+			}},
+		}},
+	}})
+	entries := withDecisionLog(p, DecisionLogConfig{SamplingRate: 1})
+
+	md := pmetric.NewMetrics()
+	m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName("http.requests")
+	gauge := m.SetEmptyGauge()
+	gauge.DataPoints().AppendEmpty().Attributes().PutStr("http.method", "GET")
+	gauge.DataPoints().AppendEmpty().Attributes().PutStr("http.method", "OPTIONS")
+
+	_, err := p.processMetrics(context.Background(), md)
+	require.NoError(t, err)
+
+	// Without a policy filter, records no policy matched are logged too.
+	require.Equal(t, 2, entries.Len())
+	assert.Equal(t, "no_match", entries.All()[0].ContextMap()["result"])
+	assert.Equal(t, map[string]any{
+		"signal":      "metrics",
+		"result":      "dropped",
+		"winner":      "drop-options",
+		"policy_ids":  []any{"drop-options"},
+		"metric_name": "http.requests",
+	}, entries.All()[1].ContextMap())
+}
+
+func TestDecisionLog_Sampling(t *testing.T) {
+	core, _ := observer.New(zapcore.DebugLevel)
+	l := newDecisionLog(DecisionLogConfig{Enabled: true, SamplingRate: 0.25}, zap.New(core))
+	draws := []float64{0.1, 0.3, 0.24, 0.9}
+	l.random = func() float64 {
+		v := draws[0]
+		draws = draws[1:]
+		return v
+	}
+	var sampled []bool
+	for range 4 {
+		sampled = append(sampled, l.sample())
+	}
+	assert.Equal(t, []bool{true, false, true, false}, sampled)
+
+	// Decisions are only logged when the collector logs at debug level.
+	core, logs := observer.New(zapcore.InfoLevel)
+	assert.Nil(t, newDecisionLog(DecisionLogConfig{Enabled: true}, zap.New(core)))
+	assert.Equal(t, 1, logs.Len())
+	assert.Nil(t, newDecisionLog(DecisionLogConfig{}, zap.New(core)))
+	assert.False(t, (*decisionLog)(nil).sample())
+}
@@ -44,6 +44,37 @@ func createTracesProcessor(
 		return nil, err
 	}
 	proc := newPolicyProcessor(set.Logger, pcfg, telemetry, set.Resource)
+	if pcfg.TailSampling.Enabled {
+		proc.tail = newTailSampler(pcfg.TailSampling)
+		proc.tail.decide = proc.decideTrace
+		proc.nextTraces = nextConsumer
+	}
+	if pcfg.DroppedSpanMetrics.Enabled {
+		proc.droppedSpans = droppedSpanSummaries.acquire(set.ID, func() *droppedSpanSummary {
+			return newDroppedSpanSummary(pcfg.DroppedSpanMetrics)
+		})
+		proc.droppedSpansID = set.ID
+		if proc.tail != nil {
+			proc.tail.dropped = proc.recordDroppedSpan
+		}
+	}
+	if pcfg.TraceDecisions.Enabled {
+		proc.traceDecisions = traceDecisionCaches.acquire(set.ID, func() *traceDecisionCache {
+			return newTraceDecisionCache(pcfg.TraceDecisions)
+		})
+		proc.traceDecisionsID = set.ID
+	}
+
+	proc.pipeline = "traces"
+	if pcfg.Telemetry.Tracing {
+		proc.tracer = metadata.Tracer(set.TelemetrySettings)
+	}
+	if pcfg.Debug.Endpoint != "" {
+		proc.debug = debugServers.acquire(set.ID, func() *debugServer {
+			return newDebugServer(pcfg.Debug, set.Logger)
+		})
+		proc.debugID = set.ID
+	}
 
 	return processorhelper.NewTraces(
 		ctx,
@@ -69,6 +100,37 @@ func createMetricsProcessor(
 		return nil, err
 	}
 	proc := newPolicyProcessor(set.Logger, pcfg, telemetry, set.Resource)
+	if proc.cardinality != nil {
+		if err := telemetry.RegisterProcessorPolicyMetricSeriesCallback(proc.cardinality.observe); err != nil {
+			return nil, err
+		}
+	}
+	if pcfg.DroppedLogMetrics.Enabled {
+		proc.droppedLogs = droppedLogSummaries.acquire(set.ID, func() *droppedLogSummary {
+			return newDroppedLogSummary(pcfg.DroppedLogMetrics)
+		})
+		proc.droppedLogsID = set.ID
+	}
+	proc.nextMetrics = nextConsumer
+	metricsInstances.acquire(set.ID, func() struct{} { return struct{}{} })
+	proc.metricsID = set.ID
+	if pcfg.DroppedSpanMetrics.Enabled {
+		proc.droppedSpans = droppedSpanSummaries.acquire(set.ID, func() *droppedSpanSummary {
+			return newDroppedSpanSummary(pcfg.DroppedSpanMetrics)
+		})
+		proc.droppedSpansID = set.ID
+	}
+
+	proc.pipeline = "metrics"
+	if pcfg.Telemetry.Tracing {
+		proc.tracer = metadata.Tracer(set.TelemetrySettings)
+	}
+	if pcfg.Debug.Endpoint != "" {
+		proc.debug = debugServers.acquire(set.ID, func() *debugServer {
+			return newDebugServer(pcfg.Debug, set.Logger)
+		})
+		proc.debugID = set.ID
+	}
 
 	return processorhelper.NewMetrics(
 		ctx,
@@ -94,6 +156,30 @@ func createLogsProcessor(
 		return nil, err
 	}
 	proc := newPolicyProcessor(set.Logger, pcfg, telemetry, set.Resource)
+	if pcfg.DroppedLogMetrics.Enabled {
+		proc.droppedLogs = droppedLogSummaries.acquire(set.ID, func() *droppedLogSummary {
+			return newDroppedLogSummary(pcfg.DroppedLogMetrics)
+		})
+		proc.droppedLogsID = set.ID
+	}
+	if pcfg.TraceDecisions.Enabled {
+		proc.traceDecisions = traceDecisionCaches.acquire(set.ID, func() *traceDecisionCache {
+			return newTraceDecisionCache(pcfg.TraceDecisions)
+		})
+		proc.traceDecisionsID = set.ID
+	}
+	proc.nextLogs = nextConsumer
+
+	proc.pipeline = "logs"
+	if pcfg.Telemetry.Tracing {
+		proc.tracer = metadata.Tracer(set.TelemetrySettings)
+	}
+	if pcfg.Debug.Endpoint != "" {
+		proc.debug = debugServers.acquire(set.ID, func() *debugServer {
+			return newDebugServer(pcfg.Debug, set.Logger)
+		})
+		proc.debugID = set.ID
+	}
 
 	return processorhelper.NewLogs(
 		ctx,
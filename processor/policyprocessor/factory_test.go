@@ -131,6 +131,48 @@ func TestCreateLogsProcessor(t *testing.T) {
 	}
 }
 
+func TestStart_SummariesRequireMetricsPipeline(t *testing.T) {
+	factory := NewFactory()
+	cfg := factory.CreateDefaultConfig().(*Config)
+	cfg.Providers = testProviders()
+	cfg.DroppedLogMetrics.Enabled = true
+	cfg.DroppedSpanMetrics.Enabled = true
+
+	ctx := context.Background()
+	set := processortest.NewNopSettings(testType)
+	set.ID = component.MustNewIDWithName("policy", "summaries")
+
+	logs, err := factory.CreateLogs(ctx, set, cfg, consumertest.NewNop())
+	if err != nil {
+		t.Fatalf("CreateLogs() error: %v", err)
+	}
+	if err := logs.Start(ctx, componenttest.NewNopHost()); err == nil {
+		t.Fatal("Start() succeeded without a metrics instance to emit dropped log metrics")
+	}
+	traces, err := factory.CreateTraces(ctx, set, cfg, consumertest.NewNop())
+	if err != nil {
+		t.Fatalf("CreateTraces() error: %v", err)
+	}
+	if err := traces.Start(ctx, componenttest.NewNopHost()); err == nil {
+		t.Fatal("Start() succeeded without a metrics instance to emit dropped span metrics")
+	}
+
+	metrics, err := factory.CreateMetrics(ctx, set, cfg, consumertest.NewNop())
+	if err != nil {
+		t.Fatalf("CreateMetrics() error: %v", err)
+	}
+	for _, proc := range []component.Component{logs, traces, metrics} {
+		if err := proc.Start(ctx, componenttest.NewNopHost()); err != nil {
+			t.Fatalf("Start() error: %v", err)
+		}
+	}
+	for _, proc := range []component.Component{logs, traces, metrics} {
+		if err := proc.Shutdown(ctx); err != nil {
+			t.Fatalf("Shutdown() error: %v", err)
+		}
+	}
+}
+
 func TestProcessTraces(t *testing.T) {
 	factory := NewFactory()
 	cfg := factory.CreateDefaultConfig().(*Config)
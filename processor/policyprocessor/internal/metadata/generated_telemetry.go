@@ -3,10 +3,12 @@
 package metadata
 
 import (
+	"context"
 	"errors"
 	"sync"
 
 	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/embedded"
 	"go.opentelemetry.io/otel/trace"
 
 	"go.opentelemetry.io/collector/component"
@@ -23,10 +25,22 @@ func Tracer(settings component.TelemetrySettings) trace.Tracer {
 // TelemetryBuilder provides an interface for components to report telemetry
 // as defined in metadata and user config.
 type TelemetryBuilder struct {
-	meter                  metric.Meter
-	mu                     sync.Mutex
-	registrations          []metric.Registration
-	ProcessorPolicyRecords metric.Int64Counter
+	meter                                   metric.Meter
+	mu                                      sync.Mutex
+	registrations                           []metric.Registration
+	ProcessorPolicyBytes                    metric.Int64Counter
+	ProcessorPolicyBytesSaved               metric.Int64Counter
+	ProcessorPolicyEvaluationDuration       metric.Float64Histogram
+	ProcessorPolicyMetricSeries             metric.Int64ObservableGauge
+	ProcessorPolicyProviderCompileErrors    metric.Int64Counter
+	ProcessorPolicyProviderLastSync         metric.Int64ObservableGauge
+	ProcessorPolicyProviderPolicies         metric.Int64ObservableGauge
+	ProcessorPolicyProviderSyncErrors       metric.Int64Counter
+	ProcessorPolicyRecordEvaluationDuration metric.Float64Histogram
+	ProcessorPolicyRecords                  metric.Int64Counter
+	ProcessorPolicyTailEvictedTraces        metric.Int64Counter
+	ProcessorPolicyTailLateSpans            metric.Int64Counter
+	ProcessorPolicyTransforms               metric.Int64ObservableCounter
 }
 
 // TelemetryBuilderOption applies changes to default builder.
@@ -40,6 +54,76 @@ func (tbof telemetryBuilderOptionFunc) apply(mb *TelemetryBuilder) {
 	tbof(mb)
 }
 
+// RegisterProcessorPolicyMetricSeriesCallback sets callback for observable ProcessorPolicyMetricSeries metric.
+func (builder *TelemetryBuilder) RegisterProcessorPolicyMetricSeriesCallback(cb metric.Int64Callback) error {
+	reg, err := builder.meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		cb(ctx, &observerInt64{inst: builder.ProcessorPolicyMetricSeries, obs: o})
+		return nil
+	}, builder.ProcessorPolicyMetricSeries)
+	if err != nil {
+		return err
+	}
+	builder.mu.Lock()
+	defer builder.mu.Unlock()
+	builder.registrations = append(builder.registrations, reg)
+	return nil
+}
+
+// RegisterProcessorPolicyProviderLastSyncCallback sets callback for observable ProcessorPolicyProviderLastSync metric.
+func (builder *TelemetryBuilder) RegisterProcessorPolicyProviderLastSyncCallback(cb metric.Int64Callback) error {
+	reg, err := builder.meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		cb(ctx, &observerInt64{inst: builder.ProcessorPolicyProviderLastSync, obs: o})
+		return nil
+	}, builder.ProcessorPolicyProviderLastSync)
+	if err != nil {
+		return err
+	}
+	builder.mu.Lock()
+	defer builder.mu.Unlock()
+	builder.registrations = append(builder.registrations, reg)
+	return nil
+}
+
+// RegisterProcessorPolicyProviderPoliciesCallback sets callback for observable ProcessorPolicyProviderPolicies metric.
+func (builder *TelemetryBuilder) RegisterProcessorPolicyProviderPoliciesCallback(cb metric.Int64Callback) error {
+	reg, err := builder.meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		cb(ctx, &observerInt64{inst: builder.ProcessorPolicyProviderPolicies, obs: o})
+		return nil
+	}, builder.ProcessorPolicyProviderPolicies)
+	if err != nil {
+		return err
+	}
+	builder.mu.Lock()
+	defer builder.mu.Unlock()
+	builder.registrations = append(builder.registrations, reg)
+	return nil
+}
+
+// RegisterProcessorPolicyTransformsCallback sets callback for observable ProcessorPolicyTransforms metric.
+func (builder *TelemetryBuilder) RegisterProcessorPolicyTransformsCallback(cb metric.Int64Callback) error {
+	reg, err := builder.meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		cb(ctx, &observerInt64{inst: builder.ProcessorPolicyTransforms, obs: o})
+		return nil
+	}, builder.ProcessorPolicyTransforms)
+	if err != nil {
+		return err
+	}
+	builder.mu.Lock()
+	defer builder.mu.Unlock()
+	builder.registrations = append(builder.registrations, reg)
+	return nil
+}
+
+type observerInt64 struct {
+	embedded.Int64Observer
+	inst metric.Int64Observable
+	obs  metric.Observer
+}
+
+func (oi *observerInt64) Observe(value int64, opts ...metric.ObserveOption) {
+	oi.obs.ObserveInt64(oi.inst, value, opts...)
+}
+
 // Shutdown unregister all registered callbacks for async instruments.
 func (builder *TelemetryBuilder) Shutdown() {
 	builder.mu.Lock()
@@ -58,11 +142,85 @@ func NewTelemetryBuilder(settings component.TelemetrySettings, options ...Teleme
 	}
 	builder.meter = Meter(settings)
 	var err, errs error
+	builder.ProcessorPolicyBytes, err = builder.meter.Int64Counter(
+		"otelcol_processor_policy_bytes",
+		metric.WithDescription("Estimated OTLP size of telemetry records processed by the policy processor [Development]"),
+		metric.WithUnit("By"),
+	)
+	errs = errors.Join(errs, err)
+	builder.ProcessorPolicyBytesSaved, err = builder.meter.Int64Counter(
+		"otelcol_processor_policy_bytes_saved",
+		metric.WithDescription("Estimated OTLP size of telemetry records removed or reduced by the policy processor [Development]"),
+		metric.WithUnit("By"),
+	)
+	errs = errors.Join(errs, err)
+	builder.ProcessorPolicyEvaluationDuration, err = builder.meter.Float64Histogram(
+		"otelcol_processor_policy_evaluation_duration",
+		metric.WithDescription("Duration of processing a batch of telemetry records [Development]"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries([]float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}...),
+	)
+	errs = errors.Join(errs, err)
+	builder.ProcessorPolicyMetricSeries, err = builder.meter.Int64ObservableGauge(
+		"otelcol_processor_policy_metric_series",
+		metric.WithDescription("Number of distinct metric series tracked by a cardinality limit [Development]"),
+		metric.WithUnit("{series}"),
+	)
+	errs = errors.Join(errs, err)
+	builder.ProcessorPolicyProviderCompileErrors, err = builder.meter.Int64Counter(
+		"otelcol_processor_policy_provider_compile_errors",
+		metric.WithDescription("Number of policies from a policy provider that failed to compile [Development]"),
+		metric.WithUnit("1"),
+	)
+	errs = errors.Join(errs, err)
+	builder.ProcessorPolicyProviderLastSync, err = builder.meter.Int64ObservableGauge(
+		"otelcol_processor_policy_provider_last_sync",
+		metric.WithDescription("Time of the last successful sync of a policy provider, in seconds since the Unix epoch [Development]"),
+		metric.WithUnit("s"),
+	)
+	errs = errors.Join(errs, err)
+	builder.ProcessorPolicyProviderPolicies, err = builder.meter.Int64ObservableGauge(
+		"otelcol_processor_policy_provider_policies",
+		metric.WithDescription("Number of active policies loaded from a policy provider [Development]"),
+		metric.WithUnit("{policies}"),
+	)
+	errs = errors.Join(errs, err)
+	builder.ProcessorPolicyProviderSyncErrors, err = builder.meter.Int64Counter(
+		"otelcol_processor_policy_provider_sync_errors",
+		metric.WithDescription("Number of failed syncs of a policy provider [Development]"),
+		metric.WithUnit("1"),
+	)
+	errs = errors.Join(errs, err)
+	builder.ProcessorPolicyRecordEvaluationDuration, err = builder.meter.Float64Histogram(
+		"otelcol_processor_policy_record_evaluation_duration",
+		metric.WithDescription("Duration of evaluating a single telemetry record against the policies [Development]"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries([]float64{1e-06, 2.5e-06, 5e-06, 1e-05, 2.5e-05, 5e-05, 0.0001, 0.00025, 0.0005, 0.001}...),
+	)
+	errs = errors.Join(errs, err)
 	builder.ProcessorPolicyRecords, err = builder.meter.Int64Counter(
 		"otelcol_processor_policy_records",
 		metric.WithDescription("Number of telemetry records processed by the policy processor [Development]"),
 		metric.WithUnit("1"),
 	)
 	errs = errors.Join(errs, err)
+	builder.ProcessorPolicyTailEvictedTraces, err = builder.meter.Int64Counter(
+		"otelcol_processor_policy_tail_evicted_traces",
+		metric.WithDescription("Number of buffered traces decided before their decision wait elapsed because the buffer was full or the trace reached the span limit [Development]"),
+		metric.WithUnit("1"),
+	)
+	errs = errors.Join(errs, err)
+	builder.ProcessorPolicyTailLateSpans, err = builder.meter.Int64Counter(
+		"otelcol_processor_policy_tail_late_spans",
+		metric.WithDescription("Number of spans that arrived after their trace was decided [Development]"),
+		metric.WithUnit("1"),
+	)
+	errs = errors.Join(errs, err)
+	builder.ProcessorPolicyTransforms, err = builder.meter.Int64ObservableCounter(
+		"otelcol_processor_policy_transforms",
+		metric.WithDescription("Number of transform operations policies applied to telemetry records [Development]"),
+		metric.WithUnit("{operations}"),
+	)
+	errs = errors.Join(errs, err)
 	return &builder, errs
 }
@@ -0,0 +1,457 @@
+package policyprocessor
+
+import (
+	"context"
+	"hash/fnv"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.uber.org/zap"
+)
+
+// Attributes set on the surviving record of a collapsed group.
+const (
+	attrLogRecordCount     = "log.record.count"
+	attrLogRecordFirstSeen = "log.record.first_seen"
+	attrLogRecordLastSeen  = "log.record.last_seen"
+)
+
+// maxDedupEntries bounds the number of distinct messages tracked across all
+// resources. Expired entries are pruned first; new messages beyond the cap
+// pass through without deduplication.
+const maxDedupEntries = 10000
+
+// maxDedupBytes bounds the estimated size of the representative records and
+// resources held for final summaries, as the cap on entries alone does not
+// bound memory when messages are large. New messages beyond it pass through
+// without deduplication.
+const maxDedupBytes = 32 << 20
+
+// dedupRule is the compiled form of a DedupConfig.
+type dedupRule struct {
+	policyID   string
+	window     time.Duration
+	attributes []string
+}
+
+// dedupHash identifies a message, or a resource, by the FNV-128a hash of its
+// key, so tracked state does not grow with the size of the messages.
+type dedupHash [16]byte
+
+func newDedupHash(key string) dedupHash {
+	h := fnv.New128a()
+	h.Write([]byte(key))
+	var sum dedupHash
+	h.Sum(sum[:0])
+	return sum
+}
+
+// dedupEntry tracks a message across batches for the current window.
+type dedupEntry struct {
+	window      time.Duration
+	windowStart time.Time
+	// suppressed counts duplicates dropped in later batches of the window.
+	// They are reported on the first record of the next window, or in a
+	// final summary once the message stops arriving.
+	suppressed int64
+	firstSeen  pcommon.Timestamp
+	lastSeen   pcommon.Timestamp
+
+	// resource is the resource the message was seen in.
+	resource *dedupResource
+	// record is a copy of the first record of the message, without the
+	// attributes added to survivors, carried by the final summary together
+	// with its scope.
+	record         plog.LogRecord
+	scope          pcommon.InstrumentationScope
+	scopeSchemaURL string
+	size           int
+}
+
+// dedupResource holds the messages tracked for a resource.
+type dedupResource struct {
+	resource  pcommon.Resource
+	schemaURL string
+	size      int
+	msgs      map[dedupHash]*dedupEntry
+}
+
+// deduplicator collapses repeated log records matching a dedup policy. State
+// is keyed by resource and then by message, and is shared by all batches
+// flowing through the processor. It is safe for concurrent use.
+type deduplicator struct {
+	rules []dedupRule
+	now   func() time.Time
+
+	mu        sync.Mutex
+	resources map[dedupHash]*dedupResource
+	size      int
+	bytes     int
+	// expired holds pruned entries with suppressed duplicates until the next
+	// flush reports them.
+	expired []*dedupEntry
+	// closed is set at shutdown, after which records are no longer
+	// suppressed since nothing would report them.
+	closed bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newDeduplicator returns nil when no dedup rules are configured so callers
+// can skip policy matching entirely.
+func newDeduplicator(cfgs []DedupConfig) *deduplicator {
+	if len(cfgs) == 0 {
+		return nil
+	}
+	d := &deduplicator{
+		rules:     make([]dedupRule, len(cfgs)),
+		now:       time.Now,
+		resources: make(map[dedupHash]*dedupResource),
+	}
+	for i, cfg := range cfgs {
+		d.rules[i] = dedupRule{
+			policyID:   cfg.PolicyID,
+			window:     cfg.Window,
+			attributes: cfg.Attributes,
+		}
+	}
+	return d
+}
+
+// rule returns the first configured rule whose policy is among policyIDs.
+func (d *deduplicator) rule(policyIDs []string) *dedupRule {
+	for i := range d.rules {
+		if slices.Contains(policyIDs, d.rules[i].policyID) {
+			return &d.rules[i]
+		}
+	}
+	return nil
+}
+
+// minWindow returns the shortest window of any rule.
+func (d *deduplicator) minWindow() time.Duration {
+	minWindow := d.rules[0].window
+	for _, r := range d.rules[1:] {
+		minWindow = min(minWindow, r.window)
+	}
+	return minWindow
+}
+
+// dedupSurvivor is the first record of a group within a batch.
+type dedupSurvivor struct {
+	record    plog.LogRecord
+	count     int64
+	firstSeen pcommon.Timestamp
+	lastSeen  pcommon.Timestamp
+}
+
+// dedupBatchKey identifies a message of a resource within a batch.
+type dedupBatchKey struct {
+	resource, msg dedupHash
+}
+
+// dedupBatch holds the survivors of a single processLogs call. Counts are
+// written to the surviving records when the batch finishes. collapse is safe
+// for concurrent use by the workers evaluating the batch.
+type dedupBatch struct {
+	d *deduplicator
+
+	mu        sync.Mutex
+	survivors map[dedupBatchKey]*dedupSurvivor
+}
+
+func (d *deduplicator) newBatch() *dedupBatch {
+	return &dedupBatch{d: d, survivors: make(map[dedupBatchKey]*dedupSurvivor)}
+}
+
+// collapse reports whether the record is a duplicate that should be dropped.
+// The first record of each message in a window survives and accumulates the
+// counts of its duplicates.
+func (b *dedupBatch) collapse(policyIDs []string, ctx LogContext, resourceKey string) bool {
+	rule := b.d.rule(policyIDs)
+	if rule == nil {
+		return false
+	}
+	key := dedupBatchKey{resource: newDedupHash(resourceKey), msg: dedupMessageKey(rule, ctx.Record)}
+	ts := dedupTimestamp(ctx.Record)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if s, ok := b.survivors[key]; ok {
+		s.count++
+		s.firstSeen = min(s.firstSeen, ts)
+		s.lastSeen = max(s.lastSeen, ts)
+		return true
+	}
+
+	d := b.d
+	now := d.now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return false
+	}
+	res := d.resources[key.resource]
+	var e *dedupEntry
+	if res != nil {
+		e = res.msgs[key.msg]
+	}
+	if e != nil && now.Sub(e.windowStart) < rule.window {
+		e.suppressed++
+		if e.firstSeen == 0 || ts < e.firstSeen {
+			e.firstSeen = ts
+		}
+		e.lastSeen = max(e.lastSeen, ts)
+		return true
+	}
+
+	s := &dedupSurvivor{record: ctx.Record, count: 1, firstSeen: ts, lastSeen: ts}
+	if e != nil {
+		// The previous window expired; report what it suppressed.
+		s.count += e.suppressed
+		if e.suppressed > 0 {
+			s.firstSeen = min(s.firstSeen, e.firstSeen)
+		}
+		e.windowStart, e.suppressed, e.firstSeen, e.lastSeen = now, 0, 0, 0
+	} else {
+		recordSize := logRecordSize(ctx.Record) + sizeAttributes(ctx.Scope.Attributes())
+		resourceSize := sizeAttributes(ctx.Resource.Attributes())
+		if d.full(res == nil, recordSize, resourceSize) {
+			d.pruneLocked(now)
+			res = d.resources[key.resource]
+			if d.full(res == nil, recordSize, resourceSize) {
+				return false
+			}
+		}
+		if res == nil {
+			res = &dedupResource{
+				resource:  pcommon.NewResource(),
+				schemaURL: ctx.ResourceSchemaURL,
+				size:      resourceSize,
+				msgs:      make(map[dedupHash]*dedupEntry),
+			}
+			ctx.Resource.CopyTo(res.resource)
+			d.resources[key.resource] = res
+			d.bytes += resourceSize
+		}
+		e = &dedupEntry{
+			window:         rule.window,
+			windowStart:    now,
+			resource:       res,
+			record:         plog.NewLogRecord(),
+			scope:          pcommon.NewInstrumentationScope(),
+			scopeSchemaURL: ctx.ScopeSchemaURL,
+			size:           recordSize,
+		}
+		ctx.Record.CopyTo(e.record)
+		ctx.Scope.CopyTo(e.scope)
+		res.msgs[key.msg] = e
+		d.size++
+		d.bytes += recordSize
+	}
+	b.survivors[key] = s
+	return false
+}
+
+// full reports whether a new entry of recordSize, in a new resource of
+// resourceSize when newResource is set, exceeds the bounds of the state.
+// INVARIANT: d.mu MUST be held by the caller.
+func (d *deduplicator) full(newResource bool, recordSize, resourceSize int) bool {
+	size := recordSize
+	if newResource {
+		size += resourceSize
+	}
+	return d.size >= maxDedupEntries || d.bytes+size > maxDedupBytes
+}
+
+// pruneLocked forgets entries whose window has expired. Duplicates they
+// suppressed are kept for the next flush.
+// INVARIANT: d.mu MUST be held by the caller.
+func (d *deduplicator) pruneLocked(now time.Time) {
+	for resourceKey, res := range d.resources {
+		for msgKey, e := range res.msgs {
+			if now.Sub(e.windowStart) >= e.window {
+				d.forgetLocked(resourceKey, msgKey, e)
+			}
+		}
+	}
+}
+
+// forgetLocked removes an entry, keeping it for the next flush when it
+// suppressed duplicates, and its resource once it has no entries left.
+// INVARIANT: d.mu MUST be held by the caller.
+func (d *deduplicator) forgetLocked(resourceKey, msgKey dedupHash, e *dedupEntry) {
+	if e.suppressed > 0 {
+		d.expired = append(d.expired, e)
+	}
+	res := e.resource
+	delete(res.msgs, msgKey)
+	d.size--
+	d.bytes -= e.size
+	if len(res.msgs) == 0 {
+		delete(d.resources, resourceKey)
+		d.bytes -= res.size
+	}
+}
+
+// finish annotates every survivor that absorbed duplicates.
+func (b *dedupBatch) finish() {
+	for _, s := range b.survivors {
+		if s.count < 2 {
+			continue
+		}
+		annotateDedupCount(s.record, s.count, s.firstSeen, s.lastSeen)
+	}
+}
+
+func annotateDedupCount(lr plog.LogRecord, count int64, firstSeen, lastSeen pcommon.Timestamp) {
+	attrs := lr.Attributes()
+	attrs.PutInt(attrLogRecordCount, count)
+	attrs.PutInt(attrLogRecordFirstSeen, int64(firstSeen))
+	attrs.PutInt(attrLogRecordLastSeen, int64(lastSeen))
+}
+
+// flush returns a final summary record for every message whose window
+// expired with suppressed duplicates, forgetting those messages. With all
+// set, as at shutdown, the duplicates suppressed in every window still open
+// are reported too. Each summary is a copy of the first record of the message
+// counting only the suppressed duplicates.
+func (d *deduplicator) flush(all bool) plog.Logs {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if all {
+		for resourceKey, res := range d.resources {
+			for msgKey, e := range res.msgs {
+				d.forgetLocked(resourceKey, msgKey, e)
+			}
+		}
+	} else {
+		d.pruneLocked(d.now())
+	}
+
+	ld := plog.NewLogs()
+	resources := make(map[*dedupResource]plog.ScopeLogsSlice)
+	for _, e := range d.expired {
+		sls, ok := resources[e.resource]
+		if !ok {
+			rl := ld.ResourceLogs().AppendEmpty()
+			e.resource.resource.CopyTo(rl.Resource())
+			rl.SetSchemaUrl(e.resource.schemaURL)
+			sls = rl.ScopeLogs()
+			resources[e.resource] = sls
+		}
+		sl := sls.AppendEmpty()
+		e.scope.CopyTo(sl.Scope())
+		sl.SetSchemaUrl(e.scopeSchemaURL)
+		lr := sl.LogRecords().AppendEmpty()
+		e.record.CopyTo(lr)
+		annotateDedupCount(lr, e.suppressed, e.firstSeen, e.lastSeen)
+	}
+	d.expired = nil
+	return ld
+}
+
+// dedupMessageKey identifies a message by its body and the rule's key
+// attributes.
+func dedupMessageKey(rule *dedupRule, lr plog.LogRecord) dedupHash {
+	h := fnv.New128a()
+	h.Write([]byte(lr.Body().AsString()))
+	for _, name := range rule.attributes {
+		h.Write([]byte{0})
+		if v, ok := lr.Attributes().Get(name); ok {
+			h.Write([]byte(v.AsString()))
+		}
+	}
+	var sum dedupHash
+	h.Sum(sum[:0])
+	return sum
+}
+
+// startDedupFlush forwards final dedup summaries to the next logs consumer
+// in the background, until shutdown.
+func (p *policyProcessor) startDedupFlush() {
+	d := p.deduplicator
+	d.stop = make(chan struct{})
+	d.done = make(chan struct{})
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(d.minWindow())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.stop:
+				return
+			case <-ticker.C:
+				p.flushDedup(context.Background(), false)
+			}
+		}
+	}()
+}
+
+// stopDedupFlush stops the flush loop and reports every suppressed
+// duplicate.
+func (p *policyProcessor) stopDedupFlush(ctx context.Context) {
+	d := p.deduplicator
+	if d.stop != nil {
+		close(d.stop)
+		<-d.done
+		d.stop = nil
+	}
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+	p.flushDedup(ctx, true)
+}
+
+// flushDedup forwards the final dedup summaries to the next logs consumer.
+func (p *policyProcessor) flushDedup(ctx context.Context, all bool) {
+	ld := p.deduplicator.flush(all)
+	if ld.ResourceLogs().Len() == 0 {
+		return
+	}
+	if err := p.nextLogs.ConsumeLogs(ctx, ld); err != nil {
+		p.logger.Error("Failed to emit deduplicated log summaries", zap.Error(err))
+	}
+}
+
+// dedupResourceKey identifies a resource by its sorted attributes.
+func dedupResourceKey(resource pcommon.Resource) string {
+	return attributesKey(resource.Attributes())
+}
+
+// attributesKey identifies an attribute set independently of its order.
+func attributesKey(attrs pcommon.Map) string {
+	keys := make([]string, 0, attrs.Len())
+	attrs.Range(func(k string, _ pcommon.Value) bool {
+		keys = append(keys, k)
+		return true
+	})
+	slices.Sort(keys)
+
+	var key strings.Builder
+	for _, k := range keys {
+		v, _ := attrs.Get(k)
+		key.WriteString(k)
+		key.WriteByte('=')
+		key.WriteString(v.AsString())
+		key.WriteByte(0)
+	}
+	return key.String()
+}
+
+// dedupTimestamp returns the record's timestamp, falling back to its observed
+// timestamp.
+func dedupTimestamp(lr plog.LogRecord) pcommon.Timestamp {
+	if ts := lr.Timestamp(); ts != 0 {
+		return ts
+	}
+	return lr.ObservedTimestamp()
+}
@@ -0,0 +1,219 @@
+package policyprocessor
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+)
+
+func newTestDeduplicator(cfgs []DedupConfig, now *time.Time) *deduplicator {
+	d := newDeduplicator(cfgs)
+	d.now = func() time.Time { return *now }
+	return d
+}
+
+func newDedupRecord(body string, ts int64) LogContext {
+	lr := plog.NewLogRecord()
+	lr.Body().SetStr(body)
+	lr.SetTimestamp(pcommon.Timestamp(ts))
+	return LogContext{Record: lr, Resource: pcommon.NewResource(), Scope: pcommon.NewInstrumentationScope()}
+}
+
+func recordInt(t *testing.T, lr plog.LogRecord, key string) int64 {
+	t.Helper()
+	v, ok := lr.Attributes().Get(key)
+	require.True(t, ok, "missing attribute %s", key)
+	return v.Int()
+}
+
+func TestNewDeduplicator_NoRules(t *testing.T) {
+	assert.Nil(t, newDeduplicator(nil))
+}
+
+func TestDeduplicator_CollapsesWithinBatch(t *testing.T) {
+	now := time.Unix(1000, 0)
+	d := newTestDeduplicator([]DedupConfig{{PolicyID: "crashloop", Window: time.Minute}}, &now)
+	ids := []string{"crashloop"}
+
+	b := d.newBatch()
+	first := newDedupRecord("panic: nil map", 300)
+	assert.False(t, b.collapse(ids, first, ""))
+	assert.True(t, b.collapse(ids, newDedupRecord("panic: nil map", 100), ""))
+	assert.True(t, b.collapse(ids, newDedupRecord("panic: nil map", 500), ""))
+	single := newDedupRecord("starting", 200)
+	assert.False(t, b.collapse(ids, single, ""))
+	b.finish()
+
+	assert.Equal(t, int64(3), recordInt(t, first.Record, attrLogRecordCount))
+	assert.Equal(t, int64(100), recordInt(t, first.Record, attrLogRecordFirstSeen))
+	assert.Equal(t, int64(500), recordInt(t, first.Record, attrLogRecordLastSeen))
+	_, ok := single.Record.Attributes().Get(attrLogRecordCount)
+	assert.False(t, ok, "records without duplicates are left untouched")
+}
+
+func TestDeduplicator_OnlyMatchingPolicies(t *testing.T) {
+	now := time.Unix(1000, 0)
+	d := newTestDeduplicator([]DedupConfig{{PolicyID: "crashloop", Window: time.Minute}}, &now)
+
+	b := d.newBatch()
+	assert.False(t, b.collapse([]string{"other"}, newDedupRecord("same", 1), ""))
+	assert.False(t, b.collapse([]string{"other"}, newDedupRecord("same", 2), ""))
+	assert.False(t, b.collapse(nil, newDedupRecord("same", 3), ""))
+}
+
+func TestDeduplicator_KeyAttributesAndResource(t *testing.T) {
+	now := time.Unix(1000, 0)
+	d := newTestDeduplicator([]DedupConfig{{PolicyID: "p", Window: time.Minute, Attributes: []string{"pod"}}}, &now)
+	ids := []string{"p"}
+
+	withPod := func(pod string) LogContext {
+		ctx := newDedupRecord("oops", 1)
+		ctx.Record.Attributes().PutStr("pod", pod)
+		ctx.Record.Attributes().PutStr("ignored", pod+"-x")
+		return ctx
+	}
+
+	b := d.newBatch()
+	assert.False(t, b.collapse(ids, withPod("a"), "res-1"))
+	assert.False(t, b.collapse(ids, withPod("b"), "res-1"), "different key attribute")
+	assert.True(t, b.collapse(ids, withPod("a"), "res-1"))
+	assert.False(t, b.collapse(ids, withPod("a"), "res-2"), "state is keyed by resource")
+}
+
+func TestDeduplicator_AcrossBatches(t *testing.T) {
+	now := time.Unix(1000, 0)
+	d := newTestDeduplicator([]DedupConfig{{PolicyID: "p", Window: time.Minute}}, &now)
+	ids := []string{"p"}
+
+	b := d.newBatch()
+	assert.False(t, b.collapse(ids, newDedupRecord("boom", 10), ""))
+	b.finish()
+
+	now = now.Add(30 * time.Second)
+	b = d.newBatch()
+	assert.True(t, b.collapse(ids, newDedupRecord("boom", 20), ""), "duplicate within the window")
+	assert.True(t, b.collapse(ids, newDedupRecord("boom", 30), ""))
+	b.finish()
+
+	now = now.Add(time.Minute)
+	b = d.newBatch()
+	next := newDedupRecord("boom", 40)
+	assert.False(t, b.collapse(ids, next, ""), "a new window starts")
+	b.finish()
+
+	assert.Equal(t, int64(3), recordInt(t, next.Record, attrLogRecordCount), "suppressed duplicates are reported on the next window")
+	assert.Equal(t, int64(20), recordInt(t, next.Record, attrLogRecordFirstSeen))
+	assert.Equal(t, int64(40), recordInt(t, next.Record, attrLogRecordLastSeen))
+}
+
+func TestDeduplicator_BoundedState(t *testing.T) {
+	now := time.Unix(1000, 0)
+	d := newTestDeduplicator([]DedupConfig{{PolicyID: "p", Window: time.Minute}}, &now)
+	ids := []string{"p"}
+
+	b := d.newBatch()
+	for i := range maxDedupEntries {
+		b.collapse(ids, newDedupRecord(strconv.Itoa(i), 1), "")
+	}
+	assert.Equal(t, maxDedupEntries, d.size)
+
+	b = d.newBatch()
+	assert.False(t, b.collapse(ids, newDedupRecord("new", 1), ""))
+	assert.False(t, b.collapse(ids, newDedupRecord("new", 1), ""), "new messages pass through while the state is full")
+	assert.Equal(t, maxDedupEntries, d.size)
+
+	now = now.Add(time.Minute)
+	b = d.newBatch()
+	assert.False(t, b.collapse(ids, newDedupRecord("new", 1), ""))
+	assert.Equal(t, 1, d.size, "expired entries are pruned")
+}
+
+func TestDeduplicator_BoundedBytes(t *testing.T) {
+	now := time.Unix(1000, 0)
+	d := newTestDeduplicator([]DedupConfig{{PolicyID: "p", Window: time.Minute}}, &now)
+	ids := []string{"p"}
+	large := strings.Repeat("x", maxDedupBytes/2+1)
+
+	b := d.newBatch()
+	assert.False(t, b.collapse(ids, newDedupRecord("a"+large, 1), ""))
+	assert.False(t, b.collapse(ids, newDedupRecord("b"+large, 1), ""))
+	b.finish()
+	assert.Equal(t, 1, d.size, "messages beyond the byte bound are not tracked")
+	assert.LessOrEqual(t, d.bytes, maxDedupBytes)
+
+	b = d.newBatch()
+	assert.False(t, b.collapse(ids, newDedupRecord("b"+large, 2), ""), "untracked messages pass through")
+	assert.True(t, b.collapse(ids, newDedupRecord("a"+large, 2), ""))
+}
+
+func TestDeduplicator_FlushesStoppedMessages(t *testing.T) {
+	now := time.Unix(1000, 0)
+	d := newTestDeduplicator([]DedupConfig{{PolicyID: "p", Window: time.Minute}}, &now)
+	ids := []string{"p"}
+
+	b := d.newBatch()
+	first := newDedupRecord("boom", 10)
+	first.Resource.Attributes().PutStr("service.name", "cart")
+	first.Scope.SetName("lib")
+	assert.False(t, b.collapse(ids, first, "cart"))
+	b.finish()
+
+	now = now.Add(30 * time.Second)
+	b = d.newBatch()
+	assert.True(t, b.collapse(ids, newDedupRecord("boom", 20), "cart"))
+	assert.True(t, b.collapse(ids, newDedupRecord("boom", 30), "cart"))
+	b.finish()
+	assert.Equal(t, 0, d.flush(false).LogRecordCount(), "the window is still open")
+
+	// The message stops arriving: once its window expires, what it
+	// suppressed is reported in a final summary.
+	now = now.Add(time.Minute)
+	ld := d.flush(false)
+	require.Equal(t, 1, ld.LogRecordCount())
+	rl := ld.ResourceLogs().At(0)
+	svc, _ := rl.Resource().Attributes().Get("service.name")
+	assert.Equal(t, "cart", svc.Str())
+	sl := rl.ScopeLogs().At(0)
+	assert.Equal(t, "lib", sl.Scope().Name())
+	lr := sl.LogRecords().At(0)
+	assert.Equal(t, "boom", lr.Body().Str())
+	assert.Equal(t, int64(2), recordInt(t, lr, attrLogRecordCount))
+	assert.Equal(t, int64(20), recordInt(t, lr, attrLogRecordFirstSeen))
+	assert.Equal(t, int64(30), recordInt(t, lr, attrLogRecordLastSeen))
+
+	assert.Equal(t, 0, d.size)
+	assert.Equal(t, 0, d.bytes)
+	assert.Equal(t, 0, d.flush(false).LogRecordCount(), "counts are reported once")
+}
+
+func TestDeduplicator_ExpiredWithoutDuplicatesIsForgotten(t *testing.T) {
+	now := time.Unix(1000, 0)
+	d := newTestDeduplicator([]DedupConfig{{PolicyID: "p", Window: time.Minute}}, &now)
+
+	b := d.newBatch()
+	assert.False(t, b.collapse([]string{"p"}, newDedupRecord("once", 10), ""))
+	b.finish()
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, 0, d.flush(false).LogRecordCount())
+	assert.Equal(t, 0, d.size)
+}
+
+func TestDedupResourceKey_OrderIndependent(t *testing.T) {
+	a := pcommon.NewResource()
+	a.Attributes().PutStr("service.name", "cart")
+	a.Attributes().PutStr("k8s.pod.name", "cart-1")
+	b := pcommon.NewResource()
+	b.Attributes().PutStr("k8s.pod.name", "cart-1")
+	b.Attributes().PutStr("service.name", "cart")
+
+	assert.Equal(t, dedupResourceKey(a), dedupResourceKey(b))
+	b.Attributes().PutStr("k8s.pod.name", "cart-2")
+	assert.NotEqual(t, dedupResourceKey(a), dedupResourceKey(b))
+}
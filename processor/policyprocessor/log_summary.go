@@ -0,0 +1,184 @@
+package policyprocessor
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/usetero/tero-collector-distro/processor/policyprocessor/internal/metadata"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+const (
+	// defaultDroppedLogMetricName is the metric emitted when
+	// DroppedLogMetricsConfig.MetricName is unset.
+	defaultDroppedLogMetricName = "policy.dropped_log_records"
+
+	// attrPolicyID is the datapoint attribute carrying the dropping policy's ID.
+	attrPolicyID = "policy.id"
+
+	// maxDroppedLogSeries bounds the number of distinct series accumulated
+	// between flushes. Records beyond the cap are counted under their policy
+	// ID alone.
+	maxDroppedLogSeries = 10000
+)
+
+// droppedLogSeries accumulates the count for a single attribute set.
+type droppedLogSeries struct {
+	attrs pcommon.Map
+	count int64
+}
+
+// droppedLogSummary counts dropped log records by policy ID and the configured
+// attributes. The logs instance of the processor records into it; the metrics
+// instance with the same component ID drains it into a delta Sum, every flush
+// interval and into each metrics batch it processes. It is safe for
+// concurrent use.
+type droppedLogSummary struct {
+	metricName    string
+	attributes    []string
+	flushInterval time.Duration
+
+	mu     sync.Mutex
+	series map[string]*droppedLogSeries
+	start  time.Time
+	now    func() time.Time
+}
+
+func newDroppedLogSummary(cfg DroppedLogMetricsConfig) *droppedLogSummary {
+	name := cfg.MetricName
+	if name == "" {
+		name = defaultDroppedLogMetricName
+	}
+	flushInterval := cfg.FlushInterval
+	if flushInterval == 0 {
+		flushInterval = defaultSummaryFlushInterval
+	}
+	return &droppedLogSummary{
+		metricName:    name,
+		attributes:    cfg.Attributes,
+		flushInterval: flushInterval,
+		series:        make(map[string]*droppedLogSeries),
+		start:         time.Now(),
+		now:           time.Now,
+	}
+}
+
+// record counts one dropped log record attributed to policyID.
+func (s *droppedLogSummary) record(policyID string, ctx LogContext) {
+	var key strings.Builder
+	key.WriteString(policyID)
+	values := make([]pcommon.Value, len(s.attributes))
+	for i, name := range s.attributes {
+		key.WriteByte(0)
+		if v, ok := droppedLogAttribute(ctx, name); ok {
+			values[i] = v
+			key.WriteString(v.AsString())
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key.String()
+	series, ok := s.series[k]
+	if !ok {
+		if len(s.series) >= maxDroppedLogSeries {
+			k = policyID
+			values = nil
+			series, ok = s.series[k]
+		}
+		if !ok {
+			series = &droppedLogSeries{attrs: pcommon.NewMap()}
+			series.attrs.PutStr(attrPolicyID, policyID)
+			for i, v := range values {
+				if v != (pcommon.Value{}) {
+					v.CopyTo(series.attrs.PutEmpty(s.attributes[i]))
+				}
+			}
+			s.series[k] = series
+		}
+	}
+	series.count++
+}
+
+// droppedLogAttribute resolves a configured attribute name against a log
+// record. The severity_text and event_name log fields are checked first, then
+// record attributes, then resource attributes.
+func droppedLogAttribute(ctx LogContext, name string) (pcommon.Value, bool) {
+	switch name {
+	case "severity_text":
+		if s := ctx.Record.SeverityText(); s != "" {
+			return pcommon.NewValueStr(s), true
+		}
+		return pcommon.Value{}, false
+	case "event_name":
+		if s := ctx.Record.EventName(); s != "" {
+			return pcommon.NewValueStr(s), true
+		}
+		return pcommon.Value{}, false
+	}
+	if v, ok := ctx.Record.Attributes().Get(name); ok {
+		return v, true
+	}
+	if v, ok := ctx.Resource.Attributes().Get(name); ok {
+		return v, true
+	}
+	return pcommon.Value{}, false
+}
+
+// appendTo drains the accumulated counts into md as a delta Sum under a new
+// ResourceMetrics carrying the collector's resource. Nothing is appended when
+// no logs were dropped since the last flush.
+func (s *droppedLogSummary) appendTo(md pmetric.Metrics, resource pcommon.Resource) {
+	s.mu.Lock()
+	series := s.series
+	start := s.start
+	now := s.now()
+	if len(series) == 0 {
+		s.mu.Unlock()
+		return
+	}
+	s.series = make(map[string]*droppedLogSeries)
+	s.start = now
+	s.mu.Unlock()
+
+	rm := md.ResourceMetrics().AppendEmpty()
+	resource.CopyTo(rm.Resource())
+	sm := rm.ScopeMetrics().AppendEmpty()
+	sm.Scope().SetName(metadata.ScopeName)
+
+	m := sm.Metrics().AppendEmpty()
+	m.SetName(s.metricName)
+	m.SetDescription("Number of log records dropped by policy")
+	m.SetUnit("{record}")
+	sum := m.SetEmptySum()
+	sum.SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+	sum.SetIsMonotonic(true)
+
+	dps := sum.DataPoints()
+	dps.EnsureCapacity(len(series))
+	for _, ser := range series {
+		dp := dps.AppendEmpty()
+		ser.attrs.MoveTo(dp.Attributes())
+		dp.SetStartTimestamp(pcommon.NewTimestampFromTime(start))
+		dp.SetTimestamp(pcommon.NewTimestampFromTime(now))
+		dp.SetIntValue(ser.count)
+	}
+}
+
+// droppedLogSummaries shares a droppedLogSummary between the logs and metrics
+// instances created for the same processor ID, since the collector builds a
+// separate processor per pipeline.
+var droppedLogSummaries = newSharedRegistry[*droppedLogSummary]()
+
+// recordDroppedLog counts a dropped log record under winner, the ID of the
+// winning policy matched before evaluation. A no-op when dropped log metrics
+// are disabled or no policy matched.
+func (p *policyProcessor) recordDroppedLog(winner string, ctx LogContext) {
+	if p.droppedLogs == nil || winner == "" {
+		return
+	}
+	p.droppedLogs.record(winner, ctx)
+}
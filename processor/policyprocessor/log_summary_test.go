@@ -0,0 +1,176 @@
+package policyprocessor
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+func newTestLogContext(service, severity string) LogContext {
+	resource := pcommon.NewResource()
+	resource.Attributes().PutStr("service.name", service)
+	lr := plog.NewLogRecord()
+	lr.SetSeverityText(severity)
+	return LogContext{Record: lr, Resource: resource, Scope: pcommon.NewInstrumentationScope()}
+}
+
+// droppedLogCounts flattens the emitted datapoints into a map keyed by their
+// attributes for order-independent assertions.
+func droppedLogCounts(t *testing.T, md pmetric.Metrics) map[string]int64 {
+	t.Helper()
+	require.Equal(t, 1, md.ResourceMetrics().Len())
+	m := md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0)
+	require.Equal(t, pmetric.MetricTypeSum, m.Type())
+
+	counts := make(map[string]int64)
+	dps := m.Sum().DataPoints()
+	for i := range dps.Len() {
+		dp := dps.At(i)
+		counts[attrsKey(dp.Attributes())] = dp.IntValue()
+	}
+	return counts
+}
+
+func attrsKey(attrs pcommon.Map) string {
+	keys := []string{attrPolicyID, "service.name", "severity_text"}
+	var out string
+	for _, k := range keys {
+		if v, ok := attrs.Get(k); ok {
+			out += k + "=" + v.AsString() + ";"
+		}
+	}
+	return out
+}
+
+func TestDroppedLogSummary_GroupsByPolicyAndAttributes(t *testing.T) {
+	s := newDroppedLogSummary(DroppedLogMetricsConfig{
+		Attributes: []string{"service.name", "severity_text"},
+	})
+	start := time.Unix(1000, 0)
+	s.start = start
+	now := start.Add(10 * time.Second)
+	s.now = func() time.Time { return now }
+
+	s.record("drop-debug", newTestLogContext("cart", "DEBUG"))
+	s.record("drop-debug", newTestLogContext("cart", "DEBUG"))
+	s.record("drop-debug", newTestLogContext("checkout", "DEBUG"))
+	s.record("drop-health", newTestLogContext("cart", ""))
+
+	resource := pcommon.NewResource()
+	resource.Attributes().PutStr("service.name", "collector")
+	md := pmetric.NewMetrics()
+	s.appendTo(md, resource)
+
+	rm := md.ResourceMetrics().At(0)
+	svc, _ := rm.Resource().Attributes().Get("service.name")
+	assert.Equal(t, "collector", svc.Str())
+
+	m := rm.ScopeMetrics().At(0).Metrics().At(0)
+	assert.Equal(t, defaultDroppedLogMetricName, m.Name())
+	assert.Equal(t, pmetric.AggregationTemporalityDelta, m.Sum().AggregationTemporality())
+	assert.True(t, m.Sum().IsMonotonic())
+	dp := m.Sum().DataPoints().At(0)
+	assert.Equal(t, pcommon.NewTimestampFromTime(start), dp.StartTimestamp())
+	assert.Equal(t, pcommon.NewTimestampFromTime(now), dp.Timestamp())
+
+	assert.Equal(t, map[string]int64{
+		"policy.id=drop-debug;service.name=cart;severity_text=DEBUG;":     2,
+		"policy.id=drop-debug;service.name=checkout;severity_text=DEBUG;": 1,
+		"policy.id=drop-health;service.name=cart;":                        1,
+	}, droppedLogCounts(t, md))
+}
+
+func TestDroppedLogSummary_DrainsOnAppend(t *testing.T) {
+	s := newDroppedLogSummary(DroppedLogMetricsConfig{MetricName: "custom.dropped"})
+	first := time.Unix(1000, 0)
+	second := first.Add(time.Minute)
+	now := first
+	s.now = func() time.Time { return now }
+
+	md := pmetric.NewMetrics()
+	s.appendTo(md, pcommon.NewResource())
+	assert.Equal(t, 0, md.ResourceMetrics().Len(), "nothing is emitted without drops")
+
+	s.record("p", newTestLogContext("cart", "INFO"))
+	s.appendTo(md, pcommon.NewResource())
+	require.Equal(t, 1, md.ResourceMetrics().Len())
+	assert.Equal(t, "custom.dropped", md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Name())
+
+	now = second
+	s.record("p", newTestLogContext("cart", "INFO"))
+	md = pmetric.NewMetrics()
+	s.appendTo(md, pcommon.NewResource())
+	dp := md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Sum().DataPoints().At(0)
+	assert.Equal(t, int64(1), dp.IntValue(), "counts are deltas since the last flush")
+	assert.Equal(t, pcommon.NewTimestampFromTime(first), dp.StartTimestamp())
+	assert.Equal(t, pcommon.NewTimestampFromTime(second), dp.Timestamp())
+}
+
+func TestDroppedLogSummary_OverflowFoldsToPolicy(t *testing.T) {
+	s := newDroppedLogSummary(DroppedLogMetricsConfig{Attributes: []string{"service.name"}})
+	for i := range maxDroppedLogSeries {
+		s.record("p", newTestLogContext(strconv.Itoa(i), ""))
+	}
+	s.record("p", newTestLogContext("overflow-a", ""))
+	s.record("p", newTestLogContext("overflow-b", ""))
+
+	md := pmetric.NewMetrics()
+	s.appendTo(md, pcommon.NewResource())
+	counts := droppedLogCounts(t, md)
+	assert.Len(t, counts, maxDroppedLogSeries+1)
+	assert.Equal(t, int64(2), counts["policy.id=p;"])
+}
+
+func TestDroppedLogSummary_Flush(t *testing.T) {
+	s := newDroppedLogSummary(DroppedLogMetricsConfig{})
+	sink := &consumertest.MetricsSink{}
+	p := createTestMetricProcessor(t, nil)
+	p.resource = pcommon.NewResource()
+	p.nextMetrics = sink
+
+	// Counts go out on the timer without any metrics batch to carry them.
+	p.startSummaryFlush(s, 10*time.Millisecond)
+	s.record("p", newTestLogContext("cart", "INFO"))
+	require.Eventually(t, func() bool { return len(sink.AllMetrics()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, map[string]int64{"policy.id=p;": 1}, droppedLogCounts(t, sink.AllMetrics()[0]))
+
+	// Shutdown emits the counts recorded since the last flush.
+	s.record("p", newTestLogContext("cart", "INFO"))
+	s.record("p", newTestLogContext("cart", "INFO"))
+	p.stopSummaryFlushes(context.Background())
+	var total int64
+	for _, md := range sink.AllMetrics() {
+		total += droppedLogCounts(t, md)["policy.id=p;"]
+	}
+	assert.Equal(t, int64(3), total)
+	assert.Empty(t, p.summaryFlushes)
+}
+
+func TestDroppedLogAttribute_Precedence(t *testing.T) {
+	ctx := newTestLogContext("from-resource", "WARN")
+	ctx.Record.SetEventName("user.login")
+	ctx.Record.Attributes().PutStr("service.name", "from-record")
+
+	v, ok := droppedLogAttribute(ctx, "severity_text")
+	require.True(t, ok)
+	assert.Equal(t, "WARN", v.Str())
+
+	v, ok = droppedLogAttribute(ctx, "event_name")
+	require.True(t, ok)
+	assert.Equal(t, "user.login", v.Str())
+
+	v, ok = droppedLogAttribute(ctx, "service.name")
+	require.True(t, ok)
+	assert.Equal(t, "from-record", v.Str(), "record attributes take precedence over resource attributes")
+
+	_, ok = droppedLogAttribute(ctx, "missing")
+	assert.False(t, ok)
+}
@@ -0,0 +1,327 @@
+package policyprocessor
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// defaultCardinalityWindow is how long a series is tracked after its last
+// datapoint when CardinalityLimitConfig.Window is unset.
+const defaultCardinalityWindow = 10 * time.Minute
+
+// seriesLimit is the outcome of a cardinality limit for a datapoint.
+type seriesLimit int
+
+const (
+	// seriesAdmitted leaves the datapoint unchanged.
+	seriesAdmitted seriesLimit = iota
+	// seriesOverflowed moved the datapoint into the overflow series.
+	seriesOverflowed
+	// seriesDropped means the datapoint must be dropped.
+	seriesDropped
+)
+
+// cardinalityRule is the compiled form of a CardinalityLimitConfig together
+// with the series it tracks.
+type cardinalityRule struct {
+	policyID  string
+	maxSeries int
+	window    time.Duration
+	overflow  bool
+
+	// metrics holds the tracked series of each metric name.
+	metrics   map[string]*cardinalityMetric
+	nextSweep time.Time
+}
+
+// cardinalityMetric holds the last time each series of a metric was seen.
+type cardinalityMetric struct {
+	series map[string]time.Time
+	// nextExpiry is the earliest time a tracked series can expire, so a
+	// metric at its limit is not scanned on every new series.
+	nextExpiry time.Time
+}
+
+// cardinalityLimiter bounds the number of distinct series per metric name
+// for metrics matching a cardinality policy. A series is tracked for a
+// sliding window after its last datapoint. It is safe for concurrent use.
+type cardinalityLimiter struct {
+	rules []cardinalityRule
+	now   func() time.Time
+
+	mu sync.Mutex
+}
+
+// newCardinalityLimiter returns nil when no cardinality limits are configured
+// so callers can skip policy matching entirely.
+func newCardinalityLimiter(cfgs []CardinalityLimitConfig) *cardinalityLimiter {
+	if len(cfgs) == 0 {
+		return nil
+	}
+	c := &cardinalityLimiter{
+		rules: make([]cardinalityRule, len(cfgs)),
+		now:   time.Now,
+	}
+	for i, cfg := range cfgs {
+		window := cfg.Window
+		if window == 0 {
+			window = defaultCardinalityWindow
+		}
+		c.rules[i] = cardinalityRule{
+			policyID:  cfg.PolicyID,
+			maxSeries: cfg.MaxSeries,
+			window:    window,
+			overflow:  cfg.Action == CardinalityOverflow,
+			metrics:   make(map[string]*cardinalityMetric),
+		}
+	}
+	return c
+}
+
+// rule returns the first configured rule whose policy is among policyIDs.
+func (c *cardinalityLimiter) rule(policyIDs []string) *cardinalityRule {
+	for i := range c.rules {
+		if slices.Contains(policyIDs, c.rules[i].policyID) {
+			return &c.rules[i]
+		}
+	}
+	return nil
+}
+
+// admit records a datapoint of the series identified by key of the named
+// metric. It reports false when the series is new and the metric already
+// tracks the maximum number of series.
+func (c *cardinalityLimiter) admit(rule *cardinalityRule, name, key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if !now.Before(rule.nextSweep) {
+		rule.sweepLocked(now)
+	}
+	m, ok := rule.metrics[name]
+	if !ok {
+		m = &cardinalityMetric{series: make(map[string]time.Time)}
+		rule.metrics[name] = m
+	}
+	if _, ok := m.series[key]; ok {
+		m.series[key] = now
+		return true
+	}
+	if len(m.series) >= rule.maxSeries && !now.Before(m.nextExpiry) {
+		m.expire(now, rule.window)
+	}
+	if len(m.series) >= rule.maxSeries {
+		return false
+	}
+	if len(m.series) == 0 {
+		m.nextExpiry = now.Add(rule.window)
+	}
+	m.series[key] = now
+	return true
+}
+
+// expire forgets series not seen within window.
+func (m *cardinalityMetric) expire(now time.Time, window time.Duration) {
+	var oldest time.Time
+	for key, seen := range m.series {
+		switch {
+		case now.Sub(seen) > window:
+			delete(m.series, key)
+		case oldest.IsZero() || seen.Before(oldest):
+			oldest = seen
+		}
+	}
+	m.nextExpiry = oldest.Add(window)
+}
+
+// sweepLocked expires the series of every metric and forgets metrics left
+// without series.
+// INVARIANT: the limiter's mu MUST be held by the caller.
+func (r *cardinalityRule) sweepLocked(now time.Time) {
+	for name, m := range r.metrics {
+		m.expire(now, r.window)
+		if len(m.series) == 0 {
+			delete(r.metrics, name)
+		}
+	}
+	r.nextSweep = now.Add(r.window)
+}
+
+// observe reports the number of series tracked by each rule.
+func (c *cardinalityLimiter) observe(_ context.Context, observer metric.Int64Observer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for i := range c.rules {
+		rule := &c.rules[i]
+		rule.sweepLocked(now)
+		var n int
+		for _, m := range rule.metrics {
+			n += len(m.series)
+		}
+		observer.Observe(int64(n), metric.WithAttributes(attrTelemetryPolicyID.String(rule.policyID)))
+	}
+	return nil
+}
+
+// limitSeries applies the cardinality limit of the first of policyIDs with
+// one to a datapoint with attrs. A datapoint moved into the overflow series
+// has its attributes replaced by the overflow marker; the caller merges the
+// overflowed datapoints of a metric into one. Cumulative datapoints of
+// different series cannot be merged into a consistent series, so they are
+// dropped even with overflow. resourceKey caches the key of resource across
+// the datapoints of a metric.
+func (p *policyProcessor) limitSeries(policyIDs []string, resourceKey *string, resource pcommon.Resource, scope pcommon.InstrumentationScope, name string, temporality pmetric.AggregationTemporality, attrs pcommon.Map) seriesLimit {
+	if p.cardinality == nil {
+		return seriesAdmitted
+	}
+	rule := p.cardinality.rule(policyIDs)
+	if rule == nil {
+		return seriesAdmitted
+	}
+	if *resourceKey == "" {
+		*resourceKey = dedupResourceKey(resource)
+	}
+	if p.cardinality.admit(rule, name, metricSeriesKey(*resourceKey, scope, name, attrs)) {
+		return seriesAdmitted
+	}
+	if !rule.overflow || temporality == pmetric.AggregationTemporalityCumulative {
+		return seriesDropped
+	}
+	attrs.Clear()
+	attrs.PutBool(attrOverflow, true)
+	return seriesOverflowed
+}
+
+// mergeOverflowNumber folds dp into into, the overflow datapoint of the same
+// metric in the batch. Delta sums are added up; gauges keep the latest value.
+func mergeOverflowNumber(into, dp pmetric.NumberDataPoint, temporality pmetric.AggregationTemporality) {
+	if temporality != pmetric.AggregationTemporalityDelta {
+		if dp.Timestamp() >= into.Timestamp() {
+			dp.CopyTo(into)
+		}
+		return
+	}
+	sum := newNumberValue(into)
+	if v := newNumberValue(dp); sum.typ == pmetric.NumberDataPointValueTypeInt && v.typ == pmetric.NumberDataPointValueTypeInt {
+		into.SetIntValue(sum.i + v.i)
+	} else {
+		into.SetDoubleValue(sum.float() + v.float())
+	}
+	mergeOverflowTimestamps(into, dp)
+	dp.Exemplars().MoveAndAppendTo(into.Exemplars())
+}
+
+// mergeOverflowHistogram folds dp into into, the overflow datapoint of the
+// same metric in the batch. It reports false when their bucket boundaries
+// differ, as the counts cannot be merged exactly.
+func mergeOverflowHistogram(into, dp pmetric.HistogramDataPoint) bool {
+	if !slices.Equal(into.ExplicitBounds().AsRaw(), dp.ExplicitBounds().AsRaw()) || into.BucketCounts().Len() != dp.BucketCounts().Len() {
+		return false
+	}
+	for i := range dp.BucketCounts().Len() {
+		into.BucketCounts().SetAt(i, into.BucketCounts().At(i)+dp.BucketCounts().At(i))
+	}
+	into.SetCount(into.Count() + dp.Count())
+	if into.HasSum() && dp.HasSum() {
+		into.SetSum(into.Sum() + dp.Sum())
+	} else {
+		into.RemoveSum()
+	}
+	if into.HasMin() && dp.HasMin() {
+		into.SetMin(min(into.Min(), dp.Min()))
+	} else {
+		into.RemoveMin()
+	}
+	if into.HasMax() && dp.HasMax() {
+		into.SetMax(max(into.Max(), dp.Max()))
+	} else {
+		into.RemoveMax()
+	}
+	mergeOverflowTimestamps(into, dp)
+	dp.Exemplars().MoveAndAppendTo(into.Exemplars())
+	return true
+}
+
+// mergeOverflowExponentialHistogram folds dp into into, the overflow
+// datapoint of the same metric in the batch, at the lower of their scales.
+// It reports false when their zero thresholds differ, as the counts cannot
+// be merged exactly.
+func mergeOverflowExponentialHistogram(into, dp pmetric.ExponentialHistogramDataPoint) bool {
+	if into.ZeroThreshold() != dp.ZeroThreshold() {
+		return false
+	}
+	scale := min(into.Scale(), dp.Scale())
+	for _, h := range []pmetric.ExponentialHistogramDataPoint{into, dp} {
+		if change := h.Scale() - scale; change > 0 {
+			downscaleBuckets(h.Positive(), change)
+			downscaleBuckets(h.Negative(), change)
+			h.SetScale(scale)
+		}
+	}
+	mergeBuckets(into.Positive(), dp.Positive())
+	mergeBuckets(into.Negative(), dp.Negative())
+	into.SetCount(into.Count() + dp.Count())
+	into.SetZeroCount(into.ZeroCount() + dp.ZeroCount())
+	if into.HasSum() && dp.HasSum() {
+		into.SetSum(into.Sum() + dp.Sum())
+	} else {
+		into.RemoveSum()
+	}
+	if into.HasMin() && dp.HasMin() {
+		into.SetMin(min(into.Min(), dp.Min()))
+	} else {
+		into.RemoveMin()
+	}
+	if into.HasMax() && dp.HasMax() {
+		into.SetMax(max(into.Max(), dp.Max()))
+	} else {
+		into.RemoveMax()
+	}
+	mergeOverflowTimestamps(into, dp)
+	dp.Exemplars().MoveAndAppendTo(into.Exemplars())
+	return true
+}
+
+// mergeBuckets adds the counts of b to into, at the same scale.
+func mergeBuckets(into, b pmetric.ExponentialHistogramDataPointBuckets) {
+	if b.BucketCounts().Len() == 0 {
+		return
+	}
+	if into.BucketCounts().Len() == 0 {
+		b.CopyTo(into)
+		return
+	}
+	offset := min(into.Offset(), b.Offset())
+	end := max(into.Offset()+int32(into.BucketCounts().Len()), b.Offset()+int32(b.BucketCounts().Len()))
+	merged := make([]uint64, end-offset)
+	for _, src := range []pmetric.ExponentialHistogramDataPointBuckets{into, b} {
+		for i := range src.BucketCounts().Len() {
+			merged[src.Offset()-offset+int32(i)] += src.BucketCounts().At(i)
+		}
+	}
+	into.SetOffset(offset)
+	into.BucketCounts().FromRaw(merged)
+}
+
+// timestamped is a datapoint of any type.
+type timestamped interface {
+	StartTimestamp() pcommon.Timestamp
+	SetStartTimestamp(pcommon.Timestamp)
+	Timestamp() pcommon.Timestamp
+	SetTimestamp(pcommon.Timestamp)
+}
+
+// mergeOverflowTimestamps widens the time range of into to cover dp.
+func mergeOverflowTimestamps(into, dp timestamped) {
+	into.SetStartTimestamp(min(into.StartTimestamp(), dp.StartTimestamp()))
+	into.SetTimestamp(max(into.Timestamp(), dp.Timestamp()))
+}
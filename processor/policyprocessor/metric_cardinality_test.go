@@ -0,0 +1,200 @@
+package policyprocessor
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	policyv1 "github.com/usetero/policy-go/proto/tero/policy/v1"
+	"github.com/usetero/tero-collector-distro/processor/policyprocessor/internal/metadatatest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata/metricdatatest"
+)
+
+func TestCardinalityLimiter_SlidingWindow(t *testing.T) {
+	c := newCardinalityLimiter([]CardinalityLimitConfig{{PolicyID: "p", MaxSeries: 2, Window: time.Minute}})
+	now := time.Unix(1700000000, 0)
+	c.now = func() time.Time { return now }
+	rule := &c.rules[0]
+
+	assert.True(t, c.admit(rule, "http.requests", "a"))
+	assert.True(t, c.admit(rule, "http.requests", "b"))
+	assert.False(t, c.admit(rule, "http.requests", "c"), "new series beyond the limit are rejected")
+	assert.True(t, c.admit(rule, "rpc.requests", "c"), "each metric name has its own limit")
+
+	now = now.Add(50 * time.Second)
+	assert.True(t, c.admit(rule, "http.requests", "a"), "known series are still admitted")
+	now = now.Add(20 * time.Second)
+	assert.True(t, c.admit(rule, "http.requests", "c"), "b expired and made room")
+	assert.False(t, c.admit(rule, "http.requests", "b"))
+}
+
+// newSeriesBatch builds a gauge with one datapoint per request ID.
+func newSeriesBatch(name string, requestIDs ...int) pmetric.Metrics {
+	md := newGaugeBatch(name, 0, make([]float64, len(requestIDs))...)
+	dps := md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Gauge().DataPoints()
+	for i, id := range requestIDs {
+		dps.At(i).Attributes().PutStr("request_id", strconv.Itoa(id))
+	}
+	return md
+}
+
+func TestProcessMetrics_CardinalityLimitDrops(t *testing.T) {
+	p := createTestMetricProcessor(t, []*policyv1.Policy{keepMetricPolicy("http", "http.")})
+	p.cardinality = newCardinalityLimiter([]CardinalityLimitConfig{{PolicyID: "http", MaxSeries: 2}})
+	tel := withTelemetry(t, p)
+
+	out, err := p.processMetrics(context.Background(), newSeriesBatch("http.requests", 1, 2, 3, 1))
+	require.NoError(t, err)
+	assert.Len(t, numberDataPoints(out), 3)
+	out, err = p.processMetrics(context.Background(), newSeriesBatch("rpc.requests", 1, 2, 3))
+	require.NoError(t, err)
+	assert.Len(t, numberDataPoints(out), 3, "unmatched metrics are not limited")
+
+	metadatatest.AssertEqualProcessorPolicyRecords(t, tel, []metricdata.DataPoint[int64]{
+		{Attributes: attribute.NewSet(attrTelemetryType.String("metrics"), attrResult.String("kept")), Value: 3},
+		{Attributes: attribute.NewSet(attrTelemetryType.String("metrics"), attrResult.String("cardinality_limited")), Value: 1},
+		{Attributes: attribute.NewSet(attrTelemetryType.String("metrics"), attrResult.String("no_match")), Value: 3},
+	}, metricdatatest.IgnoreTimestamp())
+
+	require.NoError(t, p.telemetry.RegisterProcessorPolicyMetricSeriesCallback(p.cardinality.observe))
+	metadatatest.AssertEqualProcessorPolicyMetricSeries(t, tel, []metricdata.DataPoint[int64]{
+		{Attributes: attribute.NewSet(attrTelemetryPolicyID.String("http")), Value: 2},
+	}, metricdatatest.IgnoreTimestamp())
+}
+
+func TestProcessMetrics_CardinalityLimitOverflows(t *testing.T) {
+	p := createTestMetricProcessor(t, []*policyv1.Policy{keepMetricPolicy("http", "http.")})
+	p.cardinality = newCardinalityLimiter([]CardinalityLimitConfig{{PolicyID: "http", MaxSeries: 1, Action: CardinalityOverflow}})
+	tel := withTelemetry(t, p)
+
+	out, err := p.processMetrics(context.Background(), newSeriesBatch("http.requests", 1, 2, 3))
+	require.NoError(t, err)
+	dps := numberDataPoints(out)
+	require.Len(t, dps, 2, "overflowed datapoints are merged into one")
+	assert.Equal(t, map[string]any{"request_id": "1"}, dps[0].Attributes().AsRaw())
+	assert.Equal(t, map[string]any{attrOverflow: true}, dps[1].Attributes().AsRaw())
+
+	metadatatest.AssertEqualProcessorPolicyRecords(t, tel, []metricdata.DataPoint[int64]{
+		{Attributes: attribute.NewSet(attrTelemetryType.String("metrics"), attrResult.String("kept")), Value: 1},
+		{Attributes: attribute.NewSet(attrTelemetryType.String("metrics"), attrResult.String("transformed")), Value: 2},
+	}, metricdatatest.IgnoreTimestamp())
+}
+
+// newSumSeriesBatch builds a sum with one datapoint per request ID, each
+// with the value of its ID.
+func newSumSeriesBatch(temporality pmetric.AggregationTemporality, requestIDs ...int) pmetric.Metrics {
+	md := pmetric.NewMetrics()
+	m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName("http.requests")
+	sum := m.SetEmptySum()
+	sum.SetAggregationTemporality(temporality)
+	for i, id := range requestIDs {
+		dp := sum.DataPoints().AppendEmpty()
+		dp.SetStartTimestamp(pcommon.Timestamp(int64(i) * int64(time.Second)))
+		dp.SetTimestamp(pcommon.Timestamp(int64(i+10) * int64(time.Second)))
+		dp.SetIntValue(int64(id))
+		dp.Attributes().PutStr("request_id", strconv.Itoa(id))
+	}
+	return md
+}
+
+func TestProcessMetrics_CardinalityOverflowMergesDeltas(t *testing.T) {
+	p := createTestMetricProcessor(t, []*policyv1.Policy{keepMetricPolicy("http", "http.")})
+	p.cardinality = newCardinalityLimiter([]CardinalityLimitConfig{{PolicyID: "http", MaxSeries: 1, Action: CardinalityOverflow}})
+
+	out, err := p.processMetrics(context.Background(), newSumSeriesBatch(pmetric.AggregationTemporalityDelta, 1, 2, 3, 4))
+	require.NoError(t, err)
+	dps := numberDataPoints(out)
+	require.Len(t, dps, 2)
+	assert.Equal(t, int64(1), dps[0].IntValue())
+	assert.Equal(t, map[string]any{attrOverflow: true}, dps[1].Attributes().AsRaw())
+	assert.Equal(t, int64(9), dps[1].IntValue(), "no counts are lost")
+	assert.Equal(t, pcommon.Timestamp(time.Second), dps[1].StartTimestamp())
+	assert.Equal(t, pcommon.Timestamp(13*time.Second), dps[1].Timestamp())
+}
+
+func TestProcessMetrics_CardinalityOverflowDropsCumulative(t *testing.T) {
+	p := createTestMetricProcessor(t, []*policyv1.Policy{keepMetricPolicy("http", "http.")})
+	p.cardinality = newCardinalityLimiter([]CardinalityLimitConfig{{PolicyID: "http", MaxSeries: 1, Action: CardinalityOverflow}})
+	tel := withTelemetry(t, p)
+
+	out, err := p.processMetrics(context.Background(), newSumSeriesBatch(pmetric.AggregationTemporalityCumulative, 1, 2, 3))
+	require.NoError(t, err)
+	dps := numberDataPoints(out)
+	require.Len(t, dps, 1)
+	assert.Equal(t, map[string]any{"request_id": "1"}, dps[0].Attributes().AsRaw())
+
+	metadatatest.AssertEqualProcessorPolicyRecords(t, tel, []metricdata.DataPoint[int64]{
+		{Attributes: attribute.NewSet(attrTelemetryType.String("metrics"), attrResult.String("kept")), Value: 1},
+		{Attributes: attribute.NewSet(attrTelemetryType.String("metrics"), attrResult.String("cardinality_limited")), Value: 2},
+	}, metricdatatest.IgnoreTimestamp())
+}
+
+func TestMergeOverflowHistogram(t *testing.T) {
+	newPoint := func(bounds []float64, counts []uint64, sum, lo, hi float64) pmetric.HistogramDataPoint {
+		dp := pmetric.NewHistogramDataPoint()
+		dp.ExplicitBounds().FromRaw(bounds)
+		dp.BucketCounts().FromRaw(counts)
+		var n uint64
+		for _, c := range counts {
+			n += c
+		}
+		dp.SetCount(n)
+		dp.SetSum(sum)
+		dp.SetMin(lo)
+		dp.SetMax(hi)
+		return dp
+	}
+
+	into := newPoint([]float64{1, 10}, []uint64{1, 2, 0}, 12, 0.5, 9)
+	require.True(t, mergeOverflowHistogram(into, newPoint([]float64{1, 10}, []uint64{0, 1, 1}, 20, 4, 15)))
+	assert.Equal(t, []uint64{1, 3, 1}, into.BucketCounts().AsRaw())
+	assert.Equal(t, uint64(5), into.Count())
+	assert.Equal(t, 32.0, into.Sum())
+	assert.Equal(t, 0.5, into.Min())
+	assert.Equal(t, 15.0, into.Max())
+
+	withoutMin := newPoint([]float64{1, 10}, []uint64{1, 0, 0}, 0.1, 0.1, 0.1)
+	withoutMin.RemoveMin()
+	require.True(t, mergeOverflowHistogram(into, withoutMin))
+	assert.False(t, into.HasMin(), "the merged minimum is unknown")
+
+	assert.False(t, mergeOverflowHistogram(into, newPoint([]float64{5}, []uint64{1, 1}, 8, 2, 6)), "different boundaries cannot be merged")
+	assert.Equal(t, uint64(6), into.Count())
+}
+
+func TestMergeOverflowExponentialHistogram(t *testing.T) {
+	into := pmetric.NewExponentialHistogramDataPoint()
+	into.SetScale(1)
+	into.Positive().SetOffset(2)
+	into.Positive().BucketCounts().FromRaw([]uint64{1, 1})
+	into.SetZeroCount(1)
+	into.SetCount(3)
+
+	dp := pmetric.NewExponentialHistogramDataPoint()
+	dp.SetScale(0)
+	dp.Positive().SetOffset(-1)
+	dp.Positive().BucketCounts().FromRaw([]uint64{2, 0, 1})
+	dp.Negative().BucketCounts().FromRaw([]uint64{4})
+	dp.SetCount(7)
+
+	require.True(t, mergeOverflowExponentialHistogram(into, dp))
+	assert.Equal(t, int32(0), into.Scale(), "merged at the lower scale")
+	assert.Equal(t, int32(-1), into.Positive().Offset())
+	// Buckets 2 and 3 at scale 1 are bucket 1 at scale 0.
+	assert.Equal(t, []uint64{2, 0, 3}, into.Positive().BucketCounts().AsRaw())
+	assert.Equal(t, []uint64{4}, into.Negative().BucketCounts().AsRaw())
+	assert.Equal(t, uint64(10), into.Count())
+	assert.Equal(t, uint64(1), into.ZeroCount())
+
+	other := pmetric.NewExponentialHistogramDataPoint()
+	other.SetZeroThreshold(0.5)
+	assert.False(t, mergeOverflowExponentialHistogram(into, other), "different zero thresholds cannot be merged")
+}
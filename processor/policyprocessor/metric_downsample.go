@@ -0,0 +1,429 @@
+package policyprocessor
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// maxDownsampleSeries bounds the number of series tracked across all rules.
+// Idle series are pruned first; new series beyond the cap pass through
+// without downsampling.
+const maxDownsampleSeries = 10000
+
+// downsampleRule is the compiled form of a DownsampleConfig.
+type downsampleRule struct {
+	policyID    string
+	interval    time.Duration
+	aggregation string
+}
+
+// downsampleMode is how the datapoints of an interval are combined.
+type downsampleMode int
+
+const (
+	downsampleModeLast downsampleMode = iota
+	downsampleModeMin
+	downsampleModeMax
+	downsampleModeAvg
+	// downsampleModeSum adds up the values of delta sums.
+	downsampleModeSum
+)
+
+// numberValue is a NumberDataPoint value of either type.
+type numberValue struct {
+	typ pmetric.NumberDataPointValueType
+	i   int64
+	d   float64
+}
+
+func newNumberValue(dp pmetric.NumberDataPoint) numberValue {
+	return numberValue{typ: dp.ValueType(), i: dp.IntValue(), d: dp.DoubleValue()}
+}
+
+func (v numberValue) float() float64 {
+	if v.typ == pmetric.NumberDataPointValueTypeInt {
+		return float64(v.i)
+	}
+	return v.d
+}
+
+func (v numberValue) less(o numberValue) bool {
+	if v.typ == pmetric.NumberDataPointValueTypeInt && o.typ == pmetric.NumberDataPointValueTypeInt {
+		return v.i < o.i
+	}
+	return v.float() < o.float()
+}
+
+func (v numberValue) writeTo(dp pmetric.NumberDataPoint) {
+	if v.typ == pmetric.NumberDataPointValueTypeInt {
+		dp.SetIntValue(v.i)
+	} else {
+		dp.SetDoubleValue(v.d)
+	}
+}
+
+// downsampleOrigin is where the datapoints of a series came from, so a held
+// datapoint can be emitted in a batch of its own. One origin is shared by the
+// series of a metric within a batch; it copies the resource, scope and metric
+// metadata on first use, as the batch is not retained.
+type downsampleOrigin struct {
+	resourceKey       string
+	resource          pcommon.Resource
+	resourceSchemaURL string
+	scope             pcommon.InstrumentationScope
+	scopeSchemaURL    string
+	metric            pmetric.Metric
+	temporality       pmetric.AggregationTemporality
+
+	retained bool
+}
+
+// retain copies the batch data the origin refers to.
+func (o *downsampleOrigin) retain() {
+	if o.retained {
+		return
+	}
+	o.retained = true
+	resource := pcommon.NewResource()
+	o.resource.CopyTo(resource)
+	o.resource = resource
+	scope := pcommon.NewInstrumentationScope()
+	o.scope.CopyTo(scope)
+	o.scope = scope
+
+	m := pmetric.NewMetric()
+	m.SetName(o.metric.Name())
+	m.SetDescription(o.metric.Description())
+	m.SetUnit(o.metric.Unit())
+	o.metric.Metadata().CopyTo(m.Metadata())
+	if o.metric.Type() == pmetric.MetricTypeSum {
+		sum := m.SetEmptySum()
+		sum.SetAggregationTemporality(o.temporality)
+		sum.SetIsMonotonic(o.metric.Sum().IsMonotonic())
+	} else {
+		m.SetEmptyGauge()
+	}
+	o.metric = m
+}
+
+// dataPoints returns the datapoints of the metric, which must be a gauge or
+// a sum.
+func dataPoints(m pmetric.Metric) pmetric.NumberDataPointSlice {
+	if m.Type() == pmetric.MetricTypeSum {
+		return m.Sum().DataPoints()
+	}
+	return m.Gauge().DataPoints()
+}
+
+// downsampleSeries holds the datapoints of a series seen in the current
+// interval, combined into one.
+type downsampleSeries struct {
+	mode     downsampleMode
+	interval time.Duration
+	bucket   int64
+	lastSeen time.Time
+	origin   *downsampleOrigin
+	// held is set while the interval has datapoints that were not emitted.
+	// It is cleared once the combined datapoint is flushed; bucket then
+	// remains the last interval emitted.
+	held bool
+
+	// point is a copy of the latest datapoint of the interval.
+	point pmetric.NumberDataPoint
+	// start is the start timestamp of the first datapoint of the interval.
+	start pcommon.Timestamp
+	count int64
+	// min and max hold the extreme values; sum the total of all values,
+	// kept as an int while every value is one.
+	min, max numberValue
+	sum      numberValue
+}
+
+func newDownsampleSeries(mode downsampleMode, interval time.Duration, bucket int64, origin *downsampleOrigin, dp pmetric.NumberDataPoint, now time.Time) *downsampleSeries {
+	origin.retain()
+	s := &downsampleSeries{
+		mode:     mode,
+		interval: interval,
+		bucket:   bucket,
+		lastSeen: now,
+		origin:   origin,
+		held:     true,
+		point:    pmetric.NewNumberDataPoint(),
+		start:    dp.StartTimestamp(),
+	}
+	dp.CopyTo(s.point)
+	v := newNumberValue(dp)
+	s.count, s.min, s.max, s.sum = 1, v, v, v
+	return s
+}
+
+// fold adds another datapoint to the interval.
+func (s *downsampleSeries) fold(dp pmetric.NumberDataPoint) {
+	if dp.Timestamp() >= s.point.Timestamp() {
+		dp.CopyTo(s.point)
+	}
+	s.start = min(s.start, dp.StartTimestamp())
+	v := newNumberValue(dp)
+	s.count++
+	if v.less(s.min) {
+		s.min = v
+	}
+	if s.max.less(v) {
+		s.max = v
+	}
+	if s.sum.typ == pmetric.NumberDataPointValueTypeInt && v.typ == pmetric.NumberDataPointValueTypeInt {
+		s.sum.i += v.i
+	} else {
+		s.sum = numberValue{typ: pmetric.NumberDataPointValueTypeDouble, d: s.sum.float() + v.float()}
+	}
+}
+
+// writeTo overwrites dp with the combined datapoint of the interval.
+func (s *downsampleSeries) writeTo(dp pmetric.NumberDataPoint) {
+	s.point.CopyTo(dp)
+	switch s.mode {
+	case downsampleModeMin:
+		s.min.writeTo(dp)
+	case downsampleModeMax:
+		s.max.writeTo(dp)
+	case downsampleModeAvg:
+		dp.SetDoubleValue(s.sum.float() / float64(s.count))
+	case downsampleModeSum:
+		s.sum.writeTo(dp)
+		dp.SetStartTimestamp(s.start)
+	}
+}
+
+// downsampler keeps at most one datapoint per series and interval for
+// metrics matching a downsample policy. The datapoints of an interval are
+// held and combined; the result is emitted in place of the first datapoint
+// of a later interval, or flushed in a batch of its own once the series has
+// been idle for an interval, is pruned, or the processor shuts down. State
+// is shared by all batches flowing through the processor. It is safe for
+// concurrent use.
+type downsampler struct {
+	rules []downsampleRule
+	now   func() time.Time
+
+	mu     sync.Mutex
+	series map[string]*downsampleSeries
+	// pruned holds series that were forgotten with a datapoint still held,
+	// until the next flush.
+	pruned []*downsampleSeries
+	// closed is set at shutdown; every held datapoint is flushed and new
+	// datapoints pass through.
+	closed bool
+}
+
+// newDownsampler returns nil when no downsample rules are configured so
+// callers can skip policy matching entirely.
+func newDownsampler(cfgs []DownsampleConfig) *downsampler {
+	if len(cfgs) == 0 {
+		return nil
+	}
+	d := &downsampler{
+		rules:  make([]downsampleRule, len(cfgs)),
+		now:    time.Now,
+		series: make(map[string]*downsampleSeries),
+	}
+	for i, cfg := range cfgs {
+		d.rules[i] = downsampleRule{
+			policyID:    cfg.PolicyID,
+			interval:    cfg.Interval,
+			aggregation: cfg.Aggregation,
+		}
+	}
+	return d
+}
+
+// rule returns the first configured rule whose policy is among policyIDs.
+func (d *downsampler) rule(policyIDs []string) *downsampleRule {
+	for i := range d.rules {
+		if slices.Contains(policyIDs, d.rules[i].policyID) {
+			return &d.rules[i]
+		}
+	}
+	return nil
+}
+
+// downsampleModeFor returns how datapoints of a metric are combined.
+func downsampleModeFor(rule *downsampleRule, m pmetric.Metric, temporality pmetric.AggregationTemporality) downsampleMode {
+	if m.Type() == pmetric.MetricTypeSum {
+		if temporality == pmetric.AggregationTemporalityDelta {
+			return downsampleModeSum
+		}
+		return downsampleModeLast
+	}
+	switch rule.aggregation {
+	case DownsampleMin:
+		return downsampleModeMin
+	case DownsampleMax:
+		return downsampleModeMax
+	case DownsampleAvg:
+		return downsampleModeAvg
+	default:
+		return downsampleModeLast
+	}
+}
+
+// flushInterval returns how often held datapoints are checked for a flush:
+// the shortest interval of any rule.
+func (d *downsampler) flushInterval() time.Duration {
+	interval := d.rules[0].interval
+	for _, rule := range d.rules[1:] {
+		interval = min(interval, rule.interval)
+	}
+	return interval
+}
+
+// add feeds a datapoint of the series identified by key to the downsampler.
+// It reports whether dp stays in the batch, in which case it may have been
+// overwritten with the combined datapoint of an earlier interval.
+func (d *downsampler) add(rule *downsampleRule, key string, origin *downsampleOrigin, dp pmetric.NumberDataPoint) bool {
+	if dp.ValueType() == pmetric.NumberDataPointValueTypeEmpty {
+		return true
+	}
+	mode := downsampleModeFor(rule, origin.metric, origin.temporality)
+	bucket := int64(dp.Timestamp()) / int64(rule.interval)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return true
+	}
+	now := d.now()
+	s, ok := d.series[key]
+	if !ok {
+		if len(d.series) >= maxDownsampleSeries {
+			d.pruneLocked(now)
+			if len(d.series) >= maxDownsampleSeries {
+				return true
+			}
+		}
+		d.series[key] = newDownsampleSeries(mode, rule.interval, bucket, origin, dp, now)
+		return false
+	}
+	s.lastSeen = now
+
+	switch {
+	case bucket > s.bucket:
+		next := newDownsampleSeries(mode, rule.interval, bucket, origin, dp, now)
+		d.series[key] = next
+		if !s.held {
+			return false
+		}
+		s.writeTo(dp)
+		return true
+	case !s.held:
+		// A late datapoint of an interval already flushed. Delta values
+		// pass through so none are lost.
+		return s.mode == downsampleModeSum
+	case bucket == s.bucket:
+		s.fold(dp)
+		return false
+	default:
+		// A late datapoint of an interval already emitted. Delta values
+		// are carried into the current interval so none are lost.
+		if s.mode == downsampleModeSum {
+			s.fold(dp)
+		}
+		return false
+	}
+}
+
+// pruneLocked forgets series that have not seen a datapoint for two of their
+// intervals. Series still holding a datapoint are kept for the next flush.
+// INVARIANT: d.mu MUST be held by the caller.
+func (d *downsampler) pruneLocked(now time.Time) {
+	for key, s := range d.series {
+		if now.Sub(s.lastSeen) > 2*s.interval {
+			if s.held {
+				d.pruned = append(d.pruned, s)
+			}
+			delete(d.series, key)
+		}
+	}
+}
+
+// close flushes every held datapoint on the next appendTo and lets new
+// datapoints pass through.
+func (d *downsampler) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+}
+
+// appendTo flushes the held datapoints of pruned series and of series that
+// have been idle for an interval into md, grouped by resource, scope and
+// metric. The resource argument is unused: datapoints keep their origin.
+func (d *downsampler) appendTo(md pmetric.Metrics, _ pcommon.Resource) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	flushed := d.pruned
+	d.pruned = nil
+	for _, s := range d.series {
+		if s.held && (d.closed || now.Sub(s.lastSeen) >= s.interval) {
+			flushed = append(flushed, s)
+		}
+	}
+	if len(flushed) == 0 {
+		return
+	}
+
+	resources := make(map[string]pmetric.ScopeMetricsSlice)
+	scopes := make(map[string]pmetric.MetricSlice)
+	metrics := make(map[string]pmetric.NumberDataPointSlice)
+	for _, s := range flushed {
+		s.held = false
+		o := s.origin
+		resourceKey := o.resourceKey + "\x00" + o.resourceSchemaURL
+		sms, ok := resources[resourceKey]
+		if !ok {
+			rm := md.ResourceMetrics().AppendEmpty()
+			o.resource.CopyTo(rm.Resource())
+			rm.SetSchemaUrl(o.resourceSchemaURL)
+			sms = rm.ScopeMetrics()
+			resources[resourceKey] = sms
+		}
+		scopeKey := resourceKey + "\x00" + o.scope.Name() + "\x00" + o.scope.Version() + "\x00" + o.scopeSchemaURL
+		ms, ok := scopes[scopeKey]
+		if !ok {
+			sm := sms.AppendEmpty()
+			o.scope.CopyTo(sm.Scope())
+			sm.SetSchemaUrl(o.scopeSchemaURL)
+			ms = sm.Metrics()
+			scopes[scopeKey] = ms
+		}
+		metricKey := scopeKey + "\x00" + o.metric.Name()
+		dps, ok := metrics[metricKey]
+		if !ok {
+			m := ms.AppendEmpty()
+			o.metric.CopyTo(m)
+			dps = dataPoints(m)
+			metrics[metricKey] = dps
+		}
+		s.writeTo(dps.AppendEmpty())
+	}
+}
+
+// metricSeriesKey identifies a series by its resource, scope, metric name
+// and datapoint attributes.
+func metricSeriesKey(resourceKey string, scope pcommon.InstrumentationScope, name string, attrs pcommon.Map) string {
+	var key strings.Builder
+	key.WriteString(resourceKey)
+	key.WriteByte(0)
+	key.WriteString(scope.Name())
+	key.WriteByte(0)
+	key.WriteString(name)
+	key.WriteByte(0)
+	key.WriteString(attributesKey(attrs))
+	return key.String()
+}
@@ -0,0 +1,403 @@
+package policyprocessor
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	policyv1 "github.com/usetero/policy-go/proto/tero/policy/v1"
+	"github.com/usetero/tero-collector-distro/processor/policyprocessor/internal/metadatatest"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata/metricdatatest"
+)
+
+// keepMetricPolicy keeps metrics whose name starts with prefix.
+func keepMetricPolicy(id, prefix string) *policyv1.Policy {
+	return &policyv1.Policy{
+		Id:      id,
+		Enabled: true,
+		Target: &policyv1.Policy_Metric{
+			Metric: &policyv1.MetricTarget{
+				Match: []*policyv1.MetricMatcher{
+					{
+						Field: &policyv1.MetricMatcher_MetricField{MetricField: policyv1.MetricField_METRIC_FIELD_NAME},
+						Match: &policyv1.MetricMatcher_StartsWith{StartsWith: prefix},
+					},
+				},
+				Keep: true,
+			},
+		},
+	}
+}
+
+func newDownsampleTestProcessor(t *testing.T, aggregation string) *policyProcessor {
+	p := createTestMetricProcessor(t, []*policyv1.Policy{keepMetricPolicy("noisy", "system.")})
+	p.downsampler = newDownsampler([]DownsampleConfig{{PolicyID: "noisy", Interval: time.Minute, Aggregation: aggregation}})
+	return p
+}
+
+// newGaugeBatch builds a batch with one gauge datapoint per value, at the
+// given seconds past the epoch.
+func newGaugeBatch(name string, seconds int64, values ...float64) pmetric.Metrics {
+	md := pmetric.NewMetrics()
+	rm := md.ResourceMetrics().AppendEmpty()
+	rm.Resource().Attributes().PutStr("host.name", "web-1")
+	m := rm.ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName(name)
+	dps := m.SetEmptyGauge().DataPoints()
+	for _, v := range values {
+		dp := dps.AppendEmpty()
+		dp.SetTimestamp(pcommon.Timestamp(seconds * int64(time.Second)))
+		dp.SetDoubleValue(v)
+	}
+	return md
+}
+
+// numberDataPoints returns every number datapoint of md.
+func numberDataPoints(md pmetric.Metrics) []pmetric.NumberDataPoint {
+	var out []pmetric.NumberDataPoint
+	rms := md.ResourceMetrics()
+	for i := range rms.Len() {
+		sms := rms.At(i).ScopeMetrics()
+		for j := range sms.Len() {
+			ms := sms.At(j).Metrics()
+			for k := range ms.Len() {
+				var dps pmetric.NumberDataPointSlice
+				switch m := ms.At(k); m.Type() {
+				case pmetric.MetricTypeGauge:
+					dps = m.Gauge().DataPoints()
+				case pmetric.MetricTypeSum:
+					dps = m.Sum().DataPoints()
+				default:
+					continue
+				}
+				for l := range dps.Len() {
+					out = append(out, dps.At(l))
+				}
+			}
+		}
+	}
+	return out
+}
+
+// newTestDownsampleOrigin returns the origin of a metric of the given type,
+// in an empty resource and scope.
+func newTestDownsampleOrigin(typ pmetric.MetricType, temporality pmetric.AggregationTemporality) *downsampleOrigin {
+	m := pmetric.NewMetric()
+	m.SetName("system.cpu")
+	if typ == pmetric.MetricTypeSum {
+		m.SetEmptySum()
+	} else {
+		m.SetEmptyGauge()
+	}
+	return &downsampleOrigin{
+		resource:    pcommon.NewResource(),
+		scope:       pcommon.NewInstrumentationScope(),
+		metric:      m,
+		temporality: temporality,
+	}
+}
+
+func TestDownsample_GaugeAggregations(t *testing.T) {
+	for _, tt := range []struct {
+		aggregation string
+		want        float64
+	}{
+		{DownsampleLast, 2},
+		{DownsampleMin, 1},
+		{DownsampleMax, 9},
+		{DownsampleAvg, 4},
+	} {
+		t.Run(tt.aggregation, func(t *testing.T) {
+			p := newDownsampleTestProcessor(t, tt.aggregation)
+
+			// A scrape every 10s within the first minute is held back.
+			for i, v := range []float64{3, 9, 1, 5, 4, 2} {
+				out, err := p.processMetrics(context.Background(), newGaugeBatch("system.cpu", int64(i*10), v))
+				require.NoError(t, err)
+				assert.Empty(t, numberDataPoints(out))
+			}
+
+			// The first point of the next minute releases the combined one.
+			out, err := p.processMetrics(context.Background(), newGaugeBatch("system.cpu", 60, 7))
+			require.NoError(t, err)
+			dps := numberDataPoints(out)
+			require.Len(t, dps, 1)
+			assert.Equal(t, tt.want, dps[0].DoubleValue())
+			assert.Equal(t, pcommon.Timestamp(50*time.Second), dps[0].Timestamp())
+		})
+	}
+}
+
+func TestDownsample_IntGaugeKeepsType(t *testing.T) {
+	d := newDownsampler([]DownsampleConfig{{PolicyID: "p", Interval: time.Minute, Aggregation: DownsampleMax}})
+	origin := newTestDownsampleOrigin(pmetric.MetricTypeGauge, pmetric.AggregationTemporalityUnspecified)
+
+	for i, v := range []int64{4, 8, 6} {
+		dp := pmetric.NewNumberDataPoint()
+		dp.SetTimestamp(pcommon.Timestamp(int64(i*10) * int64(time.Second)))
+		dp.SetIntValue(v)
+		assert.False(t, d.add(&d.rules[0], "k", origin, dp))
+	}
+	dp := pmetric.NewNumberDataPoint()
+	dp.SetTimestamp(pcommon.Timestamp(time.Minute))
+	dp.SetIntValue(1)
+	require.True(t, d.add(&d.rules[0], "k", origin, dp))
+	assert.Equal(t, pmetric.NumberDataPointValueTypeInt, dp.ValueType())
+	assert.Equal(t, int64(8), dp.IntValue())
+}
+
+func TestDownsample_DeltaSumAccumulates(t *testing.T) {
+	d := newDownsampler([]DownsampleConfig{{PolicyID: "p", Interval: time.Minute}})
+	origin := newTestDownsampleOrigin(pmetric.MetricTypeSum, pmetric.AggregationTemporalityDelta)
+	add := func(start, end time.Duration, v int64) (pmetric.NumberDataPoint, bool) {
+		dp := pmetric.NewNumberDataPoint()
+		dp.SetStartTimestamp(pcommon.Timestamp(start))
+		dp.SetTimestamp(pcommon.Timestamp(end))
+		dp.SetIntValue(v)
+		return dp, d.add(&d.rules[0], "k", origin, dp)
+	}
+
+	_, kept := add(0, 10*time.Second, 1)
+	assert.False(t, kept)
+	_, kept = add(10*time.Second, 20*time.Second, 2)
+	assert.False(t, kept)
+	_, kept = add(20*time.Second, 30*time.Second, 3)
+	assert.False(t, kept)
+
+	dp, kept := add(time.Minute, 70*time.Second, 10)
+	require.True(t, kept)
+	assert.Equal(t, int64(6), dp.IntValue())
+	assert.Equal(t, pcommon.Timestamp(0), dp.StartTimestamp())
+	assert.Equal(t, pcommon.Timestamp(30*time.Second), dp.Timestamp())
+
+	// A late point of the emitted minute is carried into the current one.
+	_, kept = add(30*time.Second, 40*time.Second, 4)
+	assert.False(t, kept)
+	dp, kept = add(2*time.Minute, 130*time.Second, 1)
+	require.True(t, kept)
+	assert.Equal(t, int64(14), dp.IntValue())
+	assert.Equal(t, pcommon.Timestamp(30*time.Second), dp.StartTimestamp())
+	assert.Equal(t, pcommon.Timestamp(70*time.Second), dp.Timestamp())
+}
+
+func TestDownsample_CumulativeSumKeepsLast(t *testing.T) {
+	d := newDownsampler([]DownsampleConfig{{PolicyID: "p", Interval: time.Minute, Aggregation: DownsampleAvg}})
+	origin := newTestDownsampleOrigin(pmetric.MetricTypeSum, pmetric.AggregationTemporalityCumulative)
+	for i, v := range []int64{10, 20, 30} {
+		dp := pmetric.NewNumberDataPoint()
+		dp.SetTimestamp(pcommon.Timestamp(int64(i*10) * int64(time.Second)))
+		dp.SetIntValue(v)
+		d.add(&d.rules[0], "k", origin, dp)
+	}
+	dp := pmetric.NewNumberDataPoint()
+	dp.SetTimestamp(pcommon.Timestamp(time.Minute))
+	dp.SetIntValue(40)
+	require.True(t, d.add(&d.rules[0], "k", origin, dp))
+	assert.Equal(t, int64(30), dp.IntValue())
+}
+
+func TestDownsample_SeriesAreSeparate(t *testing.T) {
+	p := newDownsampleTestProcessor(t, DownsampleLast)
+
+	batch := func(seconds int64) pmetric.Metrics {
+		md := newGaugeBatch("system.cpu", seconds, 1, 2)
+		dps := md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Gauge().DataPoints()
+		dps.At(0).Attributes().PutStr("cpu", "0")
+		dps.At(1).Attributes().PutStr("cpu", "1")
+		return md
+	}
+	_, err := p.processMetrics(context.Background(), batch(0))
+	require.NoError(t, err)
+	out, err := p.processMetrics(context.Background(), batch(60))
+	require.NoError(t, err)
+
+	dps := numberDataPoints(out)
+	require.Len(t, dps, 2)
+	cpu, _ := dps[0].Attributes().Get("cpu")
+	assert.Equal(t, "0", cpu.Str())
+	assert.Equal(t, 1.0, dps[0].DoubleValue())
+	cpu, _ = dps[1].Attributes().Get("cpu")
+	assert.Equal(t, "1", cpu.Str())
+	assert.Equal(t, 2.0, dps[1].DoubleValue())
+}
+
+func TestDownsample_OtherMetricsPassThrough(t *testing.T) {
+	p := newDownsampleTestProcessor(t, DownsampleLast)
+	tel := withTelemetry(t, p)
+
+	_, err := p.processMetrics(context.Background(), newGaugeBatch("system.cpu", 0, 1, 2))
+	require.NoError(t, err)
+	out, err := p.processMetrics(context.Background(), newGaugeBatch("http.requests", 0, 1, 2))
+	require.NoError(t, err)
+	assert.Len(t, numberDataPoints(out), 2)
+
+	metadatatest.AssertEqualProcessorPolicyRecords(t, tel, []metricdata.DataPoint[int64]{
+		{Attributes: attribute.NewSet(attrTelemetryType.String("metrics"), attrResult.String("downsampled")), Value: 2},
+		{Attributes: attribute.NewSet(attrTelemetryType.String("metrics"), attrResult.String("no_match")), Value: 2},
+	}, metricdatatest.IgnoreTimestamp())
+}
+
+func TestDownsample_BoundedSeries(t *testing.T) {
+	d := newDownsampler([]DownsampleConfig{{PolicyID: "p", Interval: time.Minute}})
+	now := time.Unix(1700000000, 0)
+	d.now = func() time.Time { return now }
+	origin := newTestDownsampleOrigin(pmetric.MetricTypeGauge, pmetric.AggregationTemporalityUnspecified)
+
+	add := func(key string) bool {
+		dp := pmetric.NewNumberDataPoint()
+		dp.SetDoubleValue(1)
+		return d.add(&d.rules[0], key, origin, dp)
+	}
+	for i := range maxDownsampleSeries {
+		require.False(t, add(strconv.Itoa(i)))
+	}
+	assert.True(t, add("extra"), "new series beyond the cap pass through")
+
+	now = now.Add(3 * time.Minute)
+	assert.False(t, add("extra"), "idle series are pruned to make room")
+	assert.Len(t, d.series, 1)
+
+	// The datapoints the pruned series held are flushed, not lost.
+	md := pmetric.NewMetrics()
+	d.appendTo(md, pcommon.NewResource())
+	assert.Equal(t, maxDownsampleSeries, md.DataPointCount())
+}
+
+// newDeltaSumBatch builds a batch with one delta sum datapoint covering the
+// ten seconds up to the given seconds past the epoch.
+func newDeltaSumBatch(name string, seconds, value int64) pmetric.Metrics {
+	md := pmetric.NewMetrics()
+	rm := md.ResourceMetrics().AppendEmpty()
+	rm.Resource().Attributes().PutStr("host.name", "web-1")
+	sm := rm.ScopeMetrics().AppendEmpty()
+	sm.Scope().SetName("scraper")
+	m := sm.Metrics().AppendEmpty()
+	m.SetName(name)
+	m.SetUnit("1")
+	sum := m.SetEmptySum()
+	sum.SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+	sum.SetIsMonotonic(true)
+	dp := sum.DataPoints().AppendEmpty()
+	dp.SetStartTimestamp(pcommon.Timestamp((seconds - 10) * int64(time.Second)))
+	dp.SetTimestamp(pcommon.Timestamp(seconds * int64(time.Second)))
+	dp.SetIntValue(value)
+	return md
+}
+
+func TestDownsample_FlushesStoppedSeries(t *testing.T) {
+	p := newDownsampleTestProcessor(t, DownsampleLast)
+	now := time.Unix(1700000000, 0)
+	p.downsampler.now = func() time.Time { return now }
+
+	for i, v := range []int64{1, 2, 3} {
+		out, err := p.processMetrics(context.Background(), newDeltaSumBatch("system.requests", int64(10+i*10), v))
+		require.NoError(t, err)
+		assert.Empty(t, numberDataPoints(out))
+	}
+
+	// Nothing is flushed while the series may still report.
+	md := pmetric.NewMetrics()
+	p.downsampler.appendTo(md, pcommon.NewResource())
+	assert.Equal(t, 0, md.DataPointCount())
+
+	// Once the series has been idle for an interval, its held datapoint
+	// goes out in a batch of its own, with its resource, scope and metric.
+	now = now.Add(time.Minute)
+	md = pmetric.NewMetrics()
+	p.downsampler.appendTo(md, pcommon.NewResource())
+	require.Equal(t, 1, md.ResourceMetrics().Len())
+	rm := md.ResourceMetrics().At(0)
+	host, _ := rm.Resource().Attributes().Get("host.name")
+	assert.Equal(t, "web-1", host.Str())
+	require.Equal(t, 1, rm.ScopeMetrics().Len())
+	sm := rm.ScopeMetrics().At(0)
+	assert.Equal(t, "scraper", sm.Scope().Name())
+	require.Equal(t, 1, sm.Metrics().Len())
+	m := sm.Metrics().At(0)
+	assert.Equal(t, "system.requests", m.Name())
+	assert.Equal(t, "1", m.Unit())
+	assert.Equal(t, pmetric.AggregationTemporalityDelta, m.Sum().AggregationTemporality())
+	assert.True(t, m.Sum().IsMonotonic())
+	require.Equal(t, 1, m.Sum().DataPoints().Len())
+	dp := m.Sum().DataPoints().At(0)
+	assert.Equal(t, int64(6), dp.IntValue())
+	assert.Equal(t, pcommon.Timestamp(0), dp.StartTimestamp())
+	assert.Equal(t, pcommon.Timestamp(30*time.Second), dp.Timestamp())
+
+	// It is flushed once.
+	md = pmetric.NewMetrics()
+	p.downsampler.appendTo(md, pcommon.NewResource())
+	assert.Equal(t, 0, md.DataPointCount())
+
+	// A late delta of the flushed interval passes through so no count is
+	// lost; the next interval is held again.
+	out, err := p.processMetrics(context.Background(), newDeltaSumBatch("system.requests", 40, 4))
+	require.NoError(t, err)
+	dps := numberDataPoints(out)
+	require.Len(t, dps, 1)
+	assert.Equal(t, int64(4), dps[0].IntValue())
+	out, err = p.processMetrics(context.Background(), newDeltaSumBatch("system.requests", 70, 5))
+	require.NoError(t, err)
+	assert.Empty(t, numberDataPoints(out))
+}
+
+func TestDownsample_LateGaugeAfterFlushIsDropped(t *testing.T) {
+	p := newDownsampleTestProcessor(t, DownsampleLast)
+	now := time.Unix(1700000000, 0)
+	p.downsampler.now = func() time.Time { return now }
+
+	_, err := p.processMetrics(context.Background(), newGaugeBatch("system.cpu", 10, 1))
+	require.NoError(t, err)
+	now = now.Add(time.Minute)
+	md := pmetric.NewMetrics()
+	p.downsampler.appendTo(md, pcommon.NewResource())
+	assert.Equal(t, 1, md.DataPointCount())
+
+	// The interval already has its datapoint.
+	out, err := p.processMetrics(context.Background(), newGaugeBatch("system.cpu", 20, 2))
+	require.NoError(t, err)
+	assert.Empty(t, numberDataPoints(out))
+}
+
+func TestDownsample_FlushesOnShutdown(t *testing.T) {
+	p := newDownsampleTestProcessor(t, DownsampleLast)
+	sink := &consumertest.MetricsSink{}
+	p.nextMetrics = sink
+	p.startSummaryFlush(p.downsampler, time.Hour)
+
+	for i, v := range []int64{1, 2} {
+		_, err := p.processMetrics(context.Background(), newDeltaSumBatch("system.requests", int64(10+i*10), v))
+		require.NoError(t, err)
+	}
+	_, err := p.processMetrics(context.Background(), newGaugeBatch("system.cpu", 10, 7))
+	require.NoError(t, err)
+
+	require.NoError(t, p.shutdown(context.Background()))
+	require.Len(t, sink.AllMetrics(), 1)
+	md := sink.AllMetrics()[0]
+	assert.Equal(t, 2, md.DataPointCount())
+	assert.Equal(t, 1, md.ResourceMetrics().Len(), "series of one resource share it")
+	values := map[string]float64{}
+	for _, dp := range numberDataPoints(md) {
+		switch dp.ValueType() {
+		case pmetric.NumberDataPointValueTypeInt:
+			values["int"] = float64(dp.IntValue())
+		case pmetric.NumberDataPointValueTypeDouble:
+			values["double"] = dp.DoubleValue()
+		}
+	}
+	assert.Equal(t, map[string]float64{"int": 3, "double": 7}, values)
+
+	// Datapoints arriving after shutdown pass through.
+	out, err := p.processMetrics(context.Background(), newGaugeBatch("system.cpu", 20, 8))
+	require.NoError(t, err)
+	assert.Len(t, numberDataPoints(out), 1)
+}
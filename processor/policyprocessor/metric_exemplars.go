@@ -0,0 +1,155 @@
+package policyprocessor
+
+import (
+	"context"
+	"slices"
+
+	"github.com/usetero/policy-go/policy"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// exemplarAction is what an exemplar rule did to a datapoint.
+type exemplarAction uint8
+
+const (
+	// exemplarsUnchanged leaves the datapoint unchanged.
+	exemplarsUnchanged exemplarAction = iota
+	// exemplarsStripped removed exemplars from the datapoint.
+	exemplarsStripped
+	// exemplarsDropped means the datapoint must be dropped.
+	exemplarsDropped
+)
+
+// exemplarAttribute is a compiled filtered attribute condition. An empty
+// value matches any value.
+type exemplarAttribute struct {
+	key   string
+	value string
+}
+
+// exemplarRule is the compiled form of an ExemplarConfig.
+type exemplarRule struct {
+	policyID           string
+	filteredAttributes []exemplarAttribute
+	traceID            bool
+	stripAll           bool
+	drop               bool
+}
+
+// exemplarStripper matches the datapoints of metrics matching an exemplar
+// policy by their exemplars, and strips their exemplars or drops them.
+type exemplarStripper struct {
+	rules []exemplarRule
+}
+
+// newExemplarStripper returns nil when no exemplar rules are configured so
+// callers can skip policy matching entirely.
+func newExemplarStripper(cfgs []ExemplarConfig) *exemplarStripper {
+	if len(cfgs) == 0 {
+		return nil
+	}
+	e := &exemplarStripper{rules: make([]exemplarRule, len(cfgs))}
+	for i, cfg := range cfgs {
+		rule := exemplarRule{
+			policyID: cfg.PolicyID,
+			traceID:  cfg.TraceID,
+			stripAll: cfg.Strip != ExemplarStripMatching,
+			drop:     cfg.Action == ExemplarActionDrop,
+		}
+		for _, a := range cfg.FilteredAttributes {
+			rule.filteredAttributes = append(rule.filteredAttributes, exemplarAttribute{key: a.Key, value: a.Value})
+		}
+		e.rules[i] = rule
+	}
+	return e
+}
+
+// rule returns the first configured rule whose policy is among policyIDs.
+func (e *exemplarStripper) rule(policyIDs []string) *exemplarRule {
+	for i := range e.rules {
+		if slices.Contains(policyIDs, e.rules[i].policyID) {
+			return &e.rules[i]
+		}
+	}
+	return nil
+}
+
+// matches reports whether ex carries one of the rule's filtered attributes,
+// with its value when one is set, or, when requested, a trace ID. A rule
+// without conditions matches every exemplar.
+func (r *exemplarRule) matches(ex pmetric.Exemplar) bool {
+	if len(r.filteredAttributes) == 0 && !r.traceID {
+		return true
+	}
+	if r.traceID && !ex.TraceID().IsEmpty() {
+		return true
+	}
+	attrs := ex.FilteredAttributes()
+	for _, a := range r.filteredAttributes {
+		if v, ok := attrs.Get(a.key); ok && (a.value == "" || v.AsString() == a.value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAny reports whether any of exemplars matches the rule. A datapoint
+// matches the rule when it does.
+func (r *exemplarRule) matchesAny(exemplars pmetric.ExemplarSlice) bool {
+	for _, ex := range exemplars.All() {
+		if r.matches(ex) {
+			return true
+		}
+	}
+	return false
+}
+
+// strip removes the exemplars selected by the rule. It reports whether any
+// exemplar was removed.
+func (r *exemplarRule) strip(exemplars pmetric.ExemplarSlice) bool {
+	if r.stripAll {
+		if !r.matchesAny(exemplars) {
+			return false
+		}
+		exemplars.RemoveIf(func(pmetric.Exemplar) bool { return true })
+		return true
+	}
+	before := exemplars.Len()
+	exemplars.RemoveIf(r.matches)
+	return exemplars.Len() != before
+}
+
+// apply applies the rule to the exemplars of a datapoint.
+func (r *exemplarRule) apply(exemplars pmetric.ExemplarSlice) exemplarAction {
+	switch {
+	case r.drop && r.matchesAny(exemplars):
+		return exemplarsDropped
+	case !r.drop && r.strip(exemplars):
+		return exemplarsStripped
+	default:
+		return exemplarsUnchanged
+	}
+}
+
+// applyExemplars applies the exemplar rule of the first matching policy to
+// the exemplars of a datapoint.
+func (p *policyProcessor) applyExemplars(policyIDs []string, exemplars pmetric.ExemplarSlice) exemplarAction {
+	if p.exemplars == nil || exemplars.Len() == 0 {
+		return exemplarsUnchanged
+	}
+	rule := p.exemplars.rule(policyIDs)
+	if rule == nil {
+		return exemplarsUnchanged
+	}
+	return rule.apply(exemplars)
+}
+
+// recordExemplarDrop records a datapoint dropped by an exemplar rule,
+// attributed to the rule's policy.
+func (p *policyProcessor) recordExemplarDrop(ctx context.Context, matches policyMatches, metricCtx MetricContext, logged bool, size int) {
+	matches.Winner = p.exemplars.rule(matches.IDs).policyID
+	matches.WinnerKeep = policy.KeepNone
+	p.recordResult(ctx, "metrics", "dropped")
+	p.recordBytes(ctx, "metrics", "dropped", matches, size, 0)
+	p.logMetricDecision(logged, metricCtx, "dropped", matches)
+}
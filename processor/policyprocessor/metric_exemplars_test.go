@@ -0,0 +1,165 @@
+package policyprocessor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	policyv1 "github.com/usetero/policy-go/proto/tero/policy/v1"
+	"github.com/usetero/tero-collector-distro/processor/policyprocessor/internal/metadatatest"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata/metricdatatest"
+)
+
+// appendTestExemplars adds an exemplar linked to a trace, one carrying
+// user.id and tenant filtered attributes and a bare one.
+func appendTestExemplars(exemplars pmetric.ExemplarSlice) {
+	linked := exemplars.AppendEmpty()
+	linked.SetDoubleValue(1)
+	linked.SetTraceID(testTraceID(1))
+	user := exemplars.AppendEmpty()
+	user.SetDoubleValue(2)
+	user.FilteredAttributes().PutStr("user.id", "42")
+	user.FilteredAttributes().PutStr("tenant", "internal")
+	bare := exemplars.AppendEmpty()
+	bare.SetDoubleValue(3)
+}
+
+func exemplarValues(exemplars pmetric.ExemplarSlice) []float64 {
+	out := []float64{}
+	for i := range exemplars.Len() {
+		out = append(out, exemplars.At(i).DoubleValue())
+	}
+	return out
+}
+
+var userID = []ExemplarAttributeConfig{{Key: "user.id"}}
+
+func TestExemplarRule_Strip(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		cfg  ExemplarConfig
+		want []float64
+	}{
+		{"presence", ExemplarConfig{}, []float64{}},
+		{"matching presence", ExemplarConfig{Strip: ExemplarStripMatching}, []float64{}},
+		{"matching trace id", ExemplarConfig{TraceID: true, Strip: ExemplarStripMatching}, []float64{2, 3}},
+		{"matching filtered attribute", ExemplarConfig{FilteredAttributes: userID, Strip: ExemplarStripMatching}, []float64{1, 3}},
+		{"matching either", ExemplarConfig{FilteredAttributes: userID, TraceID: true, Strip: ExemplarStripMatching}, []float64{3}},
+		{"all on filtered attribute", ExemplarConfig{FilteredAttributes: userID}, []float64{}},
+		{"no match", ExemplarConfig{FilteredAttributes: []ExemplarAttributeConfig{{Key: "session.id"}}}, []float64{1, 2, 3}},
+		{"matching attribute value", ExemplarConfig{FilteredAttributes: []ExemplarAttributeConfig{{Key: "tenant", Value: "internal"}}, Strip: ExemplarStripMatching}, []float64{1, 3}},
+		{"other attribute value", ExemplarConfig{FilteredAttributes: []ExemplarAttributeConfig{{Key: "tenant", Value: "external"}}}, []float64{1, 2, 3}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.PolicyID = "p"
+			rule := newExemplarStripper([]ExemplarConfig{tt.cfg}).rule([]string{"p"})
+			require.NotNil(t, rule)
+
+			exemplars := pmetric.NewExemplarSlice()
+			appendTestExemplars(exemplars)
+			assert.Equal(t, len(tt.want) != 3, rule.strip(exemplars))
+			assert.Equal(t, tt.want, exemplarValues(exemplars))
+		})
+	}
+}
+
+func TestProcessMetrics_StripsExemplars(t *testing.T) {
+	p := createTestMetricProcessor(t, []*policyv1.Policy{keepMetricPolicy("pii", "http.")})
+	p.exemplars = newExemplarStripper([]ExemplarConfig{{PolicyID: "pii", FilteredAttributes: userID, Strip: ExemplarStripMatching}})
+	tel := withTelemetry(t, p)
+
+	md := pmetric.NewMetrics()
+	ms := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+	gauge := ms.AppendEmpty()
+	gauge.SetName("http.inflight")
+	appendTestExemplars(gauge.SetEmptyGauge().DataPoints().AppendEmpty().Exemplars())
+	sum := ms.AppendEmpty()
+	sum.SetName("http.requests")
+	appendTestExemplars(sum.SetEmptySum().DataPoints().AppendEmpty().Exemplars())
+	hist := ms.AppendEmpty()
+	hist.SetName("http.duration")
+	appendTestExemplars(hist.SetEmptyHistogram().DataPoints().AppendEmpty().Exemplars())
+	exp := ms.AppendEmpty()
+	exp.SetName("http.size")
+	appendTestExemplars(exp.SetEmptyExponentialHistogram().DataPoints().AppendEmpty().Exemplars())
+	clean := ms.AppendEmpty()
+	clean.SetName("http.clean")
+	clean.SetEmptyGauge().DataPoints().AppendEmpty().Exemplars().AppendEmpty().SetTraceID(testTraceID(2))
+	other := ms.AppendEmpty()
+	other.SetName("rpc.duration")
+	appendTestExemplars(other.SetEmptyGauge().DataPoints().AppendEmpty().Exemplars())
+
+	out, err := p.processMetrics(context.Background(), md)
+	require.NoError(t, err)
+	ms = out.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics()
+
+	assert.Equal(t, []float64{1, 3}, exemplarValues(ms.At(0).Gauge().DataPoints().At(0).Exemplars()))
+	assert.Equal(t, []float64{1, 3}, exemplarValues(ms.At(1).Sum().DataPoints().At(0).Exemplars()))
+	assert.Equal(t, []float64{1, 3}, exemplarValues(ms.At(2).Histogram().DataPoints().At(0).Exemplars()))
+	assert.Equal(t, []float64{1, 3}, exemplarValues(ms.At(3).ExponentialHistogram().DataPoints().At(0).Exemplars()))
+	assert.Equal(t, 1, ms.At(4).Gauge().DataPoints().At(0).Exemplars().Len())
+	assert.Equal(t, []float64{1, 2, 3}, exemplarValues(ms.At(5).Gauge().DataPoints().At(0).Exemplars()), "unmatched metrics keep their exemplars")
+
+	metadatatest.AssertEqualProcessorPolicyRecords(t, tel, []metricdata.DataPoint[int64]{
+		{Attributes: attribute.NewSet(attrTelemetryType.String("metrics"), attrResult.String("transformed")), Value: 4},
+		{Attributes: attribute.NewSet(attrTelemetryType.String("metrics"), attrResult.String("kept")), Value: 1},
+		{Attributes: attribute.NewSet(attrTelemetryType.String("metrics"), attrResult.String("no_match")), Value: 1},
+	}, metricdatatest.IgnoreTimestamp())
+}
+
+func TestExemplarRule_Drop(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		cfg  ExemplarConfig
+		add  func(pmetric.ExemplarSlice)
+		want exemplarAction
+	}{
+		{"presence", ExemplarConfig{}, appendTestExemplars, exemplarsDropped},
+		{"no exemplars", ExemplarConfig{}, func(pmetric.ExemplarSlice) {}, exemplarsUnchanged},
+		{"trace id", ExemplarConfig{TraceID: true}, appendTestExemplars, exemplarsDropped},
+		{"attribute value", ExemplarConfig{FilteredAttributes: []ExemplarAttributeConfig{{Key: "tenant", Value: "internal"}}}, appendTestExemplars, exemplarsDropped},
+		{"other attribute value", ExemplarConfig{FilteredAttributes: []ExemplarAttributeConfig{{Key: "tenant", Value: "external"}}}, appendTestExemplars, exemplarsUnchanged},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.PolicyID = "p"
+			tt.cfg.Action = ExemplarActionDrop
+			p := &policyProcessor{exemplars: newExemplarStripper([]ExemplarConfig{tt.cfg})}
+
+			exemplars := pmetric.NewExemplarSlice()
+			tt.add(exemplars)
+			n := exemplars.Len()
+			assert.Equal(t, tt.want, p.applyExemplars([]string{"p"}, exemplars))
+			assert.Equal(t, n, exemplars.Len(), "dropping leaves the exemplars alone")
+		})
+	}
+}
+
+func TestProcessMetrics_DropsDatapointsByExemplars(t *testing.T) {
+	p := createTestMetricProcessor(t, []*policyv1.Policy{keepMetricPolicy("traced", "http.")})
+	p.exemplars = newExemplarStripper([]ExemplarConfig{{PolicyID: "traced", TraceID: true, Action: ExemplarActionDrop}})
+	tel := withTelemetry(t, p)
+
+	md := pmetric.NewMetrics()
+	ms := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+	sum := ms.AppendEmpty()
+	sum.SetName("http.requests")
+	dps := sum.SetEmptySum().DataPoints()
+	dps.AppendEmpty().Exemplars().AppendEmpty().SetTraceID(testTraceID(1))
+	dps.AppendEmpty().SetIntValue(7)
+	dps.AppendEmpty().Exemplars().AppendEmpty().SetDoubleValue(1)
+
+	out, err := p.processMetrics(context.Background(), md)
+	require.NoError(t, err)
+	dps = out.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Sum().DataPoints()
+	require.Equal(t, 2, dps.Len(), "only the datapoint with a traced exemplar is dropped")
+	assert.Equal(t, int64(7), dps.At(0).IntValue())
+
+	metadatatest.AssertEqualProcessorPolicyRecords(t, tel, []metricdata.DataPoint[int64]{
+		{Attributes: attribute.NewSet(attrTelemetryType.String("metrics"), attrResult.String("dropped")), Value: 1},
+		{Attributes: attribute.NewSet(attrTelemetryType.String("metrics"), attrResult.String("kept")), Value: 2},
+	}, metricdatatest.IgnoreTimestamp())
+}
@@ -0,0 +1,126 @@
+package policyprocessor
+
+import (
+	"slices"
+
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// histogramRule is the compiled form of a HistogramConfig.
+type histogramRule struct {
+	policyID   string
+	boundaries []float64
+	// maxScale caps the exponential histogram scale when hasMaxScale is set.
+	maxScale    int32
+	hasMaxScale bool
+}
+
+// histogramTransformer reduces the resolution of histogram datapoints of
+// metrics matching a histogram policy. Counts, sums, min and max are left
+// exactly as they were.
+type histogramTransformer struct {
+	rules []histogramRule
+}
+
+// newHistogramTransformer returns nil when no histogram rules are configured
+// so callers can skip policy matching entirely.
+func newHistogramTransformer(cfgs []HistogramConfig) *histogramTransformer {
+	if len(cfgs) == 0 {
+		return nil
+	}
+	h := &histogramTransformer{rules: make([]histogramRule, len(cfgs))}
+	for i, cfg := range cfgs {
+		h.rules[i] = histogramRule{
+			policyID:   cfg.PolicyID,
+			boundaries: cfg.Boundaries,
+		}
+		if cfg.MaxScale != nil {
+			h.rules[i].maxScale = *cfg.MaxScale
+			h.rules[i].hasMaxScale = true
+		}
+	}
+	return h
+}
+
+// rule returns the first configured rule whose policy is among policyIDs.
+func (h *histogramTransformer) rule(policyIDs []string) *histogramRule {
+	for i := range h.rules {
+		if slices.Contains(policyIDs, h.rules[i].policyID) {
+			return &h.rules[i]
+		}
+	}
+	return nil
+}
+
+// coarsen merges the buckets of dp into the rule's boundaries. Boundaries
+// the datapoint does not have are skipped, since its counts cannot be split
+// exactly. It reports whether dp changed.
+func (r *histogramRule) coarsen(dp pmetric.HistogramDataPoint) bool {
+	if len(r.boundaries) == 0 {
+		return false
+	}
+	bounds := dp.ExplicitBounds().AsRaw()
+	counts := dp.BucketCounts().AsRaw()
+	if len(counts) != len(bounds)+1 {
+		// Malformed or count-only datapoints have nothing to merge.
+		return false
+	}
+
+	kept := make([]float64, 0, min(len(bounds), len(r.boundaries)))
+	for _, b := range bounds {
+		if _, ok := slices.BinarySearch(r.boundaries, b); ok {
+			kept = append(kept, b)
+		}
+	}
+	if len(kept) == len(bounds) {
+		return false
+	}
+
+	// Source bucket i covers (bounds[i-1], bounds[i]] and falls into the
+	// first kept bucket whose upper bound is at least bounds[i].
+	merged := make([]uint64, len(kept)+1)
+	j := 0
+	for i, c := range counts {
+		for i < len(bounds) && j < len(kept) && kept[j] < bounds[i] {
+			j++
+		}
+		if i == len(bounds) {
+			j = len(kept)
+		}
+		merged[j] += c
+	}
+	dp.ExplicitBounds().FromRaw(kept)
+	dp.BucketCounts().FromRaw(merged)
+	return true
+}
+
+// downscale lowers the scale of dp to the rule's maximum, merging adjacent
+// buckets. It reports whether dp changed.
+func (r *histogramRule) downscale(dp pmetric.ExponentialHistogramDataPoint) bool {
+	if !r.hasMaxScale || dp.Scale() <= r.maxScale {
+		return false
+	}
+	change := dp.Scale() - r.maxScale
+	downscaleBuckets(dp.Positive(), change)
+	downscaleBuckets(dp.Negative(), change)
+	dp.SetScale(r.maxScale)
+	return true
+}
+
+// downscaleBuckets merges buckets for a scale reduced by change. Each step
+// halves the resolution, so bucket index i maps to i >> change.
+func downscaleBuckets(b pmetric.ExponentialHistogramDataPointBuckets, change int32) {
+	counts := b.BucketCounts()
+	if counts.Len() == 0 {
+		b.SetOffset(b.Offset() >> change)
+		return
+	}
+	offset := b.Offset() >> change
+	last := (b.Offset() + int32(counts.Len()) - 1) >> change
+	merged := make([]uint64, last-offset+1)
+	for i := range counts.Len() {
+		merged[((b.Offset()+int32(i))>>change)-offset] += counts.At(i)
+	}
+	b.SetOffset(offset)
+	counts.FromRaw(merged)
+}
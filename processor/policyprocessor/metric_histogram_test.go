@@ -0,0 +1,132 @@
+package policyprocessor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	policyv1 "github.com/usetero/policy-go/proto/tero/policy/v1"
+	"github.com/usetero/tero-collector-distro/processor/policyprocessor/internal/metadatatest"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata/metricdatatest"
+)
+
+func newTestHistogramDataPoint(bounds []float64, counts []uint64) pmetric.HistogramDataPoint {
+	dp := pmetric.NewHistogramDataPoint()
+	dp.ExplicitBounds().FromRaw(bounds)
+	dp.BucketCounts().FromRaw(counts)
+	var total uint64
+	for _, c := range counts {
+		total += c
+	}
+	dp.SetCount(total)
+	dp.SetSum(123.5)
+	dp.SetMin(0.5)
+	dp.SetMax(42)
+	return dp
+}
+
+func TestHistogramRule_Coarsen(t *testing.T) {
+	rule := histogramRule{boundaries: []float64{2, 10, 50}}
+	dp := newTestHistogramDataPoint([]float64{1, 2, 5, 10}, []uint64{1, 2, 3, 4, 5})
+
+	require.True(t, rule.coarsen(dp))
+	assert.Equal(t, []float64{2, 10}, dp.ExplicitBounds().AsRaw(), "50 is not a boundary of the datapoint")
+	assert.Equal(t, []uint64{3, 7, 5}, dp.BucketCounts().AsRaw())
+	assert.Equal(t, uint64(15), dp.Count())
+	assert.Equal(t, 123.5, dp.Sum())
+	assert.Equal(t, 0.5, dp.Min())
+	assert.Equal(t, 42.0, dp.Max())
+}
+
+func TestHistogramRule_CoarsenWithoutSharedBoundaries(t *testing.T) {
+	rule := histogramRule{boundaries: []float64{3}}
+	dp := newTestHistogramDataPoint([]float64{1, 2}, []uint64{1, 2, 3})
+
+	require.True(t, rule.coarsen(dp))
+	assert.Empty(t, dp.ExplicitBounds().AsRaw())
+	assert.Equal(t, []uint64{6}, dp.BucketCounts().AsRaw())
+}
+
+func TestHistogramRule_CoarsenLeavesCoarseDataPoints(t *testing.T) {
+	rule := histogramRule{boundaries: []float64{1, 2, 5}}
+	dp := newTestHistogramDataPoint([]float64{2, 5}, []uint64{1, 2, 3})
+	assert.False(t, rule.coarsen(dp))
+
+	countOnly := pmetric.NewHistogramDataPoint()
+	countOnly.SetCount(4)
+	assert.False(t, rule.coarsen(countOnly))
+}
+
+func TestHistogramRule_Downscale(t *testing.T) {
+	rule := histogramRule{maxScale: 1, hasMaxScale: true}
+	dp := pmetric.NewExponentialHistogramDataPoint()
+	dp.SetScale(3)
+	dp.SetCount(13)
+	dp.SetZeroCount(2)
+	dp.SetSum(99)
+	// Indices -3..1 merge into -1 and 0 at scale 1.
+	dp.Positive().SetOffset(-3)
+	dp.Positive().BucketCounts().FromRaw([]uint64{1, 1, 1, 1, 1})
+	// Indices 5..9 merge into 1 and 2.
+	dp.Negative().SetOffset(5)
+	dp.Negative().BucketCounts().FromRaw([]uint64{1, 1, 1, 2, 1})
+
+	require.True(t, rule.downscale(dp))
+	assert.Equal(t, int32(1), dp.Scale())
+	assert.Equal(t, int32(-1), dp.Positive().Offset())
+	assert.Equal(t, []uint64{3, 2}, dp.Positive().BucketCounts().AsRaw())
+	assert.Equal(t, int32(1), dp.Negative().Offset())
+	assert.Equal(t, []uint64{3, 3}, dp.Negative().BucketCounts().AsRaw())
+	assert.Equal(t, uint64(13), dp.Count())
+	assert.Equal(t, uint64(2), dp.ZeroCount())
+	assert.Equal(t, 99.0, dp.Sum())
+
+	assert.False(t, rule.downscale(dp), "datapoints at or below the maximum scale are left alone")
+}
+
+func TestProcessMetrics_HistogramTransforms(t *testing.T) {
+	p := createTestMetricProcessor(t, []*policyv1.Policy{keepMetricPolicy("latency", "http.")})
+	p.histograms = newHistogramTransformer([]HistogramConfig{{
+		PolicyID:   "latency",
+		Boundaries: []float64{10, 100},
+		MaxScale:   int32Ptr(0),
+	}})
+	tel := withTelemetry(t, p)
+
+	md := pmetric.NewMetrics()
+	ms := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics()
+	hist := ms.AppendEmpty()
+	hist.SetName("http.duration")
+	newTestHistogramDataPoint([]float64{5, 10, 50, 100}, []uint64{1, 1, 1, 1, 1}).CopyTo(hist.SetEmptyHistogram().DataPoints().AppendEmpty())
+	exp := ms.AppendEmpty()
+	exp.SetName("http.size")
+	edp := exp.SetEmptyExponentialHistogram().DataPoints().AppendEmpty()
+	edp.SetScale(2)
+	edp.Positive().BucketCounts().FromRaw([]uint64{1, 1, 1, 1})
+	other := ms.AppendEmpty()
+	other.SetName("rpc.duration")
+	newTestHistogramDataPoint([]float64{5, 10}, []uint64{1, 1, 1}).CopyTo(other.SetEmptyHistogram().DataPoints().AppendEmpty())
+
+	out, err := p.processMetrics(context.Background(), md)
+	require.NoError(t, err)
+	ms = out.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics()
+
+	dp := ms.At(0).Histogram().DataPoints().At(0)
+	assert.Equal(t, []float64{10, 100}, dp.ExplicitBounds().AsRaw())
+	assert.Equal(t, []uint64{2, 2, 1}, dp.BucketCounts().AsRaw())
+
+	edp = ms.At(1).ExponentialHistogram().DataPoints().At(0)
+	assert.Equal(t, int32(0), edp.Scale())
+	assert.Equal(t, []uint64{4}, edp.Positive().BucketCounts().AsRaw())
+
+	assert.Equal(t, []float64{5, 10}, ms.At(2).Histogram().DataPoints().At(0).ExplicitBounds().AsRaw(), "unmatched metrics keep their buckets")
+
+	metadatatest.AssertEqualProcessorPolicyRecords(t, tel, []metricdata.DataPoint[int64]{
+		{Attributes: attribute.NewSet(attrTelemetryType.String("metrics"), attrResult.String("transformed")), Value: 2},
+		{Attributes: attribute.NewSet(attrTelemetryType.String("metrics"), attrResult.String("no_match")), Value: 1},
+	}, metricdatatest.IgnoreTimestamp())
+}
@@ -0,0 +1,177 @@
+package policyprocessor
+
+import (
+	"context"
+	"sync"
+
+	"github.com/usetero/policy-go/policy"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// attrRemovedAncestors is set on a re-parented span to the number of dropped
+// ancestors between it and its new parent.
+const attrRemovedAncestors = "span.removed_ancestors"
+
+// spanKey identifies a span within a batch.
+type spanKey struct {
+	traceID pcommon.TraceID
+	spanID  pcommon.SpanID
+}
+
+// spanParents maps every span of td to its parent span ID.
+func spanParents(td ptrace.Traces) map[spanKey]pcommon.SpanID {
+	parents := make(map[spanKey]pcommon.SpanID, td.SpanCount())
+	forEachSpan(td, func(span ptrace.Span) {
+		parents[spanKey{span.TraceID(), span.SpanID()}] = span.ParentSpanID()
+	})
+	return parents
+}
+
+// forEachSpan calls fn for every span of td.
+func forEachSpan(td ptrace.Traces, fn func(ptrace.Span)) {
+	rss := td.ResourceSpans()
+	for i := range rss.Len() {
+		sss := rss.At(i).ScopeSpans()
+		for j := range sss.Len() {
+			spans := sss.At(j).Spans()
+			for k := range spans.Len() {
+				fn(spans.At(k))
+			}
+		}
+	}
+}
+
+// keptSpan is the outcome of a span the policies kept. Its result is only
+// recorded once orphaned span handling has decided whether the span stays.
+type keptSpan struct {
+	result  policy.EvaluateResult
+	matches policyMatches
+	size    int
+	capture *spanCapture
+}
+
+// keptSpans collects the kept spans of a batch across workers.
+type keptSpans struct {
+	mu    sync.Mutex
+	spans map[spanKey]keptSpan
+}
+
+func newKeptSpans() *keptSpans {
+	return &keptSpans{spans: make(map[spanKey]keptSpan)}
+}
+
+func (k *keptSpans) add(span ptrace.Span, ks keptSpan) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.spans[spanKey{span.TraceID(), span.SpanID()}] = ks
+}
+
+// orphanedSpans resolves the ancestry of the spans left in a batch after
+// policies dropped some of them.
+type orphanedSpans struct {
+	// parents holds every span of the batch before anything was dropped.
+	parents map[spanKey]pcommon.SpanID
+	// kept holds the spans still in the batch.
+	kept map[spanKey]bool
+	// orphaned memoizes whether a kept span descends from a dropped one.
+	orphaned map[spanKey]bool
+}
+
+// nearestKeptAncestor walks up from parent past spans dropped from the batch.
+// It returns the first ancestor that was kept or is not part of the batch,
+// and how many dropped spans were skipped to reach it.
+func (o *orphanedSpans) nearestKeptAncestor(traceID pcommon.TraceID, parent pcommon.SpanID) (pcommon.SpanID, int64) {
+	var removed int64
+	// Bound the walk in case of a parent cycle in malformed data.
+	for range len(o.parents) {
+		if parent.IsEmpty() {
+			break
+		}
+		k := spanKey{traceID, parent}
+		grandparent, inBatch := o.parents[k]
+		if !inBatch || o.kept[k] {
+			break
+		}
+		removed++
+		parent = grandparent
+	}
+	return parent, removed
+}
+
+// isOrphaned reports whether a kept span has a dropped span among its
+// ancestors in the batch.
+func (o *orphanedSpans) isOrphaned(k spanKey) bool {
+	if orphaned, ok := o.orphaned[k]; ok {
+		return orphaned
+	}
+	// Guard against parent cycles while the answer is computed.
+	o.orphaned[k] = false
+
+	ancestor, removed := o.nearestKeptAncestor(k.traceID, o.parents[k])
+	orphaned := removed > 0
+	if !orphaned && !ancestor.IsEmpty() {
+		if a := (spanKey{k.traceID, ancestor}); o.kept[a] {
+			orphaned = o.isOrphaned(a)
+		}
+	}
+	o.orphaned[k] = orphaned
+	return orphaned
+}
+
+// handleOrphanedSpans applies the orphaned span strategy to the spans left in
+// td and records their results. parents must describe td before any span was
+// dropped, and kept hold the outcome of every span left. Re-parented spans
+// count as transformed, and dropped orphans as dropped.
+func (p *policyProcessor) handleOrphanedSpans(ctx context.Context, td ptrace.Traces, parents map[spanKey]pcommon.SpanID, kept *keptSpans) {
+	o := &orphanedSpans{
+		parents:  parents,
+		kept:     make(map[spanKey]bool, td.SpanCount()),
+		orphaned: make(map[spanKey]bool),
+	}
+	forEachSpan(td, func(span ptrace.Span) {
+		o.kept[spanKey{span.TraceID(), span.SpanID()}] = true
+	})
+	if len(o.kept) == len(parents) {
+		forEachSpan(td, func(span ptrace.Span) {
+			p.recordKeptSpan(ctx, span, kept.spans[spanKey{span.TraceID(), span.SpanID()}])
+		})
+		return
+	}
+
+	switch p.orphanedSpans {
+	case OrphanedSpansReparent:
+		forEachSpan(td, func(span ptrace.Span) {
+			ks := kept.spans[spanKey{span.TraceID(), span.SpanID()}]
+			if ancestor, removed := o.nearestKeptAncestor(span.TraceID(), span.ParentSpanID()); removed > 0 {
+				span.SetParentSpanID(ancestor)
+				span.Attributes().PutInt(attrRemovedAncestors, removed)
+				ks.result = policy.ResultKeepWithTransform
+			}
+			p.recordKeptSpan(ctx, span, ks)
+		})
+	case OrphanedSpansDrop:
+		td.ResourceSpans().RemoveIf(func(rs ptrace.ResourceSpans) bool {
+			rs.ScopeSpans().RemoveIf(func(ss ptrace.ScopeSpans) bool {
+				ss.Spans().RemoveIf(func(span ptrace.Span) bool {
+					k := spanKey{span.TraceID(), span.SpanID()}
+					ks := kept.spans[k]
+					if !o.isOrphaned(k) {
+						p.recordKeptSpan(ctx, span, ks)
+						return false
+					}
+					// The span is dropped for its ancestry, not by the policy
+					// that kept it, so its bytes are not attributed to one.
+					p.recordResult(ctx, "traces", "dropped")
+					p.recordTraceDecision(span.TraceID(), false)
+					p.recordBytes(ctx, "traces", "dropped", policyMatches{}, ks.size, 0)
+					ks.capture.publish("dropped")
+					p.recordDroppedSpan(rs.Resource(), span)
+					return true
+				})
+				return ss.Spans().Len() == 0
+			})
+			return rs.ScopeSpans().Len() == 0
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package policyprocessor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	policyv1 "github.com/usetero/policy-go/proto/tero/policy/v1"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func testSpanID(i byte) pcommon.SpanID {
+	return pcommon.SpanID{0, 0, 0, 0, 0, 0, 0, i}
+}
+
+// newOrphanTestTraces builds one trace:
+//
+//	root
+//	├── healthcheck (1)
+//	│   └── healthcheck (2)
+//	│       └── query
+//	│           └── fetch
+//	└── render
+func newOrphanTestTraces() ptrace.Traces {
+	td := ptrace.NewTraces()
+	traceID := testTraceID(1)
+	for _, s := range []struct {
+		id, parent byte
+		name       string
+	}{
+		{1, 0, "root"},
+		{2, 1, "healthcheck"},
+		{3, 2, "healthcheck"},
+		{4, 3, "query"},
+		{5, 4, "fetch"},
+		{6, 1, "render"},
+	} {
+		span := appendSpan(td, traceID, s.name, false)
+		span.SetSpanID(testSpanID(s.id))
+		if s.parent != 0 {
+			span.SetParentSpanID(testSpanID(s.parent))
+		}
+	}
+	return td
+}
+
+type orphanTestSpan struct {
+	name    string
+	parent  pcommon.SpanID
+	removed int64
+}
+
+func orphanTestSpans(td ptrace.Traces) []orphanTestSpan {
+	var out []orphanTestSpan
+	forEachSpan(td, func(span ptrace.Span) {
+		s := orphanTestSpan{name: span.Name(), parent: span.ParentSpanID()}
+		if v, ok := span.Attributes().Get(attrRemovedAncestors); ok {
+			s.removed = v.Int()
+		}
+		out = append(out, s)
+	})
+	return out
+}
+
+func processOrphanTestTraces(t *testing.T, strategy string) []orphanTestSpan {
+	p := createTestTraceProcessor(t, []*policyv1.Policy{dropSpanNamePolicy("healthcheck")})
+	p.orphanedSpans = strategy
+	out, err := p.processTraces(context.Background(), newOrphanTestTraces())
+	require.NoError(t, err)
+	return orphanTestSpans(out)
+}
+
+func TestOrphanedSpans_Keep(t *testing.T) {
+	assert.Equal(t, []orphanTestSpan{
+		{name: "root"},
+		{name: "query", parent: testSpanID(3)},
+		{name: "fetch", parent: testSpanID(4)},
+		{name: "render", parent: testSpanID(1)},
+	}, processOrphanTestTraces(t, OrphanedSpansKeep))
+}
+
+func TestOrphanedSpans_Reparent(t *testing.T) {
+	assert.Equal(t, []orphanTestSpan{
+		{name: "root"},
+		{name: "query", parent: testSpanID(1), removed: 2},
+		{name: "fetch", parent: testSpanID(4)},
+		{name: "render", parent: testSpanID(1)},
+	}, processOrphanTestTraces(t, OrphanedSpansReparent))
+}
+
+func TestOrphanedSpans_Drop(t *testing.T) {
+	assert.Equal(t, []orphanTestSpan{
+		{name: "root"},
+		{name: "render", parent: testSpanID(1)},
+	}, processOrphanTestTraces(t, OrphanedSpansDrop))
+}
+
+func TestOrphanedSpans_ReparentsToRootWhenRootDropped(t *testing.T) {
+	p := createTestTraceProcessor(t, []*policyv1.Policy{dropSpanNamePolicy("root")})
+	p.orphanedSpans = OrphanedSpansReparent
+	out, err := p.processTraces(context.Background(), newOrphanTestTraces())
+	require.NoError(t, err)
+
+	spans := orphanTestSpans(out)
+	require.Len(t, spans, 5)
+	assert.Equal(t, orphanTestSpan{name: "healthcheck", removed: 1}, spans[0], "children of a dropped root become roots")
+	assert.Equal(t, orphanTestSpan{name: "render", removed: 1}, spans[4])
+}
+
+func TestOrphanedSpans_ParentOutsideBatchIsLeftAlone(t *testing.T) {
+	p := createTestTraceProcessor(t, []*policyv1.Policy{dropSpanNamePolicy("healthcheck")})
+	p.orphanedSpans = OrphanedSpansDrop
+
+	td := ptrace.NewTraces()
+	span := appendSpan(td, testTraceID(1), "query", false)
+	span.SetSpanID(testSpanID(2))
+	span.SetParentSpanID(testSpanID(9))
+	appendSpan(td, testTraceID(1), "healthcheck", false).SetSpanID(testSpanID(3))
+
+	out, err := p.processTraces(context.Background(), td)
+	require.NoError(t, err)
+	assert.Equal(t, []orphanTestSpan{{name: "query", parent: testSpanID(9)}}, orphanTestSpans(out))
+}
+
+func TestOrphanedSpans_ParentCycleTerminates(t *testing.T) {
+	p := createTestTraceProcessor(t, []*policyv1.Policy{dropSpanNamePolicy("healthcheck")})
+	p.orphanedSpans = OrphanedSpansDrop
+
+	td := ptrace.NewTraces()
+	a := appendSpan(td, testTraceID(1), "a", false)
+	a.SetSpanID(testSpanID(1))
+	a.SetParentSpanID(testSpanID(2))
+	b := appendSpan(td, testTraceID(1), "b", false)
+	b.SetSpanID(testSpanID(2))
+	b.SetParentSpanID(testSpanID(1))
+	appendSpan(td, testTraceID(1), "healthcheck", false).SetSpanID(testSpanID(3))
+
+	out, err := p.processTraces(context.Background(), td)
+	require.NoError(t, err)
+	assert.Len(t, orphanTestSpans(out), 2)
+}
+
+func TestOrphanedSpans_RecordsResults(t *testing.T) {
+	for _, tt := range []struct {
+		strategy string
+		want     map[string]int64
+	}{
+		{OrphanedSpansReparent, map[string]int64{"dropped": 2, "transformed": 1, "no_match": 3}},
+		{OrphanedSpansDrop, map[string]int64{"dropped": 4, "no_match": 2}},
+	} {
+		t.Run(tt.strategy, func(t *testing.T) {
+			p := createTestTraceProcessor(t, []*policyv1.Policy{dropSpanNamePolicy("healthcheck")})
+			p.orphanedSpans = tt.strategy
+			tel := withTelemetry(t, p)
+
+			_, err := p.processTraces(context.Background(), newOrphanTestTraces())
+			require.NoError(t, err)
+
+			got := make(map[string]int64)
+			for _, dp := range policyRecords(t, tel) {
+				result, _ := dp.Attributes.Value(attrResult)
+				got[result.AsString()] = dp.Value
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestOrphanedSpans_DroppedOrphansDropTheirTrace(t *testing.T) {
+	p := createTestTraceProcessor(t, []*policyv1.Policy{
+		dropSpanNamePolicy("healthcheck"),
+		keepSpanNamePolicy("query"),
+	})
+	p.orphanedSpans = OrphanedSpansDrop
+	p.traceDecisions = newTraceDecisionCache(TraceDecisionsConfig{Enabled: true})
+
+	_, err := p.processTraces(context.Background(), newOrphanTestTraces())
+	require.NoError(t, err)
+
+	keep, ok := p.traceDecisions.lookup(testTraceID(1))
+	require.True(t, ok)
+	assert.False(t, keep, "a kept span dropped as an orphan does not keep its trace")
+}
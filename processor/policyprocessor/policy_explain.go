@@ -0,0 +1,321 @@
+package policyprocessor
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/usetero/policy-go/backend/hyperscan"
+	"github.com/usetero/policy-go/policy"
+	policyv1 "github.com/usetero/policy-go/proto/tero/policy/v1"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// recordExplanation describes how the live policies handle a single record.
+type recordExplanation struct {
+	// Result is the outcome of policy evaluation: dropped, sampled,
+	// rate_limited, kept, transformed or no_match. Processor stages that
+	// follow evaluation, such as dedup or downsampling, are not applied.
+	Result string `json:"result"`
+	// Winner is the matching policy whose keep action applied.
+	Winner   string            `json:"winner,omitempty"`
+	Policies []explainedPolicy `json:"policies"`
+	// Output holds the record with its resource and scope as OTLP JSON after
+	// evaluation. It is omitted for dropped records and metric datapoints,
+	// which policies do not transform.
+	Output json.RawMessage `json:"output,omitempty"`
+
+	matches policyMatches
+}
+
+// explainedPolicy is a policy at least one of whose matchers hit the record.
+type explainedPolicy struct {
+	ID       string             `json:"id"`
+	Name     string             `json:"name,omitempty"`
+	Matched  bool               `json:"matched"`
+	Matchers []explainedMatcher `json:"matchers"`
+}
+
+type explainedMatcher struct {
+	Index int  `json:"index"`
+	Hit   bool `json:"hit"`
+	// Matcher is the matcher as delivered by the policy's provider.
+	Matcher json.RawMessage `json:"matcher,omitempty"`
+}
+
+// explain evaluates every record of the OTLP JSON payload of signal against
+// the live policies of p. The records are not passed to the pipeline, and
+// are evaluated by an engine of their own so that they count toward neither
+// the policy hits nor the rate limits of the live engine.
+func (p *policyProcessor) explain(signal string, payload []byte) ([]recordExplanation, error) {
+	var (
+		ld  plog.Logs
+		td  ptrace.Traces
+		md  pmetric.Metrics
+		err error
+	)
+	switch signal {
+	case "logs":
+		if ld, err = (&plog.JSONUnmarshaler{}).UnmarshalLogs(payload); err != nil {
+			return nil, fmt.Errorf("invalid OTLP JSON logs: %w", err)
+		}
+	case "traces":
+		if td, err = (&ptrace.JSONUnmarshaler{}).UnmarshalTraces(payload); err != nil {
+			return nil, fmt.Errorf("invalid OTLP JSON traces: %w", err)
+		}
+	case "metrics":
+		if md, err = (&pmetric.JSONUnmarshaler{}).UnmarshalMetrics(payload); err != nil {
+			return nil, fmt.Errorf("invalid OTLP JSON metrics: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown signal %q", signal)
+	}
+
+	registry := policy.NewPolicyRegistry(policy.WithRegexBackend(hyperscan.New()))
+	if _, err := registry.Register(staticPolicies(p.livePolicies())); err != nil {
+		return nil, fmt.Errorf("failed to load policies: %w", err)
+	}
+	engine := policy.NewPolicyEngine(registry)
+	switch signal {
+	case "logs":
+		return p.explainLogs(registry, engine, ld), nil
+	case "traces":
+		return p.explainTraces(registry, engine, td), nil
+	default:
+		return p.explainMetrics(registry, engine, md), nil
+	}
+}
+
+// livePolicies returns the policies delivered by the providers that are in
+// the current snapshots.
+func (p *policyProcessor) livePolicies() []*policyv1.Policy {
+	inv := p.inventory
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	var out []*policyv1.Policy
+	seen := make(map[string]bool)
+	for _, state := range inv.providers {
+		for _, entry := range state.entries {
+			if !entry.enabled || entry.signal < 0 || seen[entry.id] || !p.policyCompiled(entry.signal, entry.id) {
+				continue
+			}
+			seen[entry.id] = true
+			out = append(out, entry.source)
+		}
+	}
+	return out
+}
+
+// staticPolicies is a policy provider that delivers a fixed set of policies.
+type staticPolicies []*policyv1.Policy
+
+func (s staticPolicies) Load() ([]*policyv1.Policy, error) { return s, nil }
+
+func (s staticPolicies) Subscribe(callback policy.PolicyCallback) error {
+	callback(s)
+	return nil
+}
+
+func (staticPolicies) SetStatsCollector(policy.StatsCollector) {}
+
+func (p *policyProcessor) explainLogs(registry *policy.PolicyRegistry, engine *policy.PolicyEngine, ld plog.Logs) []recordExplanation {
+	snapshot := registry.LogSnapshot()
+	opts := LogOptions()
+	out := make([]recordExplanation, 0, ld.LogRecordCount())
+	for i := range ld.ResourceLogs().Len() {
+		rl := ld.ResourceLogs().At(i)
+		for j := range rl.ScopeLogs().Len() {
+			sl := rl.ScopeLogs().At(j)
+			for k := range sl.LogRecords().Len() {
+				ctx := LogContext{
+					Record:            sl.LogRecords().At(k),
+					Resource:          rl.Resource(),
+					Scope:             sl.Scope(),
+					ResourceSchemaURL: rl.SchemaUrl(),
+					ScopeSchemaURL:    sl.SchemaUrl(),
+				}
+				// Match before evaluating, which applies transforms.
+				s := logMatchSet(snapshot, ctx, true)
+				e := newExplanation(snapshot, s, p.inventory, configuredLogMatchers)
+				result := policy.EvaluateLog(engine, ctx, opts...)
+				e.setResult(result)
+				if result != policy.ResultDrop {
+					e.Output, _ = (&plog.JSONMarshaler{}).MarshalLogs(singleLog(ctx))
+				}
+				out = append(out, e)
+			}
+		}
+	}
+	return out
+}
+
+func (p *policyProcessor) explainTraces(registry *policy.PolicyRegistry, engine *policy.PolicyEngine, td ptrace.Traces) []recordExplanation {
+	snapshot := registry.TraceSnapshot()
+	opts := traceOptions()
+	out := make([]recordExplanation, 0, td.SpanCount())
+	for i := range td.ResourceSpans().Len() {
+		rs := td.ResourceSpans().At(i)
+		for j := range rs.ScopeSpans().Len() {
+			ss := rs.ScopeSpans().At(j)
+			for k := range ss.Spans().Len() {
+				ctx := TraceContext{
+					Span:              ss.Spans().At(k),
+					Resource:          rs.Resource(),
+					Scope:             ss.Scope(),
+					ResourceSchemaURL: rs.SchemaUrl(),
+					ScopeSchemaURL:    ss.SchemaUrl(),
+				}
+				s := traceMatchSet(snapshot, ctx, true)
+				e := newExplanation(snapshot, s, p.inventory, configuredTraceMatchers)
+				result := policy.EvaluateTrace(engine, ctx, opts...)
+				e.setResult(result)
+				if result != policy.ResultDrop {
+					e.Output, _ = (&ptrace.JSONMarshaler{}).MarshalTraces(singleSpan(ctx))
+				}
+				out = append(out, e)
+			}
+		}
+	}
+	return out
+}
+
+func (p *policyProcessor) explainMetrics(registry *policy.PolicyRegistry, engine *policy.PolicyEngine, md pmetric.Metrics) []recordExplanation {
+	snapshot := registry.MetricSnapshot()
+	opts := metricOptions()
+	out := make([]recordExplanation, 0, md.DataPointCount())
+	for i := range md.ResourceMetrics().Len() {
+		rm := md.ResourceMetrics().At(i)
+		for j := range rm.ScopeMetrics().Len() {
+			sm := rm.ScopeMetrics().At(j)
+			for k := range sm.Metrics().Len() {
+				m := sm.Metrics().At(k)
+				temporality, datapoints := metricDatapointAttributes(m)
+				for _, attrs := range datapoints {
+					ctx := MetricContext{
+						Metric:                 m,
+						DatapointAttributes:    attrs,
+						AggregationTemporality: temporality,
+						Resource:               rm.Resource(),
+						Scope:                  sm.Scope(),
+						ResourceSchemaURL:      rm.SchemaUrl(),
+						ScopeSchemaURL:         sm.SchemaUrl(),
+					}
+					s := metricMatchSet(snapshot, ctx, true)
+					e := newExplanation(snapshot, s, p.inventory, configuredMetricMatchers)
+					e.setResult(policy.EvaluateMetric(engine, ctx, opts...))
+					out = append(out, e)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// metricDatapointAttributes returns the aggregation temporality of m and the
+// attributes of each of its datapoints.
+func metricDatapointAttributes(m pmetric.Metric) (pmetric.AggregationTemporality, []pcommon.Map) {
+	var attrs []pcommon.Map
+	switch m.Type() {
+	case pmetric.MetricTypeGauge:
+		for i := range m.Gauge().DataPoints().Len() {
+			attrs = append(attrs, m.Gauge().DataPoints().At(i).Attributes())
+		}
+	case pmetric.MetricTypeSum:
+		for i := range m.Sum().DataPoints().Len() {
+			attrs = append(attrs, m.Sum().DataPoints().At(i).Attributes())
+		}
+		return m.Sum().AggregationTemporality(), attrs
+	case pmetric.MetricTypeHistogram:
+		for i := range m.Histogram().DataPoints().Len() {
+			attrs = append(attrs, m.Histogram().DataPoints().At(i).Attributes())
+		}
+		return m.Histogram().AggregationTemporality(), attrs
+	case pmetric.MetricTypeExponentialHistogram:
+		for i := range m.ExponentialHistogram().DataPoints().Len() {
+			attrs = append(attrs, m.ExponentialHistogram().DataPoints().At(i).Attributes())
+		}
+		return m.ExponentialHistogram().AggregationTemporality(), attrs
+	case pmetric.MetricTypeSummary:
+		for i := range m.Summary().DataPoints().Len() {
+			attrs = append(attrs, m.Summary().DataPoints().At(i).Attributes())
+		}
+	}
+	return pmetric.AggregationTemporalityUnspecified, attrs
+}
+
+// newExplanation lists the policies of snapshot with a matcher that hit in
+// s, with the matchers delivered by their providers. s may be nil when the
+// snapshot has no policies.
+func newExplanation[F fieldType](snapshot *policy.PolicySnapshot[F], s *matchSet, inv *providerInventory, configuredMatchers func(*policyv1.Policy) []proto.Message) recordExplanation {
+	e := recordExplanation{Policies: []explainedPolicy{}}
+	if s == nil {
+		return e
+	}
+	e.matches = collectMatches(snapshot, s)
+	e.Winner = e.matches.Winner
+
+	touched := make(map[int]bool, len(s.matchers))
+	for ref := range s.matchers {
+		touched[ref.policy] = true
+	}
+	matchers := snapshot.CompiledMatchers()
+	for i := range matchers.PolicyCount() {
+		if !touched[i] {
+			continue
+		}
+		compiled := matchers.PolicyByIndex(i)
+		source := inv.lookupPolicy(compiled.ID)
+		explained := explainedPolicy{
+			ID:      compiled.ID,
+			Name:    source.GetName(),
+			Matched: s.matched(i, compiled.MatcherCount),
+		}
+		configured := configuredMatchers(source)
+		count := compiled.MatcherCount
+		if source != nil {
+			count = len(configured)
+		}
+		for j := range count {
+			m := explainedMatcher{Index: j, Hit: s.matchers[matcherRef{i, j}]}
+			if j < len(configured) {
+				m.Matcher, _ = protojson.Marshal(configured[j])
+			}
+			explained.Matchers = append(explained.Matchers, m)
+		}
+		e.Policies = append(e.Policies, explained)
+	}
+	return e
+}
+
+// setResult records the engine's result, refined for dropped records by the
+// winning policy's keep action.
+func (e *recordExplanation) setResult(result policy.EvaluateResult) {
+	e.Result = resultString(result)
+	if result == policy.ResultDrop {
+		e.Result = e.matches.dropResult()
+	}
+}
+
+func configuredLogMatchers(pol *policyv1.Policy) []proto.Message {
+	return protoMessages(pol.GetLog().GetMatch())
+}
+
+func configuredTraceMatchers(pol *policyv1.Policy) []proto.Message {
+	return protoMessages(pol.GetTrace().GetMatch())
+}
+
+func configuredMetricMatchers(pol *policyv1.Policy) []proto.Message {
+	return protoMessages(pol.GetMetric().GetMatch())
+}
+
+func protoMessages[M proto.Message](ms []M) []proto.Message {
+	out := make([]proto.Message, len(ms))
+	for i, m := range ms {
+		out[i] = m
+	}
+	return out
+}
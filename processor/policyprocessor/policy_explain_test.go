@@ -0,0 +1,155 @@
+package policyprocessor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	policyv1 "github.com/usetero/policy-go/proto/tero/policy/v1"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// createTestExplainProcessor returns a processor whose policies are known to
+// its provider inventory, as they are once started.
+func createTestExplainProcessor(t *testing.T, pipeline string, policies []*policyv1.Policy) *policyProcessor {
+	t.Helper()
+	p := createTestLogProcessor(t, nil)
+	p.pipeline = pipeline
+	p.inventory = newProviderInventory()
+	tracked := &trackedProvider{
+		PolicyProvider: &staticLogProvider{policies: policies},
+		p:              p,
+		state:          p.inventory.add("static"),
+	}
+	_, err := p.registry.Register(tracked)
+	require.NoError(t, err)
+	return p
+}
+
+const explainTestLogs = `{"resourceLogs":[{
+	"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"checkout"}}]},
+	"scopeLogs":[{"logRecords":[
+		{"severityText":"DEBUG","body":{"stringValue":"cache miss"}},
+		{"severityText":"INFO","attributes":[
+			{"key":"api_key","value":{"stringValue":"secret"}},
+			{"key":"user","value":{"stringValue":"alice"}}
+		]},
+		{"severityText":"WARN"}
+	]}]
+}]}`
+
+func TestExplain_Logs(t *testing.T) {
+	p := createTestExplainProcessor(t, "logs", []*policyv1.Policy{
+		logPolicy("drop-debug", "none", severityMatcher("DEBUG")),
+		removeKeyPolicy(),
+		logPolicy("checkout-debug", "all", resourceAttrMatcher("service.name", "checkout"), severityMatcher("DEBUG")),
+	})
+
+	explanations, err := p.explain("logs", []byte(explainTestLogs))
+	require.NoError(t, err)
+	require.Len(t, explanations, 3)
+
+	debug := explanations[0]
+	assert.Equal(t, "dropped", debug.Result)
+	assert.Equal(t, "drop-debug", debug.Winner)
+	assert.Nil(t, debug.Output)
+	assert.ElementsMatch(t, []string{"drop-debug", "checkout-debug"}, explainedIDs(debug))
+
+	info := explanations[1]
+	assert.Equal(t, "transformed", info.Result)
+	assert.Equal(t, "remove-key", info.Winner)
+	assert.Equal(t, map[string]any{"user": "alice"}, tapLogRecord(t, info.Output).Attributes().AsRaw())
+	require.Len(t, info.Policies, 2)
+	for _, pol := range info.Policies {
+		switch pol.ID {
+		case "remove-key":
+			assert.True(t, pol.Matched)
+			require.Len(t, pol.Matchers, 1)
+			assert.True(t, pol.Matchers[0].Hit)
+		case "checkout-debug":
+			// The resource matcher hit, the severity matcher did not.
+			assert.False(t, pol.Matched)
+			require.Len(t, pol.Matchers, 2)
+			assert.True(t, pol.Matchers[0].Hit)
+			assert.JSONEq(t, `{"resourceAttribute":{"path":["service.name"]},"exact":"checkout"}`, string(pol.Matchers[0].Matcher))
+			assert.False(t, pol.Matchers[1].Hit)
+			assert.JSONEq(t, `{"logField":"LOG_FIELD_SEVERITY_TEXT","exact":"DEBUG"}`, string(pol.Matchers[1].Matcher))
+		default:
+			t.Errorf("unexpected policy %q", pol.ID)
+		}
+	}
+
+	warn := explanations[2]
+	assert.Equal(t, "no_match", warn.Result)
+	assert.Empty(t, warn.Winner)
+	assert.ElementsMatch(t, []string{"checkout-debug"}, explainedIDs(warn))
+	assert.NotNil(t, warn.Output)
+
+	_, err = p.explain("logs", []byte(`{"resourceLogs":`))
+	assert.ErrorContains(t, err, "invalid OTLP JSON logs")
+}
+
+func TestExplain_Metrics(t *testing.T) {
+	p := createTestExplainProcessor(t, "metrics", []*policyv1.Policy{{
+		Id:      "drop-options",
+		Enabled: true,
+		Target: &policyv1.Policy_Metric{Metric: &policyv1.MetricTarget{
+			Match: []*policyv1.MetricMatcher{{
+				Field: &policyv1.MetricMatcher_DatapointAttribute{DatapointAttribute: &policyv1.AttributePath{Path: []string{"http.method"}}},
+				Match: &policyv1.MetricMatcher_Exact{Exact: "OPTIONS"},
+			}},
+		}},
+	}})
+
+	md := pmetric.NewMetrics()
+	m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName("http.requests")
+	sum := m.SetEmptySum()
+	sum.DataPoints().AppendEmpty().Attributes().PutStr("http.method", "GET")
+	sum.DataPoints().AppendEmpty().Attributes().PutStr("http.method", "OPTIONS")
+	payload, err := (&pmetric.JSONMarshaler{}).MarshalMetrics(md)
+	require.NoError(t, err)
+
+	explanations, err := p.explain("metrics", payload)
+	require.NoError(t, err)
+	require.Len(t, explanations, 2)
+	assert.Equal(t, "no_match", explanations[0].Result)
+	assert.Empty(t, explanations[0].Policies)
+	assert.Equal(t, "dropped", explanations[1].Result)
+	assert.Equal(t, "drop-options", explanations[1].Winner)
+	assert.Equal(t, []string{"drop-options"}, explainedIDs(explanations[1]))
+	assert.Nil(t, explanations[1].Output)
+}
+
+func TestExplain_LeavesLiveEngineUntouched(t *testing.T) {
+	p := createTestExplainProcessor(t, "logs", []*policyv1.Policy{
+		logPolicy("limit-debug", "1/s", severityMatcher("DEBUG")),
+	})
+	payload := []byte(`{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"severityText":"DEBUG"}]}]}]}`)
+
+	for range 3 {
+		explanations, err := p.explain("logs", payload)
+		require.NoError(t, err)
+		require.Len(t, explanations, 1)
+		assert.Equal(t, "kept", explanations[0].Result, "explaining does not spend the rate limit")
+	}
+	for _, s := range p.registry.CollectStats() {
+		assert.Zero(t, s.MatchHits, "explaining does not count policy hits")
+	}
+
+	logs, err := (&plog.JSONUnmarshaler{}).UnmarshalLogs(payload)
+	require.NoError(t, err)
+	out, err := p.processLogs(context.Background(), logs)
+	require.NoError(t, err)
+	assert.Equal(t, 1, out.LogRecordCount(), "the live rate limit is untouched")
+}
+
+func explainedIDs(e recordExplanation) []string {
+	ids := make([]string, 0, len(e.Policies))
+	for _, pol := range e.Policies {
+		ids = append(ids, pol.ID)
+	}
+	return ids
+}
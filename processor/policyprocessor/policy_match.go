@@ -0,0 +1,227 @@
+package policyprocessor
+
+import (
+	"github.com/usetero/policy-go/policy"
+)
+
+// fieldType mirrors the policy engine's field type constraint so helpers can
+// be written once over log, metric, and trace snapshots.
+type fieldType interface {
+	policy.LogField | policy.MetricField | policy.TraceField
+}
+
+// policyMatches describes the policies a single record matched.
+type policyMatches struct {
+	// IDs lists every matching policy, in the engine's policy index order.
+	IDs []string
+	// Winner is the most restrictive matching policy; its keep action is the
+	// one the engine applies. Empty when nothing matched.
+	Winner string
+	// WinnerKeep is the keep action of the winning policy.
+	WinnerKeep policy.KeepAction
+}
+
+// matchSet tracks per-policy matcher hits while replaying a snapshot's
+// matchers against a record.
+type matchSet struct {
+	counts       []int
+	disqualified []bool
+	// matchers records each matcher that hit, keyed by policy and matcher
+	// index. It is nil unless the set explains a record.
+	matchers map[matcherRef]bool
+}
+
+// matcherRef identifies a matcher by its policy's index in the snapshot and
+// its index in the policy.
+type matcherRef struct {
+	policy  int
+	matcher int
+}
+
+func newMatchSet(policyCount int, trackMatchers bool) *matchSet {
+	s := &matchSet{
+		counts:       make([]int, policyCount),
+		disqualified: make([]bool, policyCount),
+	}
+	if trackMatchers {
+		s.matchers = make(map[matcherRef]bool)
+	}
+	return s
+}
+
+func (s *matchSet) hit(policyIndex, matcherIndex int) {
+	if s.matchers != nil {
+		s.matchers[matcherRef{policyIndex, matcherIndex}] = true
+	}
+	if !s.disqualified[policyIndex] {
+		s.counts[policyIndex]++
+	}
+}
+
+func (s *matchSet) miss(policyIndex int) {
+	s.disqualified[policyIndex] = true
+}
+
+// matched reports whether every one of the policy's matchers hit.
+func (s *matchSet) matched(policyIndex, matcherCount int) bool {
+	return !s.disqualified[policyIndex] && s.counts[policyIndex] >= matcherCount
+}
+
+// collectMatches resolves a matchSet into the matching policy IDs and the
+// winning policy, using the same restrictiveness ordering as the engine.
+func collectMatches[F fieldType](snapshot *policy.PolicySnapshot[F], s *matchSet) policyMatches {
+	matchers := snapshot.CompiledMatchers()
+	var out policyMatches
+	best := -1
+	for i := range matchers.PolicyCount() {
+		p := matchers.PolicyByIndex(i)
+		if !s.matched(i, p.MatcherCount) {
+			continue
+		}
+		out.IDs = append(out.IDs, p.ID)
+		if r := p.Keep.Restrictiveness(); r > best {
+			best = r
+			out.Winner = p.ID
+			out.WinnerKeep = p.Keep.Action
+		}
+	}
+	return out
+}
+
+// fieldAccessor bundles the field lookups a snapshot's matchers need for one
+// telemetry type, so the matching loop can be written once.
+type fieldAccessor[F fieldType, C any] struct {
+	exists func(C, policy.FieldRef[F]) bool
+	typed  func(C, policy.FieldRef[F]) policy.TypedValue
+	value  func(C, policy.FieldRef[F]) []byte
+}
+
+var (
+	logFields    = fieldAccessor[policy.LogField, LogContext]{LogExists, LogTypedMatcher, LogValue}
+	traceFields  = fieldAccessor[policy.TraceField, TraceContext]{TraceExists, TraceTypedMatcher, TraceValue}
+	metricFields = fieldAccessor[policy.MetricField, MetricContext]{MetricExists, MetricTypedMatcher, MetricValue}
+)
+
+// matchLogPolicies replays the matching phase of policy.EvaluateLog against a
+// log snapshot. Unlike EvaluateLog it has no side effects: no keep action is
+// applied, no transforms run, and policy stats are left untouched. It lets the
+// processor attribute a decision to specific policies, which the engine's
+// result alone does not expose.
+func matchLogPolicies(snapshot *policy.LogSnapshot, ctx LogContext) policyMatches {
+	return matchPolicies(snapshot, ctx, logFields)
+}
+
+// matchTracePolicies replays the matching phase of policy.EvaluateTrace
+// against a trace snapshot without side effects. See matchLogPolicies.
+func matchTracePolicies(snapshot *policy.TraceSnapshot, ctx TraceContext) policyMatches {
+	return matchPolicies(snapshot, ctx, traceFields)
+}
+
+// matchMetricPolicies replays the matching phase of policy.EvaluateMetric
+// against a metric snapshot without side effects. See matchLogPolicies.
+func matchMetricPolicies(snapshot *policy.MetricSnapshot, ctx MetricContext) policyMatches {
+	return matchPolicies(snapshot, ctx, metricFields)
+}
+
+func matchPolicies[F fieldType, C any](snapshot *policy.PolicySnapshot[F], ctx C, fields fieldAccessor[F, C]) policyMatches {
+	s := evaluateMatchers(snapshot, ctx, fields, false)
+	if s == nil {
+		return policyMatches{}
+	}
+	return collectMatches(snapshot, s)
+}
+
+func logMatchSet(snapshot *policy.LogSnapshot, ctx LogContext, trackMatchers bool) *matchSet {
+	return evaluateMatchers(snapshot, ctx, logFields, trackMatchers)
+}
+
+func traceMatchSet(snapshot *policy.TraceSnapshot, ctx TraceContext, trackMatchers bool) *matchSet {
+	return evaluateMatchers(snapshot, ctx, traceFields, trackMatchers)
+}
+
+func metricMatchSet(snapshot *policy.MetricSnapshot, ctx MetricContext, trackMatchers bool) *matchSet {
+	return evaluateMatchers(snapshot, ctx, metricFields, trackMatchers)
+}
+
+// evaluateMatchers evaluates every matcher in the snapshot against ctx. It
+// returns nil when the snapshot has no policies. With trackMatchers set, the
+// set also records which matchers hit.
+func evaluateMatchers[F fieldType, C any](snapshot *policy.PolicySnapshot[F], ctx C, fields fieldAccessor[F, C], trackMatchers bool) *matchSet {
+	matchers := snapshot.CompiledMatchers()
+	if matchers == nil || matchers.PolicyCount() == 0 {
+		return nil
+	}
+	s := newMatchSet(matchers.PolicyCount(), trackMatchers)
+
+	for _, check := range matchers.ExistenceChecks() {
+		if fields.exists(ctx, check.Ref) == check.MustExist {
+			s.hit(check.PolicyIndex, check.MatchIndex)
+		} else {
+			s.miss(check.PolicyIndex)
+		}
+	}
+
+	for _, check := range matchers.TypedChecks() {
+		if check.Matcher.Evaluate(fields.typed(ctx, check.Ref)) != check.Negate {
+			s.hit(check.PolicyIndex, check.MatchIndex)
+		} else {
+			s.miss(check.PolicyIndex)
+		}
+	}
+
+	for _, entry := range matchers.Databases() {
+		db := entry.Database
+		value := fields.value(ctx, entry.Key.Ref)
+		if len(value) == 0 {
+			if !entry.Key.Negated {
+				for _, ref := range db.PatternIndex() {
+					s.miss(ref.PolicyIndex)
+				}
+			}
+			continue
+		}
+		if entry.Key.Negated {
+			matched, err := db.ScanAll(value)
+			if err != nil {
+				continue
+			}
+			for patternID, ref := range db.PatternIndex() {
+				if matched[patternID] {
+					s.miss(ref.PolicyIndex)
+				} else {
+					s.hit(ref.PolicyIndex, ref.MatcherIndex)
+				}
+			}
+			db.ReleaseMatched(matched)
+			continue
+		}
+		hits, err := db.Scan(value)
+		if err != nil {
+			continue
+		}
+		for _, patternID := range hits {
+			ref := db.PatternIndex()[patternID]
+			s.hit(ref.PolicyIndex, ref.MatcherIndex)
+		}
+		db.ReleaseHits(hits)
+	}
+
+	return s
+}
+
+// dropResult refines the result of a record the engine dropped by the keep
+// action of the winning policy, since the engine reports records sampled out
+// or over a policy's rate the same as records dropped outright.
+func (m policyMatches) dropResult() string {
+	if m.Winner == "" {
+		return "dropped"
+	}
+	switch m.WinnerKeep {
+	case policy.KeepSample:
+		return "sampled"
+	case policy.KeepRatePerSecond, policy.KeepRatePerMinute:
+		return resultRateLimited
+	default:
+		return "dropped"
+	}
+}
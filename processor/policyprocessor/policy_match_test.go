@@ -0,0 +1,145 @@
+package policyprocessor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	policyv1 "github.com/usetero/policy-go/proto/tero/policy/v1"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func TestMatchLogPolicies(t *testing.T) {
+	policies := []*policyv1.Policy{
+		{
+			Id:      "keep-errors",
+			Enabled: true,
+			Target: &policyv1.Policy_Log{
+				Log: &policyv1.LogTarget{
+					Match: []*policyv1.LogMatcher{
+						{
+							Field: &policyv1.LogMatcher_LogField{LogField: policyv1.LogField_LOG_FIELD_SEVERITY_TEXT},
+							Match: &policyv1.LogMatcher_Exact{Exact: "ERROR"},
+						},
+					},
+					Keep: "all",
+				},
+			},
+		},
+		{
+			Id:      "drop-checkout",
+			Enabled: true,
+			Target: &policyv1.Policy_Log{
+				Log: &policyv1.LogTarget{
+					Match: []*policyv1.LogMatcher{
+						{
+							Field: &policyv1.LogMatcher_ResourceAttribute{ResourceAttribute: &policyv1.AttributePath{Path: []string{"service.name"}}},
+							Match: &policyv1.LogMatcher_Exact{Exact: "checkout"},
+						},
+						{
+							Field: &policyv1.LogMatcher_LogAttribute{LogAttribute: &policyv1.AttributePath{Path: []string{"debug"}}},
+							Match: &policyv1.LogMatcher_Exists{Exists: false},
+						},
+					},
+					Keep: "none",
+				},
+			},
+		},
+	}
+	p := createTestLogProcessor(t, policies)
+	snapshot := p.registry.LogSnapshot()
+
+	tests := []struct {
+		name       string
+		service    string
+		severity   string
+		debugAttr  bool
+		wantIDs    []string
+		wantWinner string
+	}{
+		{
+			name:     "no match",
+			service:  "cart",
+			severity: "INFO",
+		},
+		{
+			name:       "single match",
+			service:    "cart",
+			severity:   "ERROR",
+			wantIDs:    []string{"keep-errors"},
+			wantWinner: "keep-errors",
+		},
+		{
+			name:       "most restrictive policy wins",
+			service:    "checkout",
+			severity:   "ERROR",
+			wantIDs:    []string{"keep-errors", "drop-checkout"},
+			wantWinner: "drop-checkout",
+		},
+		{
+			name:      "negated existence disqualifies",
+			service:   "checkout",
+			severity:  "INFO",
+			debugAttr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resource := pcommon.NewResource()
+			resource.Attributes().PutStr("service.name", tt.service)
+			lr := plog.NewLogRecord()
+			lr.SetSeverityText(tt.severity)
+			if tt.debugAttr {
+				lr.Attributes().PutBool("debug", true)
+			}
+
+			got := matchLogPolicies(snapshot, LogContext{
+				Record:   lr,
+				Resource: resource,
+				Scope:    pcommon.NewInstrumentationScope(),
+			})
+			assert.ElementsMatch(t, tt.wantIDs, got.IDs)
+			assert.Equal(t, tt.wantWinner, got.Winner)
+		})
+	}
+}
+
+func TestMatchLogPolicies_NilSnapshot(t *testing.T) {
+	got := matchLogPolicies(nil, LogContext{Record: plog.NewLogRecord()})
+	assert.Empty(t, got.IDs)
+	assert.Empty(t, got.Winner)
+}
+
+func TestMatchTracePolicies(t *testing.T) {
+	policies := []*policyv1.Policy{
+		{
+			Id:      "drop-health",
+			Enabled: true,
+			Target: &policyv1.Policy_Trace{
+				Trace: &policyv1.TraceTarget{
+					Match: []*policyv1.TraceMatcher{
+						{
+							Field: &policyv1.TraceMatcher_TraceField{TraceField: policyv1.TraceField_TRACE_FIELD_NAME},
+							Match: &policyv1.TraceMatcher_Regex{Regex: "^/health"},
+						},
+					},
+					Keep: dropConfig(),
+				},
+			},
+		},
+	}
+	p := createTestTraceProcessor(t, policies)
+	snapshot := p.registry.TraceSnapshot()
+
+	span := ptrace.NewSpan()
+	span.SetName("/healthz")
+	got := matchTracePolicies(snapshot, TraceContext{Span: span, Resource: pcommon.NewResource(), Scope: pcommon.NewInstrumentationScope()})
+	assert.Equal(t, []string{"drop-health"}, got.IDs)
+	assert.Equal(t, "drop-health", got.Winner)
+
+	span.SetName("/checkout")
+	got = matchTracePolicies(snapshot, TraceContext{Span: span, Resource: pcommon.NewResource(), Scope: pcommon.NewInstrumentationScope()})
+	assert.Empty(t, got.IDs)
+}
@@ -0,0 +1,289 @@
+package policyprocessor
+
+import (
+	"encoding/json"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// tapBuffer is the number of events buffered per subscriber. Events arriving
+// while the buffer is full are dropped.
+const tapBuffer = 16
+
+// policyTap streams records matching a policy to debug clients.
+type policyTap struct {
+	// limit is the number of records per second sent to each subscriber.
+	limit float64
+
+	mu     sync.Mutex
+	subs   []*tapSubscriber
+	active atomic.Int32
+
+	now func() time.Time
+}
+
+// tapSubscriber receives the records matching policyID.
+type tapSubscriber struct {
+	policyID string
+	events   chan []byte
+
+	// tokens refill at the tap's limit up to a burst of one second.
+	tokens float64
+	last   time.Time
+}
+
+// tapEvent is a record sent to subscribers as a line of JSON.
+type tapEvent struct {
+	Signal string `json:"signal"`
+	// PolicyID is the policy the subscriber tapped; Winner is the matching
+	// policy whose keep action applied.
+	PolicyID string `json:"policy_id"`
+	Winner   string `json:"winner"`
+	Result   string `json:"result"`
+	// Record holds the record with its resource and scope as OTLP JSON,
+	// after evaluation. Before holds it as received for transformed records.
+	Record json.RawMessage `json:"record"`
+	Before json.RawMessage `json:"before,omitempty"`
+}
+
+func newPolicyTap(limit float64) *policyTap {
+	return &policyTap{limit: limit, now: time.Now}
+}
+
+// subscribe registers a subscriber for records matching policyID.
+func (t *policyTap) subscribe(policyID string) *tapSubscriber {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sub := &tapSubscriber{
+		policyID: policyID,
+		events:   make(chan []byte, tapBuffer),
+		tokens:   1,
+		last:     t.now(),
+	}
+	t.subs = append(t.subs, sub)
+	t.active.Add(1)
+	return sub
+}
+
+func (t *policyTap) unsubscribe(sub *tapSubscriber) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if i := slices.Index(t.subs, sub); i >= 0 {
+		t.subs = slices.Delete(t.subs, i, i+1)
+		t.active.Add(-1)
+	}
+}
+
+// tapping reports whether any subscriber is registered.
+func (t *policyTap) tapping() bool {
+	return t != nil && t.active.Load() > 0
+}
+
+// refill adds the tokens earned since the subscriber's last refill.
+func (t *policyTap) refill(sub *tapSubscriber, now time.Time) {
+	sub.tokens = min(max(t.limit, 1), sub.tokens+now.Sub(sub.last).Seconds()*t.limit)
+	sub.last = now
+}
+
+// wants reports whether a record matching ids would be sent to any
+// subscriber, so the record is only captured when it is.
+func (t *policyTap) wants(ids []string) bool {
+	if len(ids) == 0 {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	for _, sub := range t.subs {
+		if !slices.Contains(ids, sub.policyID) {
+			continue
+		}
+		t.refill(sub, now)
+		if sub.tokens >= 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// publish sends ev to each subscriber tapping one of ids that has not
+// reached its limit.
+func (t *policyTap) publish(ids []string, ev tapEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	for _, sub := range t.subs {
+		if !slices.Contains(ids, sub.policyID) {
+			continue
+		}
+		t.refill(sub, now)
+		if sub.tokens < 1 {
+			continue
+		}
+		ev.PolicyID = sub.policyID
+		line, err := json.Marshal(ev)
+		if err != nil {
+			continue
+		}
+		select {
+		case sub.events <- line:
+			sub.tokens--
+		default:
+		}
+	}
+}
+
+// logCapture holds a log record tapped or sampled by the decision log before
+// evaluation.
+type logCapture struct {
+	// tap is nil unless a subscriber wants the record; decisions is nil
+	// unless its decision is logged.
+	tap       *policyTap
+	decisions *decisionLog
+	matches   policyMatches
+	ctx       LogContext
+	before    plog.Logs
+}
+
+// captureLog captures the record of ctx when a subscriber wants it or, with
+// logged set, the decision log wants its decision. It returns nil otherwise.
+func (p *policyProcessor) captureLog(matches policyMatches, ctx LogContext, logged bool) *logCapture {
+	c := &logCapture{tap: p.tapFor(matches), matches: matches, ctx: ctx}
+	if logged && p.decisionLog.wants(matches.IDs) {
+		c.decisions = p.decisionLog
+	}
+	if c.tap == nil && c.decisions == nil {
+		return nil
+	}
+	if c.tap != nil {
+		c.before = singleLog(ctx)
+	}
+	return c
+}
+
+// publish sends the record with its result to the subscribers and the
+// decision log.
+func (c *logCapture) publish(result string) {
+	if c == nil {
+		return
+	}
+	if c.decisions != nil {
+		c.decisions.log("logs", result, c.matches, logDecisionFields(c.ctx)...)
+	}
+	if c.tap == nil {
+		return
+	}
+	if result == "dropped" {
+		result = c.matches.dropResult()
+	}
+	marshaler := &plog.JSONMarshaler{}
+	ev := tapEvent{Signal: "logs", Winner: c.matches.Winner, Result: result}
+	ev.Record, _ = marshaler.MarshalLogs(singleLog(c.ctx))
+	if result == "transformed" {
+		ev.Before, _ = marshaler.MarshalLogs(c.before)
+	}
+	c.tap.publish(c.matches.IDs, ev)
+}
+
+// publishHeld publishes a record that was held for its trace's decision,
+// and so has since moved to ctx.
+func (c *logCapture) publishHeld(ctx LogContext, result string) {
+	if c == nil {
+		return
+	}
+	c.ctx = ctx
+	c.publish(result)
+}
+
+// singleLog copies the record of ctx with its resource and scope.
+func singleLog(ctx LogContext) plog.Logs {
+	ld := plog.NewLogs()
+	rl := ld.ResourceLogs().AppendEmpty()
+	ctx.Resource.CopyTo(rl.Resource())
+	rl.SetSchemaUrl(ctx.ResourceSchemaURL)
+	sl := rl.ScopeLogs().AppendEmpty()
+	ctx.Scope.CopyTo(sl.Scope())
+	sl.SetSchemaUrl(ctx.ScopeSchemaURL)
+	ctx.Record.CopyTo(sl.LogRecords().AppendEmpty())
+	return ld
+}
+
+// spanCapture is the span equivalent of logCapture.
+type spanCapture struct {
+	tap       *policyTap
+	decisions *decisionLog
+	matches   policyMatches
+	ctx       TraceContext
+	before    ptrace.Traces
+}
+
+// captureSpan is the span equivalent of captureLog.
+func (p *policyProcessor) captureSpan(matches policyMatches, ctx TraceContext, logged bool) *spanCapture {
+	c := &spanCapture{tap: p.tapFor(matches), matches: matches, ctx: ctx}
+	if logged && p.decisionLog.wants(matches.IDs) {
+		c.decisions = p.decisionLog
+	}
+	if c.tap == nil && c.decisions == nil {
+		return nil
+	}
+	if c.tap != nil {
+		c.before = singleSpan(ctx)
+	}
+	return c
+}
+
+// publish sends the span with its result to the subscribers and the
+// decision log.
+func (c *spanCapture) publish(result string) {
+	if c == nil {
+		return
+	}
+	if c.decisions != nil {
+		c.decisions.log("traces", result, c.matches, spanDecisionFields(c.ctx)...)
+	}
+	if c.tap == nil {
+		return
+	}
+	if result == "dropped" {
+		result = c.matches.dropResult()
+	}
+	marshaler := &ptrace.JSONMarshaler{}
+	ev := tapEvent{Signal: "traces", Winner: c.matches.Winner, Result: result}
+	ev.Record, _ = marshaler.MarshalTraces(singleSpan(c.ctx))
+	if result == "transformed" {
+		ev.Before, _ = marshaler.MarshalTraces(c.before)
+	}
+	c.tap.publish(c.matches.IDs, ev)
+}
+
+// singleSpan copies the span of ctx with its resource and scope.
+func singleSpan(ctx TraceContext) ptrace.Traces {
+	td := ptrace.NewTraces()
+	rs := td.ResourceSpans().AppendEmpty()
+	ctx.Resource.CopyTo(rs.Resource())
+	rs.SetSchemaUrl(ctx.ResourceSchemaURL)
+	ss := rs.ScopeSpans().AppendEmpty()
+	ctx.Scope.CopyTo(ss.Scope())
+	ss.SetSchemaUrl(ctx.ScopeSchemaURL)
+	ctx.Span.CopyTo(ss.Spans().AppendEmpty())
+	return td
+}
+
+// tapping reports whether records of p are being tapped.
+func (p *policyProcessor) tapping() bool {
+	return p.debug != nil && p.debug.tap.tapping()
+}
+
+// tapFor returns the tap of p when a subscriber wants a record that matched
+// matches, or nil.
+func (p *policyProcessor) tapFor(matches policyMatches) *policyTap {
+	if !p.tapping() || !p.debug.tap.wants(matches.IDs) {
+		return nil
+	}
+	return p.debug.tap
+}
@@ -0,0 +1,150 @@
+package policyprocessor
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	policyv1 "github.com/usetero/policy-go/proto/tero/policy/v1"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.uber.org/zap"
+)
+
+// removeKeyPolicy keeps INFO logs and removes their api_key attribute.
+func removeKeyPolicy() *policyv1.Policy {
+	pol := logPolicy("remove-key", "all", severityMatcher("INFO"))
+	pol.GetLog().Transform = &policyv1.LogTransform{
+		Remove: []*policyv1.LogRemove{{
+			Field: &policyv1.LogRemove_LogAttribute{LogAttribute: &policyv1.AttributePath{Path: []string{"api_key"}}},
+		}},
+	}
+	return pol
+}
+
+// nextTapEvent decodes the next event of sub.
+func nextTapEvent(t *testing.T, sub *tapSubscriber) tapEvent {
+	t.Helper()
+	select {
+	case line := <-sub.events:
+		var ev tapEvent
+		require.NoError(t, json.Unmarshal(line, &ev))
+		return ev
+	default:
+		require.FailNow(t, "no tap event")
+		return tapEvent{}
+	}
+}
+
+func tapLogRecord(t *testing.T, raw json.RawMessage) plog.LogRecord {
+	t.Helper()
+	ld, err := (&plog.JSONUnmarshaler{}).UnmarshalLogs(raw)
+	require.NoError(t, err)
+	require.Equal(t, 1, ld.LogRecordCount())
+	return ld.ResourceLogs().At(0).ScopeLogs().At(0).LogRecords().At(0)
+}
+
+func TestPolicyTap_Logs(t *testing.T) {
+	p := createTestLogProcessor(t, []*policyv1.Policy{
+		logPolicy("drop-debug", "none", severityMatcher("DEBUG")),
+		removeKeyPolicy(),
+	})
+	p.debug = newDebugServer(DebugConfig{TapLimit: 100}, zap.NewNop())
+	drops := p.debug.tap.subscribe("drop-debug")
+	transforms := p.debug.tap.subscribe("remove-key")
+
+	logs := plog.NewLogs()
+	rl := logs.ResourceLogs().AppendEmpty()
+	rl.Resource().Attributes().PutStr("service.name", "checkout")
+	records := rl.ScopeLogs().AppendEmpty().LogRecords()
+	debug := records.AppendEmpty()
+	debug.SetSeverityText("DEBUG")
+	debug.Body().SetStr("cache miss")
+	info := records.AppendEmpty()
+	info.SetSeverityText("INFO")
+	info.Attributes().PutStr("api_key", "secret")
+	info.Attributes().PutStr("user", "alice")
+	records.AppendEmpty().SetSeverityText("WARN")
+
+	_, err := p.processLogs(context.Background(), logs)
+	require.NoError(t, err)
+
+	ev := nextTapEvent(t, drops)
+	assert.Equal(t, "logs", ev.Signal)
+	assert.Equal(t, "drop-debug", ev.PolicyID)
+	assert.Equal(t, "drop-debug", ev.Winner)
+	assert.Equal(t, "dropped", ev.Result)
+	assert.Nil(t, ev.Before)
+	assert.Equal(t, "cache miss", tapLogRecord(t, ev.Record).Body().Str())
+	assert.Empty(t, drops.events)
+
+	ev = nextTapEvent(t, transforms)
+	assert.Equal(t, "transformed", ev.Result)
+	assert.Equal(t, map[string]any{"user": "alice"}, tapLogRecord(t, ev.Record).Attributes().AsRaw())
+	assert.Equal(t, map[string]any{"api_key": "secret", "user": "alice"}, tapLogRecord(t, ev.Before).Attributes().AsRaw())
+	assert.Empty(t, transforms.events)
+}
+
+func TestPolicyTap_Spans(t *testing.T) {
+	p := createTestTraceProcessor(t, []*policyv1.Policy{
+		{
+			Id:      "drop-health",
+			Enabled: true,
+			Target: &policyv1.Policy_Trace{Trace: &policyv1.TraceTarget{
+				Match: []*policyv1.TraceMatcher{{
+					Field: &policyv1.TraceMatcher_TraceField{TraceField: policyv1.TraceField_TRACE_FIELD_NAME},
+					Match: &policyv1.TraceMatcher_Exact{Exact: "GET /health"},
+				}},
+				Keep: &policyv1.TraceSamplingConfig{Percentage: 0},
+			}},
+		},
+	})
+	p.debug = newDebugServer(DebugConfig{}, zap.NewNop())
+	sub := p.debug.tap.subscribe("drop-health")
+
+	td := ptrace.NewTraces()
+	spans := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+	spans.AppendEmpty().SetName("GET /health")
+	spans.AppendEmpty().SetName("GET /checkout")
+
+	_, err := p.processTraces(context.Background(), td)
+	require.NoError(t, err)
+
+	ev := nextTapEvent(t, sub)
+	assert.Equal(t, "traces", ev.Signal)
+	assert.Equal(t, "drop-health", ev.PolicyID)
+	traces, err := (&ptrace.JSONUnmarshaler{}).UnmarshalTraces(ev.Record)
+	require.NoError(t, err)
+	assert.Equal(t, "GET /health", traces.ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Name())
+	assert.Empty(t, sub.events)
+}
+
+func TestPolicyTap_Limit(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tap := newPolicyTap(2)
+	tap.now = func() time.Time { return now }
+	sub := tap.subscribe("p")
+	other := tap.subscribe("q")
+
+	ids := []string{"p"}
+	for range 3 {
+		require.Equal(t, len(sub.events) == 0, tap.wants(ids))
+		tap.publish(ids, tapEvent{Signal: "logs"})
+	}
+	assert.Len(t, sub.events, 1)
+	assert.Empty(t, other.events)
+
+	// The limit refills over time up to one second's worth.
+	now = now.Add(10 * time.Second)
+	for range 3 {
+		tap.publish(ids, tapEvent{Signal: "logs"})
+	}
+	assert.Len(t, sub.events, 3)
+
+	tap.unsubscribe(sub)
+	tap.unsubscribe(other)
+	assert.False(t, tap.tapping())
+}
@@ -3,14 +3,23 @@ package policyprocessor
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/usetero/policy-go/backend/hyperscan"
 	"github.com/usetero/policy-go/policy"
 	policyv1 "github.com/usetero/policy-go/proto/tero/policy/v1"
+	"github.com/usetero/tero-collector-distro/processor/policyprocessor/internal/metadata"
+	"github.com/usetero/tero-collector-distro/processor/policyprocessor/internal/metadatatest"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/consumer/consumertest"
 	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata/metricdatatest"
 	"go.uber.org/zap"
 )
 
@@ -1534,3 +1543,359 @@ func TestProcessLogs_TypedMatchers(t *testing.T) {
 		})
 	}
 }
+
+func TestProcessLogs_RateLimit(t *testing.T) {
+	policies := []*policyv1.Policy{
+		{
+			Id:      "limit-noisy",
+			Name:    "Limit noisy service",
+			Enabled: true,
+			Target: &policyv1.Policy_Log{
+				Log: &policyv1.LogTarget{
+					Match: []*policyv1.LogMatcher{
+						{
+							Field: &policyv1.LogMatcher_ResourceAttribute{ResourceAttribute: &policyv1.AttributePath{Path: []string{"service.name"}}},
+							Match: &policyv1.LogMatcher_StartsWith{StartsWith: "noisy"},
+						},
+					},
+					Keep: "all",
+				},
+			},
+		},
+	}
+
+	p := createTestLogProcessor(t, policies)
+	p.rateLimiter = newRateLimiter([]RateLimitConfig{
+		{PolicyID: "limit-noisy", RecordsPerSecond: 2, ResourceAttribute: "service.name"},
+	})
+	frozen := time.Unix(1000, 0)
+	p.rateLimiter.now = func() time.Time { return frozen }
+
+	tel := componenttest.NewTelemetry()
+	t.Cleanup(func() { require.NoError(t, tel.Shutdown(context.Background())) })
+	tb, err := metadata.NewTelemetryBuilder(tel.NewTelemetrySettings())
+	require.NoError(t, err)
+	p.telemetry = tb
+
+	logs := plog.NewLogs()
+	for _, svc := range []string{"noisy-a", "noisy-b", "quiet"} {
+		rl := logs.ResourceLogs().AppendEmpty()
+		rl.Resource().Attributes().PutStr("service.name", svc)
+		sl := rl.ScopeLogs().AppendEmpty()
+		for range 5 {
+			sl.LogRecords().AppendEmpty().Body().SetStr("message from " + svc)
+		}
+	}
+
+	result, err := p.processLogs(context.Background(), logs)
+	require.NoError(t, err)
+
+	require.Equal(t, 3, result.ResourceLogs().Len())
+	assert.Equal(t, 2, result.ResourceLogs().At(0).ScopeLogs().At(0).LogRecords().Len())
+	assert.Equal(t, 2, result.ResourceLogs().At(1).ScopeLogs().At(0).LogRecords().Len())
+	assert.Equal(t, 5, result.ResourceLogs().At(2).ScopeLogs().At(0).LogRecords().Len())
+
+	metadatatest.AssertEqualProcessorPolicyRecords(t, tel, []metricdata.DataPoint[int64]{
+		{Attributes: attribute.NewSet(attrTelemetryType.String("logs"), attrResult.String("kept")), Value: 4},
+		{Attributes: attribute.NewSet(attrTelemetryType.String("logs"), attrResult.String("rate_limited")), Value: 6},
+		{Attributes: attribute.NewSet(attrTelemetryType.String("logs"), attrResult.String("no_match")), Value: 5},
+	}, metricdatatest.IgnoreTimestamp())
+}
+
+func TestProcessLogs_DroppedLogMetrics(t *testing.T) {
+	policies := []*policyv1.Policy{
+		{
+			Id:      "drop-debug",
+			Name:    "Drop debug logs",
+			Enabled: true,
+			Target: &policyv1.Policy_Log{
+				Log: &policyv1.LogTarget{
+					Match: []*policyv1.LogMatcher{
+						{
+							Field: &policyv1.LogMatcher_LogField{LogField: policyv1.LogField_LOG_FIELD_SEVERITY_TEXT},
+							Match: &policyv1.LogMatcher_Exact{Exact: "DEBUG"},
+						},
+					},
+					Keep: "none",
+				},
+			},
+		},
+	}
+
+	summary := newDroppedLogSummary(DroppedLogMetricsConfig{Attributes: []string{"service.name"}})
+	logsProc := createTestLogProcessor(t, policies)
+	logsProc.droppedLogs = summary
+	metricsProc := createTestMetricProcessor(t, nil)
+	metricsProc.droppedLogs = summary
+	metricsProc.resource = pcommon.NewResource()
+
+	logs := plog.NewLogs()
+	for _, svc := range []string{"cart", "checkout"} {
+		rl := logs.ResourceLogs().AppendEmpty()
+		rl.Resource().Attributes().PutStr("service.name", svc)
+		sl := rl.ScopeLogs().AppendEmpty()
+		for _, sev := range []string{"DEBUG", "DEBUG", "INFO"} {
+			sl.LogRecords().AppendEmpty().SetSeverityText(sev)
+		}
+	}
+
+	result, err := logsProc.processLogs(context.Background(), logs)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.ResourceLogs().At(0).ScopeLogs().At(0).LogRecords().Len())
+	assert.Equal(t, 1, result.ResourceLogs().At(1).ScopeLogs().At(0).LogRecords().Len())
+
+	metrics := pmetric.NewMetrics()
+	metrics.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty().SetName("app.requests")
+	metrics.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).SetEmptyGauge().DataPoints().AppendEmpty()
+
+	out, err := metricsProc.processMetrics(context.Background(), metrics)
+	require.NoError(t, err)
+	require.Equal(t, 2, out.ResourceMetrics().Len(), "summary is appended alongside the incoming data")
+
+	summaryMetrics := pmetric.NewMetrics()
+	out.ResourceMetrics().At(1).CopyTo(summaryMetrics.ResourceMetrics().AppendEmpty())
+	assert.Equal(t, map[string]int64{
+		"policy.id=drop-debug;service.name=cart;":     2,
+		"policy.id=drop-debug;service.name=checkout;": 2,
+	}, droppedLogCounts(t, summaryMetrics))
+}
+
+func TestProcessLogs_DroppedLogMetricsRateLimited(t *testing.T) {
+	// The policy removes the attribute it matches on, so the rate-limited
+	// record must be attributed to it as received, not as transformed.
+	pol := logPolicy("strip-key", "all", &policyv1.LogMatcher{
+		Field: &policyv1.LogMatcher_LogAttribute{LogAttribute: &policyv1.AttributePath{Path: []string{"api_key"}}},
+		Match: &policyv1.LogMatcher_Exists{Exists: true},
+	})
+	pol.GetLog().Transform = &policyv1.LogTransform{
+		Remove: []*policyv1.LogRemove{{
+			Field: &policyv1.LogRemove_LogAttribute{LogAttribute: &policyv1.AttributePath{Path: []string{"api_key"}}},
+		}},
+	}
+	p := createTestLogProcessor(t, []*policyv1.Policy{pol})
+	p.droppedLogs = newDroppedLogSummary(DroppedLogMetricsConfig{})
+	p.rateLimiter = newRateLimiter([]RateLimitConfig{{PolicyID: "strip-key", RecordsPerSecond: 1}})
+	frozen := time.Unix(1000, 0)
+	p.rateLimiter.now = func() time.Time { return frozen }
+
+	logs := plog.NewLogs()
+	records := logs.ResourceLogs().AppendEmpty().ScopeLogs().AppendEmpty().LogRecords()
+	for range 3 {
+		records.AppendEmpty().Attributes().PutStr("api_key", "secret")
+	}
+
+	result, err := p.processLogs(context.Background(), logs)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.LogRecordCount())
+
+	md := pmetric.NewMetrics()
+	p.droppedLogs.appendTo(md, pcommon.NewResource())
+	assert.Equal(t, map[string]int64{"policy.id=strip-key;": 2}, droppedLogCounts(t, md))
+}
+
+func TestProcessLogs_Dedup(t *testing.T) {
+	policies := []*policyv1.Policy{
+		{
+			Id:      "crashloop",
+			Name:    "Collapse crash loops",
+			Enabled: true,
+			Target: &policyv1.Policy_Log{
+				Log: &policyv1.LogTarget{
+					Match: []*policyv1.LogMatcher{
+						{
+							Field: &policyv1.LogMatcher_LogField{LogField: policyv1.LogField_LOG_FIELD_SEVERITY_TEXT},
+							Match: &policyv1.LogMatcher_Exact{Exact: "FATAL"},
+						},
+					},
+					Keep: "all",
+				},
+			},
+		},
+	}
+
+	p := createTestLogProcessor(t, policies)
+	p.deduplicator = newDeduplicator([]DedupConfig{{PolicyID: "crashloop", Window: time.Minute}})
+
+	tel := componenttest.NewTelemetry()
+	t.Cleanup(func() { require.NoError(t, tel.Shutdown(context.Background())) })
+	tb, err := metadata.NewTelemetryBuilder(tel.NewTelemetrySettings())
+	require.NoError(t, err)
+	p.telemetry = tb
+
+	logs := plog.NewLogs()
+	for _, pod := range []string{"cart-1", "cart-2"} {
+		rl := logs.ResourceLogs().AppendEmpty()
+		rl.Resource().Attributes().PutStr("k8s.pod.name", pod)
+		sl := rl.ScopeLogs().AppendEmpty()
+		for i := range 4 {
+			lr := sl.LogRecords().AppendEmpty()
+			lr.SetSeverityText("FATAL")
+			lr.SetTimestamp(pcommon.Timestamp(100 + i))
+			lr.Body().SetStr("panic: out of memory")
+		}
+		info := sl.LogRecords().AppendEmpty()
+		info.SetSeverityText("INFO")
+		info.Body().SetStr("panic: out of memory")
+	}
+
+	result, err := p.processLogs(context.Background(), logs)
+	require.NoError(t, err)
+
+	require.Equal(t, 2, result.ResourceLogs().Len())
+	for i := range 2 {
+		records := result.ResourceLogs().At(i).ScopeLogs().At(0).LogRecords()
+		require.Equal(t, 2, records.Len(), "one collapsed record per resource plus the unmatched record")
+
+		count, ok := records.At(0).Attributes().Get("log.record.count")
+		require.True(t, ok)
+		assert.Equal(t, int64(4), count.Int())
+		first, _ := records.At(0).Attributes().Get("log.record.first_seen")
+		assert.Equal(t, int64(100), first.Int())
+		last, _ := records.At(0).Attributes().Get("log.record.last_seen")
+		assert.Equal(t, int64(103), last.Int())
+
+		_, ok = records.At(1).Attributes().Get("log.record.count")
+		assert.False(t, ok)
+	}
+
+	metadatatest.AssertEqualProcessorPolicyRecords(t, tel, []metricdata.DataPoint[int64]{
+		{Attributes: attribute.NewSet(attrTelemetryType.String("logs"), attrResult.String("kept")), Value: 2},
+		{Attributes: attribute.NewSet(attrTelemetryType.String("logs"), attrResult.String("deduplicated")), Value: 6},
+		{Attributes: attribute.NewSet(attrTelemetryType.String("logs"), attrResult.String("no_match")), Value: 2},
+	}, metricdatatest.IgnoreTimestamp())
+}
+
+func TestProcessLogs_DedupFlushesOnShutdown(t *testing.T) {
+	p := createTestLogProcessor(t, []*policyv1.Policy{logPolicy("crashloop", "all", severityMatcher("FATAL"))})
+	p.deduplicator = newDeduplicator([]DedupConfig{{PolicyID: "crashloop", Window: time.Hour}})
+	sink := &consumertest.LogsSink{}
+	p.nextLogs = sink
+	p.startDedupFlush()
+
+	batch := func(ts int64) plog.Logs {
+		logs := plog.NewLogs()
+		lr := logs.ResourceLogs().AppendEmpty().ScopeLogs().AppendEmpty().LogRecords().AppendEmpty()
+		lr.SetSeverityText("FATAL")
+		lr.SetTimestamp(pcommon.Timestamp(ts))
+		lr.Body().SetStr("panic: out of memory")
+		return logs
+	}
+	out, err := p.processLogs(context.Background(), batch(100))
+	require.NoError(t, err)
+	assert.Equal(t, 1, out.LogRecordCount())
+	for ts := range int64(3) {
+		out, err = p.processLogs(context.Background(), batch(200+ts))
+		require.NoError(t, err)
+		assert.Equal(t, 0, out.LogRecordCount())
+	}
+	assert.Empty(t, sink.AllLogs())
+
+	// Shutdown reports the duplicates suppressed in the open window.
+	require.NoError(t, p.shutdown(context.Background()))
+	require.Len(t, sink.AllLogs(), 1)
+	lr := sink.AllLogs()[0].ResourceLogs().At(0).ScopeLogs().At(0).LogRecords().At(0)
+	assert.Equal(t, int64(3), recordInt(t, lr, attrLogRecordCount))
+	assert.Equal(t, int64(200), recordInt(t, lr, attrLogRecordFirstSeen))
+	assert.Equal(t, int64(202), recordInt(t, lr, attrLogRecordLastSeen))
+
+	out, err = p.processLogs(context.Background(), batch(300))
+	require.NoError(t, err)
+	assert.Equal(t, 1, out.LogRecordCount(), "records pass through after shutdown")
+}
+
+func TestProcessLogs_ByteTelemetry(t *testing.T) {
+	removeSecret := logPolicy("remove-secret", "all", &policyv1.LogMatcher{
+		Field: &policyv1.LogMatcher_LogAttribute{LogAttribute: &policyv1.AttributePath{Path: []string{"secret"}}},
+		Match: &policyv1.LogMatcher_Exists{Exists: true},
+	})
+	removeSecret.GetLog().Transform = &policyv1.LogTransform{
+		Remove: []*policyv1.LogRemove{
+			{Field: &policyv1.LogRemove_LogAttribute{LogAttribute: &policyv1.AttributePath{Path: []string{"secret"}}}},
+		},
+	}
+	p := createTestLogProcessor(t, []*policyv1.Policy{
+		logPolicy("drop-batch", "none", resourceAttrMatcher("service.name", "batch")),
+		logPolicy("sample-debug", "0%", severityMatcher("DEBUG")),
+		removeSecret,
+	})
+	p.bytes = true
+	tel := withTelemetry(t, p)
+
+	logs := plog.NewLogs()
+	batch := logs.ResourceLogs().AppendEmpty()
+	batch.Resource().Attributes().PutStr("service.name", "batch")
+	batch.ScopeLogs().AppendEmpty().LogRecords().AppendEmpty().Body().SetStr("job finished")
+	app := logs.ResourceLogs().AppendEmpty()
+	app.Resource().Attributes().PutStr("service.name", "app")
+	records := app.ScopeLogs().AppendEmpty().LogRecords()
+	debug := records.AppendEmpty()
+	debug.SetSeverityText("DEBUG")
+	debug.Body().SetStr("cache miss")
+	secret := records.AppendEmpty()
+	secret.Body().SetStr("login")
+	secret.Attributes().PutStr("secret", "hunter2")
+	plain := records.AppendEmpty()
+	plain.Body().SetStr("heartbeat")
+
+	batchSize := logRecordSize(batch.ScopeLogs().At(0).LogRecords().At(0))
+	debugSize := logRecordSize(debug)
+	secretSize := logRecordSize(secret)
+	plainSize := logRecordSize(plain)
+
+	out, err := p.processLogs(context.Background(), logs)
+	require.NoError(t, err)
+	kept := out.ResourceLogs().At(0).ScopeLogs().At(0).LogRecords()
+	require.Equal(t, 2, kept.Len())
+	secretKept := logRecordSize(kept.At(0))
+	require.Less(t, secretKept, secretSize)
+
+	attrs := func(result, policyID string) attribute.Set {
+		kvs := []attribute.KeyValue{attrTelemetryType.String("logs"), attrResult.String(result)}
+		if policyID != "" {
+			kvs = append(kvs, attrTelemetryPolicyID.String(policyID))
+		}
+		return attribute.NewSet(kvs...)
+	}
+	metadatatest.AssertEqualProcessorPolicyBytes(t, tel, []metricdata.DataPoint[int64]{
+		{Attributes: attrs("dropped", "drop-batch"), Value: int64(batchSize)},
+		{Attributes: attrs("sampled", "sample-debug"), Value: int64(debugSize)},
+		{Attributes: attrs("transformed", "remove-secret"), Value: int64(secretSize)},
+		{Attributes: attrs("no_match", ""), Value: int64(plainSize)},
+	}, metricdatatest.IgnoreTimestamp())
+	metadatatest.AssertEqualProcessorPolicyBytesSaved(t, tel, []metricdata.DataPoint[int64]{
+		{Attributes: attrs("dropped", "drop-batch"), Value: int64(batchSize)},
+		{Attributes: attrs("sampled", "sample-debug"), Value: int64(debugSize)},
+		{Attributes: attrs("transformed", "remove-secret"), Value: int64(secretSize - secretKept)},
+	}, metricdatatest.IgnoreTimestamp())
+}
+
+func TestProcessLogs_EvaluationDuration(t *testing.T) {
+	p := createTestLogProcessor(t, []*policyv1.Policy{logPolicy("drop-debug", "none", severityMatcher("DEBUG"))})
+	p.recordTiming = true
+	tel := withTelemetry(t, p)
+
+	for range 2 {
+		logs := plog.NewLogs()
+		records := logs.ResourceLogs().AppendEmpty().ScopeLogs().AppendEmpty().LogRecords()
+		for _, severity := range []string{"DEBUG", "INFO", "WARN"} {
+			records.AppendEmpty().SetSeverityText(severity)
+		}
+		_, err := p.processLogs(context.Background(), logs)
+		require.NoError(t, err)
+	}
+
+	histogram := func(name string) []metricdata.HistogramDataPoint[float64] {
+		m, err := tel.GetMetric(name)
+		require.NoError(t, err)
+		return m.Data.(metricdata.Histogram[float64]).DataPoints
+	}
+	batches := histogram("otelcol_processor_policy_evaluation_duration")
+	require.Len(t, batches, 1)
+	assert.Equal(t, attribute.NewSet(attrTelemetryType.String("logs"), attrBatchSize.String("1-10")), batches[0].Attributes)
+	assert.Equal(t, uint64(2), batches[0].Count)
+
+	records := histogram("otelcol_processor_policy_record_evaluation_duration")
+	require.Len(t, records, 1)
+	assert.Equal(t, attribute.NewSet(attrTelemetryType.String("logs")), records[0].Attributes)
+	assert.Equal(t, uint64(6), records[0].Count)
+}
@@ -3,6 +3,7 @@ package policyprocessor
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -1060,3 +1061,199 @@ func TestProcessTraces_TypedMatchers(t *testing.T) {
 		})
 	}
 }
+
+func TestProcessTraces_RateLimit(t *testing.T) {
+	policies := []*policyv1.Policy{
+		{
+			Id:      "limit-db",
+			Name:    "Limit database spans",
+			Enabled: true,
+			Target: &policyv1.Policy_Trace{
+				Trace: &policyv1.TraceTarget{
+					Match: []*policyv1.TraceMatcher{
+						{
+							Field: &policyv1.TraceMatcher_TraceField{TraceField: policyv1.TraceField_TRACE_FIELD_NAME},
+							Match: &policyv1.TraceMatcher_StartsWith{StartsWith: "db."},
+						},
+					},
+					Keep: keepConfig(),
+				},
+			},
+		},
+	}
+
+	p := createTestTraceProcessor(t, policies)
+	p.rateLimiter = newRateLimiter([]RateLimitConfig{
+		{PolicyID: "limit-db", RecordsPerSecond: 3},
+	})
+	frozen := time.Unix(1000, 0)
+	p.rateLimiter.now = func() time.Time { return frozen }
+
+	traces := ptrace.NewTraces()
+	ss := traces.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty()
+	for range 10 {
+		ss.Spans().AppendEmpty().SetName("db.query")
+	}
+	ss.Spans().AppendEmpty().SetName("http.request")
+
+	result, err := p.processTraces(context.Background(), traces)
+	require.NoError(t, err)
+
+	spans := result.ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+	require.Equal(t, 4, spans.Len())
+	assert.Equal(t, "http.request", spans.At(3).Name())
+
+	// A second batch within the same second is fully throttled.
+	traces = ptrace.NewTraces()
+	ss = traces.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty()
+	ss.Spans().AppendEmpty().SetName("db.query")
+	result, err = p.processTraces(context.Background(), traces)
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.ResourceSpans().Len())
+}
+
+// createSamplingTraceProcessor returns a processor whose single policy samples
+// every span at the given percentage.
+func createSamplingTraceProcessor(t *testing.T, percentage float32, mode *policyv1.SamplingMode) *policyProcessor {
+	return createTestTraceProcessor(t, []*policyv1.Policy{
+		{
+			Id:      "sample-all",
+			Enabled: true,
+			Target: &policyv1.Policy_Trace{
+				Trace: &policyv1.TraceTarget{
+					Match: []*policyv1.TraceMatcher{
+						{
+							Field: &policyv1.TraceMatcher_TraceField{TraceField: policyv1.TraceField_TRACE_FIELD_NAME},
+							Match: &policyv1.TraceMatcher_Exact{Exact: "GET /cart"},
+						},
+					},
+					Keep: &policyv1.TraceSamplingConfig{Percentage: percentage, Mode: mode},
+				},
+			},
+		},
+	})
+}
+
+// sampledSpans runs traces through each stage in turn, as chained collectors
+// would, and returns the tracestate of every surviving span by trace ID.
+func sampledSpans(t *testing.T, traces ptrace.Traces, stages ...*policyProcessor) map[pcommon.TraceID]string {
+	t.Helper()
+	for _, stage := range stages {
+		var err error
+		traces, err = stage.processTraces(context.Background(), traces)
+		require.NoError(t, err)
+	}
+	out := make(map[pcommon.TraceID]string)
+	for i := range traces.ResourceSpans().Len() {
+		sss := traces.ResourceSpans().At(i).ScopeSpans()
+		for j := range sss.Len() {
+			spans := sss.At(j).Spans()
+			for k := range spans.Len() {
+				out[spans.At(k).TraceID()] = spans.At(k).TraceState().AsRaw()
+			}
+		}
+	}
+	return out
+}
+
+func newCartTraces(n int) ptrace.Traces {
+	traces := ptrace.NewTraces()
+	spans := traces.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+	for i := range n {
+		span := spans.AppendEmpty()
+		span.SetName("GET /cart")
+		span.SetTraceID(testTraceID(i))
+	}
+	return traces
+}
+
+func TestProcessTraces_MultiStageSampling(t *testing.T) {
+	const n = 2000
+	proportional := policyv1.SamplingMode_SAMPLING_MODE_PROPORTIONAL
+
+	single25 := sampledSpans(t, newCartTraces(n), createSamplingTraceProcessor(t, 25, nil))
+
+	tests := []struct {
+		name       string
+		stages     []*policyProcessor
+		wantTS     string
+		wantSameAs map[pcommon.TraceID]string
+	}{
+		{
+			name: "keep all downstream keeps the upstream threshold",
+			stages: []*policyProcessor{
+				createSamplingTraceProcessor(t, 50, nil),
+				createSamplingTraceProcessor(t, 100, nil),
+			},
+			wantTS: "ot=th:8",
+		},
+		{
+			name: "stricter downstream raises the threshold",
+			stages: []*policyProcessor{
+				createSamplingTraceProcessor(t, 50, nil),
+				createSamplingTraceProcessor(t, 25, nil),
+			},
+			wantTS:     "ot=th:c",
+			wantSameAs: single25,
+		},
+		{
+			name: "looser downstream never lowers the threshold",
+			stages: []*policyProcessor{
+				createSamplingTraceProcessor(t, 25, nil),
+				createSamplingTraceProcessor(t, 50, nil),
+			},
+			wantTS:     "ot=th:c",
+			wantSameAs: single25,
+		},
+		{
+			name: "proportional downstream multiplies probabilities",
+			stages: []*policyProcessor{
+				createSamplingTraceProcessor(t, 50, nil),
+				createSamplingTraceProcessor(t, 50, &proportional),
+			},
+			wantTS:     "ot=th:c",
+			wantSameAs: single25,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sampledSpans(t, newCartTraces(n), tt.stages...)
+			require.NotEmpty(t, got)
+			for _, ts := range got {
+				assert.Equal(t, tt.wantTS, ts)
+			}
+			if tt.wantSameAs != nil {
+				assert.Equal(t, tt.wantSameAs, got, "chained stages agree with a single stage at the effective rate")
+			}
+
+			first := sampledSpans(t, newCartTraces(n), tt.stages[0])
+			for id := range got {
+				assert.Contains(t, first, id, "later stages only keep a subset of earlier stages")
+			}
+		})
+	}
+}
+
+func TestProcessTraces_HonorsExplicitRandomness(t *testing.T) {
+	p := createSamplingTraceProcessor(t, 50, nil)
+
+	traces := ptrace.NewTraces()
+	spans := traces.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+
+	// Trace ID randomness says drop, rv says keep.
+	keep := spans.AppendEmpty()
+	keep.SetName("GET /cart")
+	keep.SetTraceID(pcommon.TraceID{15: 0x01})
+	keep.TraceState().FromRaw("ot=rv:f0000000000000")
+
+	// Trace ID randomness says keep, rv says drop.
+	drop := spans.AppendEmpty()
+	drop.SetName("GET /cart")
+	drop.SetTraceID(pcommon.TraceID{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	drop.TraceState().FromRaw("ot=rv:10000000000000")
+
+	got := sampledSpans(t, traces, p)
+	require.Len(t, got, 1)
+	assert.Equal(t, "ot=rv:f0000000000000;th:8", got[pcommon.TraceID{15: 0x01}])
+}
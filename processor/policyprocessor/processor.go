@@ -2,24 +2,46 @@ package policyprocessor
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/usetero/policy-go/backend/hyperscan"
 	"github.com/usetero/policy-go/policy"
 	"github.com/usetero/tero-collector-distro/processor/policyprocessor/internal/metadata"
 	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/consumer"
 	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/plog"
 	"go.opentelemetry.io/collector/pdata/pmetric"
 	"go.opentelemetry.io/collector/pdata/ptrace"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
 // Attribute keys for telemetry.
 var (
-	attrTelemetryType = attribute.Key("telemetry_type")
-	attrResult        = attribute.Key("result")
+	attrTelemetryType     = attribute.Key("telemetry_type")
+	attrResult            = attribute.Key("result")
+	attrTelemetryPolicyID = attribute.Key("policy_id")
+	attrBatchSize         = attribute.Key("batch_size")
+)
+
+// Result attribute values for records handled by the processor itself rather
+// than by the policy engine.
+const (
+	// resultRateLimited marks records dropped by a per-policy rate limit.
+	resultRateLimited = "rate_limited"
+	// resultDeduplicated marks duplicate log records collapsed into an
+	// earlier record.
+	resultDeduplicated = "deduplicated"
+	// resultDownsampled marks metric datapoints combined into another
+	// datapoint of the same series and interval.
+	resultDownsampled = "downsampled"
+	// resultCardinalityLimited marks metric datapoints of new series dropped
+	// by a per-policy cardinality limit.
+	resultCardinalityLimited = "cardinality_limited"
 )
 
 type policyProcessor struct {
@@ -30,14 +52,135 @@ type policyProcessor struct {
 	registry  *policy.PolicyRegistry
 	engine    *policy.PolicyEngine
 	providers []policy.LoadedProvider
+	inventory *providerInventory
+
+	// pipeline is the signal of the pipeline the instance runs in.
+	pipeline string
+
+	// debug serves the loaded policies over HTTP; nil when the debug
+	// endpoint is disabled. It is shared by the instances for the same
+	// component ID.
+	debug   *debugServer
+	debugID component.ID
+
+	// Decision plans for the current snapshots, used to settle whole
+	// resources and scopes without evaluating each record.
+	logPlans    planCache[policy.LogField]
+	tracePlans  planCache[policy.TraceField]
+	metricPlans planCache[policy.MetricField]
+
+	// rateLimiter enforces per-policy rate limits; nil when none are configured.
+	rateLimiter *rateLimiter
+
+	// traceConsistentLogs derives percentage log sampling decisions from the
+	// record's trace ID so they line up with span sampling.
+	traceConsistentLogs bool
+
+	// orphanedSpans is the strategy for children of dropped spans; empty or
+	// OrphanedSpansKeep leaves them unchanged.
+	orphanedSpans string
+
+	// deduplicator collapses repeated log records; nil when no dedup rules
+	// are configured.
+	deduplicator *deduplicator
+
+	// downsampler keeps one datapoint per series and interval; nil when no
+	// downsample rules are configured.
+	downsampler *downsampler
+
+	// histograms reduces histogram resolution; nil when no histogram rules
+	// are configured.
+	histograms *histogramTransformer
+
+	// exemplars removes exemplars from datapoints; nil when no exemplar rules
+	// are configured.
+	exemplars *exemplarStripper
+
+	// cardinality bounds the number of series per metric name; nil when no
+	// cardinality limits are configured.
+	cardinality *cardinalityLimiter
+
+	// bytes reports the estimated size of processed records and the bytes
+	// saved on them.
+	bytes bool
+
+	// usage reports the usage of each policy to the control plane; nil
+	// unless usage reporting is enabled.
+	usage *usageReporter
+
+	// recordTiming reports the evaluation duration of each record.
+	recordTiming bool
+
+	// tracer creates internal spans for batches and provider updates; nil
+	// unless tracing is enabled.
+	tracer trace.Tracer
+
+	// decisionLog logs a sample of individual policy decisions at debug
+	// level; nil unless enabled.
+	decisionLog *decisionLog
+
+	// droppedLogs summarizes dropped log records into metrics; nil when
+	// dropped log metrics are disabled. It is shared with the metrics
+	// instance for the same component ID, which emits it.
+	droppedLogs   *droppedLogSummary
+	droppedLogsID component.ID
+
+	// droppedSpans accumulates RED metrics for dropped spans; nil when
+	// dropped span metrics are disabled. It is shared with the metrics
+	// instance for the same component ID, which emits it.
+	droppedSpans   *droppedSpanSummary
+	droppedSpansID component.ID
+
+	// metricsID is the component ID of the metrics instance, registered in
+	// metricsInstances.
+	metricsID component.ID
+	// nextMetrics receives the summaries the metrics instance flushes
+	// periodically; nil on the other instances.
+	nextMetrics    consumer.Metrics
+	summaryFlushes []*summaryFlush
+
+	// workers evaluates the resources of a batch concurrently; nil when
+	// parallel evaluation is disabled.
+	workers *workerPool
+
+	// tail buffers spans and decides whole traces; nil unless tail sampling
+	// is enabled on a traces processor. Decided traces are released to
+	// nextTraces.
+	tail       *tailSampler
+	nextTraces consumer.Traces
+
+	// traceDecisions records trace decisions for logs to follow; nil unless
+	// enabled. It is shared between the traces and logs instances for the
+	// same component ID. The logs instance holds back records of undecided
+	// traces and releases them to nextLogs, which also receives the final
+	// dedup summaries; nextLogs is nil on the other instances.
+	traceDecisions   *traceDecisionCache
+	traceDecisionsID component.ID
+	nextLogs         consumer.Logs
+	heldLogsStop     chan struct{}
+	heldLogsDone     chan struct{}
 }
 
 func newPolicyProcessor(logger *zap.Logger, cfg *Config, telemetry *metadata.TelemetryBuilder, resource pcommon.Resource) *policyProcessor {
 	return &policyProcessor{
-		logger:    logger,
-		config:    cfg,
-		telemetry: telemetry,
-		resource:  resource,
+		logger:       logger,
+		config:       cfg,
+		telemetry:    telemetry,
+		resource:     resource,
+		rateLimiter:  newRateLimiter(cfg.RateLimits),
+		deduplicator: newDeduplicator(cfg.Dedup),
+		downsampler:  newDownsampler(cfg.Downsample),
+		histograms:   newHistogramTransformer(cfg.Histograms),
+		exemplars:    newExemplarStripper(cfg.Exemplars),
+		cardinality:  newCardinalityLimiter(cfg.CardinalityLimits),
+		workers:      newWorkerPool(cfg.Parallelism),
+		decisionLog:  newDecisionLog(cfg.DecisionLog, logger),
+		usage:        newUsageReporter(cfg.UsageReport),
+
+		traceConsistentLogs: cfg.LogSampling.TraceConsistent,
+		orphanedSpans:       cfg.OrphanedSpans.Strategy,
+		bytes:               cfg.Telemetry.Bytes,
+		recordTiming:        cfg.Telemetry.RecordTiming,
 	}
 }
 
@@ -45,6 +188,9 @@ func (p *policyProcessor) start(_ context.Context, _ component.Host) error {
 	p.logger.Info("Policy processor starting",
 		zap.Int("provider_count", len(p.config.Providers)),
 	)
+	if err := p.checkSummaryEmitter(); err != nil {
+		return err
+	}
 
 	// Create registry
 	p.registry = policy.NewPolicyRegistry(policy.WithRegexBackend(hyperscan.New()))
@@ -52,67 +198,167 @@ func (p *policyProcessor) start(_ context.Context, _ component.Host) error {
 	// Create engine with the registry
 	p.engine = policy.NewPolicyEngine(p.registry)
 
+	p.inventory = newProviderInventory()
+
 	// Set callback for when policies are recompiled.
 	p.registry.SetOnRecompile(func(err error) {
+		p.inventory.setRecompileErr(err)
 		if err != nil {
 			p.logger.Error("Policy recompile error", zap.Error(err))
 		}
 	})
+	if p.telemetry != nil {
+		if err := p.telemetry.RegisterProcessorPolicyProviderPoliciesCallback(p.inventory.observePolicies); err != nil {
+			return err
+		}
+		if err := p.telemetry.RegisterProcessorPolicyProviderLastSyncCallback(p.inventory.observeLastSync); err != nil {
+			return err
+		}
+		if err := p.telemetry.RegisterProcessorPolicyTransformsCallback(p.inventory.observeTransforms(p.registry.CollectStats)); err != nil {
+			return err
+		}
+	}
 
 	// Build service metadata from collector resource attributes
 	serviceMetadata := p.buildServiceMetadata()
 
-	// Create config loader
-	loader := policy.NewConfigLoader(p.registry).
-		WithServiceMetadata(serviceMetadata).
-		WithOnError(func(err error) {
-			p.logger.Error("Policy provider error", zap.Error(err))
-		})
-
 	// Load providers from config
-	cfg := &policy.Config{
-		Providers: p.config.Providers,
-	}
-	providers, err := loader.Load(cfg)
+	providers, err := p.loadProviders(serviceMetadata)
 	if err != nil {
 		return err
 	}
 	p.providers = providers
 
+	if p.debug != nil {
+		if err := p.debug.add(p); err != nil {
+			return fmt.Errorf("failed to start debug endpoint: %w", err)
+		}
+	}
+	if p.tail != nil {
+		p.startTailSampling()
+	}
+	if p.traceDecisions != nil && p.nextLogs != nil {
+		p.startHeldLogRelease()
+	}
+	if p.deduplicator != nil && p.nextLogs != nil {
+		p.startDedupFlush()
+	}
+	if p.usage != nil {
+		p.startUsageReport()
+	}
+	if p.nextMetrics != nil && p.droppedLogs != nil {
+		p.startSummaryFlush(p.droppedLogs, p.droppedLogs.flushInterval)
+	}
+	if p.nextMetrics != nil && p.droppedSpans != nil {
+		p.startSummaryFlush(p.droppedSpans, p.droppedSpans.flushInterval)
+	}
+	if p.nextMetrics != nil && p.downsampler != nil {
+		p.startSummaryFlush(p.downsampler, p.downsampler.flushInterval())
+	}
+
 	p.logger.Info("Policy processor started",
 		zap.Int("providers_loaded", len(p.providers)),
 	)
 	return nil
 }
 
-func (p *policyProcessor) shutdown(_ context.Context) error {
+func (p *policyProcessor) shutdown(ctx context.Context) error {
 	p.logger.Info("Policy processor shutting down")
+	if p.tail != nil && p.registry != nil {
+		p.stopTailSampling(ctx)
+	}
+	if p.traceDecisions != nil {
+		if p.nextLogs != nil && p.registry != nil {
+			p.stopHeldLogRelease(ctx)
+		}
+		traceDecisionCaches.release(p.traceDecisionsID)
+	}
+	if p.deduplicator != nil && p.nextLogs != nil && p.registry != nil {
+		p.stopDedupFlush(ctx)
+	}
+	if p.usage != nil {
+		p.stopUsageReport(ctx)
+	}
+	if p.downsampler != nil {
+		p.downsampler.close()
+	}
+	p.stopSummaryFlushes(ctx)
+	var err error
+	if p.debug != nil {
+		err = p.debug.remove(ctx, p)
+		debugServers.release(p.debugID)
+	}
 	if len(p.providers) > 0 {
 		policy.StopAll(p.providers)
 		policy.UnregisterAll(p.providers)
 	}
+	if p.droppedLogs != nil {
+		droppedLogSummaries.release(p.droppedLogsID)
+	}
+	if p.droppedSpans != nil {
+		droppedSpanSummaries.release(p.droppedSpansID)
+	}
+	if p.nextMetrics != nil {
+		metricsInstances.release(p.metricsID)
+	}
 	if p.telemetry != nil {
 		p.telemetry.Shutdown()
 	}
-	return nil
+	return err
 }
 
 func (p *policyProcessor) processTraces(ctx context.Context, td ptrace.Traces) (ptrace.Traces, error) {
-	traceOpts := []policy.TraceOption[TraceContext]{
-		policy.WithTraceValue(TraceValue),
-		policy.WithTraceTypedValue(TraceTypedMatcher),
-		policy.WithTraceExists(TraceExists),
-		policy.WithTraceSet(TraceSet),
+	defer p.recordBatchDuration(ctx, "traces", td.SpanCount(), time.Now())
+	ctx, batch := p.startBatchSpan(ctx, "traces", td.SpanCount())
+	defer batch.end()
+
+	if p.tail != nil {
+		return p.tailSampleTraces(ctx, td)
+	}
+
+	traceOpts := traceOptions()
+
+	snapshot := p.registry.TraceSnapshot()
+	plan := p.tracePlans.get(snapshot, traceFieldLevel)
+
+	var parents map[spanKey]pcommon.SpanID
+	var kept *keptSpans
+	if p.orphanedSpans == OrphanedSpansReparent || p.orphanedSpans == OrphanedSpansDrop {
+		parents = spanParents(td)
+		kept = newKeptSpans()
+	}
+
+	// Tapped spans skip the container shortcuts, like tapped log records.
+	// So do spans that would be kept in bulk while orphaned spans are
+	// handled, since whether they stay is only known once every span has
+	// been evaluated.
+	tapping := p.tapping()
+	settled := func(d containerDecision) bool {
+		return d.action != actionEvaluate && !tapping && (kept == nil || d.action == actionDrop)
 	}
 
-	td.ResourceSpans().RemoveIf(func(rs ptrace.ResourceSpans) bool {
+	removeResources(p.workers, td.ResourceSpans(), func(rs ptrace.ResourceSpans) bool {
 		resource := rs.Resource()
 		resourceSchemaURL := rs.SchemaUrl()
 
+		resCtx := TraceContext{Resource: resource, ResourceSchemaURL: resourceSchemaURL}
+		if d := p.decideTraceContainer(snapshot, plan, levelResource, resCtx); settled(d) {
+			for i := range rs.ScopeSpans().Len() {
+				p.settleSpans(ctx, snapshot, d, resource, rs.ScopeSpans().At(i).Spans())
+			}
+			return d.action == actionDrop
+		}
+
 		rs.ScopeSpans().RemoveIf(func(ss ptrace.ScopeSpans) bool {
 			scope := ss.Scope()
 			scopeSchemaURL := ss.SchemaUrl()
 
+			scopeCtx := TraceContext{Resource: resource, Scope: scope, ResourceSchemaURL: resourceSchemaURL, ScopeSchemaURL: scopeSchemaURL}
+			if d := p.decideTraceContainer(snapshot, plan, levelScope, scopeCtx); settled(d) {
+				p.settleSpans(ctx, snapshot, d, resource, ss.Spans())
+				return d.action == actionDrop
+			}
+
 			ss.Spans().RemoveIf(func(span ptrace.Span) bool {
 				traceCtx := TraceContext{
 					Span:              span,
@@ -122,10 +368,48 @@ func (p *policyProcessor) processTraces(ctx context.Context, td ptrace.Traces) (
 					ScopeSchemaURL:    scopeSchemaURL,
 				}
 
-				result := policy.EvaluateTrace(p.engine, traceCtx, traceOpts...)
-				p.recordMetric(ctx, "traces", result)
+				var size int
+				if p.attributeRecords() {
+					size = spanSize(span)
+				}
+				// Match the span as received, before evaluation writes its
+				// sampling threshold.
+				logged := p.decisionLog.sample()
+				var matches policyMatches
+				if p.rateLimiter != nil || p.attributeRecords() || tapping || logged {
+					matches = matchTracePolicies(snapshot, traceCtx)
+				}
+				var capture *spanCapture
+				if tapping || logged {
+					capture = p.captureSpan(matches, traceCtx, logged)
+				}
 
-				return result == policy.ResultDrop
+				start := p.recordStart()
+				result := policy.EvaluateTrace(p.engine, traceCtx, traceOpts...)
+				p.recordEvaluationDuration(ctx, "traces", start)
+				if result != policy.ResultDrop && p.rateLimiter != nil && !p.rateLimiter.allow(matches.IDs, resource) {
+					p.recordResult(ctx, "traces", resultRateLimited)
+					p.recordBytes(ctx, "traces", resultRateLimited, matches, size, 0)
+					capture.publish(resultRateLimited)
+					p.recordTraceDecision(span.TraceID(), false)
+					p.recordDroppedSpan(resource, span)
+					return true
+				}
+				if result == policy.ResultDrop {
+					p.recordMetric(ctx, "traces", result)
+					p.recordTraceDecision(span.TraceID(), false)
+					p.recordBytes(ctx, "traces", "dropped", matches, size, 0)
+					capture.publish("dropped")
+					p.recordDroppedSpan(resource, span)
+					return true
+				}
+				ks := keptSpan{result: result, matches: matches, size: size, capture: capture}
+				if kept != nil {
+					kept.add(span, ks)
+				} else {
+					p.recordKeptSpan(ctx, span, ks)
+				}
+				return false
 			})
 
 			return ss.Spans().Len() == 0
@@ -134,22 +418,59 @@ func (p *policyProcessor) processTraces(ctx context.Context, td ptrace.Traces) (
 		return rs.ScopeSpans().Len() == 0
 	})
 
+	if parents != nil {
+		p.handleOrphanedSpans(ctx, td, parents, kept)
+	}
+
 	return td, nil
 }
 
+// recordKeptSpan records the result of a span that stays in its batch.
+func (p *policyProcessor) recordKeptSpan(ctx context.Context, span ptrace.Span, ks keptSpan) {
+	p.recordMetric(ctx, "traces", ks.result)
+	if ks.result != policy.ResultNoMatch {
+		p.recordTraceDecision(span.TraceID(), true)
+	}
+	if p.attributeRecords() {
+		p.recordBytes(ctx, "traces", resultString(ks.result), ks.matches, ks.size, spanSize(span))
+	}
+	ks.capture.publish(resultString(ks.result))
+}
+
 func (p *policyProcessor) processMetrics(ctx context.Context, md pmetric.Metrics) (pmetric.Metrics, error) {
+	defer p.recordBatchDuration(ctx, "metrics", md.DataPointCount(), time.Now())
+	ctx, batch := p.startBatchSpan(ctx, "metrics", md.DataPointCount())
+	defer batch.end()
+
 	metricOpts := metricOptions()
 
-	md.ResourceMetrics().RemoveIf(func(rm pmetric.ResourceMetrics) bool {
+	snapshot := p.registry.MetricSnapshot()
+	plan := p.metricPlans.get(snapshot, metricFieldLevel)
+
+	removeResources(p.workers, md.ResourceMetrics(), func(rm pmetric.ResourceMetrics) bool {
 		resource := rm.Resource()
 		resourceSchemaURL := rm.SchemaUrl()
 
+		resCtx := MetricContext{Resource: resource, ResourceSchemaURL: resourceSchemaURL}
+		if d := p.decideMetricContainer(snapshot, plan, levelResource, resCtx); d.action != actionEvaluate {
+			for i := range rm.ScopeMetrics().Len() {
+				p.settleMetrics(ctx, snapshot, d, resource, rm.ScopeMetrics().At(i).Metrics())
+			}
+			return d.action == actionDrop
+		}
+
 		rm.ScopeMetrics().RemoveIf(func(sm pmetric.ScopeMetrics) bool {
 			scope := sm.Scope()
 			scopeSchemaURL := sm.SchemaUrl()
 
+			scopeCtx := MetricContext{Resource: resource, Scope: scope, ResourceSchemaURL: resourceSchemaURL, ScopeSchemaURL: scopeSchemaURL}
+			if d := p.decideMetricContainer(snapshot, plan, levelScope, scopeCtx); d.action != actionEvaluate {
+				p.settleMetrics(ctx, snapshot, d, resource, sm.Metrics())
+				return d.action == actionDrop
+			}
+
 			sm.Metrics().RemoveIf(func(m pmetric.Metric) bool {
-				return p.processMetricDatapoints(ctx, m, resource, scope, resourceSchemaURL, scopeSchemaURL, metricOpts)
+				return p.processMetricDatapoints(ctx, snapshot, m, resource, scope, resourceSchemaURL, scopeSchemaURL, metricOpts)
 			})
 
 			return sm.Metrics().Len() == 0
@@ -158,9 +479,26 @@ func (p *policyProcessor) processMetrics(ctx context.Context, md pmetric.Metrics
 		return rm.ScopeMetrics().Len() == 0
 	})
 
+	if p.droppedLogs != nil {
+		p.droppedLogs.appendTo(md, p.resource)
+	}
+	if p.droppedSpans != nil {
+		p.droppedSpans.appendTo(md, p.resource)
+	}
+
 	return md, nil
 }
 
+// traceOptions returns the option slice used by policy.EvaluateTrace.
+func traceOptions() []policy.TraceOption[TraceContext] {
+	return []policy.TraceOption[TraceContext]{
+		policy.WithTraceValue(TraceValue),
+		policy.WithTraceTypedValue(TraceTypedMatcher),
+		policy.WithTraceExists(TraceExists),
+		policy.WithTraceSet(TraceSet),
+	}
+}
+
 // metricOptions returns the option slice used by policy.EvaluateMetric.
 func metricOptions() []policy.MetricOption[MetricContext] {
 	return []policy.MetricOption[MetricContext]{
@@ -172,32 +510,38 @@ func metricOptions() []policy.MetricOption[MetricContext] {
 
 // processMetricDatapoints evaluates all datapoints in a metric and removes dropped ones.
 // Returns true if the entire metric should be dropped (all datapoints were dropped).
-func (p *policyProcessor) processMetricDatapoints(ctx context.Context, m pmetric.Metric, resource pcommon.Resource, scope pcommon.InstrumentationScope, resourceSchemaURL, scopeSchemaURL string, opts []policy.MetricOption[MetricContext]) bool {
+func (p *policyProcessor) processMetricDatapoints(ctx context.Context, snapshot *policy.MetricSnapshot, m pmetric.Metric, resource pcommon.Resource, scope pcommon.InstrumentationScope, resourceSchemaURL, scopeSchemaURL string, opts []policy.MetricOption[MetricContext]) bool {
 	switch m.Type() {
 	case pmetric.MetricTypeGauge:
-		p.processNumberDataPoints(ctx, m, m.Gauge().DataPoints(), pmetric.AggregationTemporalityUnspecified, resource, scope, resourceSchemaURL, scopeSchemaURL, opts)
+		p.processNumberDataPoints(ctx, snapshot, m, m.Gauge().DataPoints(), pmetric.AggregationTemporalityUnspecified, resource, scope, resourceSchemaURL, scopeSchemaURL, opts)
 		return m.Gauge().DataPoints().Len() == 0
 	case pmetric.MetricTypeSum:
 		sum := m.Sum()
-		p.processNumberDataPoints(ctx, m, sum.DataPoints(), sum.AggregationTemporality(), resource, scope, resourceSchemaURL, scopeSchemaURL, opts)
+		p.processNumberDataPoints(ctx, snapshot, m, sum.DataPoints(), sum.AggregationTemporality(), resource, scope, resourceSchemaURL, scopeSchemaURL, opts)
 		return sum.DataPoints().Len() == 0
 	case pmetric.MetricTypeHistogram:
 		hist := m.Histogram()
-		p.processHistogramDataPoints(ctx, m, hist.DataPoints(), hist.AggregationTemporality(), resource, scope, resourceSchemaURL, scopeSchemaURL, opts)
+		p.processHistogramDataPoints(ctx, snapshot, m, hist.DataPoints(), hist.AggregationTemporality(), resource, scope, resourceSchemaURL, scopeSchemaURL, opts)
 		return hist.DataPoints().Len() == 0
 	case pmetric.MetricTypeExponentialHistogram:
 		expHist := m.ExponentialHistogram()
-		p.processExponentialHistogramDataPoints(ctx, m, expHist.DataPoints(), expHist.AggregationTemporality(), resource, scope, resourceSchemaURL, scopeSchemaURL, opts)
+		p.processExponentialHistogramDataPoints(ctx, snapshot, m, expHist.DataPoints(), expHist.AggregationTemporality(), resource, scope, resourceSchemaURL, scopeSchemaURL, opts)
 		return expHist.DataPoints().Len() == 0
 	case pmetric.MetricTypeSummary:
-		p.processSummaryDataPoints(ctx, m, m.Summary().DataPoints(), resource, scope, resourceSchemaURL, scopeSchemaURL, opts)
+		p.processSummaryDataPoints(ctx, snapshot, m, m.Summary().DataPoints(), resource, scope, resourceSchemaURL, scopeSchemaURL, opts)
 		return m.Summary().DataPoints().Len() == 0
 	default:
 		return false
 	}
 }
 
-func (p *policyProcessor) processNumberDataPoints(ctx context.Context, m pmetric.Metric, datapoints pmetric.NumberDataPointSlice, temporality pmetric.AggregationTemporality, resource pcommon.Resource, scope pcommon.InstrumentationScope, resourceSchemaURL, scopeSchemaURL string, opts []policy.MetricOption[MetricContext]) {
+func (p *policyProcessor) processNumberDataPoints(ctx context.Context, snapshot *policy.MetricSnapshot, m pmetric.Metric, datapoints pmetric.NumberDataPointSlice, temporality pmetric.AggregationTemporality, resource pcommon.Resource, scope pcommon.InstrumentationScope, resourceSchemaURL, scopeSchemaURL string, opts []policy.MetricOption[MetricContext]) {
+	var resourceKey string
+	var origin *downsampleOrigin
+	// overflow is the first datapoint of the batch moved into the overflow
+	// series of m; later ones are merged into it.
+	var overflow pmetric.NumberDataPoint
+	var hasOverflow bool
 	datapoints.RemoveIf(func(dp pmetric.NumberDataPoint) bool {
 		metricCtx := MetricContext{
 			Metric:                 m,
@@ -209,14 +553,98 @@ func (p *policyProcessor) processNumberDataPoints(ctx context.Context, m pmetric
 			ScopeSchemaURL:         scopeSchemaURL,
 		}
 
+		var size int
+		if p.attributeRecords() {
+			size = numberDataPointSize(dp)
+		}
+
+		start := p.recordStart()
 		result := policy.EvaluateMetric(p.engine, metricCtx, opts...)
+		p.recordEvaluationDuration(ctx, "metrics", start)
+		needMatches := result != policy.ResultDrop && (p.downsampler != nil || p.exemplars != nil || p.cardinality != nil)
+		logged := p.decisionLog.sample()
+		var matches policyMatches
+		if needMatches || p.attributeRecords() || logged {
+			matches = matchMetricPolicies(snapshot, metricCtx)
+		}
+		if needMatches {
+			policyIDs := matches.IDs
+			transformed := false
+			switch p.applyExemplars(policyIDs, dp.Exemplars()) {
+			case exemplarsDropped:
+				p.recordExemplarDrop(ctx, matches, metricCtx, logged, size)
+				return true
+			case exemplarsStripped:
+				transformed = true
+			}
+			overflowed := false
+			switch p.limitSeries(policyIDs, &resourceKey, resource, scope, m.Name(), temporality, dp.Attributes()) {
+			case seriesDropped:
+				p.recordResult(ctx, "metrics", resultCardinalityLimited)
+				p.recordBytes(ctx, "metrics", resultCardinalityLimited, matches, size, 0)
+				p.logMetricDecision(logged, metricCtx, resultCardinalityLimited, matches)
+				return true
+			case seriesOverflowed:
+				transformed, overflowed = true, true
+			}
+			if transformed && result == policy.ResultKeep {
+				result = policy.ResultKeepWithTransform
+			}
+			if p.downsampler != nil {
+				if rule := p.downsampler.rule(policyIDs); rule != nil {
+					if resourceKey == "" {
+						resourceKey = dedupResourceKey(resource)
+					}
+					if origin == nil {
+						origin = &downsampleOrigin{
+							resourceKey:       resourceKey,
+							resource:          resource,
+							resourceSchemaURL: resourceSchemaURL,
+							scope:             scope,
+							scopeSchemaURL:    scopeSchemaURL,
+							metric:            m,
+							temporality:       temporality,
+						}
+					}
+					key := metricSeriesKey(resourceKey, scope, m.Name(), dp.Attributes())
+					if !p.downsampler.add(rule, key, origin, dp) {
+						p.recordResult(ctx, "metrics", resultDownsampled)
+						p.recordBytes(ctx, "metrics", resultDownsampled, matches, size, 0)
+						p.logMetricDecision(logged, metricCtx, resultDownsampled, matches)
+						return true
+					}
+				}
+			}
+			if overflowed && hasOverflow {
+				mergeOverflowNumber(overflow, dp, temporality)
+				p.recordMetric(ctx, "metrics", result)
+				p.recordBytes(ctx, "metrics", resultString(result), matches, size, 0)
+				p.logMetricDecision(logged, metricCtx, resultString(result), matches)
+				return true
+			}
+			if overflowed {
+				overflow, hasOverflow = dp, true
+			}
+		}
 		p.recordMetric(ctx, "metrics", result)
 
-		return result == policy.ResultDrop
+		if result == policy.ResultDrop {
+			p.recordBytes(ctx, "metrics", "dropped", matches, size, 0)
+			p.logMetricDecision(logged, metricCtx, "dropped", matches)
+			return true
+		}
+		if p.attributeRecords() {
+			p.recordBytes(ctx, "metrics", resultString(result), matches, size, numberDataPointSize(dp))
+		}
+		p.logMetricDecision(logged, metricCtx, resultString(result), matches)
+		return false
 	})
 }
 
-func (p *policyProcessor) processHistogramDataPoints(ctx context.Context, m pmetric.Metric, datapoints pmetric.HistogramDataPointSlice, temporality pmetric.AggregationTemporality, resource pcommon.Resource, scope pcommon.InstrumentationScope, resourceSchemaURL, scopeSchemaURL string, opts []policy.MetricOption[MetricContext]) {
+func (p *policyProcessor) processHistogramDataPoints(ctx context.Context, snapshot *policy.MetricSnapshot, m pmetric.Metric, datapoints pmetric.HistogramDataPointSlice, temporality pmetric.AggregationTemporality, resource pcommon.Resource, scope pcommon.InstrumentationScope, resourceSchemaURL, scopeSchemaURL string, opts []policy.MetricOption[MetricContext]) {
+	var resourceKey string
+	var overflow pmetric.HistogramDataPoint
+	var hasOverflow bool
 	datapoints.RemoveIf(func(dp pmetric.HistogramDataPoint) bool {
 		metricCtx := MetricContext{
 			Metric:                 m,
@@ -228,14 +656,85 @@ func (p *policyProcessor) processHistogramDataPoints(ctx context.Context, m pmet
 			ScopeSchemaURL:         scopeSchemaURL,
 		}
 
+		var size int
+		if p.attributeRecords() {
+			size = histogramDataPointSize(dp)
+		}
+
+		start := p.recordStart()
 		result := policy.EvaluateMetric(p.engine, metricCtx, opts...)
+		p.recordEvaluationDuration(ctx, "metrics", start)
+		needMatches := result != policy.ResultDrop && (p.histograms != nil || p.exemplars != nil || p.cardinality != nil)
+		logged := p.decisionLog.sample()
+		var matches policyMatches
+		if needMatches || p.attributeRecords() || logged {
+			matches = matchMetricPolicies(snapshot, metricCtx)
+		}
+		if needMatches {
+			policyIDs := matches.IDs
+			transformed := false
+			switch p.applyExemplars(policyIDs, dp.Exemplars()) {
+			case exemplarsDropped:
+				p.recordExemplarDrop(ctx, matches, metricCtx, logged, size)
+				return true
+			case exemplarsStripped:
+				transformed = true
+			}
+			overflowed := false
+			switch p.limitSeries(policyIDs, &resourceKey, resource, scope, m.Name(), temporality, dp.Attributes()) {
+			case seriesDropped:
+				p.recordResult(ctx, "metrics", resultCardinalityLimited)
+				p.recordBytes(ctx, "metrics", resultCardinalityLimited, matches, size, 0)
+				p.logMetricDecision(logged, metricCtx, resultCardinalityLimited, matches)
+				return true
+			case seriesOverflowed:
+				transformed, overflowed = true, true
+			}
+			if p.histograms != nil {
+				if rule := p.histograms.rule(policyIDs); rule != nil && rule.coarsen(dp) {
+					transformed = true
+				}
+			}
+			if transformed && result == policy.ResultKeep {
+				result = policy.ResultKeepWithTransform
+			}
+			if overflowed && hasOverflow {
+				// Datapoints whose buckets cannot be merged exactly are
+				// dropped as if the limit had no overflow.
+				if !mergeOverflowHistogram(overflow, dp) {
+					p.recordResult(ctx, "metrics", resultCardinalityLimited)
+					p.recordBytes(ctx, "metrics", resultCardinalityLimited, matches, size, 0)
+					p.logMetricDecision(logged, metricCtx, resultCardinalityLimited, matches)
+					return true
+				}
+				p.recordMetric(ctx, "metrics", result)
+				p.recordBytes(ctx, "metrics", resultString(result), matches, size, 0)
+				p.logMetricDecision(logged, metricCtx, resultString(result), matches)
+				return true
+			}
+			if overflowed {
+				overflow, hasOverflow = dp, true
+			}
+		}
 		p.recordMetric(ctx, "metrics", result)
 
-		return result == policy.ResultDrop
+		if result == policy.ResultDrop {
+			p.recordBytes(ctx, "metrics", "dropped", matches, size, 0)
+			p.logMetricDecision(logged, metricCtx, "dropped", matches)
+			return true
+		}
+		if p.attributeRecords() {
+			p.recordBytes(ctx, "metrics", resultString(result), matches, size, histogramDataPointSize(dp))
+		}
+		p.logMetricDecision(logged, metricCtx, resultString(result), matches)
+		return false
 	})
 }
 
-func (p *policyProcessor) processExponentialHistogramDataPoints(ctx context.Context, m pmetric.Metric, datapoints pmetric.ExponentialHistogramDataPointSlice, temporality pmetric.AggregationTemporality, resource pcommon.Resource, scope pcommon.InstrumentationScope, resourceSchemaURL, scopeSchemaURL string, opts []policy.MetricOption[MetricContext]) {
+func (p *policyProcessor) processExponentialHistogramDataPoints(ctx context.Context, snapshot *policy.MetricSnapshot, m pmetric.Metric, datapoints pmetric.ExponentialHistogramDataPointSlice, temporality pmetric.AggregationTemporality, resource pcommon.Resource, scope pcommon.InstrumentationScope, resourceSchemaURL, scopeSchemaURL string, opts []policy.MetricOption[MetricContext]) {
+	var resourceKey string
+	var overflow pmetric.ExponentialHistogramDataPoint
+	var hasOverflow bool
 	datapoints.RemoveIf(func(dp pmetric.ExponentialHistogramDataPoint) bool {
 		metricCtx := MetricContext{
 			Metric:                 m,
@@ -247,14 +746,83 @@ func (p *policyProcessor) processExponentialHistogramDataPoints(ctx context.Cont
 			ScopeSchemaURL:         scopeSchemaURL,
 		}
 
+		var size int
+		if p.attributeRecords() {
+			size = exponentialHistogramDataPointSize(dp)
+		}
+
+		start := p.recordStart()
 		result := policy.EvaluateMetric(p.engine, metricCtx, opts...)
+		p.recordEvaluationDuration(ctx, "metrics", start)
+		needMatches := result != policy.ResultDrop && (p.histograms != nil || p.exemplars != nil || p.cardinality != nil)
+		logged := p.decisionLog.sample()
+		var matches policyMatches
+		if needMatches || p.attributeRecords() || logged {
+			matches = matchMetricPolicies(snapshot, metricCtx)
+		}
+		if needMatches {
+			policyIDs := matches.IDs
+			transformed := false
+			switch p.applyExemplars(policyIDs, dp.Exemplars()) {
+			case exemplarsDropped:
+				p.recordExemplarDrop(ctx, matches, metricCtx, logged, size)
+				return true
+			case exemplarsStripped:
+				transformed = true
+			}
+			overflowed := false
+			switch p.limitSeries(policyIDs, &resourceKey, resource, scope, m.Name(), temporality, dp.Attributes()) {
+			case seriesDropped:
+				p.recordResult(ctx, "metrics", resultCardinalityLimited)
+				p.recordBytes(ctx, "metrics", resultCardinalityLimited, matches, size, 0)
+				p.logMetricDecision(logged, metricCtx, resultCardinalityLimited, matches)
+				return true
+			case seriesOverflowed:
+				transformed, overflowed = true, true
+			}
+			if p.histograms != nil {
+				if rule := p.histograms.rule(policyIDs); rule != nil && rule.downscale(dp) {
+					transformed = true
+				}
+			}
+			if transformed && result == policy.ResultKeep {
+				result = policy.ResultKeepWithTransform
+			}
+			if overflowed && hasOverflow {
+				// Datapoints whose buckets cannot be merged exactly are
+				// dropped as if the limit had no overflow.
+				if !mergeOverflowExponentialHistogram(overflow, dp) {
+					p.recordResult(ctx, "metrics", resultCardinalityLimited)
+					p.recordBytes(ctx, "metrics", resultCardinalityLimited, matches, size, 0)
+					p.logMetricDecision(logged, metricCtx, resultCardinalityLimited, matches)
+					return true
+				}
+				p.recordMetric(ctx, "metrics", result)
+				p.recordBytes(ctx, "metrics", resultString(result), matches, size, 0)
+				p.logMetricDecision(logged, metricCtx, resultString(result), matches)
+				return true
+			}
+			if overflowed {
+				overflow, hasOverflow = dp, true
+			}
+		}
 		p.recordMetric(ctx, "metrics", result)
 
-		return result == policy.ResultDrop
+		if result == policy.ResultDrop {
+			p.recordBytes(ctx, "metrics", "dropped", matches, size, 0)
+			p.logMetricDecision(logged, metricCtx, "dropped", matches)
+			return true
+		}
+		if p.attributeRecords() {
+			p.recordBytes(ctx, "metrics", resultString(result), matches, size, exponentialHistogramDataPointSize(dp))
+		}
+		p.logMetricDecision(logged, metricCtx, resultString(result), matches)
+		return false
 	})
 }
 
-func (p *policyProcessor) processSummaryDataPoints(ctx context.Context, m pmetric.Metric, datapoints pmetric.SummaryDataPointSlice, resource pcommon.Resource, scope pcommon.InstrumentationScope, resourceSchemaURL, scopeSchemaURL string, opts []policy.MetricOption[MetricContext]) {
+func (p *policyProcessor) processSummaryDataPoints(ctx context.Context, snapshot *policy.MetricSnapshot, m pmetric.Metric, datapoints pmetric.SummaryDataPointSlice, resource pcommon.Resource, scope pcommon.InstrumentationScope, resourceSchemaURL, scopeSchemaURL string, opts []policy.MetricOption[MetricContext]) {
+	var resourceKey string
 	datapoints.RemoveIf(func(dp pmetric.SummaryDataPoint) bool {
 		metricCtx := MetricContext{
 			Metric:                 m,
@@ -266,24 +834,90 @@ func (p *policyProcessor) processSummaryDataPoints(ctx context.Context, m pmetri
 			ScopeSchemaURL:         scopeSchemaURL,
 		}
 
+		var size int
+		if p.attributeRecords() {
+			size = summaryDataPointSize(dp)
+		}
+
+		start := p.recordStart()
 		result := policy.EvaluateMetric(p.engine, metricCtx, opts...)
+		p.recordEvaluationDuration(ctx, "metrics", start)
+		needMatches := result != policy.ResultDrop && p.cardinality != nil
+		logged := p.decisionLog.sample()
+		var matches policyMatches
+		if needMatches || p.attributeRecords() || logged {
+			matches = matchMetricPolicies(snapshot, metricCtx)
+		}
+		// Summaries are cumulative, so new series are dropped even with
+		// overflow.
+		if needMatches && p.limitSeries(matches.IDs, &resourceKey, resource, scope, m.Name(), pmetric.AggregationTemporalityCumulative, dp.Attributes()) == seriesDropped {
+			p.recordResult(ctx, "metrics", resultCardinalityLimited)
+			p.recordBytes(ctx, "metrics", resultCardinalityLimited, matches, size, 0)
+			p.logMetricDecision(logged, metricCtx, resultCardinalityLimited, matches)
+			return true
+		}
 		p.recordMetric(ctx, "metrics", result)
 
-		return result == policy.ResultDrop
+		if result == policy.ResultDrop {
+			p.recordBytes(ctx, "metrics", "dropped", matches, size, 0)
+			p.logMetricDecision(logged, metricCtx, "dropped", matches)
+			return true
+		}
+		if p.attributeRecords() {
+			p.recordBytes(ctx, "metrics", resultString(result), matches, size, summaryDataPointSize(dp))
+		}
+		p.logMetricDecision(logged, metricCtx, resultString(result), matches)
+		return false
 	})
 }
 
 func (p *policyProcessor) processLogs(ctx context.Context, ld plog.Logs) (plog.Logs, error) {
+	defer p.recordBatchDuration(ctx, "logs", ld.LogRecordCount(), time.Now())
+	ctx, batch := p.startBatchSpan(ctx, "logs", ld.LogRecordCount())
+	defer batch.end()
+
 	logOpts := LogOptions()
 
-	ld.ResourceLogs().RemoveIf(func(rl plog.ResourceLogs) bool {
+	snapshot := p.registry.LogSnapshot()
+	plan := p.logPlans.get(snapshot, logFieldLevel)
+
+	var dedup *dedupBatch
+	if p.deduplicator != nil {
+		dedup = p.deduplicator.newBatch()
+	}
+	// While a tap is open, records are evaluated one by one so each can be
+	// attributed to the policies it matched.
+	tapping := p.tapping()
+
+	removeResources(p.workers, ld.ResourceLogs(), func(rl plog.ResourceLogs) bool {
 		resource := rl.Resource()
 		resourceSchemaURL := rl.SchemaUrl()
 
+		resCtx := LogContext{Resource: resource, ResourceSchemaURL: resourceSchemaURL}
+		if d := p.decideLogContainer(snapshot, plan, levelResource, resCtx); d.action != actionEvaluate && !tapping {
+			for i := range rl.ScopeLogs().Len() {
+				sl := rl.ScopeLogs().At(i)
+				scopeCtx := LogContext{Resource: resource, Scope: sl.Scope(), ResourceSchemaURL: resourceSchemaURL, ScopeSchemaURL: sl.SchemaUrl()}
+				p.settleLogs(ctx, snapshot, d, scopeCtx, sl.LogRecords())
+			}
+			return d.action == actionDrop
+		}
+
+		var resourceKey string
+		if dedup != nil {
+			resourceKey = dedupResourceKey(resource)
+		}
+
 		rl.ScopeLogs().RemoveIf(func(sl plog.ScopeLogs) bool {
 			scope := sl.Scope()
 			scopeSchemaURL := sl.SchemaUrl()
 
+			scopeCtx := LogContext{Resource: resource, Scope: scope, ResourceSchemaURL: resourceSchemaURL, ScopeSchemaURL: scopeSchemaURL}
+			if d := p.decideLogContainer(snapshot, plan, levelScope, scopeCtx); d.action != actionEvaluate && !tapping {
+				p.settleLogs(ctx, snapshot, d, scopeCtx, sl.LogRecords())
+				return d.action == actionDrop
+			}
+
 			sl.LogRecords().RemoveIf(func(lr plog.LogRecord) bool {
 				logCtx := LogContext{
 					Record:            lr,
@@ -293,10 +927,77 @@ func (p *policyProcessor) processLogs(ctx context.Context, ld plog.Logs) (plog.L
 					ScopeSchemaURL:    scopeSchemaURL,
 				}
 
+				var size int
+				if p.attributeRecords() {
+					size = logRecordSize(lr)
+				}
+				// Match the record as received: evaluation applies the
+				// policies' transforms, which may remove matched fields.
+				perPolicy := p.rateLimiter != nil || dedup != nil || p.traceConsistentLogs
+				var matches policyMatches
+				logged := p.decisionLog.sample()
+				if perPolicy || p.attributeRecords() || tapping || logged || p.droppedLogs != nil || p.traceDecisions != nil {
+					matches = matchLogPolicies(snapshot, logCtx)
+				}
+				var capture *logCapture
+				if tapping || logged {
+					capture = p.captureLog(matches, logCtx, logged)
+				}
+
+				start := p.recordStart()
 				result := policy.EvaluateLog(p.engine, logCtx, logOpts...)
+				p.recordEvaluationDuration(ctx, "logs", start)
+				followed := false
+				// The engine does not transform records it drops, so a dropped
+				// record matching a transform policy stays dropped whatever
+				// its trace's decision.
+				canFollow := p.traceDecisions != nil && (result != policy.ResultDrop || !logTransformsMatched(snapshot, matches.IDs))
+				if canFollow {
+					result, followed = p.followTraceDecision(lr, result)
+				}
+				if perPolicy && result != policy.ResultDrop {
+					switch {
+					case p.traceConsistentLogs && !followed && !traceConsistentLogKeep(snapshot, matches.Winner, lr):
+						result = policy.ResultDrop
+					case dedup != nil && dedup.collapse(matches.IDs, logCtx, resourceKey):
+						p.recordResult(ctx, "logs", resultDeduplicated)
+						p.recordBytes(ctx, "logs", resultDeduplicated, matches, size, 0)
+						capture.publish(resultDeduplicated)
+						return true
+					case p.rateLimiter != nil && !p.rateLimiter.allow(matches.IDs, resource):
+						p.recordResult(ctx, "logs", resultRateLimited)
+						p.recordBytes(ctx, "logs", resultRateLimited, matches, size, 0)
+						capture.publish(resultRateLimited)
+						p.recordDroppedLog(matches.Winner, logCtx)
+						return true
+					}
+				}
+				// Records of an undecided trace are held once every other
+				// stage has run; their result is recorded on release.
+				if canFollow && !followed && p.nextLogs != nil && !lr.TraceID().IsEmpty() {
+					held := heldRecord{
+						result:  resultString(result),
+						matches: policyMatches{Winner: matches.Winner, WinnerKeep: matches.WinnerKeep},
+						size:    size,
+						capture: capture,
+					}
+					if p.traceDecisions.hold(logCtx, result != policy.ResultDrop, held) {
+						return true
+					}
+				}
 				p.recordMetric(ctx, "logs", result)
 
-				return result == policy.ResultDrop
+				if result == policy.ResultDrop {
+					p.recordBytes(ctx, "logs", "dropped", matches, size, 0)
+					capture.publish("dropped")
+					p.recordDroppedLog(matches.Winner, logCtx)
+					return true
+				}
+				if p.attributeRecords() {
+					p.recordBytes(ctx, "logs", resultString(result), matches, size, logRecordSize(lr))
+				}
+				capture.publish(resultString(result))
+				return false
 			})
 
 			return sl.LogRecords().Len() == 0
@@ -305,6 +1006,10 @@ func (p *policyProcessor) processLogs(ctx context.Context, ld plog.Logs) (plog.L
 		return rl.ScopeLogs().Len() == 0
 	})
 
+	if dedup != nil {
+		dedup.finish()
+	}
+
 	return ld, nil
 }
 
@@ -335,28 +1040,151 @@ func (p *policyProcessor) buildServiceMetadata() *policy.ServiceMetadata {
 }
 
 func (p *policyProcessor) recordMetric(ctx context.Context, telemetryType string, result policy.EvaluateResult) {
-	if p.telemetry == nil {
-		return
-	}
+	p.recordResult(ctx, telemetryType, resultString(result))
+}
 
-	var resultStr string
+// resultString maps an engine result to its telemetry result value.
+func resultString(result policy.EvaluateResult) string {
 	switch result {
 	case policy.ResultDrop:
-		resultStr = "dropped"
+		return "dropped"
 	case policy.ResultKeep:
-		resultStr = "kept"
+		return "kept"
 	case policy.ResultKeepWithTransform:
-		resultStr = "transformed"
+		return "transformed"
 	case policy.ResultSample:
-		resultStr = "sampled"
+		return "sampled"
 	default:
-		resultStr = "no_match"
+		return "no_match"
 	}
+}
+
+// recordResult counts a single record under the given result attribute value.
+func (p *policyProcessor) recordResult(ctx context.Context, telemetryType, resultStr string) {
+	p.recordResults(ctx, telemetryType, resultStr, 1)
+}
 
-	p.telemetry.ProcessorPolicyRecords.Add(ctx, 1,
+// recordResults counts n records under the given result attribute value.
+func (p *policyProcessor) recordResults(ctx context.Context, telemetryType, resultStr string, n int64) {
+	if n == 0 {
+		return
+	}
+	if p.tracer != nil {
+		if b := batchSpanFromContext(ctx); b != nil {
+			b.add(resultStr, n)
+		}
+	}
+	if p.telemetry == nil {
+		return
+	}
+
+	p.telemetry.ProcessorPolicyRecords.Add(ctx, n,
 		metric.WithAttributes(
 			attrTelemetryType.String(telemetryType),
 			attrResult.String(resultStr),
 		),
 	)
 }
+
+// attributeRecords reports whether records are sized and attributed to their
+// winning policy, for the byte metrics or the usage report.
+func (p *policyProcessor) attributeRecords() bool {
+	return p.bytes || p.usage != nil
+}
+
+// recordBytes counts the estimated serialized size of a record under the
+// given result and the winning policy of matches, along with the bytes saved
+// on it: size less kept, the size of what remains after processing.
+func (p *policyProcessor) recordBytes(ctx context.Context, telemetryType, resultStr string, matches policyMatches, size, kept int) {
+	p.recordSettledBytes(ctx, telemetryType, resultStr, matches, 1, size, kept)
+}
+
+// recordSettledBytes counts the size of n records settled together, as
+// recordBytes does for one, and attributes them to the winning policy in the
+// usage report.
+func (p *policyProcessor) recordSettledBytes(ctx context.Context, telemetryType, resultStr string, matches policyMatches, n, size, kept int) {
+	if n == 0 {
+		return
+	}
+	if resultStr == "dropped" {
+		resultStr = matches.dropResult()
+	}
+	if p.usage != nil {
+		p.usage.add(matches.Winner, resultStr, int64(n), size, kept)
+	}
+	if p.telemetry == nil || !p.bytes || size == 0 {
+		return
+	}
+
+	attrs := []attribute.KeyValue{
+		attrTelemetryType.String(telemetryType),
+		attrResult.String(resultStr),
+	}
+	if matches.Winner != "" {
+		attrs = append(attrs, attrTelemetryPolicyID.String(matches.Winner))
+	}
+	opt := metric.WithAttributes(attrs...)
+	p.telemetry.ProcessorPolicyBytes.Add(ctx, int64(size), opt)
+	if saved := size - kept; saved > 0 {
+		p.telemetry.ProcessorPolicyBytesSaved.Add(ctx, int64(saved), opt)
+	}
+}
+
+// logMetricDecision logs the decision for a datapoint when the decision log
+// sampled it.
+func (p *policyProcessor) logMetricDecision(logged bool, ctx MetricContext, result string, matches policyMatches) {
+	if logged {
+		p.decisionLog.log("metrics", result, matches, metricDecisionFields(ctx)...)
+	}
+}
+
+// recordBatchDuration records how long a batch of n records took to process
+// since start.
+func (p *policyProcessor) recordBatchDuration(ctx context.Context, telemetryType string, n int, start time.Time) {
+	if p.telemetry == nil {
+		return
+	}
+	p.telemetry.ProcessorPolicyEvaluationDuration.Record(ctx, time.Since(start).Seconds(),
+		metric.WithAttributes(
+			attrTelemetryType.String(telemetryType),
+			attrBatchSize.String(batchSizeBucket(n)),
+		),
+	)
+}
+
+// batchSizeBucket returns the batch_size attribute value for a batch of n
+// records.
+func batchSizeBucket(n int) string {
+	switch {
+	case n <= 10:
+		return "1-10"
+	case n <= 100:
+		return "11-100"
+	case n <= 1000:
+		return "101-1000"
+	case n <= 10000:
+		return "1001-10000"
+	default:
+		return "10001+"
+	}
+}
+
+// recordStart returns the start time for recordEvaluationDuration, or the
+// zero time when record timing is disabled.
+func (p *policyProcessor) recordStart() time.Time {
+	if !p.recordTiming {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// recordEvaluationDuration records how long a single record took to evaluate
+// since start, as returned by recordStart.
+func (p *policyProcessor) recordEvaluationDuration(ctx context.Context, telemetryType string, start time.Time) {
+	if p.telemetry == nil || start.IsZero() {
+		return
+	}
+	p.telemetry.ProcessorPolicyRecordEvaluationDuration.Record(ctx, time.Since(start).Seconds(),
+		metric.WithAttributes(attrTelemetryType.String(telemetryType)),
+	)
+}
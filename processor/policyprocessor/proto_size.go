@@ -0,0 +1,303 @@
+package policyprocessor
+
+import (
+	"math/bits"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// The functions in this file estimate the OTLP protobuf size of single
+// records without marshaling them. Each returns the size of the record as a
+// field of its parent message, tag and length prefix included, following the
+// encoding of pdata's own marshaler.
+
+// sizeVarint returns the encoded size of x as a varint.
+func sizeVarint(x uint64) int {
+	return (bits.Len64(x|1) + 6) / 7
+}
+
+// sizeZigzag returns the encoded size of x as a zigzag varint.
+func sizeZigzag(x int32) int {
+	return sizeVarint(uint64(uint32(x<<1) ^ uint32(x>>31)))
+}
+
+// sizeField returns the size of a length-delimited field with a one byte tag.
+func sizeField(l int) int {
+	return 1 + sizeVarint(uint64(l)) + l
+}
+
+// sizeString returns the size of a string field, omitted when empty.
+func sizeString(s string) int {
+	if s == "" {
+		return 0
+	}
+	return sizeField(len(s))
+}
+
+// sizeTraceID and sizeSpanID return the size of an ID field, which is
+// written even when empty.
+func sizeTraceID(id pcommon.TraceID) int {
+	if id.IsEmpty() {
+		return sizeField(0)
+	}
+	return sizeField(len(id))
+}
+
+func sizeSpanID(id pcommon.SpanID) int {
+	if id.IsEmpty() {
+		return sizeField(0)
+	}
+	return sizeField(len(id))
+}
+
+// sizeFixed64 returns the size of a fixed 64-bit field, omitted when zero.
+func sizeFixed64[T ~uint64](v T) int {
+	if v == 0 {
+		return 0
+	}
+	return 9
+}
+
+// sizeUvarint returns the size of a varint field, omitted when zero.
+func sizeUvarint[T ~uint32 | ~int32](v T) int {
+	if v == 0 {
+		return 0
+	}
+	return 1 + sizeVarint(uint64(v))
+}
+
+func sizeValue(v pcommon.Value) int {
+	switch v.Type() {
+	case pcommon.ValueTypeStr:
+		return sizeField(len(v.Str()))
+	case pcommon.ValueTypeBool:
+		return 2
+	case pcommon.ValueTypeInt:
+		return 1 + sizeVarint(uint64(v.Int()))
+	case pcommon.ValueTypeDouble:
+		return 9
+	case pcommon.ValueTypeMap:
+		return sizeField(sizeAttributes(v.Map()))
+	case pcommon.ValueTypeSlice:
+		s := v.Slice()
+		var n int
+		for i := range s.Len() {
+			n += sizeField(sizeValue(s.At(i)))
+		}
+		return sizeField(n)
+	case pcommon.ValueTypeBytes:
+		return sizeField(v.Bytes().Len())
+	default:
+		return 0
+	}
+}
+
+// sizeAttributes returns the size of the key-value pairs of m as repeated
+// fields of their parent.
+func sizeAttributes(m pcommon.Map) int {
+	var n int
+	m.Range(func(k string, v pcommon.Value) bool {
+		n += sizeField(sizeString(k) + sizeField(sizeValue(v)))
+		return true
+	})
+	return n
+}
+
+func logRecordSize(lr plog.LogRecord) int {
+	n := sizeFixed64(lr.Timestamp()) +
+		sizeFixed64(lr.ObservedTimestamp()) +
+		sizeUvarint(int32(lr.SeverityNumber())) +
+		sizeString(lr.SeverityText()) +
+		sizeField(sizeValue(lr.Body())) +
+		sizeAttributes(lr.Attributes()) +
+		sizeUvarint(lr.DroppedAttributesCount()) +
+		sizeTraceID(lr.TraceID()) +
+		sizeSpanID(lr.SpanID()) +
+		sizeString(lr.EventName())
+	if lr.Flags() != 0 {
+		n += 5
+	}
+	return sizeField(n)
+}
+
+func spanSize(span ptrace.Span) int {
+	n := sizeTraceID(span.TraceID()) +
+		sizeSpanID(span.SpanID()) +
+		sizeString(span.TraceState().AsRaw()) +
+		sizeSpanID(span.ParentSpanID()) +
+		sizeString(span.Name()) +
+		sizeUvarint(int32(span.Kind())) +
+		sizeFixed64(span.StartTimestamp()) +
+		sizeFixed64(span.EndTimestamp()) +
+		sizeAttributes(span.Attributes()) +
+		sizeUvarint(span.DroppedAttributesCount()) +
+		sizeUvarint(span.DroppedEventsCount()) +
+		sizeUvarint(span.DroppedLinksCount()) +
+		sizeField(sizeString(span.Status().Message())+sizeUvarint(int32(span.Status().Code())))
+	if span.Flags() != 0 {
+		// Field 16 takes a two byte tag.
+		n += 6
+	}
+	events := span.Events()
+	for i := range events.Len() {
+		e := events.At(i)
+		n += sizeField(sizeFixed64(e.Timestamp()) +
+			sizeString(e.Name()) +
+			sizeAttributes(e.Attributes()) +
+			sizeUvarint(e.DroppedAttributesCount()))
+	}
+	links := span.Links()
+	for i := range links.Len() {
+		l := links.At(i)
+		ln := sizeTraceID(l.TraceID()) +
+			sizeSpanID(l.SpanID()) +
+			sizeString(l.TraceState().AsRaw()) +
+			sizeAttributes(l.Attributes()) +
+			sizeUvarint(l.DroppedAttributesCount())
+		if l.Flags() != 0 {
+			ln += 5
+		}
+		n += sizeField(ln)
+	}
+	return sizeField(n)
+}
+
+func exemplarsSize(exemplars pmetric.ExemplarSlice) int {
+	var n int
+	for i := range exemplars.Len() {
+		ex := exemplars.At(i)
+		en := sizeAttributes(ex.FilteredAttributes()) +
+			sizeFixed64(ex.Timestamp()) +
+			sizeTraceID(ex.TraceID()) +
+			sizeSpanID(ex.SpanID())
+		if ex.ValueType() != pmetric.ExemplarValueTypeEmpty {
+			en += 9
+		}
+		n += sizeField(en)
+	}
+	return n
+}
+
+// sizeOptionalDouble returns the size of an optional double field.
+func sizeOptionalDouble(set bool) int {
+	if set {
+		return 9
+	}
+	return 0
+}
+
+func numberDataPointSize(dp pmetric.NumberDataPoint) int {
+	n := sizeAttributes(dp.Attributes()) +
+		sizeFixed64(dp.StartTimestamp()) +
+		sizeFixed64(dp.Timestamp()) +
+		exemplarsSize(dp.Exemplars()) +
+		sizeUvarint(uint32(dp.Flags())) +
+		sizeOptionalDouble(dp.ValueType() != pmetric.NumberDataPointValueTypeEmpty)
+	return sizeField(n)
+}
+
+func histogramDataPointSize(dp pmetric.HistogramDataPoint) int {
+	n := sizeAttributes(dp.Attributes()) +
+		sizeFixed64(dp.StartTimestamp()) +
+		sizeFixed64(dp.Timestamp()) +
+		sizeFixed64(dp.Count()) +
+		sizeOptionalDouble(dp.HasSum()) +
+		exemplarsSize(dp.Exemplars()) +
+		sizeUvarint(uint32(dp.Flags())) +
+		sizeOptionalDouble(dp.HasMin()) +
+		sizeOptionalDouble(dp.HasMax())
+	if l := dp.BucketCounts().Len(); l > 0 {
+		n += sizeField(8 * l)
+	}
+	if l := dp.ExplicitBounds().Len(); l > 0 {
+		n += sizeField(8 * l)
+	}
+	return sizeField(n)
+}
+
+func exponentialHistogramBucketsSize(b pmetric.ExponentialHistogramDataPointBuckets) int {
+	var n int
+	if b.Offset() != 0 {
+		n += 1 + sizeZigzag(b.Offset())
+	}
+	counts := b.BucketCounts()
+	if counts.Len() > 0 {
+		var l int
+		for i := range counts.Len() {
+			l += sizeVarint(counts.At(i))
+		}
+		n += sizeField(l)
+	}
+	return sizeField(n)
+}
+
+func exponentialHistogramDataPointSize(dp pmetric.ExponentialHistogramDataPoint) int {
+	n := sizeAttributes(dp.Attributes()) +
+		sizeFixed64(dp.StartTimestamp()) +
+		sizeFixed64(dp.Timestamp()) +
+		sizeFixed64(dp.Count()) +
+		sizeOptionalDouble(dp.HasSum()) +
+		sizeFixed64(dp.ZeroCount()) +
+		exponentialHistogramBucketsSize(dp.Positive()) +
+		exponentialHistogramBucketsSize(dp.Negative()) +
+		sizeUvarint(uint32(dp.Flags())) +
+		exemplarsSize(dp.Exemplars()) +
+		sizeOptionalDouble(dp.HasMin()) +
+		sizeOptionalDouble(dp.HasMax()) +
+		sizeOptionalDouble(dp.ZeroThreshold() != 0)
+	if dp.Scale() != 0 {
+		n += 1 + sizeZigzag(dp.Scale())
+	}
+	return sizeField(n)
+}
+
+func summaryDataPointSize(dp pmetric.SummaryDataPoint) int {
+	n := sizeAttributes(dp.Attributes()) +
+		sizeFixed64(dp.StartTimestamp()) +
+		sizeFixed64(dp.Timestamp()) +
+		sizeFixed64(dp.Count()) +
+		sizeOptionalDouble(dp.Sum() != 0) +
+		sizeUvarint(uint32(dp.Flags()))
+	quantiles := dp.QuantileValues()
+	for i := range quantiles.Len() {
+		q := quantiles.At(i)
+		n += sizeField(sizeOptionalDouble(q.Quantile() != 0) + sizeOptionalDouble(q.Value() != 0))
+	}
+	return sizeField(n)
+}
+
+// metricDataPointsSize returns the total size of the datapoints of m.
+func metricDataPointsSize(m pmetric.Metric) int {
+	var n int
+	switch m.Type() {
+	case pmetric.MetricTypeGauge:
+		dps := m.Gauge().DataPoints()
+		for i := range dps.Len() {
+			n += numberDataPointSize(dps.At(i))
+		}
+	case pmetric.MetricTypeSum:
+		dps := m.Sum().DataPoints()
+		for i := range dps.Len() {
+			n += numberDataPointSize(dps.At(i))
+		}
+	case pmetric.MetricTypeHistogram:
+		dps := m.Histogram().DataPoints()
+		for i := range dps.Len() {
+			n += histogramDataPointSize(dps.At(i))
+		}
+	case pmetric.MetricTypeExponentialHistogram:
+		dps := m.ExponentialHistogram().DataPoints()
+		for i := range dps.Len() {
+			n += exponentialHistogramDataPointSize(dps.At(i))
+		}
+	case pmetric.MetricTypeSummary:
+		dps := m.Summary().DataPoints()
+		for i := range dps.Len() {
+			n += summaryDataPointSize(dps.At(i))
+		}
+	}
+	return n
+}
@@ -0,0 +1,207 @@
+package policyprocessor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// sizePadding is long enough that the length prefixes of the enclosing
+// resource and scope take two bytes with or without the record under test, so
+// adding the record grows the marshaled batch by exactly its own size.
+var sizePadding = strings.Repeat("x", 200)
+
+func putTestAttributes(m pcommon.Map) {
+	m.PutStr("service.name", "checkout")
+	m.PutInt("http.status_code", 503)
+	m.PutInt("negative", -1)
+	m.PutDouble("ratio", 0.25)
+	m.PutBool("retry", true)
+	m.PutEmptyBytes("raw").FromRaw([]byte{1, 2, 3})
+	m.PutEmpty("empty")
+	m.PutStr("blank", "")
+	nested := m.PutEmptyMap("nested")
+	nested.PutStr("key", "value")
+	nested.PutEmptySlice("list").FromRaw([]any{"a", int64(1), false, nil})
+}
+
+func TestLogRecordSize(t *testing.T) {
+	for name, fill := range map[string]func(plog.LogRecord){
+		"empty": func(plog.LogRecord) {},
+		"full": func(lr plog.LogRecord) {
+			lr.SetTimestamp(1700000000000000000)
+			lr.SetObservedTimestamp(1700000000000000001)
+			lr.SetSeverityNumber(plog.SeverityNumberError)
+			lr.SetSeverityText("ERROR")
+			lr.Body().SetStr(strings.Repeat("stack frame\n", 1000))
+			putTestAttributes(lr.Attributes())
+			lr.SetDroppedAttributesCount(300)
+			lr.SetTraceID(testTraceID(1))
+			lr.SetSpanID(testSpanID(2))
+			lr.SetFlags(plog.DefaultLogRecordFlags.WithIsSampled(true))
+			lr.SetEventName("exception")
+		},
+		"map body": func(lr plog.LogRecord) {
+			putTestAttributes(lr.Body().SetEmptyMap())
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ld := plog.NewLogs()
+			rl := ld.ResourceLogs().AppendEmpty()
+			rl.Resource().Attributes().PutStr("padding", sizePadding)
+			sl := rl.ScopeLogs().AppendEmpty()
+			sl.Scope().SetName(sizePadding)
+			before := (&plog.ProtoMarshaler{}).LogsSize(ld)
+
+			lr := sl.LogRecords().AppendEmpty()
+			fill(lr)
+			assert.Equal(t, (&plog.ProtoMarshaler{}).LogsSize(ld)-before, logRecordSize(lr))
+		})
+	}
+}
+
+func TestSpanSize(t *testing.T) {
+	for name, fill := range map[string]func(ptrace.Span){
+		"empty": func(ptrace.Span) {},
+		"full": func(span ptrace.Span) {
+			span.SetTraceID(testTraceID(1))
+			span.SetSpanID(testSpanID(2))
+			span.SetParentSpanID(testSpanID(3))
+			span.TraceState().FromRaw("ot=th:8")
+			span.SetFlags(1)
+			span.SetName("GET /checkout")
+			span.SetKind(ptrace.SpanKindServer)
+			span.SetStartTimestamp(1700000000000000000)
+			span.SetEndTimestamp(1700000000500000000)
+			putTestAttributes(span.Attributes())
+			span.SetDroppedAttributesCount(1)
+			span.SetDroppedEventsCount(2)
+			span.SetDroppedLinksCount(3)
+			span.Status().SetCode(ptrace.StatusCodeError)
+			span.Status().SetMessage("upstream timeout")
+			event := span.Events().AppendEmpty()
+			event.SetName("exception")
+			event.SetTimestamp(1700000000100000000)
+			putTestAttributes(event.Attributes())
+			span.Events().AppendEmpty()
+			link := span.Links().AppendEmpty()
+			link.SetTraceID(testTraceID(4))
+			link.SetSpanID(testSpanID(5))
+			link.TraceState().FromRaw("vendor=1")
+			link.SetFlags(1)
+			putTestAttributes(link.Attributes())
+			span.Links().AppendEmpty()
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			td := ptrace.NewTraces()
+			rs := td.ResourceSpans().AppendEmpty()
+			rs.Resource().Attributes().PutStr("padding", sizePadding)
+			ss := rs.ScopeSpans().AppendEmpty()
+			ss.Scope().SetName(sizePadding)
+			before := (&ptrace.ProtoMarshaler{}).TracesSize(td)
+
+			span := ss.Spans().AppendEmpty()
+			fill(span)
+			assert.Equal(t, (&ptrace.ProtoMarshaler{}).TracesSize(td)-before, spanSize(span))
+		})
+	}
+}
+
+func TestMetricDataPointsSize(t *testing.T) {
+	for name, fill := range map[string]func(pmetric.Metric){
+		"gauge": func(m pmetric.Metric) {
+			dps := m.SetEmptyGauge().DataPoints()
+			dps.AppendEmpty()
+			dp := dps.AppendEmpty()
+			dp.SetStartTimestamp(1700000000000000000)
+			dp.SetTimestamp(1700000060000000000)
+			dp.SetDoubleValue(0.5)
+			putTestAttributes(dp.Attributes())
+			dp.SetFlags(pmetric.DefaultDataPointFlags.WithNoRecordedValue(true))
+			appendTestExemplars(dp.Exemplars())
+			dp.Exemplars().AppendEmpty()
+		},
+		"sum": func(m pmetric.Metric) {
+			dp := m.SetEmptySum().DataPoints().AppendEmpty()
+			dp.SetIntValue(-42)
+		},
+		"histogram": func(m pmetric.Metric) {
+			dps := m.SetEmptyHistogram().DataPoints()
+			dps.AppendEmpty()
+			dp := dps.AppendEmpty()
+			dp.SetCount(10)
+			dp.SetSum(12.5)
+			dp.SetMin(0.1)
+			dp.SetMax(5)
+			dp.ExplicitBounds().FromRaw([]float64{1, 2, 5})
+			dp.BucketCounts().FromRaw([]uint64{4, 3, 2, 1})
+			appendTestExemplars(dp.Exemplars())
+		},
+		"exponential histogram": func(m pmetric.Metric) {
+			dps := m.SetEmptyExponentialHistogram().DataPoints()
+			dps.AppendEmpty()
+			dp := dps.AppendEmpty()
+			dp.SetScale(-3)
+			dp.SetCount(1000)
+			dp.SetSum(1)
+			dp.SetZeroCount(7)
+			dp.SetZeroThreshold(0.001)
+			dp.Positive().SetOffset(-5)
+			dp.Positive().BucketCounts().FromRaw([]uint64{0, 1, 300, 70000})
+			dp.Negative().SetOffset(2)
+			dp.Negative().BucketCounts().FromRaw([]uint64{1})
+		},
+		"summary": func(m pmetric.Metric) {
+			dps := m.SetEmptySummary().DataPoints()
+			dps.AppendEmpty()
+			dp := dps.AppendEmpty()
+			dp.SetCount(3)
+			dp.SetSum(4.5)
+			dp.QuantileValues().AppendEmpty()
+			q := dp.QuantileValues().AppendEmpty()
+			q.SetQuantile(0.99)
+			q.SetValue(2)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			md := pmetric.NewMetrics()
+			rm := md.ResourceMetrics().AppendEmpty()
+			rm.Resource().Attributes().PutStr("padding", sizePadding)
+			sm := rm.ScopeMetrics().AppendEmpty()
+			sm.Scope().SetName(sizePadding)
+			m := sm.Metrics().AppendEmpty()
+			m.SetName(sizePadding)
+			fill(m)
+			withPoints := (&pmetric.ProtoMarshaler{}).MetricsSize(md)
+
+			size := metricDataPointsSize(m)
+			removeAllDataPoints(m)
+			// The datapoints are the only content of the metric's data
+			// message, whose length prefix grows with them.
+			grown := size + sizeVarint(uint64(size)) - 1
+			assert.Equal(t, withPoints-(&pmetric.ProtoMarshaler{}).MetricsSize(md), grown)
+		})
+	}
+}
+
+// removeAllDataPoints empties the datapoints of m, keeping its type.
+func removeAllDataPoints(m pmetric.Metric) {
+	switch m.Type() {
+	case pmetric.MetricTypeGauge:
+		m.Gauge().DataPoints().RemoveIf(func(pmetric.NumberDataPoint) bool { return true })
+	case pmetric.MetricTypeSum:
+		m.Sum().DataPoints().RemoveIf(func(pmetric.NumberDataPoint) bool { return true })
+	case pmetric.MetricTypeHistogram:
+		m.Histogram().DataPoints().RemoveIf(func(pmetric.HistogramDataPoint) bool { return true })
+	case pmetric.MetricTypeExponentialHistogram:
+		m.ExponentialHistogram().DataPoints().RemoveIf(func(pmetric.ExponentialHistogramDataPoint) bool { return true })
+	case pmetric.MetricTypeSummary:
+		m.Summary().DataPoints().RemoveIf(func(pmetric.SummaryDataPoint) bool { return true })
+	}
+}
@@ -0,0 +1,600 @@
+package policyprocessor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/usetero/policy-go/policy"
+	policyv1 "github.com/usetero/policy-go/proto/tero/policy/v1"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	attrProviderID = attribute.Key("provider_id")
+	attrPolicyHash = attribute.Key("policy_hash")
+)
+
+// Indexes of the per-signal policy counts of a provider.
+const (
+	signalLogs = iota
+	signalMetrics
+	signalTraces
+	signalCount
+)
+
+var signalTelemetryTypes = [signalCount]string{"logs", "metrics", "traces"}
+
+// Indexes of the transform operation counts of a signal, in the order the
+// engine applies the operations.
+const (
+	transformRemove = iota
+	transformRedact
+	transformRename
+	transformAdd
+	transformCount
+)
+
+var (
+	attrOperation = attribute.Key("operation")
+	attrOutcome   = attribute.Key("outcome")
+
+	transformOperations = [transformCount]string{"remove", "redact", "rename", "add"}
+)
+
+// providerInventory tracks the sync state and loaded policies of each
+// configured provider for telemetry and the debug pages.
+type providerInventory struct {
+	mu        sync.Mutex
+	providers []*providerState
+
+	// syncMu serializes policy updates so a recompile error can be
+	// attributed to the provider whose update caused it.
+	syncMu       sync.Mutex
+	recompileErr error
+
+	// lastCompileErr is the latest failed recompile, kept after later
+	// recompiles succeed.
+	lastCompileErr     string
+	lastCompileErrTime time.Time
+
+	// statsMu guards totals, the policy engine's hit counts accumulated
+	// across the resets done when its stats are collected, and the
+	// transform counts of each signal. pending holds the stats collected
+	// since a provider last collected them, for the next provider to.
+	statsMu    sync.Mutex
+	totals     map[string]policyTotals
+	transforms [signalCount][transformCount]policyTotals
+	pending    map[string]policy.PolicyStatsSnapshot
+
+	now func() time.Time
+}
+
+// providerState is the sync state of a single provider.
+type providerState struct {
+	id       string
+	lastSync time.Time
+	hash     string
+	policies [signalCount]int
+	entries  []providerPolicy
+
+	syncErrors    int64
+	compileErrors int64
+	lastErr       string
+	lastErrTime   time.Time
+}
+
+// providerPolicy is a policy as last delivered by a provider.
+type providerPolicy struct {
+	id      string
+	name    string
+	signal  int
+	enabled bool
+	failed  bool
+	source  *policyv1.Policy
+}
+
+// policyTotals are the engine's hit counts of a policy or transform
+// operation.
+type policyTotals struct {
+	hits   uint64
+	misses uint64
+}
+
+func newProviderInventory() *providerInventory {
+	return &providerInventory{
+		now:     time.Now,
+		totals:  make(map[string]policyTotals),
+		pending: make(map[string]policy.PolicyStatsSnapshot),
+	}
+}
+
+func (inv *providerInventory) add(id string) *providerState {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	state := &providerState{id: id}
+	inv.providers = append(inv.providers, state)
+	return state
+}
+
+// synced records a successful sync of state.
+func (inv *providerInventory) synced(state *providerState) {
+	inv.mu.Lock()
+	state.lastSync = inv.now()
+	inv.mu.Unlock()
+}
+
+// setRecompileErr records the result of the latest recompile.
+func (inv *providerInventory) setRecompileErr(err error) {
+	inv.mu.Lock()
+	inv.recompileErr = err
+	if err != nil {
+		inv.lastCompileErr = err.Error()
+		inv.lastCompileErrTime = inv.now()
+	}
+	inv.mu.Unlock()
+}
+
+// collect calls collector, which resets the engine's counts, and adds the
+// counts it returns to the totals and to the stats pending for providers.
+// Pending stats of policies no longer loaded are dropped.
+func (inv *providerInventory) collect(collector policy.StatsCollector) {
+	signals := inv.policySignals()
+	inv.statsMu.Lock()
+	defer inv.statsMu.Unlock()
+	snapshots := collector()
+	loaded := make(map[string]bool, len(snapshots))
+	for _, s := range snapshots {
+		loaded[s.PolicyID] = true
+		t := inv.totals[s.PolicyID]
+		t.hits += s.MatchHits
+		t.misses += s.MatchMisses
+		inv.totals[s.PolicyID] = t
+		if signal, ok := signals[s.PolicyID]; ok {
+			ops := &inv.transforms[signal]
+			ops[transformRemove].add(s.RemoveHits, s.RemoveMisses)
+			ops[transformRedact].add(s.RedactHits, s.RedactMisses)
+			ops[transformRename].add(s.RenameHits, s.RenameMisses)
+			ops[transformAdd].add(s.AddHits, s.AddMisses)
+		}
+		inv.pending[s.PolicyID] = mergeStats(inv.pending[s.PolicyID], s)
+	}
+	for id := range inv.pending {
+		if !loaded[id] {
+			delete(inv.pending, id)
+		}
+	}
+}
+
+// collectStats collects the engine's counts for a provider, returning those
+// collected since a provider last did.
+func (inv *providerInventory) collectStats(collector policy.StatsCollector) []policy.PolicyStatsSnapshot {
+	inv.collect(collector)
+	inv.statsMu.Lock()
+	defer inv.statsMu.Unlock()
+	snapshots := make([]policy.PolicyStatsSnapshot, 0, len(inv.pending))
+	for _, s := range inv.pending {
+		snapshots = append(snapshots, s)
+	}
+	clear(inv.pending)
+	return snapshots
+}
+
+func (t *policyTotals) add(hits, misses uint64) {
+	t.hits += hits
+	t.misses += misses
+}
+
+// mergeStats adds the counts of s to those of pending, keeping the compile
+// errors of s, the latest.
+func mergeStats(pending, s policy.PolicyStatsSnapshot) policy.PolicyStatsSnapshot {
+	s.MatchHits += pending.MatchHits
+	s.MatchMisses += pending.MatchMisses
+	s.RemoveHits += pending.RemoveHits
+	s.RemoveMisses += pending.RemoveMisses
+	s.RedactHits += pending.RedactHits
+	s.RedactMisses += pending.RedactMisses
+	s.RenameHits += pending.RenameHits
+	s.RenameMisses += pending.RenameMisses
+	s.AddHits += pending.AddHits
+	s.AddMisses += pending.AddMisses
+	return s
+}
+
+// policySignals returns the signal of each active policy.
+func (inv *providerInventory) policySignals() map[string]int {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	signals := make(map[string]int)
+	for _, state := range inv.providers {
+		for _, entry := range state.entries {
+			if entry.enabled && !entry.failed && entry.signal >= 0 {
+				signals[entry.id] = entry.signal
+			}
+		}
+	}
+	return signals
+}
+
+// observeTransforms returns a callback that collects the engine's counts
+// with collector and reports the transform operations applied per signal,
+// operation and outcome since start.
+func (inv *providerInventory) observeTransforms(collector policy.StatsCollector) metric.Int64Callback {
+	return func(_ context.Context, observer metric.Int64Observer) error {
+		inv.collect(collector)
+		inv.statsMu.Lock()
+		defer inv.statsMu.Unlock()
+		for signal, ops := range inv.transforms {
+			for op, t := range ops {
+				for outcome, n := range map[string]uint64{"hit": t.hits, "miss": t.misses} {
+					if n == 0 {
+						continue
+					}
+					observer.Observe(int64(n), metric.WithAttributes(
+						attrTelemetryType.String(signalTelemetryTypes[signal]),
+						attrOperation.String(transformOperations[op]),
+						attrOutcome.String(outcome),
+					))
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// policyTotals returns the hit counts of the policy with id since start,
+// given its live stats from the current snapshot.
+func (inv *providerInventory) policyTotals(id string, stats *policy.PolicyStats) policyTotals {
+	inv.statsMu.Lock()
+	defer inv.statsMu.Unlock()
+	t := inv.totals[id]
+	if stats != nil {
+		t.hits += stats.MatchHits.Load()
+		t.misses += stats.MatchMisses.Load()
+	}
+	return t
+}
+
+// lookupPolicy returns the policy with id as last delivered by any
+// provider, or nil when no provider delivered it.
+func (inv *providerInventory) lookupPolicy(id string) *policyv1.Policy {
+	if inv == nil {
+		return nil
+	}
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	for _, state := range inv.providers {
+		for _, entry := range state.entries {
+			if entry.id == id {
+				return entry.source
+			}
+		}
+	}
+	return nil
+}
+
+func (inv *providerInventory) lastRecompileErr() error {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	return inv.recompileErr
+}
+
+// observePolicies reports the number of active policies per signal of each
+// provider, along with the hash of the policies it last delivered.
+func (inv *providerInventory) observePolicies(_ context.Context, observer metric.Int64Observer) error {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	for _, state := range inv.providers {
+		if state.hash == "" {
+			continue
+		}
+		for signal, n := range state.policies {
+			observer.Observe(int64(n), metric.WithAttributes(
+				attrProviderID.String(state.id),
+				attrTelemetryType.String(signalTelemetryTypes[signal]),
+				attrPolicyHash.String(state.hash),
+			))
+		}
+	}
+	return nil
+}
+
+// observeLastSync reports the time of the last successful sync of each
+// provider as seconds since the Unix epoch.
+func (inv *providerInventory) observeLastSync(_ context.Context, observer metric.Int64Observer) error {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	for _, state := range inv.providers {
+		if state.lastSync.IsZero() {
+			continue
+		}
+		observer.Observe(state.lastSync.Unix(), metric.WithAttributes(attrProviderID.String(state.id)))
+	}
+	return nil
+}
+
+// policiesHash returns a short hash of the content of policies.
+func policiesHash(policies []*policyv1.Policy) string {
+	h := sha256.New()
+	opts := proto.MarshalOptions{Deterministic: true}
+	for _, pol := range policies {
+		b, err := opts.Marshal(pol)
+		if err != nil {
+			continue
+		}
+		// Length prefixes keep the concatenation unambiguous.
+		fmt.Fprintf(h, "%d:", len(b))
+		h.Write(b)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// policySignal returns the signal index of the target of pol, or -1 when it
+// has none.
+func policySignal(pol *policyv1.Policy) int {
+	switch {
+	case pol.GetLog() != nil:
+		return signalLogs
+	case pol.GetMetric() != nil:
+		return signalMetrics
+	case pol.GetTrace() != nil:
+		return signalTraces
+	default:
+		return -1
+	}
+}
+
+// trackedProvider wraps a provider to record each policy update it delivers
+// to the registry.
+type trackedProvider struct {
+	policy.PolicyProvider
+	p     *policyProcessor
+	state *providerState
+}
+
+func (t *trackedProvider) Subscribe(callback policy.PolicyCallback) error {
+	return t.PolicyProvider.Subscribe(func(policies []*policyv1.Policy) {
+		t.p.providerUpdate(t.state, policies, callback)
+	})
+}
+
+// SetStatsCollector passes the provider a collector that also accumulates
+// the collected counts in the inventory.
+func (t *trackedProvider) SetStatsCollector(collector policy.StatsCollector) {
+	t.PolicyProvider.SetStatsCollector(func() []policy.PolicyStatsSnapshot {
+		return t.p.inventory.collectStats(collector)
+	})
+}
+
+// Stop stops the wrapped provider if it supports stopping.
+func (t *trackedProvider) Stop() {
+	if stopper, ok := t.PolicyProvider.(interface{ Stop() }); ok {
+		stopper.Stop()
+	}
+}
+
+// supportedPolicies returns policies without those the processor cannot
+// apply as configured, logging each one left out. Those are reported as
+// failed to compile.
+func (p *policyProcessor) supportedPolicies(state *providerState, policies []*policyv1.Policy) []*policyv1.Policy {
+	supported := make([]*policyv1.Policy, 0, len(policies))
+	for _, pol := range policies {
+		reason := p.rateLimiter.conflict(pol)
+		if reason == "" && p.tail != nil {
+			reason = tailUnsupported(pol)
+		}
+		if reason != "" {
+			p.logger.Warn("Policy not supported",
+				zap.String("provider_id", state.id),
+				zap.String("policy_id", pol.GetId()),
+				zap.String("reason", reason),
+			)
+			continue
+		}
+		supported = append(supported, pol)
+	}
+	return supported
+}
+
+// providerUpdate forwards policies to the registry through callback, then
+// records the policies of state that are active and counts those that failed
+// to compile.
+func (p *policyProcessor) providerUpdate(state *providerState, policies []*policyv1.Policy, callback policy.PolicyCallback) {
+	inv := p.inventory
+	inv.syncMu.Lock()
+	defer inv.syncMu.Unlock()
+
+	start := time.Now()
+	callback(p.supportedPolicies(state, policies))
+	recompileErr := inv.lastRecompileErr()
+
+	var (
+		active  [signalCount]int
+		failed  []string
+		entries = make([]providerPolicy, 0, len(policies))
+	)
+	for _, pol := range policies {
+		entry := providerPolicy{
+			id:      pol.GetId(),
+			name:    pol.GetName(),
+			signal:  policySignal(pol),
+			enabled: pol.GetEnabled(),
+			source:  pol,
+		}
+		if entry.enabled && entry.signal >= 0 {
+			if recompileErr == nil && p.policyCompiled(entry.signal, entry.id) {
+				active[entry.signal]++
+			} else {
+				entry.failed = true
+				failed = append(failed, entry.id)
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	inv.mu.Lock()
+	state.lastSync = inv.now()
+	state.hash = policiesHash(policies)
+	state.policies = active
+	state.entries = entries
+	state.compileErrors += int64(len(failed))
+	inv.mu.Unlock()
+
+	p.traceRecompile(state.id, start, recompileErr, failed)
+	if len(failed) == 0 {
+		return
+	}
+	p.logger.Warn("Policies failed to compile",
+		zap.String("provider_id", state.id),
+		zap.Strings("policy_ids", failed),
+	)
+	if p.telemetry != nil {
+		p.telemetry.ProcessorPolicyProviderCompileErrors.Add(context.Background(), int64(len(failed)),
+			metric.WithAttributes(attrProviderID.String(state.id)))
+	}
+}
+
+// policyCompiled reports whether the policy with id is in the current
+// snapshot of signal.
+func (p *policyProcessor) policyCompiled(signal int, id string) bool {
+	var ok bool
+	switch signal {
+	case signalLogs:
+		_, ok = p.registry.LogSnapshot().GetPolicy(id)
+	case signalMetrics:
+		_, ok = p.registry.MetricSnapshot().GetPolicy(id)
+	case signalTraces:
+		_, ok = p.registry.TraceSnapshot().GetPolicy(id)
+	}
+	return ok
+}
+
+// providerSyncError logs and counts a failed sync of state.
+func (p *policyProcessor) providerSyncError(state *providerState, err error) {
+	p.logger.Error("Policy provider error", zap.String("provider_id", state.id), zap.Error(err))
+	inv := p.inventory
+	inv.mu.Lock()
+	state.syncErrors++
+	state.lastErr = err.Error()
+	state.lastErrTime = inv.now()
+	inv.mu.Unlock()
+	if p.telemetry != nil {
+		p.telemetry.ProcessorPolicyProviderSyncErrors.Add(context.Background(), 1,
+			metric.WithAttributes(attrProviderID.String(state.id)))
+	}
+}
+
+// loadProviders creates the configured providers and registers them with
+// the registry. It mirrors policy.ConfigLoader, wrapping each provider so
+// its syncs are recorded in the inventory.
+func (p *policyProcessor) loadProviders(metadata *policy.ServiceMetadata) ([]policy.LoadedProvider, error) {
+	for _, pc := range p.config.Providers {
+		if pc.Type == "http" || pc.Type == "grpc" {
+			if metadata == nil {
+				return nil, fmt.Errorf("service metadata is required for %s providers", pc.Type)
+			}
+			if err := metadata.Validate(); err != nil {
+				return nil, fmt.Errorf("invalid service metadata: %w", err)
+			}
+			break
+		}
+	}
+
+	loaded := make([]policy.LoadedProvider, 0, len(p.config.Providers))
+	for i, pc := range p.config.Providers {
+		state := p.inventory.add(pc.ID)
+		provider, err := newProvider(pc, metadata,
+			func(err error) { p.providerSyncError(state, err) },
+			func() { p.inventory.synced(state) },
+		)
+		if err != nil {
+			policy.UnregisterAll(loaded)
+			return nil, fmt.Errorf("provider %d (%s): %w", i, pc.ID, err)
+		}
+		tracked := &trackedProvider{PolicyProvider: provider, p: p, state: state}
+
+		handle, err := p.registry.Register(tracked)
+		if err != nil {
+			tracked.Stop()
+			policy.UnregisterAll(loaded)
+			return nil, fmt.Errorf("provider %d (%s): failed to register: %w", i, pc.ID, err)
+		}
+		loaded = append(loaded, policy.LoadedProvider{
+			ID:       pc.ID,
+			Handle:   handle,
+			Provider: tracked,
+		})
+	}
+	return loaded, nil
+}
+
+// newProvider creates the provider for pc with the same options
+// policy.ConfigLoader would use, plus onError and onSync callbacks.
+func newProvider(pc policy.ProviderConfig, metadata *policy.ServiceMetadata, onError func(error), onSync func()) (policy.PolicyProvider, error) {
+	var headers map[string]string
+	if len(pc.Headers) > 0 {
+		headers = make(map[string]string, len(pc.Headers))
+		for _, h := range pc.Headers {
+			headers[h.Name] = h.Value
+		}
+	}
+	pollInterval := pc.PollInterval()
+
+	switch pc.Type {
+	case "file":
+		opts := []policy.FileProviderOption{
+			policy.WithOnError(onError),
+			policy.WithOnReload(onSync),
+		}
+		if pollInterval > 0 {
+			opts = append(opts, policy.WithPollInterval(pollInterval))
+		}
+		return policy.NewFileProvider(pc.Path, opts...), nil
+	case "http":
+		opts := []policy.HttpProviderOption{
+			policy.WithHTTPOnError(onError),
+			policy.WithHTTPOnSync(onSync),
+		}
+		if pollInterval > 0 {
+			opts = append(opts, policy.WithHTTPPollInterval(pollInterval))
+		}
+		if headers != nil {
+			opts = append(opts, policy.WithHeaders(headers))
+		}
+		switch pc.ContentType {
+		case "":
+		case "json", "application/json":
+			opts = append(opts, policy.WithContentType(policy.ContentTypeJSON))
+		default:
+			opts = append(opts, policy.WithContentType(policy.ContentTypeProtobuf))
+		}
+		if metadata != nil {
+			opts = append(opts, policy.WithServiceMetadata(metadata))
+		}
+		return policy.NewHttpProvider(pc.URL, opts...), nil
+	case "grpc":
+		opts := []policy.GrpcProviderOption{
+			policy.WithGrpcOnError(onError),
+			policy.WithGrpcOnSync(onSync),
+			policy.WithGrpcInsecure(),
+		}
+		if pollInterval > 0 {
+			opts = append(opts, policy.WithGrpcPollInterval(pollInterval))
+		}
+		if headers != nil {
+			opts = append(opts, policy.WithGrpcHeaders(headers))
+		}
+		if metadata != nil {
+			opts = append(opts, policy.WithGrpcServiceMetadata(metadata))
+		}
+		return policy.NewGrpcProvider(pc.URL, opts...), nil
+	default:
+		return nil, fmt.Errorf("unknown provider type: %s", pc.Type)
+	}
+}
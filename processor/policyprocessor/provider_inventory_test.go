@@ -0,0 +1,195 @@
+package policyprocessor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usetero/policy-go/policy"
+	policyv1 "github.com/usetero/policy-go/proto/tero/policy/v1"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata/metricdatatest"
+	"go.uber.org/zap"
+
+	"github.com/usetero/tero-collector-distro/processor/policyprocessor/internal/metadatatest"
+)
+
+const inventoryTestPolicies = `{
+  "policies": [
+    {
+      "id": "drop-debug",
+      "name": "Drop debug logs",
+      "log": {
+        "match": [{"log_field": "severity_text", "regex": "DEBUG"}],
+        "keep": "none"
+      }
+    },
+    {
+      "id": "drop-promhttp",
+      "name": "Drop promhttp metrics",
+      "metric": {
+        "match": [{"metric_field": "name", "regex": "^promhttp.*$"}],
+        "keep": false
+      }
+    },
+    {
+      "id": "disabled",
+      "name": "Disabled",
+      "enabled": false,
+      "log": {
+        "match": [{"log_field": "severity_text", "regex": "WARN"}],
+        "keep": "none"
+      }
+    }
+  ]
+}`
+
+func TestProviderInventoryTelemetry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies.json")
+	require.NoError(t, os.WriteFile(path, []byte(inventoryTestPolicies), 0o600))
+	pollInterval := 1
+
+	p := newPolicyProcessor(zap.NewNop(), &Config{
+		Providers: []policy.ProviderConfig{{
+			Type:             "file",
+			ID:               "local",
+			Path:             path,
+			PollIntervalSecs: &pollInterval,
+		}},
+	}, nil, pcommon.NewResource())
+	tel := withTelemetry(t, p)
+	require.NoError(t, p.start(context.Background(), nil))
+	defer func() { require.NoError(t, p.shutdown(context.Background())) }()
+
+	hash := p.inventory.providers[0].hash
+	require.NotEmpty(t, hash)
+	policies := func(telemetryType string, n int64) metricdata.DataPoint[int64] {
+		return metricdata.DataPoint[int64]{
+			Attributes: attribute.NewSet(
+				attrProviderID.String("local"),
+				attrTelemetryType.String(telemetryType),
+				attrPolicyHash.String(hash),
+			),
+			Value: n,
+		}
+	}
+	metadatatest.AssertEqualProcessorPolicyProviderPolicies(t, tel, []metricdata.DataPoint[int64]{
+		policies("logs", 1),
+		policies("metrics", 1),
+		policies("traces", 0),
+	}, metricdatatest.IgnoreTimestamp())
+
+	m, err := tel.GetMetric("otelcol_processor_policy_provider_last_sync")
+	require.NoError(t, err)
+	dps := m.Data.(metricdata.Gauge[int64]).DataPoints
+	require.Len(t, dps, 1)
+	assert.InDelta(t, time.Now().Unix(), dps[0].Value, 5)
+
+	require.NoError(t, os.Remove(path))
+	require.Eventually(t, func() bool {
+		m, err := tel.GetMetric("otelcol_processor_policy_provider_sync_errors")
+		return err == nil && m.Data.(metricdata.Sum[int64]).DataPoints[0].Value > 0
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestProviderInventoryCompileErrors(t *testing.T) {
+	p := createTestLogProcessor(t, nil)
+	tel := withTelemetry(t, p)
+	p.inventory = newProviderInventory()
+	p.registry.SetOnRecompile(p.inventory.setRecompileErr)
+
+	state := p.inventory.add("static")
+	_, err := p.registry.Register(&trackedProvider{
+		PolicyProvider: &staticLogProvider{policies: []*policyv1.Policy{
+			logPolicy("drop-debug", "none", severityMatcher("DEBUG")),
+			logPolicy("bad-keep", "sometimes", severityMatcher("INFO")),
+		}},
+		p:     p,
+		state: state,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, [signalCount]int{signalLogs: 1}, state.policies)
+	metadatatest.AssertEqualProcessorPolicyProviderCompileErrors(t, tel, []metricdata.DataPoint[int64]{{
+		Attributes: attribute.NewSet(attrProviderID.String("static")),
+		Value:      1,
+	}}, metricdatatest.IgnoreTimestamp())
+}
+
+func TestProviderInventoryTransforms(t *testing.T) {
+	renameUser := logPolicy("rename-user", "all", severityMatcher("WARN"))
+	renameUser.GetLog().Transform = &policyv1.LogTransform{
+		Rename: []*policyv1.LogRename{{
+			From: &policyv1.LogRename_FromLogAttribute{FromLogAttribute: &policyv1.AttributePath{Path: []string{"username"}}},
+			To:   "user",
+		}},
+	}
+	p := createTestLogProcessor(t, nil)
+	tel := withTelemetry(t, p)
+	p.inventory = newProviderInventory()
+	_, err := p.registry.Register(&trackedProvider{
+		PolicyProvider: &staticLogProvider{policies: []*policyv1.Policy{removeKeyPolicy(), renameUser}},
+		p:              p,
+		state:          p.inventory.add("static"),
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.telemetry.RegisterProcessorPolicyTransformsCallback(p.inventory.observeTransforms(p.registry.CollectStats)))
+
+	logs := plog.NewLogs()
+	records := logs.ResourceLogs().AppendEmpty().ScopeLogs().AppendEmpty().LogRecords()
+	withKey := records.AppendEmpty()
+	withKey.SetSeverityText("INFO")
+	withKey.Attributes().PutStr("api_key", "secret")
+	records.AppendEmpty().SetSeverityText("INFO")
+	// The renamed attribute no longer exists.
+	records.AppendEmpty().SetSeverityText("WARN")
+	_, err = p.processLogs(context.Background(), logs)
+	require.NoError(t, err)
+
+	transforms := func(operation, outcome string, n int64) metricdata.DataPoint[int64] {
+		return metricdata.DataPoint[int64]{
+			Attributes: attribute.NewSet(
+				attrTelemetryType.String("logs"),
+				attrOperation.String(operation),
+				attrOutcome.String(outcome),
+			),
+			Value: n,
+		}
+	}
+	metadatatest.AssertEqualProcessorPolicyTransforms(t, tel, []metricdata.DataPoint[int64]{
+		transforms("remove", "hit", 1),
+		transforms("remove", "miss", 1),
+		transforms("rename", "miss", 1),
+	}, metricdatatest.IgnoreTimestamp())
+
+	// Counts collected for telemetry are still passed to the next provider
+	// to collect stats, and only to it.
+	byID := make(map[string]policy.PolicyStatsSnapshot)
+	for _, s := range p.inventory.collectStats(p.registry.CollectStats) {
+		byID[s.PolicyID] = s
+	}
+	assert.Equal(t, uint64(2), byID["remove-key"].MatchHits)
+	assert.Equal(t, uint64(1), byID["remove-key"].RemoveHits)
+	assert.Equal(t, uint64(1), byID["remove-key"].RemoveMisses)
+	assert.Equal(t, uint64(1), byID["rename-user"].RenameMisses)
+	for _, s := range p.inventory.collectStats(p.registry.CollectStats) {
+		assert.Zero(t, s.MatchHits, s.PolicyID)
+	}
+	assert.Equal(t, policyTotals{hits: 2}, p.inventory.policyTotals("remove-key", nil))
+}
+
+func TestPoliciesHash(t *testing.T) {
+	a := logPolicy("a", "none")
+	b := logPolicy("b", "all")
+	assert.Equal(t, policiesHash([]*policyv1.Policy{a, b}), policiesHash([]*policyv1.Policy{a, b}))
+	assert.NotEqual(t, policiesHash([]*policyv1.Policy{a, b}), policiesHash([]*policyv1.Policy{b, a}))
+	assert.NotEqual(t, policiesHash([]*policyv1.Policy{a}), policiesHash([]*policyv1.Policy{a, b}))
+	assert.Len(t, policiesHash(nil), 16)
+}
@@ -0,0 +1,205 @@
+package policyprocessor
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/usetero/policy-go/policy"
+	policyv1 "github.com/usetero/policy-go/proto/tero/policy/v1"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+)
+
+// maxRateLimitKeys bounds the number of token buckets held per policy when
+// limits are keyed by a resource attribute. Idle buckets are pruned first.
+const maxRateLimitKeys = 10000
+
+// tokenBucket is a classic token bucket refilled continuously at rate tokens
+// per second up to burst tokens. It is not safe for concurrent use; callers
+// hold the owning policyRateLimit's mutex.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time, rate, burst float64) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed*rate)
+		b.last = now
+	}
+}
+
+// policyRateLimit holds the buckets for a single policy.
+type policyRateLimit struct {
+	policyID string
+	rate     float64
+	burst    float64
+	keyAttr  string
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// allow takes a token from the bucket for key, reporting whether the record
+// is within the limit.
+func (l *policyRateLimit) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucketLocked(key, now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// bucketLocked returns the bucket for key refilled up to now, creating it
+// when needed.
+// INVARIANT: l.mu MUST be held by the caller.
+func (l *policyRateLimit) bucketLocked(key string, now time.Time) *tokenBucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxRateLimitKeys {
+			l.pruneLocked(now)
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.refill(now, l.rate, l.burst)
+	return b
+}
+
+// pruneLocked drops buckets that have refilled completely, since a fresh
+// bucket behaves identically. If every bucket is still active, the map is
+// reset rather than allowed to grow without bound.
+// INVARIANT: l.mu MUST be held by the caller.
+func (l *policyRateLimit) pruneLocked(now time.Time) {
+	for key, b := range l.buckets {
+		b.refill(now, l.rate, l.burst)
+		if b.tokens >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	if len(l.buckets) >= maxRateLimitKeys {
+		clear(l.buckets)
+	}
+}
+
+// rateLimiter enforces the configured per-policy rate limits. It is shared by
+// all batches flowing through the processor and is safe for concurrent use.
+type rateLimiter struct {
+	limits map[string]*policyRateLimit
+	now    func() time.Time
+}
+
+// newRateLimiter builds a rateLimiter from config. Returns nil when no limits
+// are configured so callers can skip policy attribution entirely.
+func newRateLimiter(cfgs []RateLimitConfig) *rateLimiter {
+	if len(cfgs) == 0 {
+		return nil
+	}
+	rl := &rateLimiter{
+		limits: make(map[string]*policyRateLimit, len(cfgs)),
+		now:    time.Now,
+	}
+	for _, c := range cfgs {
+		burst := float64(c.Burst)
+		if burst <= 0 {
+			burst = math.Max(1, math.Ceil(c.RecordsPerSecond))
+		}
+		rl.limits[c.PolicyID] = &policyRateLimit{
+			policyID: c.PolicyID,
+			rate:     c.RecordsPerSecond,
+			burst:    burst,
+			keyAttr:  c.ResourceAttribute,
+			buckets:  make(map[string]*tokenBucket),
+		}
+	}
+	return rl
+}
+
+// conflict returns why pol cannot be combined with the configured limits, or
+// "" when it can. A policy whose own keep action is a rate already has the
+// engine's limiter; a second limit on the same policy would report two
+// different rate semantics under the same rate_limited result.
+func (rl *rateLimiter) conflict(pol *policyv1.Policy) string {
+	if rl == nil {
+		return ""
+	}
+	if _, ok := rl.limits[pol.GetId()]; !ok {
+		return ""
+	}
+	keep, err := policy.ParseKeep(pol.GetLog().GetKeep())
+	if err != nil {
+		return ""
+	}
+	switch keep.Action {
+	case policy.KeepRatePerSecond, policy.KeepRatePerMinute:
+		return "keep rate " + pol.GetLog().GetKeep() + " cannot be combined with rate_limits for the same policy"
+	}
+	return ""
+}
+
+// limitKey is the bucket of a policy's limit that applies to a record.
+type limitKey struct {
+	limit *policyRateLimit
+	key   string
+}
+
+// allow reports whether a record that matched policyIDs is within every
+// applicable rate limit. A token is only taken from the matching policies'
+// buckets when all of them have one, so a limit that throttles the record
+// does not drain the others.
+func (rl *rateLimiter) allow(policyIDs []string, resource pcommon.Resource) bool {
+	now := rl.now()
+	var buf [4]limitKey
+	keys := buf[:0]
+	for _, id := range policyIDs {
+		l, ok := rl.limits[id]
+		if !ok {
+			continue
+		}
+		var key string
+		if l.keyAttr != "" {
+			if v, ok := resource.Attributes().Get(l.keyAttr); ok {
+				key = v.AsString()
+			}
+		}
+		keys = append(keys, limitKey{limit: l, key: key})
+	}
+	switch len(keys) {
+	case 0:
+		return true
+	case 1:
+		return keys[0].limit.allow(keys[0].key, now)
+	}
+
+	// Lock in policy ID order so concurrent records matching the same
+	// limits cannot deadlock.
+	slices.SortFunc(keys, func(a, b limitKey) int { return strings.Compare(a.limit.policyID, b.limit.policyID) })
+	keys = slices.CompactFunc(keys, func(a, b limitKey) bool { return a.limit == b.limit })
+	for _, k := range keys {
+		k.limit.mu.Lock()
+	}
+	defer func() {
+		for _, k := range keys {
+			k.limit.mu.Unlock()
+		}
+	}()
+	var bucketBuf [4]*tokenBucket
+	buckets := bucketBuf[:0]
+	for _, k := range keys {
+		b := k.limit.bucketLocked(k.key, now)
+		if b.tokens < 1 {
+			return false
+		}
+		buckets = append(buckets, b)
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return true
+}
@@ -0,0 +1,156 @@
+package policyprocessor
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	policyv1 "github.com/usetero/policy-go/proto/tero/policy/v1"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+)
+
+func newTestRateLimiter(cfgs []RateLimitConfig, now *time.Time) *rateLimiter {
+	rl := newRateLimiter(cfgs)
+	rl.now = func() time.Time { return *now }
+	return rl
+}
+
+func TestNewRateLimiter_NoLimits(t *testing.T) {
+	assert.Nil(t, newRateLimiter(nil))
+}
+
+func TestRateLimiter_BurstThenRefill(t *testing.T) {
+	now := time.Unix(1000, 0)
+	rl := newTestRateLimiter([]RateLimitConfig{
+		{PolicyID: "limited", RecordsPerSecond: 2},
+	}, &now)
+	resource := pcommon.NewResource()
+
+	assert.True(t, rl.allow([]string{"limited"}, resource))
+	assert.True(t, rl.allow([]string{"limited"}, resource))
+	assert.False(t, rl.allow([]string{"limited"}, resource), "bucket should be empty after burst")
+
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, rl.allow([]string{"limited"}, resource), "half a second refills one token")
+	assert.False(t, rl.allow([]string{"limited"}, resource))
+}
+
+func TestRateLimiter_ExplicitBurst(t *testing.T) {
+	now := time.Unix(1000, 0)
+	rl := newTestRateLimiter([]RateLimitConfig{
+		{PolicyID: "limited", RecordsPerSecond: 1, Burst: 3},
+	}, &now)
+	resource := pcommon.NewResource()
+
+	for range 3 {
+		assert.True(t, rl.allow([]string{"limited"}, resource))
+	}
+	assert.False(t, rl.allow([]string{"limited"}, resource))
+}
+
+func TestRateLimiter_UnlimitedPolicy(t *testing.T) {
+	now := time.Unix(1000, 0)
+	rl := newTestRateLimiter([]RateLimitConfig{
+		{PolicyID: "limited", RecordsPerSecond: 1},
+	}, &now)
+	resource := pcommon.NewResource()
+
+	for range 10 {
+		assert.True(t, rl.allow([]string{"other"}, resource))
+		assert.True(t, rl.allow(nil, resource))
+	}
+}
+
+func TestRateLimiter_KeyedByResourceAttribute(t *testing.T) {
+	now := time.Unix(1000, 0)
+	rl := newTestRateLimiter([]RateLimitConfig{
+		{PolicyID: "limited", RecordsPerSecond: 1, ResourceAttribute: "service.name"},
+	}, &now)
+
+	svcA := pcommon.NewResource()
+	svcA.Attributes().PutStr("service.name", "a")
+	svcB := pcommon.NewResource()
+	svcB.Attributes().PutStr("service.name", "b")
+
+	assert.True(t, rl.allow([]string{"limited"}, svcA))
+	assert.False(t, rl.allow([]string{"limited"}, svcA))
+	assert.True(t, rl.allow([]string{"limited"}, svcB), "each service has its own bucket")
+	assert.False(t, rl.allow([]string{"limited"}, svcB))
+}
+
+func TestRateLimiter_AnyMatchingLimitThrottles(t *testing.T) {
+	now := time.Unix(1000, 0)
+	rl := newTestRateLimiter([]RateLimitConfig{
+		{PolicyID: "loose", RecordsPerSecond: 100},
+		{PolicyID: "strict", RecordsPerSecond: 1},
+	}, &now)
+	resource := pcommon.NewResource()
+
+	assert.True(t, rl.allow([]string{"loose", "strict"}, resource))
+	assert.False(t, rl.allow([]string{"loose", "strict"}, resource))
+	assert.True(t, rl.allow([]string{"loose"}, resource))
+}
+
+func TestRateLimiter_ThrottledRecordKeepsOtherTokens(t *testing.T) {
+	now := time.Unix(1000, 0)
+	rl := newTestRateLimiter([]RateLimitConfig{
+		{PolicyID: "loose", RecordsPerSecond: 2},
+		{PolicyID: "strict", RecordsPerSecond: 1},
+	}, &now)
+	resource := pcommon.NewResource()
+
+	assert.True(t, rl.allow([]string{"strict", "loose"}, resource))
+	// Throttled by strict, so loose keeps its last token.
+	assert.False(t, rl.allow([]string{"loose", "strict"}, resource))
+	assert.True(t, rl.allow([]string{"loose"}, resource))
+	assert.False(t, rl.allow([]string{"loose"}, resource))
+}
+
+func TestPolicyRateLimit_PrunesIdleBuckets(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newRateLimiter([]RateLimitConfig{
+		{PolicyID: "limited", RecordsPerSecond: 1},
+	}).limits["limited"]
+
+	for i := range maxRateLimitKeys {
+		l.allow(strconv.Itoa(i), now)
+	}
+	assert.Len(t, l.buckets, maxRateLimitKeys)
+
+	now = now.Add(time.Minute)
+	assert.True(t, l.allow("new-key", now))
+	assert.Len(t, l.buckets, 1, "fully refilled buckets are pruned")
+}
+
+func TestRateLimiter_RejectsPoliciesWithKeepRate(t *testing.T) {
+	p := createTestLogProcessor(t, nil)
+	p.rateLimiter = newRateLimiter([]RateLimitConfig{
+		{PolicyID: "keep-rate", RecordsPerSecond: 10},
+		{PolicyID: "keep-all", RecordsPerSecond: 10},
+	})
+	p.inventory = newProviderInventory()
+	p.registry.SetOnRecompile(p.inventory.setRecompileErr)
+
+	state := p.inventory.add("static")
+	_, err := p.registry.Register(&trackedProvider{
+		PolicyProvider: &staticLogProvider{policies: []*policyv1.Policy{
+			logPolicy("keep-rate", "100/s", severityMatcher("INFO")),
+			logPolicy("keep-all", "all", severityMatcher("INFO")),
+			logPolicy("unlimited", "6000/m", severityMatcher("INFO")),
+		}},
+		p:     p,
+		state: state,
+	})
+	require.NoError(t, err)
+
+	snapshot := p.registry.LogSnapshot()
+	_, ok := snapshot.GetPolicy("keep-rate")
+	assert.False(t, ok, "a keep rate and a rate limit on one policy are rejected")
+	for _, id := range []string{"keep-all", "unlimited"} {
+		_, ok = snapshot.GetPolicy(id)
+		assert.True(t, ok, id)
+	}
+	assert.Equal(t, int64(1), state.compileErrors)
+}
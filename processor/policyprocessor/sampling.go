@@ -0,0 +1,93 @@
+package policyprocessor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/usetero/policy-go/policy"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// maxThreshold is 2^56, the size of the OpenTelemetry consistent probability
+// sampling threshold and randomness space.
+const maxThreshold uint64 = 1 << 56
+
+// rejectionThreshold converts a keep percentage to a 56-bit rejection
+// threshold: records with randomness below it are dropped. It matches the
+// threshold the policy engine encodes into the span's "ot=th:" tracestate.
+func rejectionThreshold(percentage float64) uint64 {
+	if percentage >= 100 {
+		return 0
+	}
+	if percentage <= 0 {
+		return maxThreshold
+	}
+	return uint64((1 - percentage/100) * float64(maxThreshold))
+}
+
+// traceIDRandomness derives 56-bit randomness from a trace ID the same way the
+// policy engine does for spans without explicit randomness: the
+// least-significant 56 bits of the trace ID.
+func traceIDRandomness(traceID pcommon.TraceID) uint64 {
+	var r uint64
+	for _, b := range traceID[len(traceID)-7:] {
+		r = r<<8 | uint64(b)
+	}
+	return r
+}
+
+// spanRandomness returns the span's explicit randomness from the "rv" value of
+// its OpenTelemetry tracestate entry, falling back to the trace ID.
+func spanRandomness(span ptrace.Span) uint64 {
+	if rv, ok := otTracestateValue(span.TraceState().AsRaw(), "rv"); ok && len(rv) == 14 {
+		if r, err := strconv.ParseUint(rv, 16, 64); err == nil {
+			return r
+		}
+	}
+	return traceIDRandomness(span.TraceID())
+}
+
+// encodeThreshold renders a 56-bit rejection threshold as a tracestate "th"
+// value: at most precision hex digits (default 4) with trailing zeros
+// removed. It matches the encoding the policy engine writes for spans.
+func encodeThreshold(threshold uint64, precision uint32) string {
+	if precision < 1 {
+		precision = 4
+	}
+	precision = min(precision, 14)
+	hex := strings.TrimRight(fmt.Sprintf("%014x", threshold)[:precision], "0")
+	if hex == "" {
+		return "0"
+	}
+	return hex
+}
+
+// traceConsistentLogKeep decides whether a log record kept by a percentage
+// sampling policy survives trace-consistent sampling. The decision is derived
+// from the record's trace ID so that logs and spans of the same trace sampled
+// at the same percentage are kept or dropped together. Records without a
+// trace ID, and policies that already sample on an explicit sample key, keep
+// the engine's decision.
+//
+// This is the decision the engine makes for a policy with sample_key:
+// trace_id, applied to policies that set no sample key, which the engine
+// keeps in full. It lets the collector sample policies it does not author,
+// such as those served by a provider, consistently with traces.
+func traceConsistentLogKeep(snapshot *policy.LogSnapshot, policyID string, lr plog.LogRecord) bool {
+	traceID := lr.TraceID()
+	if traceID.IsEmpty() || policyID == "" || snapshot == nil {
+		return true
+	}
+	matchers := snapshot.CompiledMatchers()
+	if matchers == nil {
+		return true
+	}
+	p, ok := matchers.GetPolicy(policyID)
+	if !ok || p.Keep.Action != policy.KeepSample || p.SampleKey != nil {
+		return true
+	}
+	return traceIDRandomness(traceID) >= rejectionThreshold(p.Keep.Value)
+}
@@ -0,0 +1,188 @@
+package policyprocessor
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	policyv1 "github.com/usetero/policy-go/proto/tero/policy/v1"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func testTraceID(i int) pcommon.TraceID {
+	var id pcommon.TraceID
+	binary.BigEndian.PutUint64(id[:8], uint64(i)*0x9e3779b97f4a7c15)
+	binary.BigEndian.PutUint64(id[8:], uint64(i+1)*0xbf58476d1ce4e5b9)
+	return id
+}
+
+func TestRejectionThreshold(t *testing.T) {
+	assert.Equal(t, uint64(0), rejectionThreshold(100))
+	assert.Equal(t, maxThreshold, rejectionThreshold(0))
+	assert.Equal(t, maxThreshold/2, rejectionThreshold(50))
+	assert.Equal(t, maxThreshold/4*3, rejectionThreshold(25))
+}
+
+func TestTraceIDRandomness(t *testing.T) {
+	id := pcommon.TraceID{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0xa, 0xb, 0xc, 0xd, 0xe, 0xf}
+	assert.Equal(t, uint64(0x090a0b0c0d0e0f), traceIDRandomness(id), "least-significant 56 bits")
+}
+
+func TestTraceConsistentLogSampling_MatchesSpanSampling(t *testing.T) {
+	const traces = 1000
+
+	logProc := createTestLogProcessor(t, []*policyv1.Policy{
+		{
+			Id:      "sample-logs",
+			Enabled: true,
+			Target: &policyv1.Policy_Log{
+				Log: &policyv1.LogTarget{
+					Match: []*policyv1.LogMatcher{
+						{
+							Field: &policyv1.LogMatcher_LogField{LogField: policyv1.LogField_LOG_FIELD_BODY},
+							Match: &policyv1.LogMatcher_Exact{Exact: "request handled"},
+						},
+					},
+					Keep: "25%",
+				},
+			},
+		},
+	})
+	logProc.traceConsistentLogs = true
+
+	traceProc := createTestTraceProcessor(t, []*policyv1.Policy{
+		{
+			Id:      "sample-spans",
+			Enabled: true,
+			Target: &policyv1.Policy_Trace{
+				Trace: &policyv1.TraceTarget{
+					Match: []*policyv1.TraceMatcher{
+						{
+							Field: &policyv1.TraceMatcher_TraceField{TraceField: policyv1.TraceField_TRACE_FIELD_NAME},
+							Match: &policyv1.TraceMatcher_Exact{Exact: "GET /cart"},
+						},
+					},
+					Keep: &policyv1.TraceSamplingConfig{Percentage: 25},
+				},
+			},
+		},
+	})
+
+	logs := plog.NewLogs()
+	lrs := logs.ResourceLogs().AppendEmpty().ScopeLogs().AppendEmpty().LogRecords()
+	spans := ptrace.NewTraces()
+	ss := spans.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+	for i := range traces {
+		lr := lrs.AppendEmpty()
+		lr.Body().SetStr("request handled")
+		lr.SetTraceID(testTraceID(i))
+		span := ss.AppendEmpty()
+		span.SetName("GET /cart")
+		span.SetTraceID(testTraceID(i))
+	}
+	// Logs without a trace ID fall back to the engine's decision.
+	lrs.AppendEmpty().Body().SetStr("request handled")
+
+	logs, err := logProc.processLogs(context.Background(), logs)
+	require.NoError(t, err)
+	spans, err = traceProc.processTraces(context.Background(), spans)
+	require.NoError(t, err)
+
+	keptLogs := make(map[pcommon.TraceID]bool)
+	var untraced int
+	gotLogs := logs.ResourceLogs().At(0).ScopeLogs().At(0).LogRecords()
+	for i := range gotLogs.Len() {
+		if id := gotLogs.At(i).TraceID(); id.IsEmpty() {
+			untraced++
+		} else {
+			keptLogs[id] = true
+		}
+	}
+	keptSpans := make(map[pcommon.TraceID]bool)
+	gotSpans := spans.ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+	for i := range gotSpans.Len() {
+		keptSpans[gotSpans.At(i).TraceID()] = true
+	}
+
+	assert.Equal(t, keptSpans, keptLogs, "logs and spans of the same trace are kept together")
+	assert.InDelta(t, traces/4, len(keptLogs), traces/10)
+	assert.Equal(t, 1, untraced)
+}
+
+func TestTraceConsistentLogSampling_MatchesTraceIDSampleKey(t *testing.T) {
+	const traces = 1000
+
+	// The engine samples a policy keyed on trace_id; trace_consistent
+	// samples the same policy without a sample key.
+	keyed := logPolicy("sample-logs", "25%", severityMatcher("INFO"))
+	keyed.GetLog().SampleKey = &policyv1.LogSampleKey{
+		Field: &policyv1.LogSampleKey_LogField{LogField: policyv1.LogField_LOG_FIELD_TRACE_ID},
+	}
+	engineProc := createTestLogProcessor(t, []*policyv1.Policy{keyed})
+	consistentProc := createTestLogProcessor(t, []*policyv1.Policy{logPolicy("sample-logs", "25%", severityMatcher("INFO"))})
+	consistentProc.traceConsistentLogs = true
+
+	logs := plog.NewLogs()
+	lrs := logs.ResourceLogs().AppendEmpty().ScopeLogs().AppendEmpty().LogRecords()
+	for i := range traces {
+		lr := lrs.AppendEmpty()
+		lr.SetSeverityText("INFO")
+		lr.SetTraceID(testTraceID(i))
+	}
+	keptTraces := func(p *policyProcessor) map[pcommon.TraceID]bool {
+		ld := plog.NewLogs()
+		logs.CopyTo(ld)
+		ld, err := p.processLogs(context.Background(), ld)
+		require.NoError(t, err)
+		kept := make(map[pcommon.TraceID]bool)
+		if ld.ResourceLogs().Len() == 0 {
+			return kept
+		}
+		got := ld.ResourceLogs().At(0).ScopeLogs().At(0).LogRecords()
+		for i := range got.Len() {
+			kept[got.At(i).TraceID()] = true
+		}
+		return kept
+	}
+
+	fromEngine := keptTraces(engineProc)
+	assert.Equal(t, fromEngine, keptTraces(consistentProc))
+	assert.InDelta(t, traces/4, len(fromEngine), traces/10)
+}
+
+func TestTraceConsistentLogSampling_Disabled(t *testing.T) {
+	p := createTestLogProcessor(t, []*policyv1.Policy{
+		{
+			Id:      "sample-logs",
+			Enabled: true,
+			Target: &policyv1.Policy_Log{
+				Log: &policyv1.LogTarget{
+					Match: []*policyv1.LogMatcher{
+						{
+							Field: &policyv1.LogMatcher_LogField{LogField: policyv1.LogField_LOG_FIELD_BODY},
+							Match: &policyv1.LogMatcher_Exact{Exact: "request handled"},
+						},
+					},
+					Keep: "0.0001%",
+				},
+			},
+		},
+	})
+
+	logs := plog.NewLogs()
+	lrs := logs.ResourceLogs().AppendEmpty().ScopeLogs().AppendEmpty().LogRecords()
+	for i := range 10 {
+		lr := lrs.AppendEmpty()
+		lr.Body().SetStr("request handled")
+		lr.SetTraceID(testTraceID(i))
+	}
+
+	logs, err := p.processLogs(context.Background(), logs)
+	require.NoError(t, err)
+	assert.Equal(t, 10, logs.ResourceLogs().At(0).ScopeLogs().At(0).LogRecords().Len(),
+		"without a sample key the engine keeps every record")
+}
@@ -0,0 +1,59 @@
+package policyprocessor
+
+import (
+	"sync"
+
+	"go.opentelemetry.io/collector/component"
+)
+
+// sharedRegistry shares state between the processor instances the collector
+// builds for the same component ID, one per pipeline. Entries are reference
+// counted and forgotten once the last instance using them shuts down.
+type sharedRegistry[T any] struct {
+	mu      sync.Mutex
+	entries map[component.ID]*sharedEntry[T]
+}
+
+type sharedEntry[T any] struct {
+	value T
+	refs  int
+}
+
+func newSharedRegistry[T any]() *sharedRegistry[T] {
+	return &sharedRegistry[T]{entries: make(map[component.ID]*sharedEntry[T])}
+}
+
+// acquire returns the value for id, calling create on first use.
+func (r *sharedRegistry[T]) acquire(id component.ID, create func() T) T {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.entries[id]
+	if !ok {
+		e = &sharedEntry[T]{value: create()}
+		r.entries[id] = e
+	}
+	e.refs++
+	return e.value
+}
+
+// has reports whether any instance holds the value for id.
+func (r *sharedRegistry[T]) has(id component.ID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.entries[id]
+	return ok
+}
+
+// release drops a reference to the value for id.
+func (r *sharedRegistry[T]) release(id component.ID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.entries[id]
+	if !ok {
+		return
+	}
+	e.refs--
+	if e.refs <= 0 {
+		delete(r.entries, id)
+	}
+}
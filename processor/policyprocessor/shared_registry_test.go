@@ -0,0 +1,26 @@
+package policyprocessor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/collector/component"
+)
+
+func TestSharedRegistry_SharedUntilReleased(t *testing.T) {
+	r := newSharedRegistry[*droppedLogSummary]()
+	create := func() *droppedLogSummary { return newDroppedLogSummary(DroppedLogMetricsConfig{}) }
+	id := component.MustNewID("policy")
+	other := component.MustNewIDWithName("policy", "other")
+
+	logs := r.acquire(id, create)
+	metrics := r.acquire(id, create)
+	assert.Same(t, logs, metrics)
+	assert.NotSame(t, logs, r.acquire(other, create))
+
+	r.release(id)
+	assert.Same(t, logs, r.acquire(id, create), "still referenced by one instance")
+	r.release(id)
+	r.release(id)
+	assert.NotSame(t, logs, r.acquire(id, create), "released values are recreated")
+}
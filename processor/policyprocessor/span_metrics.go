@@ -0,0 +1,280 @@
+package policyprocessor
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/usetero/tero-collector-distro/processor/policyprocessor/internal/metadata"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+const (
+	// defaultDroppedSpanNamespace prefixes the emitted metric names when
+	// DroppedSpanMetricsConfig.Namespace is unset.
+	defaultDroppedSpanNamespace = "policy.dropped_spans"
+
+	// Datapoint attributes of the dropped span metrics, named as by the
+	// spanmetrics connector.
+	attrServiceName = "service.name"
+	attrSpanName    = "span.name"
+	attrSpanKind    = "span.kind"
+	attrStatusCode  = "status.code"
+
+	// attrOverflow marks the series that spans beyond maxDroppedSpanSeries
+	// are counted under, split by status only.
+	attrOverflow = "otel.metric.overflow"
+
+	// maxDroppedSpanSeries bounds the number of distinct series accumulated
+	// between flushes.
+	maxDroppedSpanSeries = 10000
+)
+
+// defaultDroppedSpanBuckets are the spanmetrics connector's default duration
+// histogram boundaries.
+var defaultDroppedSpanBuckets = []time.Duration{
+	2 * time.Millisecond,
+	4 * time.Millisecond,
+	6 * time.Millisecond,
+	8 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	400 * time.Millisecond,
+	800 * time.Millisecond,
+	time.Second,
+	1400 * time.Millisecond,
+	2 * time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	15 * time.Second,
+}
+
+// droppedSpanKey identifies a series of the dropped span metrics.
+type droppedSpanKey struct {
+	serviceName string
+	spanName    string
+	kind        ptrace.SpanKind
+	status      ptrace.StatusCode
+	overflow    bool
+}
+
+// droppedSpanSeries accumulates calls and durations for a single series.
+type droppedSpanSeries struct {
+	calls        uint64
+	bucketCounts []uint64
+	sum          float64
+	min, max     float64
+}
+
+// droppedSpanSummary accumulates RED metrics for dropped spans by service,
+// span name, kind and status. The traces instance of the processor records
+// into it; the metrics instance with the same component ID drains it, every
+// flush interval and into each metrics batch it processes. It is safe for
+// concurrent use.
+type droppedSpanSummary struct {
+	namespace string
+	// bounds are the histogram boundaries in milliseconds.
+	bounds        []float64
+	flushInterval time.Duration
+
+	mu     sync.Mutex
+	series map[droppedSpanKey]*droppedSpanSeries
+	start  time.Time
+	now    func() time.Time
+}
+
+func newDroppedSpanSummary(cfg DroppedSpanMetricsConfig) *droppedSpanSummary {
+	namespace := cfg.Namespace
+	if namespace == "" {
+		namespace = defaultDroppedSpanNamespace
+	}
+	buckets := cfg.Buckets
+	if len(buckets) == 0 {
+		buckets = defaultDroppedSpanBuckets
+	}
+	bounds := make([]float64, len(buckets))
+	for i, b := range buckets {
+		bounds[i] = durationMillis(b)
+	}
+	flushInterval := cfg.FlushInterval
+	if flushInterval == 0 {
+		flushInterval = defaultSummaryFlushInterval
+	}
+	return &droppedSpanSummary{
+		namespace:     namespace,
+		bounds:        bounds,
+		flushInterval: flushInterval,
+		series:        make(map[droppedSpanKey]*droppedSpanSeries),
+		start:         time.Now(),
+		now:           time.Now,
+	}
+}
+
+func durationMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// record adds a dropped span to its series.
+func (s *droppedSpanSummary) record(resource pcommon.Resource, span ptrace.Span) {
+	key := droppedSpanKey{
+		spanName: span.Name(),
+		kind:     span.Kind(),
+		status:   span.Status().Code(),
+	}
+	if v, ok := resource.Attributes().Get(attrServiceName); ok {
+		key.serviceName = v.AsString()
+	}
+	var duration float64
+	if end, start := span.EndTimestamp(), span.StartTimestamp(); end > start {
+		duration = durationMillis(time.Duration(end - start))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	series, ok := s.series[key]
+	if !ok {
+		if len(s.series) >= maxDroppedSpanSeries {
+			key = droppedSpanKey{status: key.status, overflow: true}
+			series, ok = s.series[key]
+		}
+		if !ok {
+			series = &droppedSpanSeries{
+				bucketCounts: make([]uint64, len(s.bounds)+1),
+				min:          math.Inf(1),
+				max:          math.Inf(-1),
+			}
+			s.series[key] = series
+		}
+	}
+	series.calls++
+	series.bucketCounts[sort.SearchFloat64s(s.bounds, duration)]++
+	series.sum += duration
+	series.min = min(series.min, duration)
+	series.max = max(series.max, duration)
+}
+
+// putAttributes writes the series attributes of key to attrs.
+func (key droppedSpanKey) putAttributes(attrs pcommon.Map) {
+	if key.overflow {
+		attrs.PutBool(attrOverflow, true)
+	} else {
+		attrs.PutStr(attrServiceName, key.serviceName)
+		attrs.PutStr(attrSpanName, key.spanName)
+		attrs.PutStr(attrSpanKind, "SPAN_KIND_"+strings.ToUpper(key.kind.String()))
+	}
+	attrs.PutStr(attrStatusCode, "STATUS_CODE_"+strings.ToUpper(key.status.String()))
+}
+
+// appendTo drains the accumulated series into md as delta metrics under a
+// new ResourceMetrics carrying the collector's resource: a calls Sum, an
+// errors Sum for series with an error status, and a duration Histogram.
+// Nothing is appended when no spans were dropped since the last flush, and
+// the errors Sum is left out when none of them had an error status.
+func (s *droppedSpanSummary) appendTo(md pmetric.Metrics, resource pcommon.Resource) {
+	s.mu.Lock()
+	series := s.series
+	start := s.start
+	now := s.now()
+	if len(series) == 0 {
+		s.mu.Unlock()
+		return
+	}
+	s.series = make(map[droppedSpanKey]*droppedSpanSeries)
+	s.start = now
+	s.mu.Unlock()
+
+	startTs := pcommon.NewTimestampFromTime(start)
+	nowTs := pcommon.NewTimestampFromTime(now)
+
+	rm := md.ResourceMetrics().AppendEmpty()
+	resource.CopyTo(rm.Resource())
+	sm := rm.ScopeMetrics().AppendEmpty()
+	sm.Scope().SetName(metadata.ScopeName)
+	metrics := sm.Metrics()
+
+	newSum := func(name, description string) pmetric.NumberDataPointSlice {
+		m := metrics.AppendEmpty()
+		m.SetName(s.namespace + "." + name)
+		m.SetDescription(description)
+		m.SetUnit("{span}")
+		sum := m.SetEmptySum()
+		sum.SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+		sum.SetIsMonotonic(true)
+		return sum.DataPoints()
+	}
+	calls := newSum("calls", "Number of spans dropped by policy")
+	var errorCalls pmetric.NumberDataPointSlice
+	for key := range series {
+		if key.status == ptrace.StatusCodeError {
+			errorCalls = newSum("errors", "Number of spans with an error status dropped by policy")
+			break
+		}
+	}
+
+	m := metrics.AppendEmpty()
+	m.SetName(s.namespace + ".duration")
+	m.SetDescription("Duration of spans dropped by policy")
+	m.SetUnit("ms")
+	hist := m.SetEmptyHistogram()
+	hist.SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+	durations := hist.DataPoints()
+
+	calls.EnsureCapacity(len(series))
+	durations.EnsureCapacity(len(series))
+	for key, ser := range series {
+		dp := calls.AppendEmpty()
+		key.putAttributes(dp.Attributes())
+		dp.SetStartTimestamp(startTs)
+		dp.SetTimestamp(nowTs)
+		dp.SetIntValue(int64(ser.calls))
+
+		if key.status == ptrace.StatusCodeError {
+			edp := errorCalls.AppendEmpty()
+			dp.Attributes().CopyTo(edp.Attributes())
+			edp.SetStartTimestamp(startTs)
+			edp.SetTimestamp(nowTs)
+			edp.SetIntValue(int64(ser.calls))
+		}
+
+		hdp := durations.AppendEmpty()
+		dp.Attributes().CopyTo(hdp.Attributes())
+		hdp.SetStartTimestamp(startTs)
+		hdp.SetTimestamp(nowTs)
+		hdp.SetCount(ser.calls)
+		hdp.SetSum(ser.sum)
+		hdp.SetMin(ser.min)
+		hdp.SetMax(ser.max)
+		hdp.ExplicitBounds().FromRaw(s.bounds)
+		hdp.BucketCounts().FromRaw(ser.bucketCounts)
+	}
+}
+
+// droppedSpanSummaries shares a droppedSpanSummary between the traces and
+// metrics instances created for the same processor ID.
+var droppedSpanSummaries = newSharedRegistry[*droppedSpanSummary]()
+
+// recordDroppedSpan adds a dropped span to the dropped span metrics. A no-op
+// unless dropped span metrics are enabled.
+func (p *policyProcessor) recordDroppedSpan(resource pcommon.Resource, span ptrace.Span) {
+	if p.droppedSpans == nil {
+		return
+	}
+	p.droppedSpans.record(resource, span)
+}
+
+// recordDroppedSpans adds every span of spans to the dropped span metrics.
+func (p *policyProcessor) recordDroppedSpans(resource pcommon.Resource, spans ptrace.SpanSlice) {
+	if p.droppedSpans == nil {
+		return
+	}
+	for i := range spans.Len() {
+		p.droppedSpans.record(resource, spans.At(i))
+	}
+}
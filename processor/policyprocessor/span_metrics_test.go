@@ -0,0 +1,217 @@
+package policyprocessor
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	policyv1 "github.com/usetero/policy-go/proto/tero/policy/v1"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func newTestDroppedSpan(name string, kind ptrace.SpanKind, status ptrace.StatusCode, duration time.Duration) ptrace.Span {
+	span := ptrace.NewSpan()
+	span.SetName(name)
+	span.SetKind(kind)
+	span.Status().SetCode(status)
+	start := time.Unix(1000, 0)
+	span.SetStartTimestamp(pcommon.NewTimestampFromTime(start))
+	span.SetEndTimestamp(pcommon.NewTimestampFromTime(start.Add(duration)))
+	return span
+}
+
+func newTestServiceResource(service string) pcommon.Resource {
+	resource := pcommon.NewResource()
+	resource.Attributes().PutStr("service.name", service)
+	return resource
+}
+
+func spanMetricsKey(attrs pcommon.Map) string {
+	var out string
+	for _, k := range []string{attrOverflow, attrServiceName, attrSpanName, attrSpanKind, attrStatusCode} {
+		if v, ok := attrs.Get(k); ok {
+			out += k + "=" + v.AsString() + ";"
+		}
+	}
+	return out
+}
+
+// droppedSpanMetrics returns the emitted metrics by name.
+func droppedSpanMetrics(t *testing.T, md pmetric.Metrics) map[string]pmetric.Metric {
+	t.Helper()
+	require.Equal(t, 1, md.ResourceMetrics().Len())
+	ms := md.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics()
+	out := make(map[string]pmetric.Metric, ms.Len())
+	for i := range ms.Len() {
+		out[ms.At(i).Name()] = ms.At(i)
+	}
+	return out
+}
+
+func sumCounts(m pmetric.Metric) map[string]int64 {
+	counts := make(map[string]int64)
+	dps := m.Sum().DataPoints()
+	for i := range dps.Len() {
+		counts[spanMetricsKey(dps.At(i).Attributes())] = dps.At(i).IntValue()
+	}
+	return counts
+}
+
+func TestDroppedSpanSummary_RED(t *testing.T) {
+	s := newDroppedSpanSummary(DroppedSpanMetricsConfig{
+		Buckets: []time.Duration{10 * time.Millisecond, 100 * time.Millisecond},
+	})
+	start := time.Unix(1000, 0)
+	s.start = start
+	now := start.Add(10 * time.Second)
+	s.now = func() time.Time { return now }
+
+	cart := newTestServiceResource("cart")
+	s.record(cart, newTestDroppedSpan("GET /health", ptrace.SpanKindServer, ptrace.StatusCodeUnset, 5*time.Millisecond))
+	s.record(cart, newTestDroppedSpan("GET /health", ptrace.SpanKindServer, ptrace.StatusCodeUnset, 50*time.Millisecond))
+	s.record(cart, newTestDroppedSpan("GET /health", ptrace.SpanKindServer, ptrace.StatusCodeError, 500*time.Millisecond))
+	s.record(newTestServiceResource("checkout"), newTestDroppedSpan("SELECT", ptrace.SpanKindClient, ptrace.StatusCodeOk, 10*time.Millisecond))
+
+	md := pmetric.NewMetrics()
+	s.appendTo(md, pcommon.NewResource())
+	metrics := droppedSpanMetrics(t, md)
+
+	const (
+		healthOK  = "service.name=cart;span.name=GET /health;span.kind=SPAN_KIND_SERVER;status.code=STATUS_CODE_UNSET;"
+		healthErr = "service.name=cart;span.name=GET /health;span.kind=SPAN_KIND_SERVER;status.code=STATUS_CODE_ERROR;"
+		query     = "service.name=checkout;span.name=SELECT;span.kind=SPAN_KIND_CLIENT;status.code=STATUS_CODE_OK;"
+	)
+
+	calls := metrics["policy.dropped_spans.calls"]
+	require.Equal(t, pmetric.MetricTypeSum, calls.Type())
+	assert.Equal(t, pmetric.AggregationTemporalityDelta, calls.Sum().AggregationTemporality())
+	assert.True(t, calls.Sum().IsMonotonic())
+	assert.Equal(t, map[string]int64{healthOK: 2, healthErr: 1, query: 1}, sumCounts(calls))
+
+	errorCalls := metrics["policy.dropped_spans.errors"]
+	assert.Equal(t, map[string]int64{healthErr: 1}, sumCounts(errorCalls))
+
+	duration := metrics["policy.dropped_spans.duration"]
+	require.Equal(t, pmetric.MetricTypeHistogram, duration.Type())
+	assert.Equal(t, "ms", duration.Unit())
+	dps := duration.Histogram().DataPoints()
+	require.Equal(t, 3, dps.Len())
+	for i := range dps.Len() {
+		dp := dps.At(i)
+		assert.Equal(t, pcommon.NewTimestampFromTime(start), dp.StartTimestamp())
+		assert.Equal(t, pcommon.NewTimestampFromTime(now), dp.Timestamp())
+		assert.Equal(t, []float64{10, 100}, dp.ExplicitBounds().AsRaw())
+		switch spanMetricsKey(dp.Attributes()) {
+		case healthOK:
+			assert.Equal(t, uint64(2), dp.Count())
+			assert.Equal(t, []uint64{1, 1, 0}, dp.BucketCounts().AsRaw())
+			assert.InDelta(t, 55.0, dp.Sum(), 1e-9)
+			assert.InDelta(t, 5.0, dp.Min(), 1e-9)
+			assert.InDelta(t, 50.0, dp.Max(), 1e-9)
+		case healthErr:
+			assert.Equal(t, []uint64{0, 0, 1}, dp.BucketCounts().AsRaw())
+		case query:
+			assert.Equal(t, []uint64{1, 0, 0}, dp.BucketCounts().AsRaw(), "bucket bounds are inclusive")
+		default:
+			t.Errorf("unexpected series %q", spanMetricsKey(dp.Attributes()))
+		}
+	}
+}
+
+func TestDroppedSpanSummary_DrainsOnAppend(t *testing.T) {
+	s := newDroppedSpanSummary(DroppedSpanMetricsConfig{Namespace: "spans.dropped"})
+	s.record(newTestServiceResource("cart"), newTestDroppedSpan("a", ptrace.SpanKindInternal, ptrace.StatusCodeUnset, time.Millisecond))
+
+	md := pmetric.NewMetrics()
+	s.appendTo(md, pcommon.NewResource())
+	metrics := droppedSpanMetrics(t, md)
+	assert.Contains(t, metrics, "spans.dropped.calls")
+	assert.NotContains(t, metrics, "spans.dropped.errors", "no errors Sum without error spans")
+
+	md = pmetric.NewMetrics()
+	s.appendTo(md, pcommon.NewResource())
+	assert.Equal(t, 0, md.ResourceMetrics().Len(), "nothing is emitted without new drops")
+}
+
+func TestDroppedSpanSummary_Flush(t *testing.T) {
+	s := newDroppedSpanSummary(DroppedSpanMetricsConfig{})
+	sink := &consumertest.MetricsSink{}
+	p := createTestMetricProcessor(t, nil)
+	p.resource = pcommon.NewResource()
+	p.nextMetrics = sink
+
+	p.startSummaryFlush(s, time.Hour)
+	s.record(newTestServiceResource("cart"), newTestDroppedSpan("a", ptrace.SpanKindInternal, ptrace.StatusCodeError, time.Millisecond))
+	p.stopSummaryFlushes(context.Background())
+
+	require.Len(t, sink.AllMetrics(), 1, "shutdown emits the pending metrics")
+	assert.Equal(t, map[string]int64{
+		"service.name=cart;span.name=a;span.kind=SPAN_KIND_INTERNAL;status.code=STATUS_CODE_ERROR;": 1,
+	}, sumCounts(droppedSpanMetrics(t, sink.AllMetrics()[0])["policy.dropped_spans.errors"]))
+}
+
+func TestDroppedSpanSummary_OverflowKeepsStatus(t *testing.T) {
+	s := newDroppedSpanSummary(DroppedSpanMetricsConfig{})
+	resource := newTestServiceResource("cart")
+	for i := range maxDroppedSpanSeries {
+		s.record(resource, newTestDroppedSpan(strconv.Itoa(i), ptrace.SpanKindServer, ptrace.StatusCodeUnset, time.Millisecond))
+	}
+	s.record(resource, newTestDroppedSpan("extra", ptrace.SpanKindServer, ptrace.StatusCodeError, time.Millisecond))
+	s.record(resource, newTestDroppedSpan("extra", ptrace.SpanKindServer, ptrace.StatusCodeError, time.Millisecond))
+
+	md := pmetric.NewMetrics()
+	s.appendTo(md, pcommon.NewResource())
+	counts := sumCounts(droppedSpanMetrics(t, md)["policy.dropped_spans.errors"])
+	assert.Equal(t, map[string]int64{"otel.metric.overflow=true;status.code=STATUS_CODE_ERROR;": 2}, counts)
+}
+
+func TestProcessTraces_RecordsDroppedSpanMetrics(t *testing.T) {
+	p := createTestTraceProcessor(t, []*policyv1.Policy{dropSpanNamePolicy("healthcheck")})
+	p.droppedSpans = newDroppedSpanSummary(DroppedSpanMetricsConfig{})
+
+	td := ptrace.NewTraces()
+	appendSpan(td, testTraceID(1), "healthcheck", false)
+	appendSpan(td, testTraceID(2), "healthcheck", true)
+	appendSpan(td, testTraceID(3), "checkout", false)
+	_, err := p.processTraces(context.Background(), td)
+	require.NoError(t, err)
+
+	md := pmetric.NewMetrics()
+	p.droppedSpans.appendTo(md, pcommon.NewResource())
+	assert.Equal(t, map[string]int64{
+		"service.name=cart;span.name=healthcheck;span.kind=SPAN_KIND_UNSPECIFIED;status.code=STATUS_CODE_UNSET;": 1,
+		"service.name=cart;span.name=healthcheck;span.kind=SPAN_KIND_UNSPECIFIED;status.code=STATUS_CODE_ERROR;": 1,
+	}, sumCounts(droppedSpanMetrics(t, md)["policy.dropped_spans.calls"]))
+}
+
+func TestTailSampling_RecordsDroppedSpanMetrics(t *testing.T) {
+	tp := newTailTestProcessor(t, []*policyv1.Policy{dropHealthyTracesPolicy()}, TailSamplingConfig{
+		Enabled:  true,
+		Policies: []TailSamplingPolicyConfig{{PolicyID: "drop-healthy", Match: TailMatchAll}},
+	})
+	tp.droppedSpans = newDroppedSpanSummary(DroppedSpanMetricsConfig{})
+	tp.tail.dropped = tp.recordDroppedSpan
+
+	td := ptrace.NewTraces()
+	appendSpan(td, testTraceID(1), "browse", false)
+	appendSpan(td, testTraceID(2), "charge", true)
+	_, _ = tp.processTraces(context.Background(), td)
+	tp.release()
+
+	// A late span follows the drop decision of its trace.
+	td = ptrace.NewTraces()
+	appendSpan(td, testTraceID(1), "browse", false)
+	_, _ = tp.processTraces(context.Background(), td)
+
+	md := pmetric.NewMetrics()
+	tp.droppedSpans.appendTo(md, pcommon.NewResource())
+	assert.Equal(t, map[string]int64{
+		"service.name=cart;span.name=browse;span.kind=SPAN_KIND_UNSPECIFIED;status.code=STATUS_CODE_UNSET;": 2,
+	}, sumCounts(droppedSpanMetrics(t, md)["policy.dropped_spans.calls"]))
+}
@@ -0,0 +1,99 @@
+package policyprocessor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.uber.org/zap"
+)
+
+// defaultSummaryFlushInterval is how often metric summaries are emitted when
+// no flush interval is configured.
+const defaultSummaryFlushInterval = time.Minute
+
+// metricSummary accumulates metrics recorded by the processor, or datapoints
+// it holds back, until the metrics instance drains them.
+type metricSummary interface {
+	// appendTo drains the accumulated metrics into md, appending nothing
+	// when there is nothing to report.
+	appendTo(md pmetric.Metrics, resource pcommon.Resource)
+}
+
+// metricsInstances records the component IDs that have a metrics instance,
+// the only one that emits the summaries of the logs and traces instances.
+var metricsInstances = newSharedRegistry[struct{}]()
+
+// checkSummaryEmitter returns an error when the logs or traces instance
+// records a summary that no metrics instance of the same component would
+// ever emit. Every instance is created before any is started.
+func (p *policyProcessor) checkSummaryEmitter() error {
+	if p.nextMetrics != nil {
+		return nil
+	}
+	if p.droppedLogs != nil && !metricsInstances.has(p.droppedLogsID) {
+		return fmt.Errorf("dropped_log_metrics requires %s to also be in a metrics pipeline, which emits them", p.droppedLogsID)
+	}
+	if p.droppedSpans != nil && !metricsInstances.has(p.droppedSpansID) {
+		return fmt.Errorf("dropped_span_metrics requires %s to also be in a metrics pipeline, which emits them", p.droppedSpansID)
+	}
+	return nil
+}
+
+// summaryFlush emits a metricSummary every interval, so its metrics go out
+// even when no metrics batch passes through the processor.
+type summaryFlush struct {
+	summary metricSummary
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// startSummaryFlush starts emitting summary to the next metrics consumer
+// every interval, until shutdown.
+func (p *policyProcessor) startSummaryFlush(summary metricSummary, interval time.Duration) {
+	f := &summaryFlush{
+		summary: summary,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	p.summaryFlushes = append(p.summaryFlushes, f)
+	go func() {
+		defer close(f.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-f.stop:
+				return
+			case <-ticker.C:
+				p.flushSummary(context.Background(), summary)
+			}
+		}
+	}()
+}
+
+// stopSummaryFlushes stops the flush loops and emits what accumulated since
+// their last flush.
+func (p *policyProcessor) stopSummaryFlushes(ctx context.Context) {
+	for _, f := range p.summaryFlushes {
+		close(f.stop)
+		<-f.done
+		p.flushSummary(ctx, f.summary)
+	}
+	p.summaryFlushes = nil
+}
+
+// flushSummary drains summary into a metrics batch of its own and forwards
+// it to the next metrics consumer.
+func (p *policyProcessor) flushSummary(ctx context.Context, summary metricSummary) {
+	md := pmetric.NewMetrics()
+	summary.appendTo(md, p.resource)
+	if md.ResourceMetrics().Len() == 0 {
+		return
+	}
+	if err := p.nextMetrics.ConsumeMetrics(ctx, md); err != nil {
+		p.logger.Error("Failed to emit summary metrics", zap.Error(err))
+	}
+}
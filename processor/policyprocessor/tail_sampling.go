@@ -0,0 +1,566 @@
+package policyprocessor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/usetero/policy-go/policy"
+	policyv1 "github.com/usetero/policy-go/proto/tero/policy/v1"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/collector/processor/processorhelper"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultTailDecisionWait is used when TailSamplingConfig.DecisionWait
+	// is unset.
+	defaultTailDecisionWait = 30 * time.Second
+
+	// defaultTailMaxTraces is used when TailSamplingConfig.MaxTraces is
+	// unset. The same number of decisions is remembered for late spans.
+	defaultTailMaxTraces = 50000
+
+	// defaultTailMaxSpansPerTrace is used when
+	// TailSamplingConfig.MaxSpansPerTrace is unset.
+	defaultTailMaxSpansPerTrace = 10000
+)
+
+// tailSource identifies the incoming resource and scope a buffered span was
+// copied from.
+type tailSource struct {
+	batch    uint64
+	resource int
+	scope    int
+}
+
+// pendingTrace buffers the spans of a trace until it is decided.
+type pendingTrace struct {
+	traceID   pcommon.TraceID
+	firstSeen time.Time
+	spans     ptrace.Traces
+	spanCount int
+	// released is set once the trace was decided ahead of its place in the
+	// queue, which still lists it.
+	released bool
+
+	// last is where the most recently buffered span came from, so that
+	// consecutive spans of the same resource and scope share a
+	// ResourceSpans and ScopeSpans.
+	last tailSource
+}
+
+// append moves span into the buffer under copies of its resource and scope.
+func (t *pendingTrace) append(src tailSource, rs ptrace.ResourceSpans, ss ptrace.ScopeSpans, span ptrace.Span) {
+	rss := t.spans.ResourceSpans()
+	if src.batch != t.last.batch || src.resource != t.last.resource {
+		dst := rss.AppendEmpty()
+		rs.Resource().CopyTo(dst.Resource())
+		dst.SetSchemaUrl(rs.SchemaUrl())
+		t.last = tailSource{batch: src.batch, resource: src.resource, scope: -1}
+	}
+	scopes := rss.At(rss.Len() - 1).ScopeSpans()
+	if src.scope != t.last.scope {
+		dst := scopes.AppendEmpty()
+		ss.Scope().CopyTo(dst.Scope())
+		dst.SetSchemaUrl(ss.SchemaUrl())
+		t.last.scope = src.scope
+	}
+	span.MoveTo(scopes.At(scopes.Len() - 1).Spans().AppendEmpty())
+	t.spanCount++
+}
+
+// tailDecision is the outcome for a whole trace. It is remembered after the
+// trace is released so that late spans follow it.
+type tailDecision struct {
+	keep bool
+	// threshold is the tracestate "th" value written to kept spans; empty
+	// when none is written.
+	threshold string
+	// result is the processor_policy_records result for the trace's spans.
+	result string
+}
+
+// apply writes the decision's threshold to every span of t.
+func (d tailDecision) apply(t ptrace.Traces) {
+	if d.threshold == "" {
+		return
+	}
+	ref := policy.SpanSamplingThreshold()
+	rss := t.ResourceSpans()
+	for i := range rss.Len() {
+		sss := rss.At(i).ScopeSpans()
+		for j := range sss.Len() {
+			spans := sss.At(j).Spans()
+			for k := range spans.Len() {
+				TraceSet(TraceContext{Span: spans.At(k)}, ref, d.threshold)
+			}
+		}
+	}
+}
+
+// tailAddResult reports what happened to the spans of an incoming batch
+// that were not buffered.
+type tailAddResult struct {
+	// late counts spans of already decided traces, by decision.
+	late map[tailDecision]int64
+	// untraced counts spans without a trace ID, which pass through.
+	untraced int64
+	// evicted counts traces decided early to make room in the buffer or
+	// because they reached the span limit.
+	evicted int64
+	// released holds the kept spans of evicted traces.
+	released ptrace.Traces
+}
+
+// tailSampler buffers spans by trace ID and decides each trace as a unit
+// once its decision wait has elapsed. Traces are decided in arrival order,
+// which is also the order their waits expire in. It is safe for concurrent
+// use.
+type tailSampler struct {
+	wait      time.Duration
+	maxTraces int
+	maxSpans  int
+	matchAll  map[string]bool
+	now       func() time.Time
+
+	// decide evaluates a whole trace. It is called with mu held.
+	decide func(ctx context.Context, t *pendingTrace) tailDecision
+	// dropped, when set, is called for every span dropped by a decision.
+	// It is called with mu held.
+	dropped func(resource pcommon.Resource, span ptrace.Span)
+
+	mu      sync.Mutex
+	batches uint64
+	pending map[pcommon.TraceID]*pendingTrace
+	queue   []*pendingTrace
+
+	// decided remembers recent decisions for late spans. decidedOrder is a
+	// ring buffer of the same trace IDs so the oldest is forgotten first.
+	decided      map[pcommon.TraceID]tailDecision
+	decidedOrder []pcommon.TraceID
+	decidedNext  int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newTailSampler(cfg TailSamplingConfig) *tailSampler {
+	ts := &tailSampler{
+		wait:      cfg.DecisionWait,
+		maxTraces: cfg.MaxTraces,
+		maxSpans:  cfg.MaxSpansPerTrace,
+		matchAll:  make(map[string]bool),
+		now:       time.Now,
+		pending:   make(map[pcommon.TraceID]*pendingTrace),
+		decided:   make(map[pcommon.TraceID]tailDecision),
+	}
+	if ts.wait == 0 {
+		ts.wait = defaultTailDecisionWait
+	}
+	if ts.maxTraces == 0 {
+		ts.maxTraces = defaultTailMaxTraces
+	}
+	if ts.maxSpans == 0 {
+		ts.maxSpans = defaultTailMaxSpansPerTrace
+	}
+	for _, p := range cfg.Policies {
+		if p.Match == TailMatchAll {
+			ts.matchAll[p.PolicyID] = true
+		}
+	}
+	return ts
+}
+
+// add moves the spans of td into the buffer. Spans of already decided traces
+// follow that decision: kept spans stay in td with the decision's threshold
+// written, dropped spans are removed. Spans without a trace ID stay in td.
+func (ts *tailSampler) add(ctx context.Context, td ptrace.Traces) tailAddResult {
+	res := tailAddResult{late: make(map[tailDecision]int64), released: ptrace.NewTraces()}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.batches++
+	now := ts.now()
+	src := tailSource{batch: ts.batches, resource: -1}
+
+	td.ResourceSpans().RemoveIf(func(rs ptrace.ResourceSpans) bool {
+		src.resource++
+		src.scope = -1
+		rs.ScopeSpans().RemoveIf(func(ss ptrace.ScopeSpans) bool {
+			src.scope++
+			ss.Spans().RemoveIf(func(span ptrace.Span) bool {
+				traceID := span.TraceID()
+				if traceID.IsEmpty() {
+					res.untraced++
+					return false
+				}
+				if d, ok := ts.decided[traceID]; ok {
+					res.late[d]++
+					if d.keep && d.threshold != "" {
+						TraceSet(TraceContext{Span: span}, policy.SpanSamplingThreshold(), d.threshold)
+					}
+					if !d.keep && ts.dropped != nil {
+						ts.dropped(rs.Resource(), span)
+					}
+					return !d.keep
+				}
+				t, ok := ts.pending[traceID]
+				if !ok {
+					if len(ts.pending) >= ts.maxTraces {
+						ts.releaseLocked(ctx, ts.popLocked(), res.released)
+						res.evicted++
+					}
+					t = &pendingTrace{traceID: traceID, firstSeen: now, spans: ptrace.NewTraces()}
+					ts.pending[traceID] = t
+					ts.queue = append(ts.queue, t)
+				}
+				t.append(src, rs, ss, span)
+				if t.spanCount >= ts.maxSpans {
+					delete(ts.pending, traceID)
+					t.released = true
+					ts.releaseLocked(ctx, t, res.released)
+					t.spans = ptrace.NewTraces()
+					res.evicted++
+				}
+				return true
+			})
+			return ss.Spans().Len() == 0
+		})
+		return rs.ScopeSpans().Len() == 0
+	})
+
+	return res
+}
+
+// expire decides every trace whose decision wait has elapsed and returns the
+// spans of the kept ones.
+func (ts *tailSampler) expire(ctx context.Context) ptrace.Traces {
+	out := ptrace.NewTraces()
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	now := ts.now()
+	for t := ts.headLocked(); t != nil && now.Sub(t.firstSeen) >= ts.wait; t = ts.headLocked() {
+		ts.releaseLocked(ctx, ts.popLocked(), out)
+	}
+	return out
+}
+
+// drain decides every buffered trace regardless of its wait.
+func (ts *tailSampler) drain(ctx context.Context) ptrace.Traces {
+	out := ptrace.NewTraces()
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for ts.headLocked() != nil {
+		ts.releaseLocked(ctx, ts.popLocked(), out)
+	}
+	return out
+}
+
+// headLocked returns the oldest buffered trace, or nil when none is left,
+// discarding queue entries of traces that were already released.
+// INVARIANT: ts.mu MUST be held by the caller.
+func (ts *tailSampler) headLocked() *pendingTrace {
+	for len(ts.queue) > 0 && ts.queue[0].released {
+		ts.queue[0] = nil
+		ts.queue = ts.queue[1:]
+	}
+	if len(ts.queue) == 0 {
+		return nil
+	}
+	return ts.queue[0]
+}
+
+// popLocked removes the oldest buffered trace. One MUST be buffered.
+// INVARIANT: ts.mu MUST be held by the caller.
+func (ts *tailSampler) popLocked() *pendingTrace {
+	ts.headLocked()
+	t := ts.queue[0]
+	ts.queue[0] = nil
+	ts.queue = ts.queue[1:]
+	delete(ts.pending, t.traceID)
+	return t
+}
+
+// releaseLocked decides t, remembers the decision and moves kept spans to out.
+// INVARIANT: ts.mu MUST be held by the caller.
+func (ts *tailSampler) releaseLocked(ctx context.Context, t *pendingTrace, out ptrace.Traces) {
+	d := ts.decide(ctx, t)
+	ts.rememberLocked(t.traceID, d)
+	if !d.keep {
+		if ts.dropped != nil {
+			rss := t.spans.ResourceSpans()
+			for i := range rss.Len() {
+				sss := rss.At(i).ScopeSpans()
+				for j := range sss.Len() {
+					spans := sss.At(j).Spans()
+					for k := range spans.Len() {
+						ts.dropped(rss.At(i).Resource(), spans.At(k))
+					}
+				}
+			}
+		}
+		return
+	}
+	d.apply(t.spans)
+	t.spans.ResourceSpans().MoveAndAppendTo(out.ResourceSpans())
+}
+
+// rememberLocked records a decision, forgetting the oldest one when full.
+// INVARIANT: ts.mu MUST be held by the caller.
+func (ts *tailSampler) rememberLocked(traceID pcommon.TraceID, d tailDecision) {
+	if _, ok := ts.decided[traceID]; ok {
+		ts.decided[traceID] = d
+		return
+	}
+	if len(ts.decidedOrder) < ts.maxTraces {
+		ts.decidedOrder = append(ts.decidedOrder, traceID)
+	} else {
+		delete(ts.decided, ts.decidedOrder[ts.decidedNext])
+		ts.decidedOrder[ts.decidedNext] = traceID
+		ts.decidedNext = (ts.decidedNext + 1) % ts.maxTraces
+	}
+	ts.decided[traceID] = d
+}
+
+// decideTrace applies the trace policies to a whole trace. A policy matches
+// the trace when any of its spans match, or all of them for policies
+// configured with match "all". The most restrictive matching policy decides,
+// as it does for single spans.
+func (p *policyProcessor) decideTrace(ctx context.Context, t *pendingTrace) tailDecision {
+	snapshot := p.registry.TraceSnapshot()
+	matchers := snapshot.CompiledMatchers()
+
+	var matchedSpans []int
+	var resource pcommon.Resource
+	rss := t.spans.ResourceSpans()
+	for i := range rss.Len() {
+		rs := rss.At(i)
+		if i == 0 {
+			resource = rs.Resource()
+		}
+		sss := rs.ScopeSpans()
+		for j := range sss.Len() {
+			ss := sss.At(j)
+			spans := ss.Spans()
+			for k := range spans.Len() {
+				s := traceMatchSet(snapshot, TraceContext{
+					Span:              spans.At(k),
+					Resource:          rs.Resource(),
+					Scope:             ss.Scope(),
+					ResourceSchemaURL: rs.SchemaUrl(),
+					ScopeSchemaURL:    ss.SchemaUrl(),
+				}, false)
+				if s == nil {
+					continue
+				}
+				if matchedSpans == nil {
+					matchedSpans = make([]int, matchers.PolicyCount())
+				}
+				for idx := range matchedSpans {
+					if s.matched(idx, matchers.PolicyByIndex(idx).MatcherCount) {
+						matchedSpans[idx]++
+					}
+				}
+			}
+		}
+	}
+
+	n := t.spanCount
+	cd := containerDecision{action: actionKeep, winner: -1}
+	var ids []string
+	best := -1
+	for idx, count := range matchedSpans {
+		pol := matchers.PolicyByIndex(idx)
+		if count == 0 || (p.tail.matchAll[pol.ID] && count < n) {
+			continue
+		}
+		cd.matched = append(cd.matched, idx)
+		ids = append(ids, pol.ID)
+		if r := pol.Keep.Restrictiveness(); r > best {
+			best = r
+			cd.winner = idx
+		}
+	}
+	var size int
+	if p.attributeRecords() {
+		for i := range rss.Len() {
+			sss := rss.At(i).ScopeSpans()
+			for j := range sss.Len() {
+				spans := sss.At(j).Spans()
+				for k := range spans.Len() {
+					size += spanSize(spans.At(k))
+				}
+			}
+		}
+	}
+	if cd.winner < 0 {
+		p.recordResults(ctx, "traces", "no_match", int64(n))
+		p.recordSettledBytes(ctx, "traces", "no_match", policyMatches{}, n, size, size)
+		return tailDecision{keep: true, result: "no_match"}
+	}
+
+	winner := matchers.PolicyByIndex(cd.winner)
+	d := tailDecision{keep: true, result: "kept"}
+	switch winner.Keep.Action {
+	case policy.KeepNone:
+		d = tailDecision{result: "dropped"}
+	case policy.KeepSample:
+		// As for single spans, a trace whose randomness is below the
+		// threshold its spans already carry is kept without writing one.
+		threshold := rejectionThreshold(winner.Keep.Value)
+		randomness, consistent := tailRandomness(t)
+		switch {
+		case !consistent:
+		case randomness < threshold:
+			d = tailDecision{result: "dropped"}
+		default:
+			d.threshold = encodeThreshold(threshold, winner.Keep.SamplingPrecision)
+			d.result = "transformed"
+		}
+	case policy.KeepAll:
+		d.threshold = "0"
+		d.result = "transformed"
+	case policy.KeepRatePerSecond, policy.KeepRatePerMinute:
+		if winner.RateLimiter != nil && !winner.RateLimiter.ShouldKeep() {
+			d = tailDecision{result: "dropped"}
+		}
+	}
+	if d.keep && p.rateLimiter != nil && !p.rateLimiter.allow(ids, resource) {
+		d = tailDecision{result: resultRateLimited}
+	}
+
+	if !d.keep && d.result == "dropped" {
+		cd.action = actionDrop
+	}
+	recordContainerStats(snapshot, cd, n)
+	p.recordResults(ctx, "traces", d.result, int64(n))
+	kept := 0
+	if d.keep {
+		kept = size
+	}
+	p.recordSettledBytes(ctx, "traces", d.result, policyMatches{IDs: ids, Winner: winner.ID, WinnerKeep: winner.Keep.Action}, n, size, kept)
+	p.recordTraceDecision(t.traceID, d.keep)
+	return d
+}
+
+// tailRandomness returns the sampling randomness of a trace: the explicit
+// randomness of its first span carrying one, otherwise its trace ID. It
+// reports false when that span's tracestate threshold exceeds its
+// randomness, which the engine treats as inconsistent.
+func tailRandomness(t *pendingTrace) (uint64, bool) {
+	rss := t.spans.ResourceSpans()
+	for i := range rss.Len() {
+		sss := rss.At(i).ScopeSpans()
+		for j := range sss.Len() {
+			spans := sss.At(j).Spans()
+			for k := range spans.Len() {
+				tracestate := spans.At(k).TraceState().AsRaw()
+				if _, ok := otTracestateValue(tracestate, "rv"); !ok {
+					continue
+				}
+				r := spanRandomness(spans.At(k))
+				if th, ok := otTracestateValue(tracestate, "th"); ok {
+					if threshold, ok := parseThreshold(th); ok && r < threshold {
+						return r, false
+					}
+				}
+				return r, true
+			}
+		}
+	}
+	return traceIDRandomness(t.traceID), true
+}
+
+// tailUnsupported returns why a trace policy cannot decide whole traces, or
+// "" when it can. Percentage sampling of a trace derives its randomness from
+// the trace ID or an explicit rv, as the default hash_seed mode does without
+// a seed; seeded and downstream sampling modes are not supported.
+func tailUnsupported(pol *policyv1.Policy) string {
+	keep := pol.GetTrace().GetKeep()
+	if keep == nil {
+		return ""
+	}
+	switch keep.GetMode() {
+	case policyv1.SamplingMode_SAMPLING_MODE_PROPORTIONAL, policyv1.SamplingMode_SAMPLING_MODE_EQUALIZING:
+		return "sampling mode " + keep.GetMode().String() + " is not supported with tail sampling"
+	}
+	if keep.GetHashSeed() != 0 {
+		return "hash_seed is not supported with tail sampling"
+	}
+	return ""
+}
+
+// tailSampleTraces buffers td for tail sampling. Only spans that can be
+// forwarded right away are returned: late spans of kept traces, spans
+// without a trace ID, and kept traces evicted from a full buffer.
+func (p *policyProcessor) tailSampleTraces(ctx context.Context, td ptrace.Traces) (ptrace.Traces, error) {
+	res := p.tail.add(ctx, td)
+
+	for d, n := range res.late {
+		p.recordResults(ctx, "traces", d.result, n)
+		if p.telemetry != nil {
+			result := "kept"
+			if !d.keep {
+				result = "dropped"
+			}
+			p.telemetry.ProcessorPolicyTailLateSpans.Add(ctx, n, metric.WithAttributes(attrResult.String(result)))
+		}
+	}
+	p.recordResults(ctx, "traces", "no_match", res.untraced)
+	if p.telemetry != nil && res.evicted > 0 {
+		p.telemetry.ProcessorPolicyTailEvictedTraces.Add(ctx, res.evicted)
+	}
+
+	res.released.ResourceSpans().MoveAndAppendTo(td.ResourceSpans())
+	if td.ResourceSpans().Len() == 0 {
+		return td, processorhelper.ErrSkipProcessingData
+	}
+	return td, nil
+}
+
+// startTailSampling releases expired traces to the next consumer in the
+// background until shutdown.
+func (p *policyProcessor) startTailSampling() {
+	ts := p.tail
+	ts.stop = make(chan struct{})
+	ts.done = make(chan struct{})
+	go func() {
+		defer close(ts.done)
+		ticker := time.NewTicker(min(ts.wait, time.Second))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ts.stop:
+				return
+			case <-ticker.C:
+				p.forwardTraces(context.Background(), ts.expire(context.Background()))
+			}
+		}
+	}()
+}
+
+// stopTailSampling stops the release loop and forwards every buffered trace
+// that is kept.
+func (p *policyProcessor) stopTailSampling(ctx context.Context) {
+	ts := p.tail
+	if ts.stop != nil {
+		close(ts.stop)
+		<-ts.done
+		ts.stop = nil
+	}
+	p.forwardTraces(ctx, ts.drain(ctx))
+}
+
+// forwardTraces sends released traces to the next consumer.
+func (p *policyProcessor) forwardTraces(ctx context.Context, td ptrace.Traces) {
+	if td.ResourceSpans().Len() == 0 || p.nextTraces == nil {
+		return
+	}
+	if err := p.nextTraces.ConsumeTraces(ctx, td); err != nil {
+		p.logger.Error("Failed to forward tail sampled traces", zap.Error(err))
+	}
+}
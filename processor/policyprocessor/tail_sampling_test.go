@@ -0,0 +1,393 @@
+package policyprocessor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	policyv1 "github.com/usetero/policy-go/proto/tero/policy/v1"
+	"github.com/usetero/tero-collector-distro/processor/policyprocessor/internal/metadatatest"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/collector/processor/processorhelper"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata/metricdatatest"
+)
+
+// dropHealthyTracesPolicy drops spans that did not error.
+func dropHealthyTracesPolicy() *policyv1.Policy {
+	return &policyv1.Policy{
+		Id:      "drop-healthy",
+		Enabled: true,
+		Target: &policyv1.Policy_Trace{
+			Trace: &policyv1.TraceTarget{
+				Match: []*policyv1.TraceMatcher{
+					{
+						Field:  &policyv1.TraceMatcher_SpanStatus{SpanStatus: policyv1.SpanStatusCode_SPAN_STATUS_CODE_ERROR},
+						Negate: true,
+					},
+				},
+				Keep: dropConfig(),
+			},
+		},
+	}
+}
+
+type tailTestProcessor struct {
+	*policyProcessor
+	sink  *consumertest.TracesSink
+	clock *time.Time
+}
+
+func newTailTestProcessor(t *testing.T, policies []*policyv1.Policy, cfg TailSamplingConfig) tailTestProcessor {
+	p := createTestTraceProcessor(t, policies)
+	clock := time.Unix(1700000000, 0)
+	sink := new(consumertest.TracesSink)
+
+	p.tail = newTailSampler(cfg)
+	p.tail.now = func() time.Time { return clock }
+	p.tail.decide = p.decideTrace
+	p.nextTraces = sink
+	return tailTestProcessor{policyProcessor: p, sink: sink, clock: &clock}
+}
+
+// release advances the clock past the decision wait and releases every
+// expired trace to the sink.
+func (tp tailTestProcessor) release() {
+	*tp.clock = tp.clock.Add(tp.tail.wait)
+	tp.forwardTraces(context.Background(), tp.tail.expire(context.Background()))
+}
+
+// appendSpan adds a span of the given trace to td under a single resource.
+func appendSpan(td ptrace.Traces, traceID pcommon.TraceID, name string, errored bool) ptrace.Span {
+	if td.ResourceSpans().Len() == 0 {
+		rs := td.ResourceSpans().AppendEmpty()
+		rs.Resource().Attributes().PutStr("service.name", "cart")
+		rs.ScopeSpans().AppendEmpty().Scope().SetName("tracer")
+	}
+	span := td.ResourceSpans().At(0).ScopeSpans().At(0).Spans().AppendEmpty()
+	span.SetTraceID(traceID)
+	span.SetName(name)
+	if errored {
+		span.Status().SetCode(ptrace.StatusCodeError)
+	}
+	return span
+}
+
+// spanNamesByTrace returns the names of all spans in the sink, by trace ID.
+func spanNamesByTrace(sink *consumertest.TracesSink) map[pcommon.TraceID][]string {
+	out := make(map[pcommon.TraceID][]string)
+	for _, td := range sink.AllTraces() {
+		rss := td.ResourceSpans()
+		for i := range rss.Len() {
+			sss := rss.At(i).ScopeSpans()
+			for j := range sss.Len() {
+				spans := sss.At(j).Spans()
+				for k := range spans.Len() {
+					out[spans.At(k).TraceID()] = append(out[spans.At(k).TraceID()], spans.At(k).Name())
+				}
+			}
+		}
+	}
+	return out
+}
+
+func TestTailSampling_KeepsErroredTracesWhole(t *testing.T) {
+	tp := newTailTestProcessor(t, []*policyv1.Policy{dropHealthyTracesPolicy()}, TailSamplingConfig{
+		Enabled:  true,
+		Policies: []TailSamplingPolicyConfig{{PolicyID: "drop-healthy", Match: TailMatchAll}},
+	})
+	tel := withTelemetry(t, tp.policyProcessor)
+	errored, healthy := testTraceID(1), testTraceID(2)
+
+	td := ptrace.NewTraces()
+	appendSpan(td, errored, "checkout", false)
+	appendSpan(td, healthy, "browse", false)
+	appendSpan(td, errored, "charge", true)
+	_, err := tp.processTraces(context.Background(), td)
+	require.ErrorIs(t, err, processorhelper.ErrSkipProcessingData)
+
+	// A span of the errored trace arriving in a later batch is decided with it.
+	td = ptrace.NewTraces()
+	appendSpan(td, errored, "receipt", false)
+	_, err = tp.processTraces(context.Background(), td)
+	require.ErrorIs(t, err, processorhelper.ErrSkipProcessingData)
+	assert.Empty(t, tp.sink.AllTraces(), "nothing is released before the decision wait")
+
+	tp.release()
+	assert.Equal(t, map[pcommon.TraceID][]string{
+		errored: {"checkout", "charge", "receipt"},
+	}, spanNamesByTrace(tp.sink))
+
+	metadatatest.AssertEqualProcessorPolicyRecords(t, tel, []metricdata.DataPoint[int64]{
+		{Attributes: attribute.NewSet(attrTelemetryType.String("traces"), attrResult.String("dropped")), Value: 1},
+		{Attributes: attribute.NewSet(attrTelemetryType.String("traces"), attrResult.String("no_match")), Value: 3},
+	}, metricdatatest.IgnoreTimestamp())
+}
+
+func TestTailSampling_MatchAnyDropsWholeTrace(t *testing.T) {
+	tp := newTailTestProcessor(t, []*policyv1.Policy{dropHealthyTracesPolicy()}, TailSamplingConfig{Enabled: true})
+	errored, healthy := testTraceID(1), testTraceID(2)
+
+	td := ptrace.NewTraces()
+	appendSpan(td, errored, "checkout", false)
+	appendSpan(td, errored, "charge", true)
+	appendSpan(td, healthy, "browse", false)
+	_, err := tp.processTraces(context.Background(), td)
+	require.ErrorIs(t, err, processorhelper.ErrSkipProcessingData)
+
+	tp.release()
+	assert.Empty(t, spanNamesByTrace(tp.sink), "a single healthy span drops its trace in any mode")
+}
+
+func TestTailSampling_SamplesWholeTraces(t *testing.T) {
+	tp := newTailTestProcessor(t, []*policyv1.Policy{
+		{
+			Id:      "sample",
+			Enabled: true,
+			Target: &policyv1.Policy_Trace{
+				Trace: &policyv1.TraceTarget{
+					Match: []*policyv1.TraceMatcher{
+						{
+							Field: &policyv1.TraceMatcher_ResourceAttribute{ResourceAttribute: &policyv1.AttributePath{Path: []string{"service.name"}}},
+							Match: &policyv1.TraceMatcher_Exact{Exact: "cart"},
+						},
+					},
+					Keep: &policyv1.TraceSamplingConfig{Percentage: 50},
+				},
+			},
+		},
+	}, TailSamplingConfig{Enabled: true})
+
+	td := ptrace.NewTraces()
+	for i := range 100 {
+		appendSpan(td, testTraceID(i), "parent", false)
+		appendSpan(td, testTraceID(i), "child", false)
+	}
+	_, err := tp.processTraces(context.Background(), td)
+	require.ErrorIs(t, err, processorhelper.ErrSkipProcessingData)
+	tp.release()
+
+	kept := spanNamesByTrace(tp.sink)
+	assert.InDelta(t, 50, len(kept), 20)
+	for i := range 100 {
+		id := testTraceID(i)
+		if traceIDRandomness(id) >= rejectionThreshold(50) {
+			assert.Equal(t, []string{"parent", "child"}, kept[id])
+		} else {
+			assert.NotContains(t, kept, id)
+		}
+	}
+
+	for _, out := range tp.sink.AllTraces() {
+		spans := out.ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+		for k := range spans.Len() {
+			assert.Equal(t, "ot=th:8", spans.At(k).TraceState().AsRaw())
+		}
+	}
+}
+
+func TestTailSampling_LateSpansFollowDecision(t *testing.T) {
+	tp := newTailTestProcessor(t, []*policyv1.Policy{dropHealthyTracesPolicy()}, TailSamplingConfig{
+		Enabled:  true,
+		Policies: []TailSamplingPolicyConfig{{PolicyID: "drop-healthy", Match: TailMatchAll}},
+	})
+	tel := withTelemetry(t, tp.policyProcessor)
+	errored, healthy := testTraceID(1), testTraceID(2)
+
+	td := ptrace.NewTraces()
+	appendSpan(td, errored, "charge", true)
+	appendSpan(td, healthy, "browse", false)
+	_, err := tp.processTraces(context.Background(), td)
+	require.ErrorIs(t, err, processorhelper.ErrSkipProcessingData)
+	tp.release()
+
+	late := ptrace.NewTraces()
+	appendSpan(late, errored, "late-kept", false)
+	appendSpan(late, healthy, "late-dropped", false)
+	appendSpan(late, healthy, "late-dropped-too", false)
+	out, err := tp.processTraces(context.Background(), late)
+	require.NoError(t, err)
+
+	spans := out.ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+	require.Equal(t, 1, spans.Len())
+	assert.Equal(t, "late-kept", spans.At(0).Name())
+
+	metadatatest.AssertEqualProcessorPolicyTailLateSpans(t, tel, []metricdata.DataPoint[int64]{
+		{Attributes: attribute.NewSet(attrResult.String("kept")), Value: 1},
+		{Attributes: attribute.NewSet(attrResult.String("dropped")), Value: 2},
+	}, metricdatatest.IgnoreTimestamp())
+}
+
+func TestTailSampling_EvictsOldestTraceWhenFull(t *testing.T) {
+	tp := newTailTestProcessor(t, nil, TailSamplingConfig{Enabled: true, MaxTraces: 2})
+	tel := withTelemetry(t, tp.policyProcessor)
+
+	td := ptrace.NewTraces()
+	appendSpan(td, testTraceID(1), "first", false)
+	appendSpan(td, testTraceID(2), "second", false)
+	appendSpan(td, testTraceID(3), "third", false)
+	out, err := tp.processTraces(context.Background(), td)
+	require.NoError(t, err)
+
+	spans := out.ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+	require.Equal(t, 1, spans.Len())
+	assert.Equal(t, "first", spans.At(0).Name())
+	assert.Len(t, tp.tail.pending, 2)
+
+	metadatatest.AssertEqualProcessorPolicyTailEvictedTraces(t, tel, []metricdata.DataPoint[int64]{
+		{Value: 1},
+	}, metricdatatest.IgnoreTimestamp())
+}
+
+func TestTailSampling_DecidesTraceAtSpanLimit(t *testing.T) {
+	tp := newTailTestProcessor(t, nil, TailSamplingConfig{Enabled: true, MaxSpansPerTrace: 2})
+	tel := withTelemetry(t, tp.policyProcessor)
+
+	td := ptrace.NewTraces()
+	appendSpan(td, testTraceID(1), "first", false)
+	appendSpan(td, testTraceID(2), "other", false)
+	appendSpan(td, testTraceID(1), "second", false)
+	appendSpan(td, testTraceID(1), "late", false)
+	out, err := tp.processTraces(context.Background(), td)
+	require.NoError(t, err)
+
+	// The full trace is released right away; its later span follows it.
+	var names []string
+	rss := out.ResourceSpans()
+	for i := range rss.Len() {
+		spans := rss.At(i).ScopeSpans().At(0).Spans()
+		for k := range spans.Len() {
+			names = append(names, spans.At(k).Name())
+		}
+	}
+	assert.ElementsMatch(t, []string{"first", "second", "late"}, names)
+	assert.Len(t, tp.tail.pending, 1)
+
+	tp.release()
+	assert.Equal(t, map[pcommon.TraceID][]string{testTraceID(2): {"other"}}, spanNamesByTrace(tp.sink))
+	assert.Empty(t, tp.tail.queue)
+
+	metadatatest.AssertEqualProcessorPolicyTailEvictedTraces(t, tel, []metricdata.DataPoint[int64]{
+		{Value: 1},
+	}, metricdatatest.IgnoreTimestamp())
+}
+
+func TestTailSampling_InconsistentThresholdKeepsTrace(t *testing.T) {
+	tp := newTailTestProcessor(t, []*policyv1.Policy{
+		{
+			Id:      "sample",
+			Enabled: true,
+			Target: &policyv1.Policy_Trace{
+				Trace: &policyv1.TraceTarget{
+					Match: []*policyv1.TraceMatcher{
+						{
+							Field: &policyv1.TraceMatcher_TraceField{TraceField: policyv1.TraceField_TRACE_FIELD_NAME},
+							Match: &policyv1.TraceMatcher_Exact{Exact: "checkout"},
+						},
+					},
+					Keep: &policyv1.TraceSamplingConfig{Percentage: 50},
+				},
+			},
+		},
+	}, TailSamplingConfig{Enabled: true})
+
+	// The randomness is below the incoming threshold, which the engine
+	// keeps without writing a threshold.
+	td := ptrace.NewTraces()
+	appendSpan(td, testTraceID(1), "checkout", false).TraceState().FromRaw("ot=rv:00000000000001;th:8")
+	_, err := tp.processTraces(context.Background(), td)
+	require.ErrorIs(t, err, processorhelper.ErrSkipProcessingData)
+	tp.release()
+
+	require.Len(t, tp.sink.AllTraces(), 1)
+	span := tp.sink.AllTraces()[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0)
+	assert.Equal(t, "ot=rv:00000000000001;th:8", span.TraceState().AsRaw())
+}
+
+func TestTailSampling_RejectsUnsupportedSamplingModes(t *testing.T) {
+	samplePolicy := func(id string, keep *policyv1.TraceSamplingConfig) *policyv1.Policy {
+		return &policyv1.Policy{
+			Id:      id,
+			Enabled: true,
+			Target: &policyv1.Policy_Trace{
+				Trace: &policyv1.TraceTarget{
+					Match: []*policyv1.TraceMatcher{
+						{
+							Field: &policyv1.TraceMatcher_TraceField{TraceField: policyv1.TraceField_TRACE_FIELD_NAME},
+							Match: &policyv1.TraceMatcher_Exact{Exact: "checkout"},
+						},
+					},
+					Keep: keep,
+				},
+			},
+		}
+	}
+	seed := uint32(7)
+	tp := newTailTestProcessor(t, nil, TailSamplingConfig{Enabled: true})
+	tp.inventory = newProviderInventory()
+	tp.registry.SetOnRecompile(tp.inventory.setRecompileErr)
+
+	state := tp.inventory.add("static")
+	_, err := tp.registry.Register(&trackedProvider{
+		PolicyProvider: &staticTraceProvider{policies: []*policyv1.Policy{
+			samplePolicy("default", &policyv1.TraceSamplingConfig{Percentage: 50}),
+			samplePolicy("proportional", &policyv1.TraceSamplingConfig{Percentage: 50, Mode: policyv1.SamplingMode_SAMPLING_MODE_PROPORTIONAL.Enum()}),
+			samplePolicy("equalizing", &policyv1.TraceSamplingConfig{Percentage: 50, Mode: policyv1.SamplingMode_SAMPLING_MODE_EQUALIZING.Enum()}),
+			samplePolicy("seeded", &policyv1.TraceSamplingConfig{Percentage: 50, HashSeed: &seed}),
+		}},
+		p:     tp.policyProcessor,
+		state: state,
+	})
+	require.NoError(t, err)
+
+	snapshot := tp.registry.TraceSnapshot()
+	for _, id := range []string{"proportional", "equalizing", "seeded"} {
+		_, ok := snapshot.GetPolicy(id)
+		assert.False(t, ok, id)
+	}
+	_, ok := snapshot.GetPolicy("default")
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), state.compileErrors)
+}
+
+func TestTailSampling_UntracedSpansPassThrough(t *testing.T) {
+	tp := newTailTestProcessor(t, []*policyv1.Policy{dropHealthyTracesPolicy()}, TailSamplingConfig{Enabled: true})
+
+	td := ptrace.NewTraces()
+	appendSpan(td, pcommon.NewTraceIDEmpty(), "untraced", false)
+	appendSpan(td, testTraceID(1), "buffered", false)
+	out, err := tp.processTraces(context.Background(), td)
+	require.NoError(t, err)
+
+	spans := out.ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+	require.Equal(t, 1, spans.Len())
+	assert.Equal(t, "untraced", spans.At(0).Name())
+}
+
+func TestTailSampling_ShutdownReleasesBufferedTraces(t *testing.T) {
+	tp := newTailTestProcessor(t, nil, TailSamplingConfig{Enabled: true, DecisionWait: time.Hour})
+	tp.startTailSampling()
+
+	td := ptrace.NewTraces()
+	appendSpan(td, testTraceID(1), "pending", false)
+	_, err := tp.processTraces(context.Background(), td)
+	require.ErrorIs(t, err, processorhelper.ErrSkipProcessingData)
+
+	tp.stopTailSampling(context.Background())
+	assert.Equal(t, map[pcommon.TraceID][]string{testTraceID(1): {"pending"}}, spanNamesByTrace(tp.sink))
+}
+
+func TestTailSampler_ForgetsOldestDecision(t *testing.T) {
+	ts := newTailSampler(TailSamplingConfig{MaxTraces: 2})
+	for i := range 3 {
+		ts.rememberLocked(testTraceID(i), tailDecision{keep: true})
+	}
+	assert.NotContains(t, ts.decided, testTraceID(0))
+	assert.Contains(t, ts.decided, testTraceID(1))
+	assert.Contains(t, ts.decided, testTraceID(2))
+}
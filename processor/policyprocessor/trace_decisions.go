@@ -0,0 +1,428 @@
+package policyprocessor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/usetero/policy-go/policy"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultTraceDecisionTTL is used when TraceDecisionsConfig.TTL is unset.
+	defaultTraceDecisionTTL = 5 * time.Minute
+
+	// defaultTraceDecisionMaxTraces is used when
+	// TraceDecisionsConfig.MaxTraces is unset.
+	defaultTraceDecisionMaxTraces = 100000
+
+	// defaultTraceDecisionLogWait is used when TraceDecisionsConfig.LogWait
+	// is unset.
+	defaultTraceDecisionLogWait = 5 * time.Second
+
+	// maxHeldLogRecords bounds the log records held back waiting for their
+	// trace. Beyond it, records keep the log policies' decision right away.
+	maxHeldLogRecords = 50000
+)
+
+// traceDecisionCaches shares a traceDecisionCache between the traces and logs
+// instances created for the same processor ID.
+var traceDecisionCaches = newSharedRegistry[*traceDecisionCache]()
+
+// traceDecision is the keep or drop decision recorded for a trace.
+type traceDecision struct {
+	keep    bool
+	expires time.Time
+}
+
+// heldRecord is what a held log record's release accounts for: the log
+// policies' decision, applied if its trace is not decided in time, and how
+// the record was attributed when it was held.
+type heldRecord struct {
+	result string
+	// matches holds the winning policy; the matching IDs are not kept.
+	matches policyMatches
+	size    int
+	capture *logCapture
+}
+
+// heldBatch holds log records grouped under their resource and scope, with a
+// heldRecord for each in the same order. Consecutive records sharing a
+// resource and scope share a ResourceLogs and ScopeLogs.
+type heldBatch struct {
+	logs    plog.Logs
+	records []heldRecord
+}
+
+func newHeldBatch() heldBatch {
+	return heldBatch{logs: plog.NewLogs()}
+}
+
+// add moves the record of ctx into the batch.
+func (b *heldBatch) add(ctx LogContext, rec heldRecord) {
+	rls := b.logs.ResourceLogs()
+	var rl plog.ResourceLogs
+	if n := rls.Len(); n > 0 && sameResource(rls.At(n-1), ctx) {
+		rl = rls.At(n - 1)
+	} else {
+		rl = rls.AppendEmpty()
+		ctx.Resource.CopyTo(rl.Resource())
+		rl.SetSchemaUrl(ctx.ResourceSchemaURL)
+	}
+	sls := rl.ScopeLogs()
+	var sl plog.ScopeLogs
+	if n := sls.Len(); n > 0 && sameScope(sls.At(n-1), ctx) {
+		sl = sls.At(n - 1)
+	} else {
+		sl = sls.AppendEmpty()
+		ctx.Scope.CopyTo(sl.Scope())
+		sl.SetSchemaUrl(ctx.ScopeSchemaURL)
+	}
+	ctx.Record.MoveTo(sl.LogRecords().AppendEmpty())
+	b.records = append(b.records, rec)
+}
+
+// moveTo moves every record of the batch to the end of dst. A non-empty
+// result replaces the records' own.
+func (b *heldBatch) moveTo(dst *heldBatch, result string) {
+	b.logs.ResourceLogs().MoveAndAppendTo(dst.logs.ResourceLogs())
+	for _, rec := range b.records {
+		if result != "" {
+			rec.result = result
+		}
+		dst.records = append(dst.records, rec)
+	}
+	b.records = nil
+}
+
+// each calls fn for every record in the batch, in order.
+func (b *heldBatch) each(fn func(LogContext, heldRecord)) {
+	i := 0
+	rls := b.logs.ResourceLogs()
+	for r := range rls.Len() {
+		rl := rls.At(r)
+		sls := rl.ScopeLogs()
+		for s := range sls.Len() {
+			sl := sls.At(s)
+			lrs := sl.LogRecords()
+			for l := range lrs.Len() {
+				fn(LogContext{
+					Record:            lrs.At(l),
+					Resource:          rl.Resource(),
+					Scope:             sl.Scope(),
+					ResourceSchemaURL: rl.SchemaUrl(),
+					ScopeSchemaURL:    sl.SchemaUrl(),
+				}, b.records[i])
+				i++
+			}
+		}
+	}
+}
+
+func sameResource(rl plog.ResourceLogs, ctx LogContext) bool {
+	res := rl.Resource()
+	return rl.SchemaUrl() == ctx.ResourceSchemaURL &&
+		res.DroppedAttributesCount() == ctx.Resource.DroppedAttributesCount() &&
+		res.Attributes().Equal(ctx.Resource.Attributes())
+}
+
+func sameScope(sl plog.ScopeLogs, ctx LogContext) bool {
+	scope := sl.Scope()
+	return sl.SchemaUrl() == ctx.ScopeSchemaURL &&
+		scope.Name() == ctx.Scope.Name() &&
+		scope.Version() == ctx.Scope.Version() &&
+		scope.DroppedAttributesCount() == ctx.Scope.DroppedAttributesCount() &&
+		scope.Attributes().Equal(ctx.Scope.Attributes())
+}
+
+// heldLogs are the log records of a trace waiting for its decision, split by
+// the decision the log policies made for them.
+type heldLogs struct {
+	since   time.Time
+	kept    heldBatch
+	dropped heldBatch
+	count   int
+}
+
+// heldLogsRelease is the outcome of settling held log records. The result of
+// each record is its final one.
+type heldLogsRelease struct {
+	// kept holds the records to forward.
+	kept heldBatch
+	// dropped holds the records that were dropped.
+	dropped heldBatch
+	// results counts the settled records by telemetry result.
+	results map[string]int64
+}
+
+// traceDecisionCache remembers which traces the traces pipeline kept or
+// dropped so that logs can follow them. Logs arriving before their trace is
+// decided are held until the decision is made or the log wait elapses. It is
+// safe for concurrent use.
+type traceDecisionCache struct {
+	ttl       time.Duration
+	maxTraces int
+	logWait   time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	decisions map[pcommon.TraceID]traceDecision
+	// order lists the trace IDs in decisions by insertion, which is also
+	// expiry order since every decision lives for ttl.
+	order     []pcommon.TraceID
+	held      map[pcommon.TraceID]*heldLogs
+	heldCount int
+}
+
+func newTraceDecisionCache(cfg TraceDecisionsConfig) *traceDecisionCache {
+	c := &traceDecisionCache{
+		ttl:       cfg.TTL,
+		maxTraces: cfg.MaxTraces,
+		logWait:   cfg.LogWait,
+		now:       time.Now,
+		decisions: make(map[pcommon.TraceID]traceDecision),
+		held:      make(map[pcommon.TraceID]*heldLogs),
+	}
+	if c.ttl == 0 {
+		c.ttl = defaultTraceDecisionTTL
+	}
+	if c.maxTraces == 0 {
+		c.maxTraces = defaultTraceDecisionMaxTraces
+	}
+	if c.logWait == 0 {
+		c.logWait = defaultTraceDecisionLogWait
+	}
+	return c
+}
+
+// record notes that a span of the trace was kept or dropped. A trace counts
+// as kept once any of its spans is kept.
+func (c *traceDecisionCache) record(traceID pcommon.TraceID, keep bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if d, ok := c.decisions[traceID]; ok && now.Before(d.expires) {
+		if keep && !d.keep {
+			d.keep = true
+			c.decisions[traceID] = d
+		}
+		return
+	}
+
+	c.pruneLocked(now)
+	if _, ok := c.decisions[traceID]; !ok {
+		c.order = append(c.order, traceID)
+	}
+	c.decisions[traceID] = traceDecision{keep: keep, expires: now.Add(c.ttl)}
+}
+
+// pruneLocked forgets expired decisions, and the oldest ones while the cache
+// is full.
+// INVARIANT: c.mu MUST be held by the caller.
+func (c *traceDecisionCache) pruneLocked(now time.Time) {
+	for len(c.order) > 0 {
+		d := c.decisions[c.order[0]]
+		if now.Before(d.expires) && len(c.decisions) < c.maxTraces {
+			return
+		}
+		delete(c.decisions, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// lookup returns the recorded decision for the trace.
+func (c *traceDecisionCache) lookup(traceID pcommon.TraceID) (keep, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lookupLocked(traceID, c.now())
+}
+
+// INVARIANT: c.mu MUST be held by the caller.
+func (c *traceDecisionCache) lookupLocked(traceID pcommon.TraceID, now time.Time) (keep, ok bool) {
+	d, ok := c.decisions[traceID]
+	if !ok || !now.Before(d.expires) {
+		return false, false
+	}
+	return d.keep, true
+}
+
+// hold moves a log record into the cache until its trace is decided. keep
+// and rec.result are the log policies' decision, applied if the trace is not
+// decided in time. It reports false when the cache is full, leaving the
+// record in place.
+func (c *traceDecisionCache) hold(ctx LogContext, keep bool, rec heldRecord) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.heldCount >= maxHeldLogRecords {
+		return false
+	}
+	traceID := ctx.Record.TraceID()
+	h, ok := c.held[traceID]
+	if !ok {
+		h = &heldLogs{
+			since:   c.now(),
+			kept:    newHeldBatch(),
+			dropped: newHeldBatch(),
+		}
+		c.held[traceID] = h
+	}
+	if keep {
+		h.kept.add(ctx, rec)
+	} else {
+		rec.result = "dropped"
+		h.dropped.add(ctx, rec)
+	}
+	h.count++
+	c.heldCount++
+	return true
+}
+
+// settle releases held records whose trace has been decided, and those that
+// waited longer than the log wait, which keep the log policies' decision.
+// With all set, every held record is settled. Records held as dropped are
+// released as they are for a kept trace: the engine transforms only the
+// records it keeps.
+func (c *traceDecisionCache) settle(all bool) heldLogsRelease {
+	rel := heldLogsRelease{
+		kept:    newHeldBatch(),
+		dropped: newHeldBatch(),
+		results: make(map[string]int64),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for traceID, h := range c.held {
+		keep, decided := c.lookupLocked(traceID, now)
+		switch {
+		case decided && keep:
+			h.kept.moveTo(&rel.kept, "kept")
+			h.dropped.moveTo(&rel.kept, "kept")
+			rel.results["kept"] += int64(h.count)
+		case decided:
+			h.kept.moveTo(&rel.dropped, "dropped")
+			h.dropped.moveTo(&rel.dropped, "dropped")
+			rel.results["dropped"] += int64(h.count)
+		case all || now.Sub(h.since) >= c.logWait:
+			for _, rec := range h.kept.records {
+				rel.results[rec.result]++
+			}
+			rel.results["dropped"] += int64(len(h.dropped.records))
+			h.kept.moveTo(&rel.kept, "")
+			h.dropped.moveTo(&rel.dropped, "")
+		default:
+			continue
+		}
+		c.heldCount -= h.count
+		delete(c.held, traceID)
+	}
+	return rel
+}
+
+// recordTraceDecision records whether a span was kept for logs to follow.
+// Only spans a policy matched decide their trace: logs of traces no policy
+// matched keep the log policies' decision.
+func (p *policyProcessor) recordTraceDecision(traceID pcommon.TraceID, keep bool) {
+	if p.traceDecisions == nil || traceID.IsEmpty() {
+		return
+	}
+	p.traceDecisions.record(traceID, keep)
+}
+
+// followTraceDecision applies the decision recorded for the log record's
+// trace. It reports false when the record has no trace ID or its trace has
+// not been decided. A dropped record is kept for a kept trace as is, so the
+// caller MUST NOT pass dropped records that matched a transform policy.
+func (p *policyProcessor) followTraceDecision(lr plog.LogRecord, result policy.EvaluateResult) (policy.EvaluateResult, bool) {
+	traceID := lr.TraceID()
+	if traceID.IsEmpty() {
+		return result, false
+	}
+	keep, ok := p.traceDecisions.lookup(traceID)
+	switch {
+	case !ok:
+		return result, false
+	case !keep:
+		return policy.ResultDrop, true
+	case result == policy.ResultDrop:
+		return policy.ResultKeep, true
+	default:
+		return result, true
+	}
+}
+
+// logTransformsMatched reports whether any of the log policies in ids
+// transforms the records it matches.
+func logTransformsMatched(snapshot *policy.LogSnapshot, ids []string) bool {
+	for _, id := range ids {
+		if pol, ok := snapshot.GetPolicy(id); ok && len(pol.Transforms) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// startHeldLogRelease forwards held log records in the background as their
+// traces are decided or their wait elapses, until shutdown.
+func (p *policyProcessor) startHeldLogRelease() {
+	p.heldLogsStop = make(chan struct{})
+	p.heldLogsDone = make(chan struct{})
+	go func() {
+		defer close(p.heldLogsDone)
+		ticker := time.NewTicker(min(p.traceDecisions.logWait, time.Second))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.heldLogsStop:
+				return
+			case <-ticker.C:
+				p.releaseHeldLogs(context.Background(), false)
+			}
+		}
+	}()
+}
+
+// stopHeldLogRelease stops the release loop and settles every held record.
+func (p *policyProcessor) stopHeldLogRelease(ctx context.Context) {
+	if p.heldLogsStop != nil {
+		close(p.heldLogsStop)
+		<-p.heldLogsDone
+		p.heldLogsStop = nil
+	}
+	p.releaseHeldLogs(ctx, true)
+}
+
+// releaseHeldLogs settles held log records and forwards the kept ones. The
+// records' bytes, tap events and decision log entries are recorded now that
+// their result is known.
+func (p *policyProcessor) releaseHeldLogs(ctx context.Context, all bool) {
+	rel := p.traceDecisions.settle(all)
+	for result, n := range rel.results {
+		p.recordResults(ctx, "logs", result, n)
+	}
+
+	rel.kept.each(func(logCtx LogContext, rec heldRecord) {
+		if p.attributeRecords() {
+			p.recordBytes(ctx, "logs", rec.result, rec.matches, rec.size, logRecordSize(logCtx.Record))
+		}
+		rec.capture.publishHeld(logCtx, rec.result)
+	})
+	rel.dropped.each(func(logCtx LogContext, rec heldRecord) {
+		p.recordBytes(ctx, "logs", "dropped", rec.matches, rec.size, 0)
+		rec.capture.publishHeld(logCtx, "dropped")
+		p.recordDroppedLog(rec.matches.Winner, logCtx)
+	})
+
+	if rel.kept.logs.ResourceLogs().Len() == 0 || p.nextLogs == nil {
+		return
+	}
+	if err := p.nextLogs.ConsumeLogs(ctx, rel.kept.logs); err != nil {
+		p.logger.Error("Failed to forward held logs", zap.Error(err))
+	}
+}
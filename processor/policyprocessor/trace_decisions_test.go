@@ -0,0 +1,346 @@
+package policyprocessor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	policyv1 "github.com/usetero/policy-go/proto/tero/policy/v1"
+	"github.com/usetero/tero-collector-distro/processor/policyprocessor/internal/metadatatest"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata/metricdatatest"
+)
+
+// dropSpanNamePolicy drops spans with the given name.
+func dropSpanNamePolicy(name string) *policyv1.Policy {
+	return &policyv1.Policy{
+		Id:      "drop-" + name,
+		Enabled: true,
+		Target: &policyv1.Policy_Trace{
+			Trace: &policyv1.TraceTarget{
+				Match: []*policyv1.TraceMatcher{
+					{
+						Field: &policyv1.TraceMatcher_TraceField{TraceField: policyv1.TraceField_TRACE_FIELD_NAME},
+						Match: &policyv1.TraceMatcher_Exact{Exact: name},
+					},
+				},
+				Keep: dropConfig(),
+			},
+		},
+	}
+}
+
+// keepSpanNamePolicy keeps every span with the given name.
+func keepSpanNamePolicy(name string) *policyv1.Policy {
+	pol := dropSpanNamePolicy(name)
+	pol.Id = "keep-" + name
+	pol.GetTrace().Keep = &policyv1.TraceSamplingConfig{Percentage: 100}
+	return pol
+}
+
+type traceDecisionsTestProcessors struct {
+	traces *policyProcessor
+	logs   *policyProcessor
+	sink   *consumertest.LogsSink
+	clock  *time.Time
+}
+
+// newTraceDecisionsTestProcessors builds a traces and a logs processor sharing
+// one decision cache driven by a fake clock. The traces processor keeps
+// "checkout" spans, drops "healthcheck" spans and matches no others.
+func newTraceDecisionsTestProcessors(t *testing.T, logPolicies []*policyv1.Policy, cfg TraceDecisionsConfig) traceDecisionsTestProcessors {
+	cache := newTraceDecisionCache(cfg)
+	clock := time.Unix(1700000000, 0)
+	cache.now = func() time.Time { return clock }
+
+	traces := createTestTraceProcessor(t, []*policyv1.Policy{
+		keepSpanNamePolicy("checkout"),
+		dropSpanNamePolicy("healthcheck"),
+	})
+	traces.traceDecisions = cache
+
+	sink := new(consumertest.LogsSink)
+	logs := createTestLogProcessor(t, logPolicies)
+	logs.traceDecisions = cache
+	logs.nextLogs = sink
+	return traceDecisionsTestProcessors{traces: traces, logs: logs, sink: sink, clock: &clock}
+}
+
+func (tp traceDecisionsTestProcessors) consumeSpans(t *testing.T, spans map[pcommon.TraceID]string) {
+	td := ptrace.NewTraces()
+	for traceID, name := range spans {
+		appendSpan(td, traceID, name, false)
+	}
+	_, err := tp.traces.processTraces(context.Background(), td)
+	require.NoError(t, err)
+}
+
+// newTracedLogs builds one log record per body, each with the trace ID at the
+// same index and DEBUG severity.
+func newTracedLogs(traceIDs []pcommon.TraceID, bodies ...string) plog.Logs {
+	logs := plog.NewLogs()
+	rl := logs.ResourceLogs().AppendEmpty()
+	rl.Resource().Attributes().PutStr("service.name", "cart")
+	sl := rl.ScopeLogs().AppendEmpty()
+	for i, body := range bodies {
+		lr := sl.LogRecords().AppendEmpty()
+		lr.Body().SetStr(body)
+		lr.SetSeverityText("DEBUG")
+		lr.SetTraceID(traceIDs[i])
+	}
+	return logs
+}
+
+func sinkBodies(sink *consumertest.LogsSink) []string {
+	var out []string
+	for _, logs := range sink.AllLogs() {
+		out = append(out, logBodies(logs)...)
+	}
+	return out
+}
+
+func TestTraceDecisions_LogsFollowDecidedTraces(t *testing.T) {
+	tp := newTraceDecisionsTestProcessors(t, []*policyv1.Policy{
+		logPolicy("drop-debug", "none", severityMatcher("DEBUG")),
+	}, TraceDecisionsConfig{Enabled: true})
+	tel := withTelemetry(t, tp.logs)
+	kept, dropped, unknown := testTraceID(1), testTraceID(2), testTraceID(3)
+
+	tp.consumeSpans(t, map[pcommon.TraceID]string{kept: "checkout", dropped: "healthcheck"})
+
+	logs := newTracedLogs([]pcommon.TraceID{kept, dropped, {}}, "kept", "dropped", "untraced")
+	out, err := tp.logs.processLogs(context.Background(), logs)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"kept"}, logBodies(out), "logs of a kept trace survive a dropping log policy")
+
+	// A record of an undecided trace is held rather than decided now.
+	logs = newTracedLogs([]pcommon.TraceID{unknown}, "waiting")
+	out, err = tp.logs.processLogs(context.Background(), logs)
+	require.NoError(t, err)
+	assert.Empty(t, logBodies(out))
+
+	tp.consumeSpans(t, map[pcommon.TraceID]string{unknown: "checkout"})
+	tp.logs.releaseHeldLogs(context.Background(), false)
+	assert.Equal(t, []string{"waiting"}, sinkBodies(tp.sink))
+
+	metadatatest.AssertEqualProcessorPolicyRecords(t, tel, []metricdata.DataPoint[int64]{
+		{Attributes: attribute.NewSet(attrTelemetryType.String("logs"), attrResult.String("kept")), Value: 2},
+		{Attributes: attribute.NewSet(attrTelemetryType.String("logs"), attrResult.String("dropped")), Value: 2},
+	}, metricdatatest.IgnoreTimestamp())
+}
+
+func TestTraceDecisions_HeldLogsFollowDroppedTrace(t *testing.T) {
+	tp := newTraceDecisionsTestProcessors(t, nil, TraceDecisionsConfig{Enabled: true})
+	traceID := testTraceID(1)
+
+	_, err := tp.logs.processLogs(context.Background(), newTracedLogs([]pcommon.TraceID{traceID}, "early"))
+	require.NoError(t, err)
+
+	tp.consumeSpans(t, map[pcommon.TraceID]string{traceID: "healthcheck"})
+	tp.logs.releaseHeldLogs(context.Background(), false)
+	assert.Empty(t, sinkBodies(tp.sink))
+	assert.Zero(t, tp.logs.traceDecisions.heldCount)
+}
+
+func TestTraceDecisions_UnmatchedSpansDoNotRescueDroppedLogs(t *testing.T) {
+	tp := newTraceDecisionsTestProcessors(t, []*policyv1.Policy{
+		logPolicy("drop-debug", "none", severityMatcher("DEBUG")),
+	}, TraceDecisionsConfig{Enabled: true, LogWait: 10 * time.Second})
+	traceID := testTraceID(1)
+
+	tp.consumeSpans(t, map[pcommon.TraceID]string{traceID: "browse"})
+	_, ok := tp.logs.traceDecisions.lookup(traceID)
+	assert.False(t, ok, "a span no policy matched does not decide its trace")
+
+	_, err := tp.logs.processLogs(context.Background(), newTracedLogs([]pcommon.TraceID{traceID}, "debug"))
+	require.NoError(t, err)
+	*tp.clock = tp.clock.Add(10 * time.Second)
+	tp.logs.releaseHeldLogs(context.Background(), false)
+	assert.Empty(t, sinkBodies(tp.sink), "the log policies decide the record")
+}
+
+func TestTraceDecisions_TransformedRecordsStayDropped(t *testing.T) {
+	redact := logPolicy("redact-debug", "all", severityMatcher("DEBUG"))
+	redact.GetLog().Transform = &policyv1.LogTransform{
+		Remove: []*policyv1.LogRemove{{
+			Field: &policyv1.LogRemove_LogAttribute{LogAttribute: &policyv1.AttributePath{Path: []string{"api_key"}}},
+		}},
+	}
+	tp := newTraceDecisionsTestProcessors(t, []*policyv1.Policy{
+		logPolicy("drop-debug", "none", severityMatcher("DEBUG")),
+		redact,
+	}, TraceDecisionsConfig{Enabled: true})
+	decided, undecided := testTraceID(1), testTraceID(2)
+	tp.consumeSpans(t, map[pcommon.TraceID]string{decided: "checkout"})
+
+	// Keeping the dropped records for their trace would forward them
+	// without the redaction the engine skipped.
+	logs := newTracedLogs([]pcommon.TraceID{decided, undecided}, "decided", "undecided")
+	lrs := logs.ResourceLogs().At(0).ScopeLogs().At(0).LogRecords()
+	for i := range lrs.Len() {
+		lrs.At(i).Attributes().PutStr("api_key", "secret")
+	}
+	out, err := tp.logs.processLogs(context.Background(), logs)
+	require.NoError(t, err)
+	assert.Empty(t, logBodies(out))
+	assert.Zero(t, tp.logs.traceDecisions.heldCount, "records that cannot follow their trace are not held")
+
+	tp.consumeSpans(t, map[pcommon.TraceID]string{undecided: "checkout"})
+	tp.logs.releaseHeldLogs(context.Background(), true)
+	assert.Empty(t, sinkBodies(tp.sink))
+}
+
+func TestTraceDecisions_HeldLogsFallBackAfterWait(t *testing.T) {
+	tp := newTraceDecisionsTestProcessors(t, []*policyv1.Policy{
+		logPolicy("drop-debug", "none", severityMatcher("DEBUG")),
+	}, TraceDecisionsConfig{Enabled: true, LogWait: 10 * time.Second})
+	traceID := testTraceID(1)
+
+	logs := newTracedLogs([]pcommon.TraceID{traceID, traceID}, "debug", "info")
+	logs.ResourceLogs().At(0).ScopeLogs().At(0).LogRecords().At(1).SetSeverityText("INFO")
+	_, err := tp.logs.processLogs(context.Background(), logs)
+	require.NoError(t, err)
+
+	*tp.clock = tp.clock.Add(5 * time.Second)
+	tp.logs.releaseHeldLogs(context.Background(), false)
+	assert.Empty(t, sinkBodies(tp.sink), "records are held until the log wait elapses")
+
+	*tp.clock = tp.clock.Add(5 * time.Second)
+	tp.logs.releaseHeldLogs(context.Background(), false)
+	assert.Equal(t, []string{"info"}, sinkBodies(tp.sink), "the log policies decide once the wait elapses")
+}
+
+func TestTraceDecisions_ShutdownSettlesHeldLogs(t *testing.T) {
+	tp := newTraceDecisionsTestProcessors(t, nil, TraceDecisionsConfig{Enabled: true})
+
+	_, err := tp.logs.processLogs(context.Background(), newTracedLogs([]pcommon.TraceID{testTraceID(1)}, "pending"))
+	require.NoError(t, err)
+
+	tp.logs.stopHeldLogRelease(context.Background())
+	assert.Equal(t, []string{"pending"}, sinkBodies(tp.sink))
+}
+
+func TestTraceDecisions_HeldLogsAreRateLimitedFirst(t *testing.T) {
+	tp := newTraceDecisionsTestProcessors(t, []*policyv1.Policy{
+		logPolicy("keep-debug", "all", severityMatcher("DEBUG")),
+	}, TraceDecisionsConfig{Enabled: true})
+	tp.logs.rateLimiter = newRateLimiter([]RateLimitConfig{{PolicyID: "keep-debug", RecordsPerSecond: 1}})
+	frozen := time.Unix(1000, 0)
+	tp.logs.rateLimiter.now = func() time.Time { return frozen }
+	tel := withTelemetry(t, tp.logs)
+	traceID := testTraceID(1)
+
+	logs := newTracedLogs([]pcommon.TraceID{traceID, traceID, traceID}, "first", "second", "third")
+	_, err := tp.logs.processLogs(context.Background(), logs)
+	require.NoError(t, err)
+	assert.Equal(t, 1, tp.logs.traceDecisions.heldCount, "records over the rate are not held")
+
+	tp.consumeSpans(t, map[pcommon.TraceID]string{traceID: "checkout"})
+	tp.logs.releaseHeldLogs(context.Background(), false)
+	assert.Equal(t, []string{"first"}, sinkBodies(tp.sink))
+
+	metadatatest.AssertEqualProcessorPolicyRecords(t, tel, []metricdata.DataPoint[int64]{
+		{Attributes: attribute.NewSet(attrTelemetryType.String("logs"), attrResult.String("kept")), Value: 1},
+		{Attributes: attribute.NewSet(attrTelemetryType.String("logs"), attrResult.String("rate_limited")), Value: 2},
+	}, metricdatatest.IgnoreTimestamp())
+}
+
+func TestTraceDecisions_HeldLogsShareResourceAndScope(t *testing.T) {
+	tp := newTraceDecisionsTestProcessors(t, nil, TraceDecisionsConfig{Enabled: true})
+	traceID := testTraceID(1)
+
+	for _, body := range []string{"first", "second"} {
+		_, err := tp.logs.processLogs(context.Background(), newTracedLogs([]pcommon.TraceID{traceID, traceID}, body+"-a", body+"-b"))
+		require.NoError(t, err)
+	}
+	h := tp.logs.traceDecisions.held[traceID]
+	require.NotNil(t, h)
+	require.Equal(t, 1, h.kept.logs.ResourceLogs().Len())
+	assert.Equal(t, 1, h.kept.logs.ResourceLogs().At(0).ScopeLogs().Len())
+	assert.Len(t, h.kept.records, 4)
+
+	tp.consumeSpans(t, map[pcommon.TraceID]string{traceID: "checkout"})
+	tp.logs.releaseHeldLogs(context.Background(), false)
+	assert.Equal(t, []string{"first-a", "first-b", "second-a", "second-b"}, sinkBodies(tp.sink))
+}
+
+func TestTraceDecisions_HeldLogsRecordBytesOnRelease(t *testing.T) {
+	tp := newTraceDecisionsTestProcessors(t, []*policyv1.Policy{
+		logPolicy("drop-debug", "none", severityMatcher("DEBUG")),
+	}, TraceDecisionsConfig{Enabled: true})
+	tp.logs.bytes = true
+	tel := withTelemetry(t, tp.logs)
+	kept, dropped := testTraceID(1), testTraceID(2)
+
+	logs := newTracedLogs([]pcommon.TraceID{kept, dropped}, "kept", "dropped")
+	lrs := logs.ResourceLogs().At(0).ScopeLogs().At(0).LogRecords()
+	keptSize, droppedSize := logRecordSize(lrs.At(0)), logRecordSize(lrs.At(1))
+	_, err := tp.logs.processLogs(context.Background(), logs)
+	require.NoError(t, err)
+	_, err = tel.GetMetric("otelcol_processor_policy_bytes")
+	require.Error(t, err, "held records are sized when released")
+
+	tp.consumeSpans(t, map[pcommon.TraceID]string{kept: "checkout", dropped: "healthcheck"})
+	tp.logs.releaseHeldLogs(context.Background(), false)
+
+	attrs := func(result string) attribute.Set {
+		return attribute.NewSet(attrTelemetryType.String("logs"), attrResult.String(result), attrTelemetryPolicyID.String("drop-debug"))
+	}
+	metadatatest.AssertEqualProcessorPolicyBytes(t, tel, []metricdata.DataPoint[int64]{
+		{Attributes: attrs("kept"), Value: int64(keptSize)},
+		{Attributes: attrs("dropped"), Value: int64(droppedSize)},
+	}, metricdatatest.IgnoreTimestamp())
+}
+
+func TestTraceDecisionCache_KeepWinsOverDrop(t *testing.T) {
+	c := newTraceDecisionCache(TraceDecisionsConfig{})
+	traceID := testTraceID(1)
+
+	c.record(traceID, false)
+	c.record(traceID, true)
+	c.record(traceID, false)
+
+	keep, ok := c.lookup(traceID)
+	assert.True(t, ok)
+	assert.True(t, keep)
+}
+
+func TestTraceDecisionCache_ExpiresAfterTTL(t *testing.T) {
+	c := newTraceDecisionCache(TraceDecisionsConfig{TTL: time.Minute})
+	clock := time.Unix(1700000000, 0)
+	c.now = func() time.Time { return clock }
+
+	c.record(testTraceID(1), true)
+	clock = clock.Add(59 * time.Second)
+	_, ok := c.lookup(testTraceID(1))
+	assert.True(t, ok)
+
+	clock = clock.Add(time.Second)
+	_, ok = c.lookup(testTraceID(1))
+	assert.False(t, ok)
+
+	c.record(testTraceID(2), true)
+	assert.Len(t, c.decisions, 1, "expired decisions are pruned")
+}
+
+func TestTraceDecisionCache_ForgetsOldestWhenFull(t *testing.T) {
+	c := newTraceDecisionCache(TraceDecisionsConfig{MaxTraces: 2})
+
+	for i := range 3 {
+		c.record(testTraceID(i), true)
+	}
+
+	_, ok := c.lookup(testTraceID(0))
+	assert.False(t, ok)
+	for i := 1; i < 3; i++ {
+		_, ok = c.lookup(testTraceID(i))
+		assert.True(t, ok)
+	}
+}
@@ -1,6 +1,7 @@
 package policyprocessor
 
 import (
+	"strconv"
 	"strings"
 
 	"github.com/usetero/policy-go/policy"
@@ -9,10 +10,53 @@ import (
 // TraceSet writes a value at ref on the span. Used as the WithTraceSet option
 // for policy.EvaluateTrace; the engine invokes it with SpanSamplingThreshold()
 // after a sampling decision so the threshold lands in the span's tracestate.
+//
+// A threshold is never lowered: if an upstream sampler already recorded a
+// stricter threshold, the span's effective sampling probability is still the
+// upstream one, so the existing value is kept.
 func TraceSet(ctx TraceContext, ref policy.TraceFieldRef, value string) {
-	if ref.Field == policy.SpanSamplingThreshold().Field {
-		ctx.Span.TraceState().FromRaw(mergeOTTracestate(ctx.Span.TraceState().AsRaw(), "th:"+value))
+	if ref.Field != policy.SpanSamplingThreshold().Field {
+		return
 	}
+	tracestate := ctx.Span.TraceState().AsRaw()
+	if existing, ok := otTracestateValue(tracestate, "th"); ok {
+		prev, prevOK := parseThreshold(existing)
+		next, nextOK := parseThreshold(value)
+		if prevOK && nextOK && prev >= next {
+			return
+		}
+	}
+	ctx.Span.TraceState().FromRaw(mergeOTTracestate(tracestate, "th:"+value))
+}
+
+// otTracestateValue returns the value of an OpenTelemetry sub-key (e.g. "th"
+// or "rv") from the "ot" vendor entry of a W3C tracestate string.
+func otTracestateValue(tracestate, subKey string) (string, bool) {
+	for _, vendor := range strings.Split(tracestate, ",") {
+		otValue, ok := strings.CutPrefix(strings.TrimSpace(vendor), "ot=")
+		if !ok {
+			continue
+		}
+		for _, part := range strings.Split(otValue, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(part), subKey+":"); ok {
+				return v, true
+			}
+		}
+	}
+	return "", false
+}
+
+// parseThreshold decodes a tracestate "th" value: 1-14 hex digits,
+// left-aligned in 56 bits with trailing zeros omitted.
+func parseThreshold(value string) (uint64, bool) {
+	if len(value) == 0 || len(value) > 14 {
+		return 0, false
+	}
+	th, err := strconv.ParseUint(value, 16, 64)
+	if err != nil {
+		return 0, false
+	}
+	return th << (uint(14-len(value)) * 4), true
 }
 
 // mergeOTTracestate merges an OpenTelemetry sub-key (e.g. "th:8000") into a
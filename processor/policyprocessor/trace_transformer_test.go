@@ -0,0 +1,97 @@
+package policyprocessor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/usetero/policy-go/policy"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+func TestParseThreshold(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   uint64
+		wantOK bool
+	}{
+		{value: "0", want: 0, wantOK: true},
+		{value: "8", want: 0x80000000000000, wantOK: true},
+		{value: "c", want: 0xc0000000000000, wantOK: true},
+		{value: "fd70a4", want: 0xfd70a400000000, wantOK: true},
+		{value: "ffffffffffffff", want: 0xffffffffffffff, wantOK: true},
+		{value: ""},
+		{value: "fffffffffffffff"},
+		{value: "xyz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, ok := parseThreshold(tt.value)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestOTTracestateValue(t *testing.T) {
+	ts := "vendor=abc,ot=rv:0123456789abcd;th:8, other=x"
+
+	v, ok := otTracestateValue(ts, "th")
+	assert.True(t, ok)
+	assert.Equal(t, "8", v)
+
+	v, ok = otTracestateValue(ts, "rv")
+	assert.True(t, ok)
+	assert.Equal(t, "0123456789abcd", v)
+
+	_, ok = otTracestateValue(ts, "p")
+	assert.False(t, ok)
+	_, ok = otTracestateValue("vendor=th:8", "th")
+	assert.False(t, ok, "sub-keys outside the ot entry are ignored")
+}
+
+func TestTraceSet_Threshold(t *testing.T) {
+	tests := []struct {
+		name       string
+		tracestate string
+		value      string
+		want       string
+	}{
+		{
+			name:  "empty tracestate",
+			value: "8",
+			want:  "ot=th:8",
+		},
+		{
+			name:       "raises a looser threshold",
+			tracestate: "ot=th:4;rv:0123456789abcd",
+			value:      "c",
+			want:       "ot=rv:0123456789abcd;th:c",
+		},
+		{
+			name:       "keeps a stricter threshold",
+			tracestate: "ot=th:c;rv:0123456789abcd,vendor=x",
+			value:      "8",
+			want:       "ot=th:c;rv:0123456789abcd,vendor=x",
+		},
+		{
+			name:       "keep all does not erase an upstream threshold",
+			tracestate: "ot=th:8",
+			value:      "0",
+			want:       "ot=th:8",
+		},
+		{
+			name:       "replaces an invalid threshold",
+			tracestate: "ot=th:zz",
+			value:      "8",
+			want:       "ot=th:8",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := ptrace.NewSpan()
+			span.TraceState().FromRaw(tt.tracestate)
+			TraceSet(TraceContext{Span: span}, policy.SpanSamplingThreshold(), tt.value)
+			assert.Equal(t, tt.want, span.TraceState().AsRaw())
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package policyprocessor
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// attrRecordsPrefix prefixes the batch span attributes counting records by
+// result.
+const attrRecordsPrefix = "policy.records."
+
+// batchSpan is the internal span of a batch of records. It counts the
+// results recorded for the batch's records.
+type batchSpan struct {
+	span trace.Span
+	size int
+
+	mu      sync.Mutex
+	results map[string]int64
+}
+
+type batchSpanKey struct{}
+
+// startBatchSpan starts the span of a batch of size records of
+// telemetryType when tracing is enabled. The returned context carries it,
+// so results recorded with the context are counted on it.
+func (p *policyProcessor) startBatchSpan(ctx context.Context, telemetryType string, size int) (context.Context, *batchSpan) {
+	if p.tracer == nil {
+		return ctx, nil
+	}
+	ctx, span := p.tracer.Start(ctx, "policy/"+telemetryType,
+		trace.WithAttributes(attrTelemetryType.String(telemetryType)))
+	b := &batchSpan{span: span, size: size, results: make(map[string]int64)}
+	return context.WithValue(ctx, batchSpanKey{}, b), b
+}
+
+// batchSpanFromContext returns the batch span carried by ctx, or nil.
+func batchSpanFromContext(ctx context.Context) *batchSpan {
+	b, _ := ctx.Value(batchSpanKey{}).(*batchSpan)
+	return b
+}
+
+func (b *batchSpan) add(result string, n int64) {
+	b.mu.Lock()
+	b.results[result] += n
+	b.mu.Unlock()
+}
+
+// end sets the record counts of the batch on the span and ends it.
+func (b *batchSpan) end() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	attrs := []attribute.KeyValue{attribute.Int(attrRecordsPrefix+"evaluated", b.size)}
+	for _, result := range slices.Sorted(maps.Keys(b.results)) {
+		attrs = append(attrs, attribute.Int64(attrRecordsPrefix+result, b.results[result]))
+	}
+	b.mu.Unlock()
+	b.span.SetAttributes(attrs...)
+	b.span.End()
+}
+
+// traceRecompile records the recompile of the policies delivered by
+// provider id, started at start, in a span when tracing is enabled.
+func (p *policyProcessor) traceRecompile(id string, start time.Time, recompileErr error, failed []string) {
+	if p.tracer == nil {
+		return
+	}
+	_, span := p.tracer.Start(context.Background(), "policy/provider_update",
+		trace.WithTimestamp(start),
+		trace.WithAttributes(attrProviderID.String(id)))
+	attrs := []attribute.KeyValue{attribute.StringSlice("policy.failed_ids", failed)}
+	if recompileErr != nil {
+		attrs = append(attrs, attribute.String("error", recompileErr.Error()))
+		span.SetStatus(codes.Error, recompileErr.Error())
+	}
+	span.AddEvent("policy.recompile", trace.WithAttributes(attrs...))
+	span.End()
+}
@@ -0,0 +1,91 @@
+package policyprocessor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	policyv1 "github.com/usetero/policy-go/proto/tero/policy/v1"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+
+	"github.com/usetero/tero-collector-distro/processor/policyprocessor/internal/metadata"
+)
+
+// withTracing enables tracing of p, returning the telemetry recording its
+// spans.
+func withTracing(p *policyProcessor) *componenttest.Telemetry {
+	tel := componenttest.NewTelemetry()
+	p.tracer = metadata.Tracer(tel.NewTelemetrySettings())
+	return tel
+}
+
+func TestTracing_Batch(t *testing.T) {
+	p := createTestLogProcessor(t, []*policyv1.Policy{
+		logPolicy("drop-debug", "none", severityMatcher("DEBUG")),
+		removeKeyPolicy(),
+	})
+	tel := withTracing(p)
+
+	logs := plog.NewLogs()
+	records := logs.ResourceLogs().AppendEmpty().ScopeLogs().AppendEmpty().LogRecords()
+	records.AppendEmpty().SetSeverityText("DEBUG")
+	info := records.AppendEmpty()
+	info.SetSeverityText("INFO")
+	info.Attributes().PutStr("api_key", "secret")
+	records.AppendEmpty().SetSeverityText("WARN")
+
+	_, err := p.processLogs(context.Background(), logs)
+	require.NoError(t, err)
+
+	spans := tel.SpanRecorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "policy/logs", spans[0].Name())
+	assert.ElementsMatch(t, []attribute.KeyValue{
+		attrTelemetryType.String("logs"),
+		attribute.Int("policy.records.evaluated", 3),
+		attribute.Int64("policy.records.dropped", 1),
+		attribute.Int64("policy.records.transformed", 1),
+		attribute.Int64("policy.records.no_match", 1),
+	}, spans[0].Attributes())
+}
+
+func TestTracing_Disabled(t *testing.T) {
+	p := createTestLogProcessor(t, nil)
+	ctx, batch := p.startBatchSpan(context.Background(), "logs", 1)
+	assert.Nil(t, batch)
+	assert.Nil(t, batchSpanFromContext(ctx))
+	batch.end()
+	p.traceRecompile("static", time.Now(), nil, nil)
+}
+
+func TestTracing_Recompile(t *testing.T) {
+	p := createTestLogProcessor(t, nil)
+	tel := withTracing(p)
+	p.inventory = newProviderInventory()
+	p.registry.SetOnRecompile(p.inventory.setRecompileErr)
+
+	_, err := p.registry.Register(&trackedProvider{
+		PolicyProvider: &staticLogProvider{policies: []*policyv1.Policy{
+			logPolicy("drop-debug", "none", severityMatcher("DEBUG")),
+			logPolicy("bad-keep", "sometimes", severityMatcher("INFO")),
+		}},
+		p:     p,
+		state: p.inventory.add("static"),
+	})
+	require.NoError(t, err)
+
+	spans := tel.SpanRecorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "policy/provider_update", spans[0].Name())
+	assert.Equal(t, []attribute.KeyValue{attrProviderID.String("static")}, spans[0].Attributes())
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+	require.Len(t, spans[0].Events(), 1)
+	event := spans[0].Events()[0]
+	assert.Equal(t, "policy.recompile", event.Name)
+	assert.Equal(t, []attribute.KeyValue{attribute.StringSlice("policy.failed_ids", []string{"bad-keep"})}, event.Attributes)
+}
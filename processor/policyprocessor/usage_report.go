@@ -0,0 +1,294 @@
+package policyprocessor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	// defaultUsageReportInterval is how often usage is reported when no
+	// interval is configured.
+	defaultUsageReportInterval = time.Minute
+	// usageReportTimeout bounds a single report request.
+	usageReportTimeout = 10 * time.Second
+)
+
+// usageReporter periodically sends the hit counts of each policy, along with
+// the records and bytes it won, to the configured endpoint.
+type usageReporter struct {
+	endpoint string
+	headers  map[string]string
+	interval time.Duration
+	client   *http.Client
+
+	// mu guards policies, the usage accumulated since the last report that
+	// was sent.
+	mu       sync.Mutex
+	policies map[string]*policyUsage
+
+	// start is the start of the period of the next report, and reported the
+	// hit counts of each policy as of the last report sent. Both are only
+	// used by the report loop, and by shutdown once the loop stopped.
+	start    time.Time
+	reported map[string]policyTotals
+
+	stop chan struct{}
+	done chan struct{}
+	now  func() time.Time
+}
+
+// policyUsage is the usage of a policy attributed to it as the winning
+// policy of records.
+type policyUsage struct {
+	records    map[string]int64
+	bytes      int64
+	bytesSaved int64
+}
+
+// newUsageReporter returns nil when usage reporting is disabled.
+func newUsageReporter(cfg UsageReportConfig) *usageReporter {
+	if cfg.Endpoint == "" {
+		return nil
+	}
+	var headers map[string]string
+	if len(cfg.Headers) > 0 {
+		headers = make(map[string]string, len(cfg.Headers))
+		for _, h := range cfg.Headers {
+			headers[h.Name] = h.Value
+		}
+	}
+	interval := cfg.Interval
+	if interval == 0 {
+		interval = defaultUsageReportInterval
+	}
+	return &usageReporter{
+		endpoint: cfg.Endpoint,
+		headers:  headers,
+		interval: interval,
+		client:   &http.Client{Timeout: usageReportTimeout},
+		policies: make(map[string]*policyUsage),
+		reported: make(map[string]policyTotals),
+		now:      time.Now,
+	}
+}
+
+// add attributes n records of size bytes, of which kept remain, to the
+// winning policy id under result.
+func (u *usageReporter) add(id, result string, n int64, size, kept int) {
+	if id == "" {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	pu := u.policies[id]
+	if pu == nil {
+		pu = &policyUsage{records: make(map[string]int64)}
+		u.policies[id] = pu
+	}
+	pu.records[result] += n
+	pu.bytes += int64(size)
+	if saved := size - kept; saved > 0 {
+		pu.bytesSaved += int64(saved)
+	}
+}
+
+// take returns the usage accumulated since the last call and resets it.
+func (u *usageReporter) take() map[string]*policyUsage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	policies := u.policies
+	u.policies = make(map[string]*policyUsage)
+	return policies
+}
+
+// restore adds back usage taken for a report that could not be sent, so it
+// is included in the next one.
+func (u *usageReporter) restore(policies map[string]*policyUsage) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for id, taken := range policies {
+		pu := u.policies[id]
+		if pu == nil {
+			u.policies[id] = taken
+			continue
+		}
+		for result, n := range taken.records {
+			pu.records[result] += n
+		}
+		pu.bytes += taken.bytes
+		pu.bytesSaved += taken.bytesSaved
+	}
+}
+
+// usageReport is the JSON body of a usage report. Counts cover the period
+// from StartTime to EndTime.
+type usageReport struct {
+	// ClientMetadata identifies the collector as in policy sync requests.
+	ClientMetadata json.RawMessage `json:"client_metadata"`
+	Pipeline       string          `json:"pipeline"`
+	StartTime      time.Time       `json:"start_time"`
+	EndTime        time.Time       `json:"end_time"`
+	Policies       []usagePolicy   `json:"policies"`
+}
+
+type usagePolicy struct {
+	ID            string `json:"id"`
+	TelemetryType string `json:"telemetry_type,omitempty"`
+	// MatchHits and MatchMisses are the policy engine's hit counts:
+	// records the policy matched, and matched but another policy overrode.
+	MatchHits   uint64 `json:"match_hits"`
+	MatchMisses uint64 `json:"match_misses"`
+	// Records counts the records the policy won by result.
+	Records    map[string]int64 `json:"records"`
+	Bytes      int64            `json:"bytes"`
+	BytesSaved int64            `json:"bytes_saved"`
+}
+
+// startUsageReport starts sending a usage report every interval.
+func (p *policyProcessor) startUsageReport() {
+	u := p.usage
+	u.start = u.now()
+	u.stop = make(chan struct{})
+	u.done = make(chan struct{})
+	go func() {
+		defer close(u.done)
+		ticker := time.NewTicker(u.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-u.stop:
+				return
+			case <-ticker.C:
+				p.sendUsageReport(context.Background())
+			}
+		}
+	}()
+}
+
+// stopUsageReport stops the report loop and sends the usage since the last
+// report.
+func (p *policyProcessor) stopUsageReport(ctx context.Context) {
+	u := p.usage
+	if u.stop == nil {
+		return
+	}
+	close(u.stop)
+	<-u.done
+	u.stop = nil
+	p.sendUsageReport(ctx)
+}
+
+// sendUsageReport sends the usage since the last report sent. Usage that
+// fails to send is kept for the next report.
+func (p *policyProcessor) sendUsageReport(ctx context.Context) {
+	u := p.usage
+	policies := u.take()
+	report, totals := p.usageReport(policies)
+	if err := u.post(ctx, report); err != nil {
+		u.restore(policies)
+		p.logger.Warn("Failed to send policy usage report", zap.String("endpoint", u.endpoint), zap.Error(err))
+		return
+	}
+	u.start = report.EndTime
+	u.reported = totals
+}
+
+// usageReport builds the report of the loaded policies and of the usage in
+// policies, which may include policies no longer loaded. It returns the hit
+// counts of each loaded policy the report is relative to the last report.
+func (p *policyProcessor) usageReport(policies map[string]*policyUsage) (usageReport, map[string]policyTotals) {
+	u := p.usage
+	metadata, _ := protojson.Marshal(p.buildServiceMetadata().ToProto())
+	report := usageReport{
+		ClientMetadata: metadata,
+		Pipeline:       p.pipeline,
+		StartTime:      u.start,
+		EndTime:        u.now(),
+		Policies:       []usagePolicy{},
+	}
+	totals := make(map[string]policyTotals)
+	seen := make(map[string]bool)
+	inv := p.inventory
+	inv.mu.Lock()
+	for _, state := range inv.providers {
+		for _, entry := range state.entries {
+			if !entry.enabled || entry.failed || entry.signal < 0 || seen[entry.id] {
+				continue
+			}
+			seen[entry.id] = true
+			t := inv.policyTotals(entry.id, p.policyStats(entry.signal, entry.id))
+			totals[entry.id] = t
+			up := usagePolicy{
+				ID:            entry.id,
+				TelemetryType: signalTelemetryTypes[entry.signal],
+				MatchHits:     countSince(t.hits, u.reported[entry.id].hits),
+				MatchMisses:   countSince(t.misses, u.reported[entry.id].misses),
+			}
+			up.setUsage(policies[entry.id])
+			report.Policies = append(report.Policies, up)
+		}
+	}
+	inv.mu.Unlock()
+	for id, pu := range policies {
+		if seen[id] {
+			continue
+		}
+		up := usagePolicy{ID: id}
+		up.setUsage(pu)
+		report.Policies = append(report.Policies, up)
+	}
+	return report, totals
+}
+
+func (up *usagePolicy) setUsage(pu *policyUsage) {
+	up.Records = map[string]int64{}
+	if pu == nil {
+		return
+	}
+	up.Records = pu.records
+	up.Bytes = pu.bytes
+	up.BytesSaved = pu.bytesSaved
+}
+
+// countSince returns the increase of a cumulative count from last, or the
+// count itself when it was reset since.
+func countSince(count, last uint64) uint64 {
+	if count < last {
+		return count
+	}
+	return count - last
+}
+
+func (u *usageReporter) post(ctx context.Context, report usageReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range u.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package policyprocessor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/usetero/policy-go/policy"
+	policyv1 "github.com/usetero/policy-go/proto/tero/policy/v1"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+)
+
+// usageServer records the usage reports it receives, failing requests while
+// status is not OK.
+type usageServer struct {
+	*httptest.Server
+	status  int
+	reports []usageReport
+	headers []http.Header
+}
+
+func newUsageServer(t *testing.T) *usageServer {
+	s := &usageServer{status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var report usageReport
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&report))
+		if s.status != http.StatusOK {
+			w.WriteHeader(s.status)
+			return
+		}
+		s.reports = append(s.reports, report)
+		s.headers = append(s.headers, r.Header)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestUsageReport(t *testing.T) {
+	server := newUsageServer(t)
+	p := createTestExplainProcessor(t, "logs", []*policyv1.Policy{
+		logPolicy("drop-debug", "none", severityMatcher("DEBUG")),
+		removeKeyPolicy(),
+		logPolicy("keep-error", "all", severityMatcher("ERROR")),
+	})
+	p.resource = pcommon.NewResource()
+	p.resource.Attributes().PutStr("service.name", "gateway")
+	p.usage = newUsageReporter(UsageReportConfig{
+		Endpoint: server.URL,
+		Headers:  []policy.Header{{Name: "Authorization", Value: "Bearer token"}},
+	})
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	p.usage.start = start
+	p.usage.now = func() time.Time { return start.Add(time.Minute) }
+
+	ld, err := (&plog.JSONUnmarshaler{}).UnmarshalLogs([]byte(explainTestLogs))
+	require.NoError(t, err)
+	_, err = p.processLogs(context.Background(), ld)
+	require.NoError(t, err)
+
+	// A failed report keeps the usage for the next one.
+	server.status = http.StatusServiceUnavailable
+	p.sendUsageReport(context.Background())
+	require.Empty(t, server.reports)
+	server.status = http.StatusOK
+	p.sendUsageReport(context.Background())
+	require.Len(t, server.reports, 1)
+
+	report := server.reports[0]
+	assert.Equal(t, "Bearer token", server.headers[0].Get("Authorization"))
+	assert.Equal(t, "logs", report.Pipeline)
+	assert.Equal(t, start, report.StartTime)
+	assert.Contains(t, string(report.ClientMetadata), `"gateway"`)
+	byID := make(map[string]usagePolicy)
+	for _, up := range report.Policies {
+		byID[up.ID] = up
+	}
+	require.Len(t, byID, 3)
+	debug := byID["drop-debug"]
+	assert.Equal(t, "logs", debug.TelemetryType)
+	assert.Equal(t, uint64(1), debug.MatchHits)
+	assert.Equal(t, map[string]int64{"dropped": 1}, debug.Records)
+	assert.Positive(t, debug.Bytes)
+	assert.Equal(t, debug.Bytes, debug.BytesSaved)
+	removeKey := byID["remove-key"]
+	assert.Equal(t, uint64(1), removeKey.MatchHits)
+	assert.Equal(t, map[string]int64{"transformed": 1}, removeKey.Records)
+	assert.Positive(t, removeKey.BytesSaved)
+	assert.Less(t, removeKey.BytesSaved, removeKey.Bytes)
+	// Policies that matched nothing are reported too.
+	assert.Equal(t, usagePolicy{ID: "keep-error", TelemetryType: "logs", Records: map[string]int64{}}, byID["keep-error"])
+
+	// The next report counts from the last one sent.
+	p.sendUsageReport(context.Background())
+	require.Len(t, server.reports, 2)
+	assert.Equal(t, start.Add(time.Minute), server.reports[1].StartTime)
+	for _, up := range server.reports[1].Policies {
+		assert.Zero(t, up.MatchHits, up.ID)
+		assert.Empty(t, up.Records, up.ID)
+	}
+}
+
+func TestUsageReport_Disabled(t *testing.T) {
+	assert.Nil(t, newUsageReporter(UsageReportConfig{}))
+	u := newUsageReporter(UsageReportConfig{Endpoint: "http://localhost:1"})
+	assert.Equal(t, defaultUsageReportInterval, u.interval)
+}
@@ -0,0 +1,96 @@
+package policyprocessor
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// workerPool evaluates independent resources of a batch concurrently. The
+// goroutine calling run always takes part, and at most maxWorkers-1 helper
+// goroutines are shared by all batches in flight, so the processor never adds
+// more than maxWorkers-1 goroutines regardless of how many pipelines feed it.
+type workerPool struct {
+	helpers chan struct{}
+}
+
+// newWorkerPool returns nil when parallel evaluation is disabled so callers
+// fall back to sequential evaluation.
+func newWorkerPool(cfg ParallelismConfig) *workerPool {
+	if !cfg.Enabled {
+		return nil
+	}
+	maxWorkers := cfg.MaxWorkers
+	if maxWorkers == 0 {
+		maxWorkers = runtime.GOMAXPROCS(0)
+	}
+	if maxWorkers < 2 {
+		return nil
+	}
+	return &workerPool{helpers: make(chan struct{}, maxWorkers-1)}
+}
+
+// run calls fn for every index in [0, n) and returns once all calls are done.
+// Indexes are handed out one at a time, so uneven resources balance across
+// workers. Helpers are only started while slots are free; when the pool is
+// saturated by other batches, the caller works through the indexes alone.
+func (wp *workerPool) run(n int, fn func(i int)) {
+	var next atomic.Int64
+	work := func() {
+		for {
+			i := int(next.Add(1)) - 1
+			if i >= n {
+				return
+			}
+			fn(i)
+		}
+	}
+
+	var wg sync.WaitGroup
+spawn:
+	for range n - 1 {
+		select {
+		case wp.helpers <- struct{}{}:
+			wg.Go(func() {
+				defer func() { <-wp.helpers }()
+				work()
+			})
+		default:
+			break spawn
+		}
+	}
+	work()
+	wg.Wait()
+}
+
+// resourceSlice is the subset of the pdata ResourceLogsSlice,
+// ResourceSpansSlice and ResourceMetricsSlice APIs used to filter resources.
+type resourceSlice[E any] interface {
+	Len() int
+	At(i int) E
+	RemoveIf(f func(E) bool)
+}
+
+// removeResources removes every resource for which process reports true,
+// keeping the remaining resources in their original order. With a worker
+// pool, resources are processed concurrently; process must then only touch
+// its own resource and state that is safe for concurrent use.
+func removeResources[E any, S resourceSlice[E]](wp *workerPool, s S, process func(E) bool) {
+	n := s.Len()
+	if wp == nil || n < 2 {
+		s.RemoveIf(process)
+		return
+	}
+
+	remove := make([]bool, n)
+	wp.run(n, func(i int) {
+		remove[i] = process(s.At(i))
+	})
+
+	i := 0
+	s.RemoveIf(func(E) bool {
+		r := remove[i]
+		i++
+		return r
+	})
+}
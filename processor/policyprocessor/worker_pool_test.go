@@ -0,0 +1,256 @@
+package policyprocessor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	policyv1 "github.com/usetero/policy-go/proto/tero/policy/v1"
+	"github.com/usetero/tero-collector-distro/processor/policyprocessor/internal/metadata"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestNewWorkerPool(t *testing.T) {
+	assert.Nil(t, newWorkerPool(ParallelismConfig{}))
+	assert.Nil(t, newWorkerPool(ParallelismConfig{Enabled: true, MaxWorkers: 1}))
+
+	wp := newWorkerPool(ParallelismConfig{Enabled: true, MaxWorkers: 4})
+	require.NotNil(t, wp)
+	assert.Equal(t, 3, cap(wp.helpers))
+}
+
+func TestWorkerPool_RunVisitsEveryIndexOnce(t *testing.T) {
+	wp := newWorkerPool(ParallelismConfig{Enabled: true, MaxWorkers: 4})
+
+	visits := make([]atomic.Int32, 100)
+	wp.run(len(visits), func(i int) {
+		visits[i].Add(1)
+	})
+	for i := range visits {
+		assert.Equal(t, int32(1), visits[i].Load(), "index %d", i)
+	}
+}
+
+func TestWorkerPool_CapIsSharedAcrossBatches(t *testing.T) {
+	const maxWorkers = 3
+	wp := newWorkerPool(ParallelismConfig{Enabled: true, MaxWorkers: maxWorkers})
+
+	var active, peak atomic.Int32
+	fn := func(int) {
+		n := active.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		active.Add(-1)
+	}
+
+	const batches = 4
+	var wg sync.WaitGroup
+	for range batches {
+		wg.Go(func() { wp.run(20, fn) })
+	}
+	wg.Wait()
+
+	// Every calling goroutine works, plus at most maxWorkers-1 shared helpers.
+	assert.LessOrEqual(t, peak.Load(), int32(batches+maxWorkers-1))
+	assert.Empty(t, wp.helpers, "helpers release their slots")
+}
+
+func TestRemoveResources_PreservesOrder(t *testing.T) {
+	wp := newWorkerPool(ParallelismConfig{Enabled: true, MaxWorkers: 4})
+
+	logs := plog.NewLogs()
+	for i := range 50 {
+		logs.ResourceLogs().AppendEmpty().Resource().Attributes().PutInt("i", int64(i))
+	}
+	removeResources(wp, logs.ResourceLogs(), func(rl plog.ResourceLogs) bool {
+		i, _ := rl.Resource().Attributes().Get("i")
+		return i.Int()%3 == 0
+	})
+
+	var got []int64
+	for i := range logs.ResourceLogs().Len() {
+		v, _ := logs.ResourceLogs().At(i).Resource().Attributes().Get("i")
+		got = append(got, v.Int())
+	}
+	var want []int64
+	for i := range int64(50) {
+		if i%3 != 0 {
+			want = append(want, i)
+		}
+	}
+	assert.Equal(t, want, got)
+}
+
+// withTelemetry attaches a test telemetry builder to p.
+func withTelemetry(t *testing.T, p *policyProcessor) *componenttest.Telemetry {
+	tel := componenttest.NewTelemetry()
+	t.Cleanup(func() { require.NoError(t, tel.Shutdown(context.Background())) })
+	tb, err := metadata.NewTelemetryBuilder(tel.NewTelemetrySettings())
+	require.NoError(t, err)
+	p.telemetry = tb
+	return tel
+}
+
+// policyRecords returns the processor_policy_records data points of tel
+// without timestamps.
+func policyRecords(t *testing.T, tel *componenttest.Telemetry) []metricdata.DataPoint[int64] {
+	m, err := tel.GetMetric("otelcol_processor_policy_records")
+	require.NoError(t, err)
+	dps := m.Data.(metricdata.Sum[int64]).DataPoints
+	for i := range dps {
+		dps[i].StartTime = time.Time{}
+		dps[i].Time = time.Time{}
+	}
+	return dps
+}
+
+// newManyResourceLogs builds n resources with a mix of DEBUG and INFO records.
+func newManyResourceLogs(n int) plog.Logs {
+	logs := plog.NewLogs()
+	for i := range n {
+		rl := logs.ResourceLogs().AppendEmpty()
+		rl.Resource().Attributes().PutStr("service.name", fmt.Sprintf("service-%d", i%4))
+		lrs := rl.ScopeLogs().AppendEmpty().LogRecords()
+		for j := range 8 {
+			lr := lrs.AppendEmpty()
+			lr.Body().SetStr(fmt.Sprintf("resource %d record %d", i, j))
+			if j%2 == 0 {
+				lr.SetSeverityText("DEBUG")
+			} else {
+				lr.SetSeverityText("INFO")
+			}
+		}
+	}
+	return logs
+}
+
+func TestProcessLogs_ParallelMatchesSequential(t *testing.T) {
+	policies := []*policyv1.Policy{
+		logPolicy("drop-service-1", "none", resourceAttrMatcher("service.name", "service-1")),
+		logPolicy("drop-debug", "none", severityMatcher("DEBUG")),
+	}
+
+	sequential := createTestLogProcessor(t, policies)
+	seqTel := withTelemetry(t, sequential)
+	want, err := sequential.processLogs(context.Background(), newManyResourceLogs(64))
+	require.NoError(t, err)
+
+	parallel := createTestLogProcessor(t, policies)
+	parallel.workers = newWorkerPool(ParallelismConfig{Enabled: true, MaxWorkers: 8})
+	parTel := withTelemetry(t, parallel)
+	got, err := parallel.processLogs(context.Background(), newManyResourceLogs(64))
+	require.NoError(t, err)
+
+	assert.Equal(t, want, got)
+	assert.ElementsMatch(t, policyRecords(t, seqTel), policyRecords(t, parTel))
+}
+
+func TestProcessTraces_ParallelMatchesSequential(t *testing.T) {
+	policies := []*policyv1.Policy{
+		{
+			Id:      "drop-checkout",
+			Enabled: true,
+			Target: &policyv1.Policy_Trace{
+				Trace: &policyv1.TraceTarget{
+					Match: []*policyv1.TraceMatcher{
+						{
+							Field: &policyv1.TraceMatcher_TraceField{TraceField: policyv1.TraceField_TRACE_FIELD_NAME},
+							Match: &policyv1.TraceMatcher_Exact{Exact: "checkout"},
+						},
+					},
+					Keep: dropConfig(),
+				},
+			},
+		},
+	}
+	newTraces := func() ptrace.Traces {
+		td := ptrace.NewTraces()
+		for i := range 32 {
+			rs := td.ResourceSpans().AppendEmpty()
+			rs.Resource().Attributes().PutInt("i", int64(i))
+			spans := rs.ScopeSpans().AppendEmpty().Spans()
+			for j, name := range []string{"checkout", "browse", "search"} {
+				s := spans.AppendEmpty()
+				s.SetName(name)
+				s.SetTraceID(testTraceID(i*3 + j))
+			}
+		}
+		return td
+	}
+
+	sequential := createTestTraceProcessor(t, policies)
+	seqTel := withTelemetry(t, sequential)
+	want, err := sequential.processTraces(context.Background(), newTraces())
+	require.NoError(t, err)
+
+	parallel := createTestTraceProcessor(t, policies)
+	parallel.workers = newWorkerPool(ParallelismConfig{Enabled: true, MaxWorkers: 8})
+	parTel := withTelemetry(t, parallel)
+	got, err := parallel.processTraces(context.Background(), newTraces())
+	require.NoError(t, err)
+
+	assert.Equal(t, want, got)
+	assert.ElementsMatch(t, policyRecords(t, seqTel), policyRecords(t, parTel))
+}
+
+func TestProcessMetrics_ParallelMatchesSequential(t *testing.T) {
+	policies := []*policyv1.Policy{
+		{
+			Id:      "drop-internal",
+			Enabled: true,
+			Target: &policyv1.Policy_Metric{
+				Metric: &policyv1.MetricTarget{
+					Match: []*policyv1.MetricMatcher{
+						{
+							Field: &policyv1.MetricMatcher_MetricField{MetricField: policyv1.MetricField_METRIC_FIELD_NAME},
+							Match: &policyv1.MetricMatcher_StartsWith{StartsWith: "internal."},
+						},
+					},
+					Keep: false,
+				},
+			},
+		},
+	}
+	newMetrics := func() pmetric.Metrics {
+		md := pmetric.NewMetrics()
+		for i := range 32 {
+			rm := md.ResourceMetrics().AppendEmpty()
+			rm.Resource().Attributes().PutInt("i", int64(i))
+			ms := rm.ScopeMetrics().AppendEmpty().Metrics()
+			for _, name := range []string{"internal.queue", "http.requests"} {
+				m := ms.AppendEmpty()
+				m.SetName(name)
+				m.SetEmptyGauge().DataPoints().AppendEmpty().SetIntValue(int64(i))
+			}
+		}
+		return md
+	}
+
+	sequential := createTestMetricProcessor(t, policies)
+	seqTel := withTelemetry(t, sequential)
+	want, err := sequential.processMetrics(context.Background(), newMetrics())
+	require.NoError(t, err)
+
+	parallel := createTestMetricProcessor(t, policies)
+	parallel.workers = newWorkerPool(ParallelismConfig{Enabled: true, MaxWorkers: 8})
+	parTel := withTelemetry(t, parallel)
+	got, err := parallel.processMetrics(context.Background(), newMetrics())
+	require.NoError(t, err)
+
+	assert.Equal(t, want, got)
+	assert.ElementsMatch(t, policyRecords(t, seqTel), policyRecords(t, parTel))
+}